@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The audit log is an append-only JSON Lines file of every pm3 command issued and the outcome
+// of every write, verification, restore and wipe. Each entry carries the SHA-256 of the entry
+// before it and its own, so editing, inserting or removing an entry breaks the chain from there
+// on. It is kept in the open workspace, else in ~/.doppelganger_assistant.
+const auditLogFile = "audit.jsonl"
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Seq       int             `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Kind      string          `json:"kind"` // command, write, verify, restore, wipe or override
+	Operation int             `json:"operation,omitempty"`
+	Device    string          `json:"device,omitempty"`
+	Operator  string          `json:"operator,omitempty"`
+	CardType  string          `json:"cardType,omitempty"`
+	Command   string          `json:"command,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"` // the values written, verified or restored, or an override's reason
+	Outcome   string          `json:"outcome"`         // ok, written, match, mismatch, restored, allowed or failed: <error>
+	Prev      string          `json:"prev"`
+	Hash      string          `json:"hash"`
+}
+
+// hash computes the entry's hash over the entry before it and the entry without its hash
+func (e AuditEntry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.Prev), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+var (
+	auditMu sync.Mutex
+	// auditActions is the last write, restore or wipe command of each operation, so a failure
+	// can be logged as the outcome of what the operation was doing
+	auditActions = make(map[int]string)
+)
+
+// auditLogPath is the audit log commands are appended to: the workspace's, else the user's
+func auditLogPath() string {
+	if w := activeWorkspace(); w != nil {
+		return filepath.Join(w.Dir, auditLogFile)
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return auditLogFile
+	}
+	return filepath.Join(homeDir, ".doppelganger_assistant", auditLogFile)
+}
+
+// appendAuditEntry chains an entry onto the audit log. The last entry is read from the file each
+// time under a file lock, so processes sharing the log, e.g. the GUI and a CLI command, keep
+// one chain.
+func appendAuditEntry(e AuditEntry) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	path := auditLogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+	if err := lockFile(file); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer unlockFile(file)
+
+	last, err := lastAuditEntry(file)
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		e.Seq = last.Seq + 1
+		e.Prev = last.Hash
+	} else {
+		e.Seq = 1
+	}
+	if e.Hash, err = e.hash(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// lastAuditEntry returns the last entry of an audit log, or nil when it is empty
+func lastAuditEntry(file *os.File) (*AuditEntry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Entries are short; read back far enough to hold the last complete one
+	size := info.Size()
+	chunk := int64(64 * 1024)
+	for {
+		if chunk > size {
+			chunk = size
+		}
+		if chunk == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, chunk)
+		if _, err := file.ReadAt(buf, size-chunk); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		buf = bytes.TrimRight(buf, "\n")
+		start := bytes.LastIndexByte(buf, '\n')
+		if start < 0 && chunk < size {
+			chunk *= 4
+			continue
+		}
+		var last AuditEntry
+		if err := json.Unmarshal(buf[start+1:], &last); err != nil {
+			return nil, fmt.Errorf("%w: the last entry of the audit log is unreadable: %v", ErrAuditLogBroken, err)
+		}
+		return &last, nil
+	}
+}
+
+// auditAction classifies a pm3 command as a write, restore or wipe, or ""
+func auditAction(command string) string {
+	for _, word := range strings.Fields(command) {
+		switch word {
+		case "cwipe", "wipe":
+			return "wipe"
+		case "restore":
+			return "restore"
+		case "clone", "encode", "wrbl", "csetuid", "gen3uid", "cload", "write":
+			return "write"
+		}
+		if strings.HasPrefix(word, "-") {
+			// Only the command words count, not its values
+			break
+		}
+	}
+	return ""
+}
+
+// auditDevice names the Proxmark3 a runner talks to
+func auditDevice(r Pm3Runner) string {
+	switch r := r.(type) {
+	case *pm3Session:
+		return r.Device
+	case offlinePm3Runner:
+		return "offline"
+	default:
+		return "replay"
+	}
+}
+
+// replayingPm3 reports whether a recorded pm3 session is replayed instead of using a Proxmark3.
+// Replayed commands and results are not audited, as no card was touched.
+func replayingPm3() bool {
+	_, replay := installedPm3Runner().(*fakePm3Runner)
+	return replay
+}
+
+// auditCommand logs a pm3 command the operation in ctx ran on runner, with how it ended
+func auditCommand(ctx context.Context, r Pm3Runner, start time.Time, command string, err error) {
+	if _, replay := r.(*fakePm3Runner); replay {
+		return
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "failed: " + err.Error()
+	}
+	kind := "command"
+	action := auditAction(command)
+	if action == "wipe" {
+		// A wipe is its own outcome; a write or restore ends with the operation's result
+		kind = "wipe"
+	}
+	if operation := operationIDFrom(ctx); operation != 0 && action != "" {
+		auditMu.Lock()
+		auditActions[operation] = action
+		auditMu.Unlock()
+	}
+	err = appendAuditEntry(AuditEntry{
+		Time:      start,
+		Kind:      kind,
+		Operation: operationIDFrom(ctx),
+		Device:    auditDevice(r),
+		Operator:  operatorName(),
+		CardType:  cardTypeFrom(ctx),
+		Command:   normalizePm3Command(command),
+		Outcome:   outcome,
+	})
+	if err != nil {
+		WriteStatusError(ctx, "Audit log: %v", err)
+	}
+}
+
+// auditInteractiveSession logs that a pm3 client was opened in a terminal. The commands typed
+// there do not pass through the assistant, so only the session is logged.
+func auditInteractiveSession(device string, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "failed: " + err.Error()
+	}
+	err = appendAuditEntry(AuditEntry{
+		Time:     time.Now(),
+		Kind:     "command",
+		Device:   device,
+		Operator: operatorName(),
+		Command:  "pm3 (interactive terminal)",
+		Outcome:  outcome,
+	})
+	if err != nil {
+		WriteStatusError(context.Background(), "Audit log: %v", err)
+	}
+}
+
+// auditOverride logs that the operation in ctx went out of scope, with the violation and the
+// operator's reason
+func auditOverride(ctx context.Context, violation error, reason string) {
+	if replayingPm3() {
+		return
+	}
+	input, err := json.Marshal(struct {
+		Violation string `json:"violation"`
+		Reason    string `json:"reason"`
+	}{violation.Error(), reason})
+	if err != nil {
+		return
+	}
+	err = appendAuditEntry(AuditEntry{
+		Time:      time.Now(),
+		Kind:      "override",
+		Operation: operationIDFrom(ctx),
+		Operator:  operatorName(),
+		CardType:  cardTypeFrom(ctx),
+		Input:     input,
+		Outcome:   "allowed",
+	})
+	if err != nil {
+		WriteStatusError(ctx, "Audit log: %v", err)
+	}
+}
+
+// auditResult logs the outcome of writes, verifications and restores from their results
+func auditResult(e Event) {
+	if e.Kind != EventResult || e.Operation == 0 || replayingPm3() {
+		return
+	}
+	auditMu.Lock()
+	action := auditActions[e.Operation]
+	delete(auditActions, e.Operation)
+	auditMu.Unlock()
+
+	var kind, outcome string
+	var input interface{}
+	switch p := e.Payload.(type) {
+	case WriteResult:
+		kind, outcome, input = "write", "written", p.Params
+	case VerifyResult:
+		kind, outcome, input = "verify", "match", p.Expected
+		if !p.Match {
+			outcome = "mismatch"
+		}
+	case RestoreResult:
+		kind, outcome, input = "restore", "restored", p
+	default:
+		if e.Err == nil || action == "" || action == "wipe" {
+			return
+		}
+		kind = action
+	}
+	if e.Err != nil && !errors.Is(e.Err, ErrVerifyMismatch) {
+		outcome = "failed: " + e.Err.Error()
+	}
+
+	entry := AuditEntry{
+		Time:      e.Time,
+		Kind:      kind,
+		Operation: e.Operation,
+		Operator:  operatorName(),
+		CardType:  e.CardType,
+		Outcome:   outcome,
+	}
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err == nil {
+			entry.Input = data
+		}
+	}
+	if err := appendAuditEntry(entry); err != nil {
+		WriteStatusError(context.Background(), "Audit log: %v", err)
+	}
+}
+
+// AuditLogCheck is the result of checking an audit log's chain
+type AuditLogCheck struct {
+	Path    string `json:"path"`
+	Entries int    `json:"entries"`
+	Last    string `json:"lastHash,omitempty"` // note it down to detect entries cut off the end later
+}
+
+// verifyAuditLog checks every entry of an audit log against the one before it. The first
+// broken entry is reported with ErrAuditLogBroken.
+func verifyAuditLog(path string) (AuditLogCheck, error) {
+	check := AuditLogCheck{Path: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return check, fmt.Errorf("%w: failed to open audit log: %v", ErrInvalidInput, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	prev := ""
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&e); err != nil {
+			return check, fmt.Errorf("%w: line %d is not an audit entry: %v", ErrAuditLogBroken, line, err)
+		}
+		// An entry must read back exactly as it was written
+		written, err := json.Marshal(e)
+		if err != nil || !bytes.Equal(written, scanner.Bytes()) {
+			return check, fmt.Errorf("%w: line %d was edited", ErrAuditLogBroken, line)
+		}
+		if e.Seq != line {
+			return check, fmt.Errorf("%w: line %d has sequence number %d; entries were removed or inserted", ErrAuditLogBroken, line, e.Seq)
+		}
+		if e.Prev != prev {
+			return check, fmt.Errorf("%w: line %d does not follow the entry before it", ErrAuditLogBroken, line)
+		}
+		hash, err := e.hash()
+		if err != nil || hash != e.Hash {
+			return check, fmt.Errorf("%w: line %d does not match its hash", ErrAuditLogBroken, line)
+		}
+		prev = e.Hash
+		check.Entries = line
+		check.Last = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return check, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return check, nil
+}
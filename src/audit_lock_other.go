@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing where file locks are not available
+func lockFile(file *os.File) error { return nil }
+
+// unlockFile does nothing where file locks are not available
+func unlockFile(file *os.File) error { return nil }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestAuditLog appends entries to the audit log in a fresh home directory and returns its
+// path and lines
+func writeTestAuditLog(t *testing.T) (string, []string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	commands := []string{"hw status", "lf hid reader", "lf hid clone -w H10301 --fc 118 --cn 1603", "lf hid reader"}
+	for i, command := range commands {
+		err := appendAuditEntry(AuditEntry{
+			Time:      time.Date(2026, 10, 17, 9, 0, i, 0, time.UTC),
+			Kind:      "command",
+			Operation: i + 1,
+			Operator:  "tester",
+			CardType:  "prox",
+			Command:   command,
+			Outcome:   "ok",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := auditLogPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	path, lines := writeTestAuditLog(t)
+	check, err := verifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last AuditEntry
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if check.Entries != 4 || check.Last != last.Hash || last.Seq != 4 {
+		t.Errorf("check = %+v, want 4 entries ending at %s", check, last.Hash)
+	}
+}
+
+func TestVerifyAuditLogTampered(t *testing.T) {
+	// rehash chains an entry back onto prev with a hash that matches its new content
+	rehash := func(t *testing.T, line string, edit func(e *AuditEntry)) string {
+		var e AuditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		edit(&e)
+		var err error
+		if e.Hash, err = e.hash(); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(e)
+		return string(data)
+	}
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, lines []string) []string
+	}{
+		{"one byte changed", func(t *testing.T, lines []string) []string {
+			lines[2] = strings.Replace(lines[2], "1603", "1604", 1)
+			return lines
+		}},
+		{"outcome edited", func(t *testing.T, lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"outcome":"ok"`, `"outcome":"failed: no card"`, 1)
+			return lines
+		}},
+		{"edited and rehashed", func(t *testing.T, lines []string) []string {
+			lines[1] = rehash(t, lines[1], func(e *AuditEntry) { e.Operator = "someone else" })
+			return lines
+		}},
+		{"reformatted", func(t *testing.T, lines []string) []string {
+			lines[0] = strings.Replace(lines[0], `,"kind"`, `, "kind"`, 1)
+			return lines
+		}},
+		{"field added", func(t *testing.T, lines []string) []string {
+			lines[0] = strings.Replace(lines[0], `{`, `{"note":"x",`, 1)
+			return lines
+		}},
+		{"not json", func(t *testing.T, lines []string) []string {
+			lines[3] = lines[3][:len(lines[3])/2]
+			return lines
+		}},
+		{"line removed", func(t *testing.T, lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{"line duplicated", func(t *testing.T, lines []string) []string {
+			return append(lines[:2], lines[1:]...)
+		}},
+		{"forged line inserted", func(t *testing.T, lines []string) []string {
+			forged := rehash(t, lines[1], func(e *AuditEntry) {
+				var before AuditEntry
+				json.Unmarshal([]byte(lines[1]), &before)
+				e.Seq, e.Prev, e.Command = 3, before.Hash, "hf mf wrbl --blk 0"
+			})
+			return append(lines[:2], append([]string{forged}, lines[2:]...)...)
+		}},
+		{"lines reordered", func(t *testing.T, lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+		{"sequence renumbered after a removal", func(t *testing.T, lines []string) []string {
+			lines = append(lines[:1], lines[2:]...)
+			lines[1] = rehash(t, lines[1], func(e *AuditEntry) { e.Seq = 2 })
+			return lines
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, lines := writeTestAuditLog(t)
+			tampered := tt.tamper(t, lines)
+			if err := os.WriteFile(path, []byte(strings.Join(tampered, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := verifyAuditLog(path); !errors.Is(err, ErrAuditLogBroken) {
+				t.Errorf("err = %v, want ErrAuditLogBroken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogCutShort(t *testing.T) {
+	// Entries cut off the end leave a valid chain; only the noted last hash tells
+	path, lines := writeTestAuditLog(t)
+	before, err := verifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path, []byte(strings.Join(lines[:3], "\n")+"\n"), 0600)
+	after, err := verifyAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Entries != 3 || after.Last == before.Last {
+		t.Errorf("after cutting the last entry: %+v, was %+v", after, before)
+	}
+	if _, err := verifyAuditLog(filepath.Join(t.TempDir(), auditLogFile)); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("missing log: err = %v, want ErrInvalidInput", err)
+	}
+}
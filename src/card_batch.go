@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchRow holds one credential from a batch CSV file
+type batchRow struct {
+	Row      int // 1-based data row, excluding any header
+	CardType string
+	Card     Card
+	bl       int
+	fc       int
+	cn       int
+	hexData  string
+	uid      string
+}
+
+// batchResult records the outcome of processing a single row
+type batchResult struct {
+	Row      int    `json:"row"`
+	CardType string `json:"cardType"`
+	Values   string `json:"values"`
+	Result   string `json:"result"` // "ok", "failed" or "skipped"
+	Message  string `json:"message,omitempty"`
+	Time     string `json:"time"`
+}
+
+// batchProgress is saved next to the CSV file so an interrupted batch can be resumed
+type batchProgress struct {
+	File    string        `json:"file"`
+	NextRow int           `json:"nextRow"`
+	Results []batchResult `json:"results"`
+}
+
+// batchProgressPath returns the path of the progress file for a CSV file
+func batchProgressPath(csvPath string) string {
+	return csvPath + ".progress.json"
+}
+
+// loadBatchProgress reads a saved progress file, returning an empty one when none exists
+func loadBatchProgress(csvPath string) (batchProgress, error) {
+	progress := batchProgress{File: csvPath, NextRow: 1}
+	data, err := os.ReadFile(batchProgressPath(csvPath))
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, err
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return progress, fmt.Errorf("invalid progress file %s: %w", batchProgressPath(csvPath), err)
+	}
+	// Progress is saved next to the CSV file, wherever it was when the batch started
+	progress.File = csvPath
+	if progress.NextRow < 1 {
+		progress.NextRow = 1
+	}
+	return progress, nil
+}
+
+// saveBatchProgress writes the progress file after each row
+func saveBatchProgress(progress batchProgress) error {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(batchProgressPath(progress.File), data, 0600)
+}
+
+// batchColumnNames are the header names accepted for each column, compared without case,
+// spaces, underscores or dashes
+var batchColumnNames = [][]string{
+	{"cardtype", "type"},
+	{"bitlength", "bl", "bits"},
+	{"facilitycode", "fc"},
+	{"cardnumber", "cn"},
+	{"hexdata", "hex", "hexvalue"},
+	{"uid"},
+}
+
+// isBatchHeader reports whether a CSV record is a header row rather than a credential: every
+// cell must name its column. Any other first row is a credential, so a typo in it is reported
+// as an invalid row instead of being dropped.
+func isBatchHeader(record []string) bool {
+	if len(record) == 0 || len(record) > len(batchColumnNames) {
+		return false
+	}
+	normalize := strings.NewReplacer(" ", "", "_", "", "-", "")
+	for i, cell := range record {
+		name := strings.ToLower(normalize.Replace(strings.TrimSpace(cell)))
+		if name == "" && i > 0 {
+			continue
+		}
+		known := false
+		for _, column := range batchColumnNames[i] {
+			if name == column {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBatchFile reads a CSV of credentials in the column order:
+// card type, bit length, facility code, card number, hex data, UID
+func parseBatchFile(r io.Reader) ([]batchRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if len(records) > 0 && isBatchHeader(records[0]) {
+		records = records[1:]
+	}
+
+	var rows []batchRow
+	for i, record := range records {
+		// Pad short records so optional trailing columns can be omitted
+		for len(record) < 6 {
+			record = append(record, "")
+		}
+		for j := range record {
+			record[j] = strings.TrimSpace(record[j])
+		}
+
+		row := batchRow{
+			Row:      i + 1,
+			CardType: strings.ToLower(record[0]),
+			hexData:  record[4],
+			uid:      record[5],
+		}
+		row.Card = Card{
+			DataType:     row.CardType,
+			BitLength:    record[1],
+			FacilityCode: record[2],
+			CardNumber:   record[3],
+			HexValue:     record[4],
+		}
+		if ct, ok := lookupCardType(row.CardType); ok && ct.Input() == InputUID {
+			row.Card.CardNumber = row.uid
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// validateBatchRow applies the same rules as the GUI and CLI to a batch row
+func validateBatchRow(row *batchRow) error {
+	atoi := func(name, value string) (int, error) {
+		if value == "" {
+			return 0, fmt.Errorf("%s is required", name)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		return n, nil
+	}
+
+	if row.CardType == "" {
+		return fmt.Errorf("card type is required")
+	}
+	ct, ok := lookupCardType(row.CardType)
+	if !ok {
+		return fmt.Errorf("unsupported card type: %s", row.CardType)
+	}
+
+	switch ct.Input() {
+	case InputWiegand:
+		var err error
+		if row.bl, err = atoi("bit length", row.Card.BitLength); err != nil {
+			return err
+		}
+		if row.fc, err = atoi("facility code", row.Card.FacilityCode); err != nil {
+			return err
+		}
+		if row.cn, err = atoi("card number", row.Card.CardNumber); err != nil {
+			return err
+		}
+	case InputHex:
+		row.bl = ct.BitLengths()[0]
+	}
+
+	return ct.Validate(CardParams{
+		BitLength:    row.bl,
+		FacilityCode: row.fc,
+		CardNumber:   row.cn,
+		HexData:      row.hexData,
+		UID:          row.uid,
+	})
+}
+
+// describe returns a short description of the row values for prompts and summaries
+func (row batchRow) describe() string {
+	ct, _ := lookupCardType(row.CardType)
+	switch {
+	case ct != nil && ct.Input() == InputHex:
+		return fmt.Sprintf("ID %s", row.hexData)
+	case ct != nil && ct.Input() == InputUID:
+		return fmt.Sprintf("UID %s", row.uid)
+	default:
+		return fmt.Sprintf("%d-bit FC %d CN %d", row.bl, row.fc, row.cn)
+	}
+}
+
+// promptBatchRow waits for the operator to place a card. Returns "run", "skip" or "quit".
+func promptBatchRow(ctx context.Context, reader *bufio.Reader, row batchRow, total int) string {
+	WriteStatusProgress(ctx, "Row %d/%d: %s %s", row.Row, total, row.CardType, row.describe())
+	WriteStatusInfo(ctx, "Place card on the Proxmark3 and press Enter (s = skip, q = quit)")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "quit"
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "s", "skip":
+		return "skip"
+	case "q", "quit":
+		return "quit"
+	}
+	return "run"
+}
+
+// BatchSummary is the result of a batch: every row processed so far, including earlier runs
+// of a resumed batch
+type BatchSummary struct {
+	File    string        `json:"file"`
+	Results []batchResult `json:"results"`
+	OK      int           `json:"ok"`
+	Failed  int           `json:"failed"`
+	Skipped int           `json:"skipped"`
+	NextRow int           `json:"nextRow,omitempty"` // the row a resumed batch starts at, when it stopped early
+}
+
+// runBatch validates every row of a CSV file, then generates, writes, verifies or simulates
+// each credential in turn. Progress is saved after every row so the batch can be resumed. It
+// returns an error when the file cannot be used or rows failed, wrapping the first failure.
+func runBatch(ctx context.Context, csvPath string, write, verify, simulate, resume bool, startRow int) error {
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open CSV file: %v", ErrInvalidInput, err)
+	}
+	rows, err := parseBatchFile(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("%w: no credentials found in %s", ErrInvalidInput, csvPath)
+	}
+
+	// Validate every row before touching any cards
+	invalid := 0
+	for i := range rows {
+		if err := validateBatchRow(&rows[i]); err != nil {
+			WriteStatusError(ctx, "Row %d: %v", rows[i].Row, err)
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%w: %d of %d rows are invalid; fix the CSV file and try again", ErrInvalidInput, invalid, len(rows))
+	}
+
+	progress := batchProgress{File: csvPath, NextRow: 1}
+	if resume {
+		if progress, err = loadBatchProgress(csvPath); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		if progress.NextRow > len(rows) {
+			WriteStatusSuccess(ctx, "All rows in this batch have already been processed")
+			return reportBatchSummary(ctx, progress, len(rows), 0, nil)
+		}
+		WriteStatusInfo(ctx, "Resuming batch at row %d of %d", progress.NextRow, len(rows))
+	}
+	if startRow > 0 {
+		if startRow > len(rows) {
+			return fmt.Errorf("%w: start row %d is past the last row (%d)", ErrInvalidInput, startRow, len(rows))
+		}
+		progress.NextRow = startRow
+	}
+
+	interactive := isInteractive() && (write || simulate)
+	stdin := bufio.NewReader(os.Stdin)
+
+	failed := 0
+	var firstErr error
+	for _, row := range rows[progress.NextRow-1:] {
+		if ctx.Err() != nil {
+			break
+		}
+		result := batchResult{
+			Row:      row.Row,
+			CardType: row.CardType,
+			Values:   row.describe(),
+			Time:     time.Now().Format(time.RFC3339),
+		}
+
+		action := "run"
+		if interactive {
+			action = promptBatchRow(ctx, stdin, row, len(rows))
+		} else {
+			WriteStatusProgress(ctx, "Row %d/%d: %s %s", row.Row, len(rows), row.CardType, row.describe())
+		}
+		if action == "quit" {
+			break
+		}
+
+		if action == "skip" {
+			result.Result = "skipped"
+		} else {
+			// Each row is its own operation so its events can be told apart
+			rowCtx := withOperationID(ctx, newOperationID())
+
+			var err error
+			if !write && !simulate {
+				var card Card
+				if card, err = generateCardData(row.CardType, row.bl, row.fc, row.cn, row.hexData, row.uid); err != nil {
+					WriteStatusError(rowCtx, "%v", err)
+					err = emitFailure(rowCtx, fmt.Errorf("%w: %v", ErrInvalidInput, err))
+				} else {
+					displayGeneratedCard(rowCtx, row.CardType, card)
+				}
+			}
+			if err == nil {
+				err = handleCardType(rowCtx, row.CardType, row.fc, row.cn, row.bl, write, verify, row.uid, row.hexData, simulate)
+			}
+			if isCancelled(err) {
+				break
+			}
+
+			if err != nil {
+				result.Result = "failed"
+				result.Message = err.Error()
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else {
+				result.Result = "ok"
+			}
+		}
+
+		// Replace any earlier result for this row, e.g. when a row is retried with -row
+		kept := progress.Results[:0]
+		for _, r := range progress.Results {
+			if r.Row != row.Row {
+				kept = append(kept, r)
+			}
+		}
+		progress.Results = append(kept, result)
+		progress.NextRow = row.Row + 1
+		if err := saveBatchProgress(progress); err != nil {
+			WriteStatusError(ctx, "Failed to save batch progress: %v", err)
+		}
+	}
+
+	return reportBatchSummary(ctx, progress, len(rows), failed, firstErr)
+}
+
+// reportBatchSummary shows the per-row results of a batch, emits them as its result and
+// returns the error the batch ends with: the first failure of this run, wrapped with how many
+// rows failed
+func reportBatchSummary(ctx context.Context, progress batchProgress, total, failed int, firstErr error) error {
+	summary := BatchSummary{File: progress.File, Results: progress.Results}
+	emitOutput(ctx, "\n--- Batch Summary ---")
+	for _, r := range progress.Results {
+		switch r.Result {
+		case "ok":
+			summary.OK++
+		case "failed":
+			summary.Failed++
+		case "skipped":
+			summary.Skipped++
+		}
+		line := fmt.Sprintf("Row %3d  %-8s  %-28s  %s", r.Row, r.CardType, r.Values, strings.ToUpper(r.Result))
+		if r.Message != "" {
+			line += "  " + r.Message
+		}
+		emitOutput(ctx, line)
+	}
+	text := fmt.Sprintf("%d ok, %d failed, %d skipped", summary.OK, summary.Failed, summary.Skipped)
+	if summary.Failed > 0 {
+		WriteStatusError(ctx, "%s", text)
+	} else {
+		WriteStatusSuccess(ctx, "%s", text)
+	}
+	if progress.NextRow <= total {
+		summary.NextRow = progress.NextRow
+		WriteStatusInfo(ctx, "Batch stopped before row %d. Run again with -resume to continue.", progress.NextRow)
+	}
+	emitResult(ctx, "Batch finished: "+text, summary)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d rows failed, the first with: %w", failed, total, firstErr)
+	}
+	return nil
+}
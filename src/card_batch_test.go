@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsBatchHeader(t *testing.T) {
+	tests := []struct {
+		record []string
+		want   bool
+	}{
+		{[]string{"Card Type", "Bit Length", "Facility Code", "Card Number", "Hex Data", "UID"}, true},
+		{[]string{"type", "bl", "fc", "cn"}, true},
+		{[]string{"card_type", "BITS", "facility-code", "CN", "hex", "uid"}, true},
+		{[]string{"type", "", "", "", "", "uid"}, true},
+		{[]string{"prox", "26", "118", "1603"}, false},
+		{[]string{"tpye", "bl", "fc", "cn"}, false},
+		{[]string{"type", "bl", "fc", "cn", "hex", "uid", "notes"}, false},
+		{[]string{"", "bl"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isBatchHeader(tt.record); got != tt.want {
+			t.Errorf("isBatchHeader(%q) = %v, want %v", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestParseBatchFile(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "batch.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := parseBatchFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		cardType, values string
+	}{
+		{"prox", "26-bit FC 118 CN 1603"},
+		{"iclass", "35-bit FC 42 CN 100200"},
+		{"em", "ID 0F0368568B"},
+		{"mifare", "UID 04A1B2C3"},
+		{"awid", "26-bit FC 12 CN 345"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("parsed %d rows, want %d: the comment and header are not credentials", len(rows), len(want))
+	}
+	for i, row := range rows {
+		if err := validateBatchRow(&row); err != nil {
+			t.Errorf("row %d: %v", i+1, err)
+		}
+		if row.Row != i+1 || row.CardType != want[i].cardType || row.describe() != want[i].values {
+			t.Errorf("row %d = %d %s %s, want %d %s %s", i+1, row.Row, row.CardType, row.describe(), i+1, want[i].cardType, want[i].values)
+		}
+	}
+	// a UID card carries its UID as the card number, like the GUI's
+	if rows[3].Card.CardNumber != "04A1B2C3" {
+		t.Errorf("mifare card number = %q, want the UID", rows[3].Card.CardNumber)
+	}
+}
+
+func TestValidateBatchRow(t *testing.T) {
+	tests := []struct {
+		csv     string
+		wantErr string
+	}{
+		{"prox,26,118,1603", ""},
+		{"PROX,26,118,1603", ""},
+		{"em,,,,0F0368568B", ""},
+		{"mifare,,,,,04A1B2C3", ""},
+		{",26,118,1603", "card type is required"},
+		{"hid,26,118,1603", "unsupported card type"},
+		{"prox,,118,1603", "bit length is required"},
+		{"prox,26,,1603", "facility code is required"},
+		{"prox,26,118", "card number is required"},
+		{"prox,26x,118,1603", "invalid bit length"},
+		{"prox,26,one,1603", "invalid facility code"},
+		{"prox,26,256,1603", "Facility Code"},
+		{"prox,25,118,1603", "bit length"},
+		{"em,,,,0F03", "Hex"},
+		{"mifare,,,,,", "UID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.csv, func(t *testing.T) {
+			rows, err := parseBatchFile(strings.NewReader(tt.csv))
+			if err != nil || len(rows) != 1 {
+				t.Fatalf("parseBatchFile = %d rows, %v", len(rows), err)
+			}
+			err = validateBatchRow(&rows[0])
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("err = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(strings.ToLower(err.Error()), strings.ToLower(tt.wantErr))) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// copyBatchFixture copies testdata files into a temporary directory and returns the CSV path
+func copyBatchFixture(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, names[0])
+}
+
+func TestRunBatchResume(t *testing.T) {
+	csvPath := copyBatchFixture(t, "batch.csv", "batch.csv.progress.json")
+	before, err := loadBatchProgress(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.File != csvPath || before.NextRow != 3 {
+		t.Fatalf("loaded progress = %+v", before)
+	}
+
+	// only the rows after the saved progress are written
+	fake := newFakePm3Runner()
+	file, _ := os.Open(csvPath)
+	rows, _ := parseBatchFile(file)
+	file.Close()
+	var wantWrites []string
+	for _, row := range rows[2:] {
+		validateBatchRow(&row)
+		ct, _ := lookupCardType(row.CardType)
+		command, err := ct.WriteCommand(CardParams{BitLength: row.bl, FacilityCode: row.fc, CardNumber: row.cn, HexData: row.hexData, UID: row.uid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake.Respond(command, "[+] Done")
+		wantWrites = append(wantWrites, command)
+	}
+	useFakePm3(t, fake)
+	c := currentConfig()
+	c.WriteAttempts = intPtr(1)
+	applyConfig(c)
+
+	if err := runBatch(context.Background(), csvPath, true, false, false, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Commands(); !reflect.DeepEqual(got, wantWrites) {
+		t.Errorf("ran %q, want %q", got, wantWrites)
+	}
+
+	after, err := loadBatchProgress(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.NextRow != 6 || len(after.Results) != 5 {
+		t.Fatalf("progress after resuming = %+v", after)
+	}
+	// results saved by the earlier run are kept as they were
+	if !reflect.DeepEqual(after.Results[:2], before.Results) {
+		t.Errorf("earlier results = %+v, want %+v", after.Results[:2], before.Results)
+	}
+	for _, r := range after.Results[2:] {
+		if r.Result != "ok" {
+			t.Errorf("row %d: %s %s", r.Row, r.Result, r.Message)
+		}
+	}
+
+	// a finished batch has nothing left to resume
+	if err := runBatch(context.Background(), csvPath, true, false, false, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fake.Commands()); got != len(wantWrites) {
+		t.Errorf("resuming a finished batch ran %d more commands", got-len(wantWrites))
+	}
+}
+
+func TestRunBatchInvalidRows(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "batch.csv")
+	os.WriteFile(csvPath, []byte("prox,26,118,1603\nprox,26,999,1603\nhid,26,1,1\n"), 0600)
+	fake := newFakePm3Runner()
+	useFakePm3(t, fake)
+
+	err := runBatch(context.Background(), csvPath, true, false, false, false, 0)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "2 of 3 rows are invalid") {
+		t.Errorf("err = %v, want 2 of 3 rows invalid", err)
+	}
+	if commands := fake.Commands(); len(commands) != 0 {
+		t.Errorf("ran %q before the CSV was fixed", commands)
+	}
+	if _, err := os.Stat(batchProgressPath(csvPath)); !os.IsNotExist(err) {
+		t.Error("an invalid batch saved progress")
+	}
+}
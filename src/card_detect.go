@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// detectCardType runs lf search and hf search and reports the card types found, including
+// both chips of dual-frequency cards
+func detectCardType(ctx context.Context) error {
+	WriteStatusInfo(ctx, "Detecting card type...")
+
+	// Check Proxmark3 connection
+	if err := checkProxmark3(ctx); err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
+	}
+
+	WriteStatusSuccess(ctx, "Proxmark3 connected")
+
+	// Always check both LF and HF to detect dual chip cards
+	var lfFound bool
+	var hfFound bool
+	var lfCardType string
+	var hfCardType string
+
+	// Try LF search
+	WriteStatusProgress(ctx, "Checking Low Frequency (LF)...")
+	emitCommand(ctx, "lf search")
+	emitOutput(ctx, "")
+
+	lfOutputStr, _ := runPm3(ctx, "lf search")
+	// Filter out "Searching for..." lines and show only results
+	filteredLFOutput := filterSearchOutput(lfOutputStr)
+	if filteredLFOutput != "" {
+		emitOutput(ctx, filteredLFOutput)
+	}
+
+	// Check if LF search found something (look for specific positive indicators)
+	// Positive indicators: "Valid ... ID found", "Valid ... tag found", "Chipset..."
+	// Negative indicators: "No known 125/134 kHz tags found", "No data found!"
+	lfFound = (strings.Contains(lfOutputStr, "Valid") &&
+		(strings.Contains(lfOutputStr, "ID found") || strings.Contains(lfOutputStr, "tag found"))) ||
+		strings.Contains(lfOutputStr, "Chipset...")
+
+	// Exclude only if we have explicit negative results
+	if lfFound && (strings.Contains(lfOutputStr, "No known 125/134 kHz tags found") ||
+		strings.Contains(lfOutputStr, "No data found!")) {
+		lfFound = false
+	}
+
+	// Extract specific LF card type if found (check in order of specificity)
+	if lfFound {
+		// Check for specific card types
+		if strings.Contains(lfOutputStr, "EM410x") || strings.Contains(lfOutputStr, "EM 410x") {
+			lfCardType = "EM4100 / Net2"
+		} else if strings.Contains(lfOutputStr, "HID Prox") {
+			lfCardType = "HID Prox"
+		} else if strings.Contains(lfOutputStr, "AWID") {
+			lfCardType = "AWID"
+		} else if strings.Contains(lfOutputStr, "Indala") {
+			lfCardType = "Indala"
+		} else if strings.Contains(lfOutputStr, "FDX-B") {
+			lfCardType = "FDX-B"
+		} else if strings.Contains(lfOutputStr, "FDX-A") || strings.Contains(lfOutputStr, "Destron") {
+			lfCardType = "FDX-A Destron"
+		} else if strings.Contains(lfOutputStr, "NEDAP") {
+			lfCardType = "NEDAP"
+		} else if strings.Contains(lfOutputStr, "IO Prox") {
+			lfCardType = "IO Prox"
+		} else if strings.Contains(lfOutputStr, "Pyramid") {
+			lfCardType = "Pyramid"
+		} else if strings.Contains(lfOutputStr, "Paradox") {
+			lfCardType = "Paradox"
+		} else if strings.Contains(lfOutputStr, "Idteck") {
+			lfCardType = "Idteck"
+		} else if strings.Contains(lfOutputStr, "KERI") {
+			lfCardType = "KERI"
+		} else if strings.Contains(lfOutputStr, "NexWatch") {
+			lfCardType = "NexWatch"
+		} else if strings.Contains(lfOutputStr, "PAC") || strings.Contains(lfOutputStr, "Stanley") {
+			lfCardType = "PAC/Stanley"
+		} else if strings.Contains(lfOutputStr, "Guardall") || strings.Contains(lfOutputStr, "G-Prox") {
+			lfCardType = "Guardall G-Prox II"
+		} else if strings.Contains(lfOutputStr, "Jablotron") {
+			lfCardType = "Jablotron"
+		} else if strings.Contains(lfOutputStr, "Viking") {
+			lfCardType = "Viking"
+		} else if strings.Contains(lfOutputStr, "Visa2000") {
+			lfCardType = "Visa2000"
+		} else if strings.Contains(lfOutputStr, "Presco") {
+			lfCardType = "Presco"
+		} else if strings.Contains(lfOutputStr, "Securakey") {
+			lfCardType = "Securakey"
+		} else if strings.Contains(lfOutputStr, "Noralsy") {
+			lfCardType = "Noralsy"
+		} else if strings.Contains(lfOutputStr, "Motorola") || strings.Contains(lfOutputStr, "FlexPass") {
+			lfCardType = "Motorola FlexPass"
+		} else if strings.Contains(lfOutputStr, "COTAG") {
+			lfCardType = "COTAG"
+		} else if strings.Contains(lfOutputStr, "EM4x50") {
+			lfCardType = "EM4x50"
+		} else if strings.Contains(lfOutputStr, "EM4x05") || strings.Contains(lfOutputStr, "EM4x69") {
+			lfCardType = "EM4x05/EM4x69"
+		} else if strings.Contains(lfOutputStr, "EM4x70") {
+			lfCardType = "EM4x70"
+		} else if strings.Contains(lfOutputStr, "Paxton") {
+			lfCardType = "Paxton"
+		} else if strings.Contains(lfOutputStr, "Hitag") {
+			lfCardType = "Hitag"
+		} else if strings.Contains(lfOutputStr, "Gallagher") {
+			lfCardType = "Gallagher (LF)"
+		} else if strings.Contains(lfOutputStr, "T55xx") {
+			lfCardType = "T55xx"
+		} else {
+			lfCardType = "LF"
+		}
+	}
+
+	// Always try HF search (for dual chip detection)
+	WriteStatusProgress(ctx, "Checking High Frequency (HF)...")
+	emitOutput(ctx, "")
+	emitCommand(ctx, "hf search")
+	emitOutput(ctx, "")
+
+	hfOutputStr, hfErr := runPm3(ctx, "hf search")
+	// Filter out "Searching for..." lines and show only results
+	filteredHFOutput := filterSearchOutput(hfOutputStr)
+	if filteredHFOutput != "" {
+		emitOutput(ctx, filteredHFOutput)
+	}
+
+	// Extract magic capabilities and PRNG info for MIFARE cards
+	var magicCapabilities []string
+	var prngInfo string
+	var specificMifareType string
+
+	// Extract MIFARE type from "Possible types:" line (handles same-line or next-line format)
+	mifareTypeRegex := regexp.MustCompile(`(?s)Possible types:.*?MIFARE\s+([^\n\r]+?)(?:\s*\n|\s*$)`)
+	mifareTypeMatch := mifareTypeRegex.FindStringSubmatch(hfOutputStr)
+	if len(mifareTypeMatch) > 1 {
+		typeStr := strings.TrimSpace(mifareTypeMatch[1])
+		specificMifareType = "MIFARE " + typeStr
+	}
+
+	// Also check for "MIFARE Classic 1K" or "MIFARE Classic 4K" directly in the output
+	if specificMifareType == "" {
+		mifareDirectRegex := regexp.MustCompile(`(?i)MIFARE\s+Classic\s+(\d+K)`)
+		mifareDirectMatch := mifareDirectRegex.FindStringSubmatch(hfOutputStr)
+		if len(mifareDirectMatch) > 0 {
+			specificMifareType = "MIFARE Classic " + mifareDirectMatch[1]
+		}
+	}
+
+	// Look for magic capabilities
+	magicRegex := regexp.MustCompile(`(?i)Magic capabilities\.\.\.\s+([^\n]+)`)
+	magicMatches := magicRegex.FindAllStringSubmatch(hfOutputStr, -1)
+	for _, match := range magicMatches {
+		if len(match) > 1 {
+			magicCapabilities = append(magicCapabilities, strings.TrimSpace(match[1]))
+		}
+	}
+
+	// Look for PRNG detection
+	prngRegex := regexp.MustCompile(`(?i)Prng detection\.\.\.\.\s+([^\n]+)`)
+	prngMatch := prngRegex.FindStringSubmatch(hfOutputStr)
+	if len(prngMatch) > 1 {
+		prngInfo = strings.TrimSpace(prngMatch[1])
+	}
+
+	// Check if HF search found something and identify specific card type
+	// MUST check for actual "Valid ... found" or "detected" messages, NOT search messages
+	// Exclude negative results first
+	hasNegativeResult := strings.Contains(hfOutputStr, "No known/supported 13.56 MHz tags found") ||
+		(strings.Contains(hfOutputStr, "No known") && strings.Contains(hfOutputStr, "tags found"))
+
+	if hasNegativeResult {
+		hfFound = false
+	} else {
+		// Check for specific card types with their actual detection messages
+		// Order matters - check more specific types first
+
+		// MIFARE Classic - check for "Possible types: MIFARE Classic 1K" or "MIFARE Classic detected"
+		if strings.Contains(hfOutputStr, "MIFARE Classic") &&
+			(strings.Contains(hfOutputStr, "Possible types") || strings.Contains(hfOutputStr, "detected") ||
+				strings.Contains(hfOutputStr, "Valid ISO 14443-A")) {
+			hfFound = true
+			if specificMifareType != "" {
+				hfCardType = specificMifareType
+			} else {
+				hfCardType = "MIFARE Classic"
+			}
+		} else if (strings.Contains(hfOutputStr, "MIFARE Plus") && strings.Contains(hfOutputStr, "detected")) ||
+			(strings.Contains(hfOutputStr, "MIFARE Plus") && strings.Contains(hfOutputStr, "Possible types")) {
+			hfFound = true
+			hfCardType = specificMifareType
+			if specificMifareType == "" {
+				hfCardType = "MIFARE Plus"
+			}
+		} else if (strings.Contains(hfOutputStr, "MIFARE DESFire") || strings.Contains(hfOutputStr, "DESFire")) &&
+			(strings.Contains(hfOutputStr, "detected") || strings.Contains(hfOutputStr, "Possible types")) {
+			hfFound = true
+			hfCardType = specificMifareType
+			if specificMifareType == "" {
+				hfCardType = "MIFARE DESFire"
+			}
+		} else if (strings.Contains(hfOutputStr, "MIFARE Ultralight") && strings.Contains(hfOutputStr, "detected")) ||
+			(strings.Contains(hfOutputStr, "MIFARE Ultralight") && strings.Contains(hfOutputStr, "Possible types")) {
+			hfFound = true
+			hfCardType = "MIFARE Ultralight / NTAG"
+		} else if strings.Contains(hfOutputStr, "NTAG 424") || strings.Contains(hfOutputStr, "NTAG424") {
+			hfFound = true
+			hfCardType = specificMifareType
+			if specificMifareType == "" {
+				hfCardType = "NTAG 424 DNA"
+			}
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "iCLASS tag / PicoPass tag") && strings.Contains(hfOutputStr, "found") {
+			// Must have "Valid ... iCLASS tag / PicoPass tag ... found"
+			hfFound = true
+			hfCardType = "iCLASS / PicoPass"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "LEGIC Prime tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "LEGIC Prime"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "Topaz tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "Topaz (NFC Type 1)"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "LTO-CM tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "LTO-CM"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "TEXKOM tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "TEXKOM"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "Fuji/Xerox tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "Fuji/Xerox"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "ISO 14443-B tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "ISO 14443-B"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "ISO 15693 tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "ISO 15693"
+		} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "ISO 18092 / FeliCa tag") && strings.Contains(hfOutputStr, "found") {
+			hfFound = true
+			hfCardType = "ISO 18092 / FeliCa"
+		} else if strings.Contains(hfOutputStr, "Valid ISO 14443-A tag found") {
+			hfFound = true
+			hfCardType = "ISO 14443-A"
+		} else if strings.Contains(hfOutputStr, "UID:") && strings.Contains(hfOutputStr, "ATQA:") && strings.Contains(hfOutputStr, "SAK:") {
+			// ISO14443-A info present (UID, ATQA, SAK) - this is a valid detection
+			hfFound = true
+			hfCardType = "ISO 14443-A"
+		}
+	}
+
+	// Report findings
+	if lfFound && hfFound {
+		// Dual chip card detected
+		WriteStatusSuccess(ctx, "DUAL CHIP CARD DETECTED!")
+		WriteStatusInfo(ctx, "LF Chip: %s", lfCardType)
+		WriteStatusInfo(ctx, "HF Chip: %s", hfCardType)
+		WriteStatusInfo(ctx, "This card contains both Low Frequency and High Frequency chips")
+
+		// Show specific MIFARE type if available
+		if specificMifareType != "" && strings.Contains(hfCardType, "MIFARE") {
+			WriteStatusInfo(ctx, "MIFARE Type: %s", specificMifareType)
+		}
+
+		// Show magic capabilities if detected
+		if len(magicCapabilities) > 0 {
+			WriteStatusInfo(ctx, "Magic Capabilities: %s", strings.Join(magicCapabilities, ", "))
+		}
+		if prngInfo != "" {
+			WriteStatusInfo(ctx, "PRNG Detection: %s", prngInfo)
+		}
+
+		// If MIFARE Classic detected, run hf mf info for more details
+		if strings.Contains(hfCardType, "MIFARE Classic") {
+			WriteStatusProgress(ctx, "Getting detailed MIFARE information...")
+			emitOutput(ctx, "")
+			emitCommand(ctx, "hf mf info")
+			emitOutput(ctx, "")
+
+			infoOutputStr, infoErr := runPm3(ctx, "hf mf info")
+			if infoErr == nil && infoOutputStr != "" {
+				emitOutput(ctx, infoOutputStr)
+
+				// Extract additional details from hf mf info
+				// Extract UID
+				uidRegex1 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{2}(?:\s+[A-F0-9]{2})+)`)
+				if uidMatch := uidRegex1.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
+					uid := strings.ReplaceAll(uidMatch[1], " ", "")
+					WriteStatusInfo(ctx, "UID: %s", uid)
+				} else {
+					uidRegex2 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{8,14})`)
+					if uidMatch := uidRegex2.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
+						WriteStatusInfo(ctx, "UID: %s", uidMatch[1])
+					}
+				}
+
+				// Check for Saflok
+				if strings.Contains(infoOutputStr, "Saflok") {
+					WriteStatusInfo(ctx, "Detected: Saflok hotel key card")
+				}
+			}
+		}
+	} else if lfFound {
+		WriteStatusSuccess(ctx, "✓ %s card detected (LF only)", lfCardType)
+	} else if hfFound {
+		WriteStatusSuccess(ctx, "✓ %s card detected (HF only)", hfCardType)
+
+		// Show specific MIFARE type if available
+		if specificMifareType != "" && strings.Contains(hfCardType, "MIFARE") {
+			WriteStatusInfo(ctx, "MIFARE Type: %s", specificMifareType)
+		}
+
+		// Show magic capabilities if detected
+		if len(magicCapabilities) > 0 {
+			WriteStatusInfo(ctx, "Magic Capabilities: %s", strings.Join(magicCapabilities, ", "))
+		}
+		if prngInfo != "" {
+			WriteStatusInfo(ctx, "PRNG Detection: %s", prngInfo)
+		}
+
+		// If MIFARE Classic detected, run hf mf info for more details
+		if strings.Contains(hfCardType, "MIFARE Classic") {
+			WriteStatusProgress(ctx, "Getting detailed MIFARE information...")
+			emitOutput(ctx, "")
+			emitCommand(ctx, "hf mf info")
+			emitOutput(ctx, "")
+
+			infoOutputStr, infoErr := runPm3(ctx, "hf mf info")
+			if infoErr == nil && infoOutputStr != "" {
+				emitOutput(ctx, infoOutputStr)
+
+				// Extract additional details from hf mf info
+				// Extract UID
+				uidRegex1 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{2}(?:\s+[A-F0-9]{2})+)`)
+				if uidMatch := uidRegex1.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
+					uid := strings.ReplaceAll(uidMatch[1], " ", "")
+					WriteStatusInfo(ctx, "UID: %s", uid)
+				} else {
+					uidRegex2 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{8,14})`)
+					if uidMatch := uidRegex2.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
+						WriteStatusInfo(ctx, "UID: %s", uidMatch[1])
+					}
+				}
+
+				// Check for Saflok
+				if strings.Contains(infoOutputStr, "Saflok") {
+					WriteStatusInfo(ctx, "Detected: Saflok hotel key card")
+				}
+			}
+		}
+	} else if isCancelled(hfErr) {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return hfErr
+	} else if hfErr != nil {
+		WriteStatusError(ctx, "HF detection failed: %v", hfErr)
+		return emitFailure(ctx, pm3Failure(hfOutputStr, hfErr, ErrNoCardPresent))
+	} else {
+		WriteStatusInfo(ctx, "No card detected. Make sure card is placed on reader.")
+		return emitFailure(ctx, fmt.Errorf("%w: lf search and hf search found no card", ErrNoCardPresent))
+	}
+
+	WriteStatusSuccess(ctx, "Card detection completed")
+	return nil
+}
+
+// filterSearchOutput filters out "Searching for..." lines and keeps only important results
+func filterSearchOutput(output string) string {
+	lines := strings.Split(output, "\n")
+	var filtered []string
+
+	for _, line := range lines {
+		// Skip "Searching for..." lines and progress indicators
+		if strings.Contains(line, "Searching for") {
+			continue
+		}
+		// Skip progress spinner lines (contain [\], [|], [/], [-])
+		if strings.Contains(line, "[\\]") || strings.Contains(line, "[|]") ||
+			strings.Contains(line, "[/]") || strings.Contains(line, "[-]") {
+			// But keep lines that have [-] and actual content (like errors or results)
+			if strings.Contains(line, "[-]") &&
+				(strings.Contains(line, "found") || strings.Contains(line, "No") ||
+					strings.Contains(line, "failed") || strings.Contains(line, "error")) {
+				filtered = append(filtered, line)
+			}
+			continue
+		}
+		// Keep everything else (results, errors, hints, etc.)
+		filtered = append(filtered, line)
+	}
+
+	return strings.Join(filtered, "\n")
+}
@@ -1 +1,388 @@
 package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// wiegandField describes a linear run of bits inside a Wiegand message.
+// Bit positions count from 0, where position 0 is the first bit transmitted (MSB).
+type wiegandField struct {
+	start  int
+	length int
+}
+
+// wiegandParity describes a single parity bit and the bit positions it covers.
+type wiegandParity struct {
+	position int
+	odd      bool
+	covers   []int
+}
+
+// wiegandFormat describes the layout of a Wiegand format as named by the Proxmark3 client
+type wiegandFormat struct {
+	name        string
+	description string
+	bitLength   int
+	fc          wiegandField
+	cn          wiegandField
+	// parity bits are computed in order, so a parity bit may cover earlier parity bits
+	parity []wiegandParity
+}
+
+// wiegandData holds an encoded or decoded Wiegand credential
+type wiegandData struct {
+	Format       string
+	BitLength    int
+	FacilityCode uint64
+	CardNumber   uint64
+	Bits         uint64
+	ParityValid  bool
+}
+
+// bitRange returns the positions start..start+length-1
+func bitRange(start, length int) []int {
+	positions := make([]int, 0, length)
+	for i := start; i < start+length; i++ {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// simpleParity builds the common leading even / trailing odd parity pair
+func simpleParity(bitLength int, evenStart, evenLength, oddStart, oddLength int) []wiegandParity {
+	return []wiegandParity{
+		{position: 0, odd: false, covers: bitRange(evenStart, evenLength)},
+		{position: bitLength - 1, odd: true, covers: bitRange(oddStart, oddLength)},
+	}
+}
+
+// corporate1000Parity builds the three parity bits used by HID Corporate 1000 formats.
+// Bit 1 is even parity over two of every three bits starting at position 2,
+// the last bit is odd parity over two of every three bits starting at position 1,
+// and bit 0 is odd parity over the whole message.
+func corporate1000Parity(bitLength int) []wiegandParity {
+	var evenCovers, oddCovers []int
+	for p := 2; p <= bitLength-2; p++ {
+		if p%3 != 1 {
+			evenCovers = append(evenCovers, p)
+		}
+	}
+	for p := 1; p <= bitLength-2; p++ {
+		if p%3 != 0 {
+			oddCovers = append(oddCovers, p)
+		}
+	}
+	return []wiegandParity{
+		{position: 1, odd: false, covers: evenCovers},
+		{position: bitLength - 1, odd: true, covers: oddCovers},
+		{position: 0, odd: true, covers: bitRange(1, bitLength-1)},
+	}
+}
+
+// wiegandFormats lists every Wiegand format the tool names, keyed by Proxmark3 format name
+var wiegandFormats = map[string]*wiegandFormat{
+	"H10301": {
+		name: "H10301", description: "HID H10301 26-bit", bitLength: 26,
+		fc: wiegandField{1, 8}, cn: wiegandField{9, 16},
+		parity: simpleParity(26, 1, 12, 13, 12),
+	},
+	"2804W": {
+		name: "2804W", description: "2804 Wiegand 28-bit", bitLength: 28,
+		fc: wiegandField{4, 8}, cn: wiegandField{12, 15},
+		parity: []wiegandParity{
+			{position: 2, odd: true, covers: []int{4, 5, 7, 8, 10, 11, 13, 14, 16, 17, 19, 20, 22, 23, 25, 26}},
+			{position: 0, odd: false, covers: bitRange(1, 13)},
+			{position: 27, odd: true, covers: bitRange(0, 27)},
+		},
+	},
+	"ATSW30": {
+		name: "ATSW30", description: "ATS Wiegand 30-bit", bitLength: 30,
+		fc: wiegandField{1, 12}, cn: wiegandField{13, 16},
+		parity: simpleParity(30, 1, 12, 13, 16),
+	},
+	"ADT31": {
+		// The ADT 31-bit parity scheme is not published; the Proxmark3 client leaves those bits clear too
+		name: "ADT31", description: "HID ADT 31-bit", bitLength: 31,
+		fc: wiegandField{1, 4}, cn: wiegandField{5, 23},
+	},
+	"D10202": {
+		name: "D10202", description: "HID D10202 33-bit", bitLength: 33,
+		fc: wiegandField{1, 7}, cn: wiegandField{8, 24},
+		parity: simpleParity(33, 1, 16, 16, 16),
+	},
+	"H10306": {
+		name: "H10306", description: "HID H10306 34-bit", bitLength: 34,
+		fc: wiegandField{1, 16}, cn: wiegandField{17, 16},
+		parity: simpleParity(34, 1, 16, 17, 16),
+	},
+	"C1k35s": {
+		name: "C1k35s", description: "HID Corporate 1000 35-bit", bitLength: 35,
+		fc: wiegandField{2, 12}, cn: wiegandField{14, 20},
+		parity: corporate1000Parity(35),
+	},
+	"S12906": {
+		// Bits 9-10 carry the issue level, which is always encoded as 0
+		name: "S12906", description: "HID Simplex 36-bit", bitLength: 36,
+		fc: wiegandField{1, 8}, cn: wiegandField{11, 24},
+		parity: []wiegandParity{
+			{position: 0, odd: true, covers: bitRange(1, 17)},
+			{position: 35, odd: true, covers: bitRange(17, 18)},
+		},
+	},
+	"H10304": {
+		name: "H10304", description: "HID H10304 37-bit", bitLength: 37,
+		fc: wiegandField{1, 16}, cn: wiegandField{17, 19},
+		parity: simpleParity(37, 1, 18, 18, 18),
+	},
+	"H800002": {
+		name: "H800002", description: "HID H800002 46-bit", bitLength: 46,
+		fc: wiegandField{1, 14}, cn: wiegandField{15, 30},
+		parity: simpleParity(46, 1, 22, 23, 22),
+	},
+	"C1k48s": {
+		name: "C1k48s", description: "HID Corporate 1000 48-bit", bitLength: 48,
+		fc: wiegandField{2, 22}, cn: wiegandField{24, 23},
+		parity: corporate1000Parity(48),
+	},
+	"Avig56": {
+		name: "Avig56", description: "Avigilon 56-bit", bitLength: 56,
+		fc: wiegandField{1, 20}, cn: wiegandField{21, 34},
+		parity: simpleParity(56, 1, 27, 28, 27),
+	},
+}
+
+// wiegandFormatFor returns the Wiegand format used for a card type and bit length
+func wiegandFormatFor(cardType string, bitLength int) (*wiegandFormat, bool) {
+	ct, ok := lookupCardType(cardType)
+	if !ok {
+		return nil, false
+	}
+	format, ok := wiegandFormats[ct.WiegandFormat(bitLength)]
+	return format, ok
+}
+
+// maxFacilityCode returns the largest facility code the format can hold
+func (f *wiegandFormat) maxFacilityCode() uint64 {
+	return 1<<uint(f.fc.length) - 1
+}
+
+// maxCardNumber returns the largest card number the format can hold
+func (f *wiegandFormat) maxCardNumber() uint64 {
+	return 1<<uint(f.cn.length) - 1
+}
+
+// getBit returns the bit at the given position of a message
+func (f *wiegandFormat) getBit(bits uint64, position int) uint64 {
+	return (bits >> uint(f.bitLength-1-position)) & 1
+}
+
+// setBit sets the bit at the given position of a message
+func (f *wiegandFormat) setBit(bits uint64, position int, value uint64) uint64 {
+	shift := uint(f.bitLength - 1 - position)
+	return bits&^(1<<shift) | (value&1)<<shift
+}
+
+// getField extracts a linear field from a message
+func (f *wiegandFormat) getField(bits uint64, field wiegandField) uint64 {
+	shift := uint(f.bitLength - field.start - field.length)
+	return (bits >> shift) & (1<<uint(field.length) - 1)
+}
+
+// setField stores a value in a linear field of a message
+func (f *wiegandFormat) setField(bits uint64, field wiegandField, value uint64) uint64 {
+	shift := uint(f.bitLength - field.start - field.length)
+	mask := uint64(1<<uint(field.length)-1) << shift
+	return bits&^mask | (value<<shift)&mask
+}
+
+// parityBit computes the value a parity bit must hold for the current message
+func (f *wiegandFormat) parityBit(bits uint64, p wiegandParity) uint64 {
+	var ones uint64
+	for _, position := range p.covers {
+		ones += f.getBit(bits, position)
+	}
+	if p.odd {
+		return (ones + 1) & 1
+	}
+	return ones & 1
+}
+
+// encode packs a facility code and card number into the format, computing parity
+func (f *wiegandFormat) encode(facilityCode, cardNumber uint64) (wiegandData, error) {
+	if facilityCode > f.maxFacilityCode() {
+		return wiegandData{}, fmt.Errorf("facility code %d exceeds %d for %s", facilityCode, f.maxFacilityCode(), f.name)
+	}
+	if cardNumber > f.maxCardNumber() {
+		return wiegandData{}, fmt.Errorf("card number %d exceeds %d for %s", cardNumber, f.maxCardNumber(), f.name)
+	}
+
+	var bits uint64
+	bits = f.setField(bits, f.fc, facilityCode)
+	bits = f.setField(bits, f.cn, cardNumber)
+	for _, p := range f.parity {
+		bits = f.setBit(bits, p.position, f.parityBit(bits, p))
+	}
+
+	return wiegandData{
+		Format:       f.name,
+		BitLength:    f.bitLength,
+		FacilityCode: facilityCode,
+		CardNumber:   cardNumber,
+		Bits:         bits,
+		ParityValid:  true,
+	}, nil
+}
+
+// decode unpacks a message of this format and checks its parity bits
+func (f *wiegandFormat) decode(bits uint64) wiegandData {
+	bits &= 1<<uint(f.bitLength) - 1
+
+	parityValid := true
+	for _, p := range f.parity {
+		// Clear the parity bit before computing so bits covering themselves are handled
+		if f.getBit(bits, p.position) != f.parityBit(f.setBit(bits, p.position, 0), p) {
+			parityValid = false
+			break
+		}
+	}
+
+	return wiegandData{
+		Format:       f.name,
+		BitLength:    f.bitLength,
+		FacilityCode: f.getField(bits, f.fc),
+		CardNumber:   f.getField(bits, f.cn),
+		Bits:         bits,
+		ParityValid:  parityValid,
+	}
+}
+
+// decodeWiegand decodes a message against every known format of the same bit length.
+// Formats whose parity checks pass are listed first.
+func decodeWiegand(bits uint64, bitLength int) []wiegandData {
+	var valid, invalid []wiegandData
+	for _, format := range wiegandFormats {
+		if format.bitLength != bitLength {
+			continue
+		}
+		decoded := format.decode(bits)
+		if decoded.ParityValid {
+			valid = append(valid, decoded)
+		} else {
+			invalid = append(invalid, decoded)
+		}
+	}
+	return append(valid, invalid...)
+}
+
+// Hex returns the message as upper-case hex, padded to whole nibbles
+func (d wiegandData) Hex() string {
+	digits := (d.BitLength + 3) / 4
+	return fmt.Sprintf("%0*X", digits, d.Bits)
+}
+
+// Binary returns the message as a bit string, first transmitted bit first
+func (d wiegandData) Binary() string {
+	return fmt.Sprintf("%0*b", d.BitLength, d.Bits)
+}
+
+// parseWiegandBinary parses a bit string such as "10110..." into a message and its length
+func parseWiegandBinary(binStr string) (uint64, int, error) {
+	binStr = strings.ReplaceAll(strings.TrimSpace(binStr), " ", "")
+	if binStr == "" || len(binStr) > 64 {
+		return 0, 0, fmt.Errorf("binary data must be between 1 and 64 bits, got %d", len(binStr))
+	}
+	bits, err := strconv.ParseUint(binStr, 2, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid binary data: %s", binStr)
+	}
+	return bits, len(binStr), nil
+}
+
+// parseWiegandHex parses hex data holding a message of the given bit length
+func parseWiegandHex(hexStr string, bitLength int) (uint64, error) {
+	hexStr = strings.TrimPrefix(strings.ReplaceAll(strings.TrimSpace(hexStr), " ", ""), "0x")
+	if hexStr == "" || len(hexStr) > 16 {
+		return 0, fmt.Errorf("hex data must be between 1 and 16 characters, got %d", len(hexStr))
+	}
+	bits, err := strconv.ParseUint(hexStr, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex data: %s", hexStr)
+	}
+	if bitLength < 64 && bits>>uint(bitLength) != 0 {
+		return 0, fmt.Errorf("hex data %s does not fit in %d bits", hexStr, bitLength)
+	}
+	return bits, nil
+}
+
+// generateCardData encodes the card values without a Proxmark3, filling the Bin and HexValue fields
+// for Wiegand based card types. Other card types are returned with the values they were given.
+func generateCardData(cardType string, bitLength, facilityCode, cardNumber int, hexData, uid string) (Card, error) {
+	card := Card{
+		DataType:     cardType,
+		BitLength:    strconv.Itoa(bitLength),
+		FacilityCode: strconv.Itoa(facilityCode),
+		CardNumber:   strconv.Itoa(cardNumber),
+	}
+
+	ct, ok := lookupCardType(cardType)
+	if !ok {
+		return card, fmt.Errorf("unsupported card type: %s", cardType)
+	}
+
+	switch ct.Input() {
+	case InputWiegand:
+		if ct.WiegandFormat(bitLength) == "" {
+			// Not a named Wiegand format (AWID, Indala), pm3 encodes these itself
+			break
+		}
+		format, ok := wiegandFormatFor(cardType, bitLength)
+		if !ok {
+			return card, fmt.Errorf("no Wiegand format for %d-bit %s cards", bitLength, cardType)
+		}
+		if facilityCode < 0 || cardNumber < 0 {
+			return card, fmt.Errorf("facility code and card number must be positive")
+		}
+		data, err := format.encode(uint64(facilityCode), uint64(cardNumber))
+		if err != nil {
+			return card, err
+		}
+		card.HexValue = data.Hex()
+		card.Bin = data.Binary()
+	case InputHex:
+		card.HexValue = strings.ToUpper(hexData)
+		card.FacilityCode = ""
+		card.CardNumber = ""
+	case InputUID:
+		card.HexValue = strings.ToUpper(uid)
+		card.BitLength = ""
+		card.FacilityCode = ""
+		card.CardNumber = uid
+	}
+
+	return card, nil
+}
+
+// displayGeneratedCard shows the encoded card values in the status window and reports them as
+// the operation's result
+func displayGeneratedCard(ctx context.Context, cardType string, card Card) {
+	ctx = withCardType(ctx, cardType)
+	emitResult(ctx, "Card data generated", card)
+	if card.Bin == "" {
+		return
+	}
+	format, _ := wiegandFormatFor(cardType, mustAtoi(card.BitLength))
+	if format != nil {
+		WriteStatusInfo(ctx, "Format: %s (%s)", format.name, format.description)
+	}
+	WriteStatusInfo(ctx, "Raw Wiegand (hex): %s", card.HexValue)
+	WriteStatusInfo(ctx, "Raw Wiegand (bin): %s", card.Bin)
+}
+
+// mustAtoi converts a string to an int, returning 0 when it is not a number
+func mustAtoi(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	return n
+}
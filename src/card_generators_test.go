@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestWiegandRoundTrip(t *testing.T) {
+	if len(wiegandFormats) != 12 {
+		t.Errorf("%d Wiegand formats, want 12", len(wiegandFormats))
+	}
+	names := make([]string, 0, len(wiegandFormats))
+	for name := range wiegandFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := wiegandFormats[name]
+		t.Run(name, func(t *testing.T) {
+			values := [][2]uint64{
+				{0, 0},
+				{1, 1},
+				{f.maxFacilityCode() / 3, f.maxCardNumber() / 5},
+				{f.maxFacilityCode(), f.maxCardNumber()},
+			}
+			for _, v := range values {
+				encoded, err := f.encode(v[0], v[1])
+				if err != nil {
+					t.Fatalf("encode(%d, %d): %v", v[0], v[1], err)
+				}
+				if encoded.Bits>>uint(f.bitLength) != 0 {
+					t.Fatalf("encode(%d, %d) = %s, longer than %d bits", v[0], v[1], encoded.Hex(), f.bitLength)
+				}
+				decoded := f.decode(encoded.Bits)
+				if decoded != encoded {
+					t.Errorf("decode(encode(%d, %d)) = %+v, want %+v", v[0], v[1], decoded, encoded)
+				}
+				if !listedWithValidParity(decodeWiegand(encoded.Bits, f.bitLength), name, v[0], v[1]) {
+					t.Errorf("decodeWiegand(%s) does not list %s FC %d CN %d with valid parity", encoded.Hex(), name, v[0], v[1])
+				}
+			}
+
+			if _, err := f.encode(f.maxFacilityCode()+1, 0); err == nil {
+				t.Error("a facility code past the maximum was encoded")
+			}
+			if _, err := f.encode(0, f.maxCardNumber()+1); err == nil {
+				t.Error("a card number past the maximum was encoded")
+			}
+		})
+	}
+}
+
+func TestWiegandBadParity(t *testing.T) {
+	for name, f := range wiegandFormats {
+		encoded, err := f.encode(f.maxFacilityCode()/3, f.maxCardNumber()/5)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, p := range f.parity {
+			bits := encoded.Bits ^ 1<<uint(f.bitLength-1-p.position)
+			if f.decode(bits).ParityValid {
+				t.Errorf("%s: flipping parity bit %d still decodes with valid parity", name, p.position)
+			}
+			for _, d := range decodeWiegand(bits, f.bitLength) {
+				if d.Format == name && d.ParityValid {
+					t.Errorf("%s: decodeWiegand accepts the parity of a message with bit %d flipped", name, p.position)
+				}
+			}
+		}
+	}
+}
+
+func TestWiegandKnownEncoding(t *testing.T) {
+	// FC 118 CN 1603 as read from a card by the Proxmark3 (raw 2006ec0c86, without the sentinel bit)
+	encoded, err := wiegandFormats["H10301"].encode(118, 1603)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.Hex() != "2EC0C86" {
+		t.Errorf("H10301 FC 118 CN 1603 = %s, want 2EC0C86", encoded.Hex())
+	}
+	bits, err := parseWiegandHex("2ec0c86", 26)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded := decodeWiegand(bits, 26); len(decoded) == 0 || decoded[0].Format != "H10301" || !decoded[0].ParityValid {
+		t.Errorf("decodeWiegand(2EC0C86) = %+v, want H10301 first", decoded)
+	}
+}
+
+// listedWithValidParity tells whether decoded holds the format with the values and valid parity,
+// among the formats listed before any with invalid parity
+func listedWithValidParity(decoded []wiegandData, format string, fc, cn uint64) bool {
+	for _, d := range decoded {
+		if !d.ParityValid {
+			return false
+		}
+		if d.Format == format && d.FacilityCode == fc && d.CardNumber == cn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CardRead is the decoded result of reading a card with the Proxmark3. Optional values are
+// nil or empty when the card or reader output does not provide them. The JSON encoding is
+// stable so reads can be saved, diffed and loaded back to write or verify a card.
+type CardRead struct {
+	CardType     string `json:"cardType"`
+	Format       string `json:"format,omitempty"`
+	BitLength    *int   `json:"bitLength,omitempty"`
+	FacilityCode *int   `json:"facilityCode,omitempty"`
+	CardNumber   *int   `json:"cardNumber,omitempty"`
+	HexData      string `json:"hexData,omitempty"`
+	UID          string `json:"uid,omitempty"`
+	CSN          string `json:"csn,omitempty"`
+	ATQA         string `json:"atqa,omitempty"`
+	SAK          string `json:"sak,omitempty"`
+	Raw          string `json:"raw,omitempty"`
+	Wiegand      string `json:"wiegand,omitempty"`
+	Bits         string `json:"bits,omitempty"`        // decoded Wiegand bits, most significant first
+	ParityValid  *bool  `json:"parityValid,omitempty"` // nil when parity could not be checked
+	Encrypted    bool   `json:"encrypted,omitempty"`
+	Command      string `json:"command,omitempty"` // pm3 command the values were read with
+	ReadAt       string `json:"readAt,omitempty"`
+}
+
+// intPtr returns a pointer to a copy of v, for the optional CardRead fields
+func intPtr(v int) *int {
+	return &v
+}
+
+// boolPtr returns a pointer to a copy of v, for the optional CardRead fields
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+// empty reports whether the read holds no card values at all
+func (r *CardRead) empty() bool {
+	return r.Format == "" && r.BitLength == nil && r.FacilityCode == nil && r.CardNumber == nil &&
+		r.HexData == "" && r.UID == "" && r.CSN == "" && r.ATQA == "" && r.SAK == "" &&
+		r.Raw == "" && r.Wiegand == ""
+}
+
+// hasCredential reports whether the read decoded both a facility code and a card number
+func (r *CardRead) hasCredential() bool {
+	return r.FacilityCode != nil && r.CardNumber != nil
+}
+
+// merge copies the Wiegand values decoded from a later pm3 command, e.g. hf iclass decrypt,
+// into the read. Zero facility codes and card numbers are ignored as decoders report them on failure.
+func (r *CardRead) merge(other *CardRead) {
+	if other == nil {
+		return
+	}
+	if other.FacilityCode != nil && *other.FacilityCode > 0 {
+		r.FacilityCode = other.FacilityCode
+	}
+	if other.CardNumber != nil && *other.CardNumber > 0 {
+		r.CardNumber = other.CardNumber
+	}
+	if other.Format != "" {
+		r.Format = other.Format
+	}
+	if other.BitLength != nil {
+		r.BitLength = other.BitLength
+	}
+	if other.Bits != "" {
+		r.Bits = other.Bits
+	}
+	if other.ParityValid != nil {
+		r.ParityValid = other.ParityValid
+	}
+	if r.hasCredential() {
+		r.Encrypted = false
+	}
+}
+
+// decodeBits fills Bits and ParityValid from the raw reader data using the Wiegand engine.
+// It only keeps the result when the decoded values agree with what the reader reported.
+func (r *CardRead) decodeBits() {
+	if r.BitLength == nil || !r.hasCredential() {
+		return
+	}
+	format, ok := wiegandFormats[r.Format]
+	if !ok || format.bitLength != *r.BitLength {
+		if format, ok = wiegandFormatFor(r.CardType, *r.BitLength); !ok {
+			return
+		}
+	}
+
+	for _, hexStr := range []string{r.Wiegand, r.Raw} {
+		hexStr = strings.TrimLeft(hexStr, "0")
+		if hexStr == "" {
+			continue
+		}
+		if len(hexStr) > 16 {
+			hexStr = hexStr[len(hexStr)-16:]
+		}
+		bits, err := parseWiegandHex(hexStr, 64)
+		if err != nil {
+			continue
+		}
+		decoded := format.decode(bits)
+		if decoded.FacilityCode != uint64(*r.FacilityCode) || decoded.CardNumber != uint64(*r.CardNumber) {
+			continue
+		}
+		r.Bits = decoded.Binary()
+		if r.ParityValid == nil {
+			r.ParityValid = boolPtr(decoded.ParityValid)
+		}
+		return
+	}
+}
+
+// verify compares a decoded Wiegand read with the expected values. The bit length is only
+// compared when the reader reported one.
+func (r *CardRead) verify(p CardParams) error {
+	if !r.hasCredential() {
+		return fmt.Errorf("unable to decode card data")
+	}
+	if *r.FacilityCode != p.FacilityCode || *r.CardNumber != p.CardNumber {
+		return fmt.Errorf("FC/CN mismatch (read FC: %d, CN: %d)", *r.FacilityCode, *r.CardNumber)
+	}
+	if r.BitLength != nil && *r.BitLength != p.BitLength {
+		return fmt.Errorf("bit length mismatch (read %d-bit)", *r.BitLength)
+	}
+	return nil
+}
+
+// Params returns the read values in the form used to write, verify or simulate a card
+func (r *CardRead) Params() CardParams {
+	var p CardParams
+	if r.BitLength != nil {
+		p.BitLength = *r.BitLength
+	}
+	if r.FacilityCode != nil {
+		p.FacilityCode = *r.FacilityCode
+	}
+	if r.CardNumber != nil {
+		p.CardNumber = *r.CardNumber
+	}
+	p.HexData = r.HexData
+	p.UID = r.UID
+	return p
+}
+
+// describe summarises the read on one line, e.g. for the REPL
+func (r *CardRead) describe() string {
+	p := r.Params()
+	summary := cardTypeDisplayName(r.CardType)
+	switch {
+	case r.hasCredential():
+		summary += fmt.Sprintf(" %d-bit  FC %d  CN %d", p.BitLength, p.FacilityCode, p.CardNumber)
+	case r.HexData != "":
+		summary += "  ID " + r.HexData
+	case r.UID != "":
+		summary += "  UID " + r.UID
+	case r.CSN != "":
+		summary += "  CSN " + r.CSN
+	}
+	if r.Format != "" {
+		summary += "  (" + r.Format + ")"
+	}
+	return summary
+}
+
+// saveCardRead writes a read to a JSON file, sealed when a vault is unlocked
+func saveCardRead(path string, r *CardRead) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if v := activeVault(); v != nil {
+		if data, err = v.seal(data); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// loadCardRead reads a JSON file saved by saveCardRead
+func loadCardRead(path string) (*CardRead, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if isSealed(data) {
+		v := activeVault()
+		if v == nil {
+			return nil, fmt.Errorf("%s is sealed; unlock its vault first", path)
+		}
+		if data, err = v.open(data); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	var r CardRead
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid card read file %s: %w", path, err)
+	}
+	if _, ok := lookupCardType(r.CardType); !ok {
+		return nil, fmt.Errorf("unsupported card type in %s: %q", path, r.CardType)
+	}
+	return &r, nil
+}
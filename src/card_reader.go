@@ -1,238 +1,220 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// readCardData reads card data from the Proxmark3 for the specified card type
-func readCardData(cardType string) {
-	if ok, msg := checkProxmark3(); !ok {
-		WriteStatusError(msg)
-		return
-	}
-
-	pm3Binary, err := getPm3Path()
-	if err != nil {
-		WriteStatusError("Failed to find pm3 binary: %v", err)
-		return
+// readCardData reads card data from the Proxmark3 for the specified card type.
+// Returns the failure when the card could not be read.
+func readCardData(ctx context.Context, cardType string) (*CardRead, error) {
+	ctx = withCardType(ctx, cardType)
+	if err := checkProxmark3(ctx); err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return nil, emitFailure(ctx, err)
 	}
 
-	device, err := getPm3Device()
-	if err != nil {
-		WriteStatusError("Failed to detect pm3 device: %v", err)
-		return
-	}
+	WriteStatusProgress(ctx, "Reading card - place card flat on reader...")
 
-	WriteStatusProgress("Reading card - place card flat on reader...")
-
-	if IsOperationCancelled() {
-		WriteStatusInfo("Operation cancelled by user")
-		return
+	if ctx.Err() != nil {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return nil, ctx.Err()
 	}
 
-	var cmdStr string
-	var cmd *exec.Cmd
-	var parser func(string) (map[string]interface{}, error)
-
-	switch cardType {
-	case "prox":
-		cmdStr = fmt.Sprintf("lf hid reader -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "lf hid reader", "-p", device)
-		parser = parseHIDReaderOutput
-	case "iclass":
-		// Use dump to get card data, then decrypt and decode block 7 for Wiegand FC/CN
-		// The dump command will automatically decrypt and decode if using standard keys
-		cmdStr = fmt.Sprintf("hf iclass dump --ki 0 -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "hf iclass dump --ki 0", "-p", device)
-		parser = parseICLASSReaderOutput
-	case "awid":
-		cmdStr = fmt.Sprintf("lf awid reader -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "lf awid reader", "-p", device)
-		parser = parseAWIDReaderOutput
-	case "indala":
-		cmdStr = fmt.Sprintf("lf indala reader -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "lf indala reader", "-p", device)
-		parser = parseIndalaReaderOutput
-	case "avigilon":
-		cmdStr = fmt.Sprintf("lf hid reader -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "lf hid reader", "-p", device)
-		parser = parseAvigilonReaderOutput
-	case "em":
-		cmdStr = fmt.Sprintf("lf em 410x reader -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "lf em 410x reader", "-p", device)
-		parser = parseEM4100ReaderOutput
-	case "piv", "mifare":
-		cmdStr = fmt.Sprintf("hf mf info -p %s", device)
-		cmd = exec.Command(pm3Binary, "-c", "hf mf info", "-p", device)
-		parser = parseMIFAREReaderOutput
-	default:
-		WriteStatusError("Unsupported card type for reading")
-		return
+	ct, ok := lookupCardType(cardType)
+	if !ok {
+		WriteStatusError(ctx, "Unsupported card type for reading")
+		return nil, emitFailure(ctx, fmt.Errorf("%w: unsupported card type %q", ErrInvalidInput, cardType))
 	}
-
 	// Print command to command output window
-	fmt.Println(cmdStr)
-	fmt.Println()
+	emitCommand(ctx, ct.ReadCommand())
+	emitOutput(ctx, "")
 
-	output, cmdErr := cmd.CombinedOutput()
-	outputStr := string(output)
+	outputStr, cmdErr := runPm3(ctx, ct.ReadCommand())
 
 	// Print full raw output to command output window
-	fmt.Println("--- Raw Proxmark3 Output ---")
-	fmt.Println(outputStr)
-	fmt.Println("--- End of Output ---")
-
-	// For iCLASS, if dump fails, check if card is encrypted
-	if cardType == "iclass" && cmdErr != nil {
-		if strings.Contains(outputStr, "authentication") || strings.Contains(outputStr, "key") {
-			WriteStatusError("Card may be encrypted. Try using 'hf iclass decrypt' with the correct key.")
-			WriteStatusInfo("Raw output: %s", outputStr)
-			return
-		}
-		WriteStatusError("Failed to read card: %v", cmdErr)
-		WriteStatusInfo("Raw output: %s", outputStr)
-		return
+	emitOutput(ctx, "--- Raw Proxmark3 Output ---")
+	emitOutput(ctx, outputStr)
+	emitOutput(ctx, "--- End of Output ---")
+
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return nil, cmdErr
 	}
 
 	if cmdErr != nil {
-		WriteStatusError("Failed to read card: %v", cmdErr)
-		return
+		failure := pm3Failure(outputStr, cmdErr, ErrNoCardPresent)
+		// For iCLASS, a failed dump that needed a key means the card is encrypted
+		if cardType == "iclass" && errors.Is(failure, ErrAuthFailed) {
+			WriteStatusError(ctx, "Card may be encrypted. Try using 'hf iclass decrypt' with the correct key.")
+		} else {
+			WriteStatusError(ctx, "Failed to read card: %v", cmdErr)
+		}
+		if cardType == "iclass" {
+			WriteStatusInfo(ctx, "Raw output: %s", outputStr)
+		}
+		return nil, emitFailure(ctx, failure)
 	}
 
 	// Try to parse the output
-	cardData, parseErr := parser(outputStr)
+	cardRead, parseErr := ct.ParseOutput(outputStr)
 	if parseErr != nil {
-		WriteStatusInfo("Could not parse card data automatically. See raw output below.")
+		WriteStatusInfo(ctx, "Could not parse card data automatically. See raw output below.")
 		// For iCLASS, check if CSN is in raw output but FC/CN is missing
 		if cardType == "iclass" {
 			if strings.Contains(outputStr, "CSN:") && !strings.Contains(outputStr, "FC:") {
-				WriteStatusInfo("Card has CSN but FC/CN not found. Card may be encrypted.")
-				WriteStatusInfo("Try: hf iclass decrypt -f <dump_file> -k <key>")
+				WriteStatusInfo(ctx, "Card has CSN but FC/CN not found. Card may be encrypted.")
+				WriteStatusInfo(ctx, "Try: hf iclass decrypt -f <dump_file> -k <key>")
 			}
 		}
-		return
+		// Output without a credential is an unknown format unless it shows why nothing was read
+		fallback := fmt.Errorf("%w: %v", ErrUnsupportedFormat, parseErr)
+		if strings.TrimSpace(outputStr) == "" {
+			fallback = fmt.Errorf("%w: %v", ErrNoCardPresent, parseErr)
+		}
+		return nil, emitFailure(ctx, pm3Failure(outputStr, nil, fallback))
 	}
+	cardRead.Command = ct.ReadCommand()
+	cardRead.ReadAt = time.Now().Format(time.RFC3339)
 
 	// For iCLASS, if we parsed but don't have FC/CN, check if we need to decrypt first
-	if cardType == "iclass" {
-		if _, hasFC := cardData["facilityCode"]; !hasFC {
-			// Check if block 7 is encrypted (shows as "Enc Cred" in dump)
-			if strings.Contains(outputStr, "Enc Cred") && strings.Contains(outputStr, "Block 7 decoder") == false {
-				// Card is encrypted, need to decrypt first
-				WriteStatusInfo("Card appears encrypted. Attempting to decrypt...")
-
-				// Extract dump filename from output
-				dumpFileRegex := regexp.MustCompile(`Saved.*?to binary file ` + "`" + `([^` + "`" + `]+)` + "`")
-				if matches := dumpFileRegex.FindStringSubmatch(outputStr); len(matches) > 1 {
-					if IsOperationCancelled() {
-						WriteStatusInfo("Operation cancelled by user")
-						return
-					}
-					dumpFile := matches[1]
-					fmt.Println()
-					fmt.Printf("hf iclass decrypt -f %s\n", dumpFile)
-
-					// Run decrypt command
-					decryptCmd := exec.Command(pm3Binary, "-c", fmt.Sprintf("hf iclass decrypt -f %s", dumpFile), "-p", device)
-					decryptOutput, decryptErr := decryptCmd.CombinedOutput()
-					if decryptErr == nil {
-						decryptStr := string(decryptOutput)
-						fmt.Println(decryptStr)
-
-						decryptedData, _ := parseICLASSReaderOutput(decryptStr)
-						if decryptedData != nil {
-							if fc, ok := decryptedData["facilityCode"].(int); ok && fc > 0 {
-								cardData["facilityCode"] = fc
-							}
-							if cn, ok := decryptedData["cardNumber"].(int); ok && cn > 0 {
-								cardData["cardNumber"] = cn
-							}
-							if format, ok := decryptedData["format"].(string); ok {
-								cardData["format"] = format
-							}
-							if bl, ok := decryptedData["bitLength"].(int); ok {
-								cardData["bitLength"] = bl
-							}
-						}
-
-						// Fallback: decode block 7 hex if FC/CN not found
-						if _, hasFC := cardData["facilityCode"]; !hasFC {
-							block7Hex := extractBlock7Hex(decryptStr)
-							if block7Hex != "" {
-								WriteStatusInfo("Attempting alternative decode of block 7 hex...")
-								fmt.Println()
-								fmt.Printf("wiegand decode --raw %s --force\n", block7Hex)
-
-								decodeCmd := exec.Command(pm3Binary, "-c", fmt.Sprintf("wiegand decode --raw %s --force", block7Hex), "-p", device)
-								decodeOutput, _ := decodeCmd.CombinedOutput()
-								fmt.Println(string(decodeOutput))
-
-								// Try to parse decode output
-								if decodedData, err := parseICLASSReaderOutput(string(decodeOutput)); err == nil {
-									if fc, ok := decodedData["facilityCode"].(int); ok && fc > 0 {
-										cardData["facilityCode"] = fc
-									}
-									if cn, ok := decodedData["cardNumber"].(int); ok && cn > 0 {
-										cardData["cardNumber"] = cn
-									}
-									if format, ok := decodedData["format"].(string); ok {
-										cardData["format"] = format
-									}
-									if bl, ok := decodedData["bitLength"].(int); ok {
-										cardData["bitLength"] = bl
-									}
-								}
-							}
-						}
-					}
+	if cardType == "iclass" && cardRead.FacilityCode == nil {
+		if !decryptICLASSRead(ctx, outputStr, cardRead) {
+			return nil, ctx.Err()
+		}
+		if cardRead.FacilityCode == nil && cardRead.CSN != "" {
+			WriteStatusInfo(ctx, "Note: Card has CSN but FC/CN not decoded. Block 7 format may not be recognized by decoder.")
+		}
+	}
+	cardRead.decodeBits()
+
+	// Display parsed card data in status window
+	displayCardData(ctx, cardRead)
+	emitResult(ctx, "Card read", cardRead)
+	return cardRead, nil
+}
+
+// decryptICLASSRead decrypts an encrypted iCLASS dump and merges the decoded block 7 values
+// into cardRead. Returns false when the operation was cancelled.
+func decryptICLASSRead(ctx context.Context, dumpOutput string, cardRead *CardRead) bool {
+	// Check if block 7 is encrypted (shows as "Enc Cred" in dump)
+	if !strings.Contains(dumpOutput, "Enc Cred") || strings.Contains(dumpOutput, "Block 7 decoder") {
+		return true
+	}
+
+	// Card is encrypted, need to decrypt first
+	WriteStatusInfo(ctx, "Card appears encrypted. Attempting to decrypt...")
+
+	// Extract dump filename from output
+	dumpFileRegex := regexp.MustCompile(`Saved.*?to binary file ` + "`" + `([^` + "`" + `]+)` + "`")
+	matches := dumpFileRegex.FindStringSubmatch(dumpOutput)
+	if len(matches) < 2 {
+		return true
+	}
+	if ctx.Err() != nil {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return false
+	}
+	dumpFile := matches[1]
+	emitOutput(ctx, "")
+	emitCommand(ctx, fmt.Sprintf("hf iclass decrypt -f %s", dumpFile))
+
+	// Run decrypt command
+	decryptStr, decryptErr := runPm3(ctx, fmt.Sprintf("hf iclass decrypt -f %s", dumpFile))
+	if decryptErr != nil {
+		return true
+	}
+	emitOutput(ctx, decryptStr)
+
+	decrypted, _ := parseICLASSReaderOutput(decryptStr)
+	cardRead.merge(decrypted)
+
+	// Fallback: decode block 7 hex if FC/CN not found
+	if cardRead.FacilityCode == nil {
+		block7Hex := extractBlock7Hex(decryptStr)
+		if block7Hex != "" {
+			WriteStatusInfo(ctx, "Attempting alternative decode of block 7 hex...")
+			emitOutput(ctx, "")
+			emitCommand(ctx, fmt.Sprintf("wiegand decode --raw %s --force", block7Hex))
+
+			decodeOutput, _ := runPm3(ctx, fmt.Sprintf("wiegand decode --raw %s --force", block7Hex))
+			emitOutput(ctx, decodeOutput)
+
+			// Try to parse decode output
+			if decoded, err := parseICLASSReaderOutput(decodeOutput); err == nil {
+				cardRead.merge(decoded)
+				if cardRead.Raw == "" {
+					cardRead.Raw = block7Hex
 				}
-			} else if _, hasCSN := cardData["csn"]; hasCSN {
-				WriteStatusInfo("Note: Card has CSN but FC/CN not decoded. Block 7 format may not be recognized by decoder.")
 			}
 		}
 	}
+	return true
+}
 
-	// Display parsed card data in status window
-	displayCardData(cardType, cardData)
+// atoiPtr converts a regex match to an optional int, returning nil when it is not a number
+func atoiPtr(s string) *int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil
+	}
+	return &n
 }
 
-// parseHIDReaderOutput parses HID Prox card reader output
-func parseHIDReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+// positivePtr is atoiPtr that also rejects zero, for decoders that report 0 on failure
+func positivePtr(s string) *int {
+	if n := atoiPtr(s); n != nil && *n > 0 {
+		return n
+	}
+	return nil
+}
 
-	// Look for FC and Card Number - try multiple patterns
-	// Pattern 1: "FC: 111" or "FC:111"
+// parseFacilityAndCard fills the facility code and card number using the combined pattern
+// first, then the individual FC and CN patterns
+func parseFacilityAndCard(r *CardRead, output string, fcCnRegex *regexp.Regexp, convert func(string) *int) {
 	fcRegex := regexp.MustCompile(`FC:\s*(\d+)`)
-	// Pattern 2: "FC: 111 CN: 1111" on same line
-	fcCnRegex := regexp.MustCompile(`FC:\s*(\d+).*?CN:\s*(\d+)`)
-	// Pattern 3: Just "FC: 111" followed by CN on same or next line
 	cnRegex := regexp.MustCompile(`(?:Card|CN):\s*(\d+)`)
 
-	// Try combined pattern first
 	if matches := fcCnRegex.FindStringSubmatch(output); len(matches) >= 3 {
-		if fc, err := strconv.Atoi(matches[1]); err == nil {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[2]); err == nil {
-			data["cardNumber"] = cn
-		}
-	} else {
-		// Try individual patterns
-		if matches := fcRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if fc, err := strconv.Atoi(matches[1]); err == nil {
-				data["facilityCode"] = fc
-			}
-		}
-		if matches := cnRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if cn, err := strconv.Atoi(matches[1]); err == nil {
-				data["cardNumber"] = cn
-			}
-		}
+		r.FacilityCode = convert(matches[1])
+		r.CardNumber = convert(matches[2])
+		return
+	}
+	if matches := fcRegex.FindStringSubmatch(output); len(matches) > 1 {
+		r.FacilityCode = convert(matches[1])
+	}
+	if matches := cnRegex.FindStringSubmatch(output); len(matches) > 1 {
+		r.CardNumber = convert(matches[1])
+	}
+}
+
+// parseParity reads the parity result pm3 prints after a decoded Wiegand format
+func parseParity(r *CardRead, output string) {
+	parityRegex := regexp.MustCompile(`parity\s*\(\s*(ok|fail)`)
+	if matches := parityRegex.FindStringSubmatch(output); len(matches) > 1 {
+		r.ParityValid = boolPtr(matches[1] == "ok")
 	}
+}
+
+// bitLengthByFormat maps pm3 Wiegand format names to their bit length
+var bitLengthByFormat = map[string]int{
+	"H10301": 26, "H10302": 37, "H10304": 37, "H10306": 34,
+	"ATSW30": 30, "ADT31": 31, "D10202": 33, "C1k35s": 35,
+	"S12906": 36, "H800002": 46, "C1k48s": 48, "Avig56": 56,
+	"2804W": 28, "IR56": 56,
+}
+
+// parseHIDReaderOutput parses HID Prox card reader output
+func parseHIDReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
+
+	// Look for FC and Card Number - try "FC: 111 CN: 1111" on the same line first
+	parseFacilityAndCard(r, output, regexp.MustCompile(`FC:\s*(\d+).*?CN:\s*(\d+)`), atoiPtr)
 
 	// Look for format - try multiple patterns
 	// Pattern 1: "Format: H10301"
@@ -243,65 +225,53 @@ func parseHIDReaderOutput(output string) (map[string]interface{}, error) {
 	formatWordRegex := regexp.MustCompile(`\b(H10301|H10302|H10304|H10306|ATSW30|ADT31|D10202|C1k35s|S12906|H800002|C1k48s|Avig56|2804W|IR56)\b`)
 
 	if matches := formatRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["format"] = matches[1]
+		r.Format = matches[1]
 	} else if matches := formatBracketRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["format"] = matches[1]
+		r.Format = matches[1]
 	} else if matches := formatWordRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["format"] = matches[1]
+		r.Format = matches[1]
 	}
 
 	// Look for raw and wiegand data
-	rawRegex := regexp.MustCompile(`Raw:\s*([0-9a-fA-F]+)`)
+	rawRegex := regexp.MustCompile(`(?i)raw:\s*([0-9a-fA-F]+)`)
 	wiegandRegex := regexp.MustCompile(`Wiegand:\s*([0-9a-fA-F]+)`)
 
 	if matches := rawRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["raw"] = matches[1]
+		r.Raw = matches[1]
 	}
-
 	if matches := wiegandRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["wiegand"] = matches[1]
+		r.Wiegand = matches[1]
 	}
 
 	// Try to detect bit length from format
-	if format, ok := data["format"].(string); ok {
-		bitLengthMap := map[string]int{
-			"H10301": 26, "H10302": 37, "H10304": 37, "H10306": 34,
-			"ATSW30": 30, "ADT31": 31, "D10202": 33, "C1k35s": 35,
-			"S12906": 36, "H800002": 46, "C1k48s": 48, "Avig56": 56,
-			"2804W": 28, "IR56": 56,
-		}
-		if bl, exists := bitLengthMap[format]; exists {
-			data["bitLength"] = bl
-		}
+	if bl, exists := bitLengthByFormat[r.Format]; exists {
+		r.BitLength = intPtr(bl)
 	}
 
 	// Try to detect bit length from output text (e.g., "26-bit", "56-bit")
 	bitLengthRegex := regexp.MustCompile(`(\d+)[-\s]bit`)
-	if matches := bitLengthRegex.FindStringSubmatch(output); len(matches) > 1 {
-		if bl, err := strconv.Atoi(matches[1]); err == nil {
-			if _, exists := data["bitLength"]; !exists {
-				data["bitLength"] = bl
-			}
-		}
+	if matches := bitLengthRegex.FindStringSubmatch(output); len(matches) > 1 && r.BitLength == nil {
+		r.BitLength = atoiPtr(matches[1])
 	}
 
-	if len(data) == 0 {
+	parseParity(r, output)
+
+	if r.empty() {
 		return nil, fmt.Errorf("no card data found in output")
 	}
 
-	return data, nil
+	return r, nil
 }
 
 // parseICLASSReaderOutput parses iCLASS card dump output
 // The dump command automatically decodes block 7 and shows Wiegand FC/CN
-func parseICLASSReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+func parseICLASSReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
 
 	// Extract CSN (Card Serial Number)
 	csnRegex := regexp.MustCompile(`CSN:\s*([0-9a-fA-F\s]+)`)
 	if matches := csnRegex.FindStringSubmatch(output); len(matches) > 1 {
-		csn := strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
-		data["csn"] = csn
+		r.CSN = strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
 	}
 
 	// Extract decoded Wiegand format output
@@ -309,50 +279,18 @@ func parseICLASSReaderOutput(output string) (map[string]interface{}, error) {
 	formatFcCnRegex1 := regexp.MustCompile(`\[(\w+)\]\s+.*?FC:\s*(\d+).*?(?:Card|CN):\s*(\d+)`)
 	// Pattern 2: Format name with FC/CN without brackets (e.g., "H10301 FC: 123 Card: 456")
 	formatFcCnRegex2 := regexp.MustCompile(`(\w+)\s+.*?FC:\s*(\d+).*?(?:Card|CN):\s*(\d+)`)
-	// Pattern 3: "FC: 123 Card: 456" or "FC: 123 CN: 456"
-	fcCnRegex := regexp.MustCompile(`FC:\s*(\d+).*?(?:Card|CN):\s*(\d+)`)
-	// Pattern 4: Separate FC and Card lines
-	fcRegex := regexp.MustCompile(`FC:\s*(\d+)`)
-	cnRegex := regexp.MustCompile(`(?:Card|CN):\s*(\d+)`)
 
-	// Try combined format+FC+CN patterns first
+	// Try combined format+FC+CN patterns first, then FC+CN even if parity fails
 	if matches := formatFcCnRegex1.FindStringSubmatch(output); len(matches) >= 4 {
-		data["format"] = matches[1]
-		if fc, err := strconv.Atoi(matches[2]); err == nil && fc > 0 {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[3]); err == nil && cn > 0 {
-			data["cardNumber"] = cn
-		}
+		r.Format = matches[1]
+		r.FacilityCode = positivePtr(matches[2])
+		r.CardNumber = positivePtr(matches[3])
 	} else if matches := formatFcCnRegex2.FindStringSubmatch(output); len(matches) >= 4 {
-		// Try format without brackets
-		data["format"] = matches[1]
-		if fc, err := strconv.Atoi(matches[2]); err == nil && fc > 0 {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[3]); err == nil && cn > 0 {
-			data["cardNumber"] = cn
-		}
-	} else if matches := fcCnRegex.FindStringSubmatch(output); len(matches) >= 3 {
-		// Try FC+CN pattern - extract even if parity fails
-		if fc, err := strconv.Atoi(matches[1]); err == nil && fc > 0 {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[2]); err == nil && cn > 0 {
-			data["cardNumber"] = cn
-		}
+		r.Format = matches[1]
+		r.FacilityCode = positivePtr(matches[2])
+		r.CardNumber = positivePtr(matches[3])
 	} else {
-		// Try individual patterns
-		if matches := fcRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if fc, err := strconv.Atoi(matches[1]); err == nil && fc > 0 {
-				data["facilityCode"] = fc
-			}
-		}
-		if matches := cnRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if cn, err := strconv.Atoi(matches[1]); err == nil && cn > 0 {
-				data["cardNumber"] = cn
-			}
-		}
+		parseFacilityAndCard(r, output, regexp.MustCompile(`FC:\s*(\d+).*?(?:Card|CN):\s*(\d+)`), positivePtr)
 	}
 
 	// Look for format name - try multiple patterns
@@ -360,53 +298,42 @@ func parseICLASSReaderOutput(output string) (map[string]interface{}, error) {
 	formatBracketRegex := regexp.MustCompile(`\[(\w+)\]`)
 	formatWordRegex := regexp.MustCompile(`\b(H10301|H10302|H10304|H10306|ATSW30|ADT31|D10202|C1k35s|S12906|H800002|C1k48s)\b`)
 
-	if _, exists := data["format"]; !exists {
+	if r.Format == "" {
 		if matches := formatRegex.FindStringSubmatch(output); len(matches) > 1 {
-			data["format"] = matches[1]
+			r.Format = matches[1]
 		} else if matches := formatBracketRegex.FindStringSubmatch(output); len(matches) > 1 {
-			data["format"] = matches[1]
+			r.Format = matches[1]
 		} else if matches := formatWordRegex.FindStringSubmatch(output); len(matches) > 1 {
-			data["format"] = matches[1]
+			r.Format = matches[1]
 		}
 	}
 
 	// Map format to bit length
-	if format, ok := data["format"].(string); ok {
-		bitLengthMap := map[string]int{
-			"H10301": 26, "H10302": 37, "H10304": 37, "H10306": 34,
-			"ATSW30": 30, "ADT31": 31, "D10202": 33, "C1k35s": 35,
-			"S12906": 36, "H800002": 46, "C1k48s": 48,
-		}
-		if bl, exists := bitLengthMap[format]; exists {
-			data["bitLength"] = bl
-		}
+	if bl, exists := bitLengthByFormat[r.Format]; exists && r.Format != "Avig56" && r.Format != "2804W" && r.Format != "IR56" {
+		r.BitLength = intPtr(bl)
 	}
 
 	// Try to detect bit length from output text (e.g., "26-bit", "HID H10301 26-bit")
 	bitLengthRegex := regexp.MustCompile(`(\d+)[-\s]bit`)
-	if matches := bitLengthRegex.FindStringSubmatch(output); len(matches) > 1 {
-		if bl, err := strconv.Atoi(matches[1]); err == nil {
-			if _, exists := data["bitLength"]; !exists {
-				data["bitLength"] = bl
-			}
-		}
+	if matches := bitLengthRegex.FindStringSubmatch(output); len(matches) > 1 && r.BitLength == nil {
+		r.BitLength = atoiPtr(matches[1])
 	}
 
+	parseParity(r, output)
+
 	// Check if block 7 is encrypted (would need decrypt command)
-	if strings.Contains(output, "encrypted") || strings.Contains(output, "Block 7 decoder") == false {
-		// Check if card is encrypted (has CSN but no FC/CN)
-		if _, hasFC := data["facilityCode"]; !hasFC {
-			if _, hasCSN := data["csn"]; hasCSN {
-				data["encrypted"] = true
-			}
+	if strings.Contains(output, "encrypted") || !strings.Contains(output, "Block 7 decoder") {
+		// Card is encrypted when it has a CSN but no FC/CN
+		if r.FacilityCode == nil && r.CSN != "" {
+			r.Encrypted = true
 		}
 	}
 
-	if len(data) == 0 {
+	if r.empty() {
 		return nil, fmt.Errorf("no card data found in output")
 	}
 
-	return data, nil
+	return r, nil
 }
 
 // extractBlock7Hex extracts block 7 hex data from iCLASS dump/decrypt output
@@ -426,318 +353,190 @@ func extractBlock7Hex(output string) string {
 }
 
 // parseAWIDReaderOutput parses AWID card reader output
-func parseAWIDReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+func parseAWIDReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
 
-	// Try multiple patterns for FC and CN
-	fcRegex := regexp.MustCompile(`FC:\s*(\d+)`)
-	fcCnRegex := regexp.MustCompile(`FC:\s*(\d+).*?Card:\s*(\d+)`)
-	cnRegex := regexp.MustCompile(`(?:Card|CN):\s*(\d+)`)
-	rawRegex := regexp.MustCompile(`Raw:\s*([0-9a-fA-F]+)`)
+	rawRegex := regexp.MustCompile(`(?i)raw:\s*([0-9a-fA-F]+)`)
 	lenRegex := regexp.MustCompile(`(?:len|length):\s*(\d+)`)
 	bitLengthRegex := regexp.MustCompile(`(\d+)[-\s]bit`)
 
-	// Try combined pattern first
-	if matches := fcCnRegex.FindStringSubmatch(output); len(matches) >= 3 {
-		if fc, err := strconv.Atoi(matches[1]); err == nil {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[2]); err == nil {
-			data["cardNumber"] = cn
-		}
-	} else {
-		if matches := fcRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if fc, err := strconv.Atoi(matches[1]); err == nil {
-				data["facilityCode"] = fc
-			}
-		}
-		if matches := cnRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if cn, err := strconv.Atoi(matches[1]); err == nil {
-				data["cardNumber"] = cn
-			}
-		}
-	}
+	parseFacilityAndCard(r, output, regexp.MustCompile(`FC:\s*(\d+).*?Card:\s*(\d+)`), atoiPtr)
 
 	if matches := rawRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["raw"] = matches[1]
+		r.Raw = matches[1]
 	}
 
 	// Try multiple patterns for bit length
 	if matches := lenRegex.FindStringSubmatch(output); len(matches) > 1 {
-		if bl, err := strconv.Atoi(matches[1]); err == nil {
-			data["bitLength"] = bl
-		}
+		r.BitLength = atoiPtr(matches[1])
 	} else if matches := bitLengthRegex.FindStringSubmatch(output); len(matches) > 1 {
-		if bl, err := strconv.Atoi(matches[1]); err == nil {
-			data["bitLength"] = bl
-		}
-	} else {
+		r.BitLength = atoiPtr(matches[1])
+	} else if strings.Contains(output, "50") || strings.Contains(output, "fifty") {
 		// AWID is typically 26 or 50 bit
-		if strings.Contains(output, "50") || strings.Contains(output, "fifty") {
-			data["bitLength"] = 50
-		} else {
-			data["bitLength"] = 26 // Default for AWID
-		}
-	}
-
-	if len(data) == 0 {
-		return nil, fmt.Errorf("no card data found in output")
+		r.BitLength = intPtr(50)
+	} else {
+		r.BitLength = intPtr(26) // Default for AWID
 	}
 
-	return data, nil
+	return r, nil
 }
 
 // parseIndalaReaderOutput parses Indala card reader output
-func parseIndalaReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+func parseIndalaReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
 
-	fcRegex := regexp.MustCompile(`FC:\s*(\d+)`)
-	fcCnRegex := regexp.MustCompile(`FC:\s*(\d+).*?Card:\s*(\d+)`)
-	cnRegex := regexp.MustCompile(`(?:Card|CN):\s*(\d+)`)
-	rawRegex := regexp.MustCompile(`Raw:\s*([0-9a-fA-F]+)`)
+	rawRegex := regexp.MustCompile(`(?i)raw:\s*([0-9a-fA-F]+)`)
 	lenRegex := regexp.MustCompile(`(?:\(len\s+(\d+)\)|len:\s*(\d+)|(\d+)[-\s]bit)`)
 
-	// Try combined pattern first
-	if matches := fcCnRegex.FindStringSubmatch(output); len(matches) >= 3 {
-		if fc, err := strconv.Atoi(matches[1]); err == nil {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[2]); err == nil {
-			data["cardNumber"] = cn
-		}
-	} else {
-		if matches := fcRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if fc, err := strconv.Atoi(matches[1]); err == nil {
-				data["facilityCode"] = fc
-			}
-		}
-		if matches := cnRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if cn, err := strconv.Atoi(matches[1]); err == nil {
-				data["cardNumber"] = cn
-			}
-		}
-	}
+	parseFacilityAndCard(r, output, regexp.MustCompile(`FC:\s*(\d+).*?Card:\s*(\d+)`), atoiPtr)
 
 	if matches := rawRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["raw"] = matches[1]
+		r.Raw = matches[1]
 	}
 
 	// Try multiple patterns for bit length
 	if matches := lenRegex.FindStringSubmatch(output); len(matches) > 1 {
-		for i := 1; i < len(matches); i++ {
-			if matches[i] != "" {
-				if bl, err := strconv.Atoi(matches[i]); err == nil {
-					data["bitLength"] = bl
-					break
-				}
+		for _, match := range matches[1:] {
+			if bl := atoiPtr(match); bl != nil {
+				r.BitLength = bl
+				break
 			}
 		}
 	}
 
-	if len(data) == 0 {
+	if r.empty() {
 		return nil, fmt.Errorf("no card data found in output")
 	}
 
-	return data, nil
+	return r, nil
 }
 
 // parseAvigilonReaderOutput parses Avigilon card reader output
-func parseAvigilonReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+func parseAvigilonReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
 
 	// Avigilon uses Avig56 format
 	if strings.Contains(output, "Avig56") || strings.Contains(output, "Avigilon") {
-		data["format"] = "Avig56"
-		data["bitLength"] = 56
+		r.Format = "Avig56"
 	}
 
-	// Try multiple patterns for FC and CN
-	fcRegex := regexp.MustCompile(`FC:\s*(\d+)`)
-	fcCnRegex := regexp.MustCompile(`FC:\s*(\d+).*?CN:\s*(\d+)`)
-	cnRegex := regexp.MustCompile(`(?:Card|CN):\s*(\d+)`)
-	rawRegex := regexp.MustCompile(`Raw:\s*([0-9a-fA-F]+)`)
+	rawRegex := regexp.MustCompile(`(?i)raw:\s*([0-9a-fA-F]+)`)
+	wiegandRegex := regexp.MustCompile(`Wiegand:\s*([0-9a-fA-F]+)`)
 
-	// Try combined pattern first
-	if matches := fcCnRegex.FindStringSubmatch(output); len(matches) >= 3 {
-		if fc, err := strconv.Atoi(matches[1]); err == nil {
-			data["facilityCode"] = fc
-		}
-		if cn, err := strconv.Atoi(matches[2]); err == nil {
-			data["cardNumber"] = cn
-		}
-	} else {
-		if matches := fcRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if fc, err := strconv.Atoi(matches[1]); err == nil {
-				data["facilityCode"] = fc
-			}
-		}
-		if matches := cnRegex.FindStringSubmatch(output); len(matches) > 1 {
-			if cn, err := strconv.Atoi(matches[1]); err == nil {
-				data["cardNumber"] = cn
-			}
-		}
-	}
+	parseFacilityAndCard(r, output, regexp.MustCompile(`FC:\s*(\d+).*?CN:\s*(\d+)`), atoiPtr)
 
 	if matches := rawRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["raw"] = matches[1]
+		r.Raw = matches[1]
 	}
-
-	// Ensure bit length is set
-	if _, exists := data["bitLength"]; !exists {
-		data["bitLength"] = 56
+	if matches := wiegandRegex.FindStringSubmatch(output); len(matches) > 1 {
+		r.Wiegand = matches[1]
 	}
 
-	if len(data) == 0 {
-		return nil, fmt.Errorf("no card data found in output")
-	}
+	// Avigilon is always 56-bit
+	r.BitLength = intPtr(56)
 
-	return data, nil
+	parseParity(r, output)
+
+	return r, nil
 }
 
 // parseEM4100ReaderOutput parses EM4100 card reader output
-func parseEM4100ReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+func parseEM4100ReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
 
-	// Look for EM 410x ID
+	// Look for EM 410x ID, then the alternative "ID:" format
 	idRegex := regexp.MustCompile(`EM\s+410x\s+ID\s+([0-9a-fA-F]+)`)
+	idRegex2 := regexp.MustCompile(`ID:\s*([0-9a-fA-F]+)`)
 	if matches := idRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["hexData"] = strings.ToUpper(matches[1])
-		data["bitLength"] = 32 // EM4100 is 32-bit
-	} else {
-		// Try alternative format
-		idRegex2 := regexp.MustCompile(`ID:\s*([0-9a-fA-F]+)`)
-		if matches := idRegex2.FindStringSubmatch(output); len(matches) > 1 {
-			data["hexData"] = strings.ToUpper(matches[1])
-			data["bitLength"] = 32
-		}
+		r.HexData = strings.ToUpper(matches[1])
+	} else if matches := idRegex2.FindStringSubmatch(output); len(matches) > 1 {
+		r.HexData = strings.ToUpper(matches[1])
 	}
 
-	if len(data) == 0 {
+	if r.HexData == "" {
 		return nil, fmt.Errorf("no card data found in output")
 	}
+	r.BitLength = intPtr(32) // EM4100 is 32-bit
 
-	return data, nil
+	return r, nil
 }
 
 // parseMIFAREReaderOutput parses MIFARE/PIV card reader output
-func parseMIFAREReaderOutput(output string) (map[string]interface{}, error) {
-	data := make(map[string]interface{})
+func parseMIFAREReaderOutput(output string) (*CardRead, error) {
+	r := &CardRead{}
 
 	// Look for UID
 	uidRegex := regexp.MustCompile(`UID:\s*([0-9a-fA-F\s]+)`)
 	if matches := uidRegex.FindStringSubmatch(output); len(matches) > 1 {
-		uid := strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
-		data["uid"] = strings.ToUpper(uid)
+		r.UID = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", ""))
 	}
 
 	// Look for ATQA, SAK, etc.
 	atqaRegex := regexp.MustCompile(`ATQA:\s*([0-9a-fA-F\s]+)`)
 	if matches := atqaRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["atqa"] = strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
+		r.ATQA = strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
 	}
 
 	sakRegex := regexp.MustCompile(`SAK:\s*([0-9a-fA-F\s]+)`)
 	if matches := sakRegex.FindStringSubmatch(output); len(matches) > 1 {
-		data["sak"] = strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
+		r.SAK = strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", "")
 	}
 
-	if len(data) == 0 {
+	if r.empty() {
 		return nil, fmt.Errorf("no card data found in output")
 	}
 
-	return data, nil
+	return r, nil
 }
 
 // displayCardData displays the parsed card data in a user-friendly format
-func displayCardData(cardType string, cardData map[string]interface{}) {
-	WriteStatusSuccess("Card read successfully!")
-	WriteStatusInfo("")
-	WriteStatusInfo("--- Card Data ---")
-
-	// Map internal card type to display name
-	cardTypeMap := map[string]string{
-		"prox":     "PROX",
-		"iclass":   "iCLASS",
-		"awid":     "AWID",
-		"indala":   "Indala",
-		"avigilon": "Avigilon",
-		"em":       "EM4100 / Net2",
-		"piv":      "PIV",
-		"mifare":   "MIFARE",
-	}
-
-	displayType := cardTypeMap[cardType]
-	if displayType == "" {
-		displayType = strings.ToUpper(cardType)
-	}
+func displayCardData(ctx context.Context, r *CardRead) {
+	WriteStatusSuccess(ctx, "Card read successfully!")
+	WriteStatusInfo(ctx, "")
+	WriteStatusInfo(ctx, "--- Card Data ---")
+
 	// Always show Card Type
-	WriteStatusInfo("Card Type: %s", displayType)
+	WriteStatusInfo(ctx, "Card Type: %s", cardTypeDisplayName(r.CardType))
 
 	// Only show FC/CN/Bit Length for card types that use them
 	// MIFARE and PIV use UID/ATQA/SAK instead
-	if cardType != "mifare" && cardType != "piv" {
-		if fc, ok := cardData["facilityCode"].(int); ok {
-			WriteStatusInfo("Facility Code: %d", fc)
-		} else {
-			WriteStatusInfo("Facility Code: N/A")
-		}
-
-		if cn, ok := cardData["cardNumber"].(int); ok {
-			WriteStatusInfo("Card Number: %d", cn)
-		} else {
-			WriteStatusInfo("Card Number: N/A")
-		}
-
-		if bl, ok := cardData["bitLength"].(int); ok {
-			WriteStatusInfo("Bit Length: %d", bl)
-		} else {
-			WriteStatusInfo("Bit Length: N/A")
+	if ct, ok := lookupCardType(r.CardType); !ok || ct.Input() != InputUID {
+		optional := func(v *int) string {
+			if v == nil {
+				return "N/A"
+			}
+			return strconv.Itoa(*v)
 		}
+		WriteStatusInfo(ctx, "Facility Code: %s", optional(r.FacilityCode))
+		WriteStatusInfo(ctx, "Card Number: %s", optional(r.CardNumber))
+		WriteStatusInfo(ctx, "Bit Length: %s", optional(r.BitLength))
 	}
 
-	// Show additional card-specific data
-	switch cardType {
-	case "prox", "avigilon":
-		if format, ok := cardData["format"].(string); ok {
-			WriteStatusInfo("Format: %s", format)
-		}
-		if raw, ok := cardData["raw"].(string); ok {
-			WriteStatusInfo("Raw: %s", raw)
-		}
-		if wiegand, ok := cardData["wiegand"].(string); ok {
-			WriteStatusInfo("Wiegand: %s", wiegand)
-		}
-
-	case "iclass":
-		if csn, ok := cardData["csn"].(string); ok {
-			WriteStatusInfo("CSN: %s", csn)
-		}
-		if format, ok := cardData["format"].(string); ok {
-			WriteStatusInfo("Format: %s", format)
-		}
-
-	case "awid", "indala":
-		if raw, ok := cardData["raw"].(string); ok {
-			WriteStatusInfo("Raw: %s", raw)
-		}
-
-	case "em":
-		if hexData, ok := cardData["hexData"].(string); ok {
-			WriteStatusInfo("Hex Data: %s", hexData)
-		}
-
-	case "piv", "mifare":
-		if uid, ok := cardData["uid"].(string); ok {
-			WriteStatusInfo("UID: %s", uid)
-		}
-		if atqa, ok := cardData["atqa"].(string); ok {
-			WriteStatusInfo("ATQA: %s", atqa)
+	// Show additional card-specific data; each parser only sets the fields its card has
+	extraFields := []struct{ label, value string }{
+		{"CSN", r.CSN},
+		{"Format", r.Format},
+		{"Raw", r.Raw},
+		{"Wiegand", r.Wiegand},
+		{"Bits", r.Bits},
+		{"Hex Data", r.HexData},
+		{"UID", r.UID},
+		{"ATQA", r.ATQA},
+		{"SAK", r.SAK},
+	}
+	for _, field := range extraFields {
+		if field.value != "" {
+			WriteStatusInfo(ctx, "%s: %s", field.label, field.value)
 		}
-		if sak, ok := cardData["sak"].(string); ok {
-			WriteStatusInfo("SAK: %s", sak)
+	}
+	if r.ParityValid != nil {
+		if *r.ParityValid {
+			WriteStatusInfo(ctx, "Parity: OK")
+		} else {
+			WriteStatusInfo(ctx, "Parity: FAIL")
 		}
 	}
 
-	WriteStatusInfo("")
-	WriteStatusSuccess("Use this data to write or verify cards")
+	WriteStatusInfo(ctx, "")
+	WriteStatusSuccess(ctx, "Use this data to write or verify cards")
 }
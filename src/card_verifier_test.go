@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// iclassTranscript dumps an iCLASS card with an encrypted credential, which is decrypted to
+// HID H10301 FC 118 CN 1603
+const iclassTranscript = `pm3 --> hf iclass dump --ki 0
+[+]    CSN: 75 D0 12 00 F7 FF 12 E0
+[+]  7/0x07 | 3A 1F 9C 22 0B 5E 71 D4 | Enc Cred
+[+] Saved 256 bytes to binary file ` + "`/tmp/hf-iclass-75D01200F7FF12E0-dump.bin`" + `
+pm3 --> hf iclass decrypt -f /tmp/hf-iclass-75D01200F7FF12E0-dump.bin
+[+] [H10301  ] HID H10301 26-bit                FC: 118  CN: 1603  parity ( ok )
+`
+
+func TestVerifyCardData(t *testing.T) {
+	tests := []struct {
+		name       string
+		cardType   string
+		params     CardParams
+		transcript string
+		wantErr    error
+	}{
+		{"prox match", "prox", CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, hidTranscript, nil},
+		{"prox card number mismatch", "prox", CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1604}, hidTranscript, ErrVerifyMismatch},
+		{"prox facility code mismatch", "prox", CardParams{BitLength: 26, FacilityCode: 42, CardNumber: 1603}, hidTranscript, ErrVerifyMismatch},
+		{"prox no card", "prox", CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, "pm3 --> lf hid reader\n[!] No data found!\n", ErrNoCardPresent},
+		{"mifare match", "mifare", CardParams{UID: "04a1b2c3"}, "pm3 --> hf mf info\n[+]  UID: 04 A1 B2 C3\n", nil},
+		{"mifare mismatch", "mifare", CardParams{UID: "04A1B2C4"}, "pm3 --> hf mf info\n[+]  UID: 04 A1 B2 C3\n", ErrVerifyMismatch},
+		{"iclass decrypted match", "iclass", CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, iclassTranscript, nil},
+		{"iclass decrypted mismatch", "iclass", CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1604}, iclassTranscript, ErrVerifyMismatch},
+		{"iclass undecodable", "iclass", CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, "pm3 --> hf iclass dump --ki 0\n[+]    CSN: 75 D0 12 00 F7 FF 12 E0\n", ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, err := loadPm3Transcript(strings.NewReader(tt.transcript))
+			if err != nil {
+				t.Fatal(err)
+			}
+			useFakePm3(t, fake)
+
+			ct, _ := lookupCardType(tt.cardType)
+			err = verifyCardData(context.Background(), ct, tt.params)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
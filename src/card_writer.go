@@ -1,268 +1,125 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
 	"time"
 )
 
-func writeProxmark3Command(command string) (string, error) {
-	if ok, msg := checkProxmark3(); !ok {
-		return "", fmt.Errorf("%s", msg)
+func writeProxmark3Command(ctx context.Context, command string) (string, error) {
+	if err := checkProxmark3(ctx); err != nil {
+		return "", err
 	}
 
-	pm3Binary, err := getPm3Path()
+	output, err := runPm3(ctx, command)
 	if err != nil {
-		return "", fmt.Errorf("failed to find pm3 binary: %w", err)
+		return output, pm3Failure(output, err, ErrWriteFailed)
 	}
-
-	device, err := getPm3Device()
-	if err != nil {
-		return "", fmt.Errorf("failed to detect pm3 device: %w", err)
-	}
-
-	cmd := exec.Command(pm3Binary, "-c", command, "-p", device)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return string(output), fmt.Errorf("error writing to card: %w. Output: %s", err, output)
-	}
-	return string(output), nil
+	return output, nil
 }
 
 // waitForProxmark3 checks if Proxmark3 is available with retries.
-func waitForProxmark3(maxRetries int) bool {
+func waitForProxmark3(ctx context.Context, maxRetries int) bool {
 	for i := 0; i < maxRetries; i++ {
-		pm3Binary, err := getPm3Path()
-		if err != nil {
-			if i < maxRetries-1 {
-				fmt.Printf("Waiting for Proxmark3 to be ready... (attempt %d/%d)\n", i+1, maxRetries)
-				time.Sleep(2 * time.Second)
-			}
-			continue
-		}
-
-		device, err := getPm3Device()
-		if err != nil {
-			if i < maxRetries-1 {
-				fmt.Printf("Waiting for Proxmark3 to be ready... (attempt %d/%d)\n", i+1, maxRetries)
-				time.Sleep(2 * time.Second)
-			}
-			continue
-		}
-
-		cmd := exec.Command(pm3Binary, "-c", "hw status", "-p", device)
-		output, err := cmd.CombinedOutput()
-		if err == nil && !strings.Contains(string(output), "cannot communicate") {
+		output, err := runPm3(ctx, "hw status")
+		if err == nil && !errors.Is(classifyPm3Output(output, nil), ErrDeviceOffline) {
 			return true
 		}
 		if i < maxRetries-1 {
-			fmt.Printf("Waiting for Proxmark3 to be ready... (attempt %d/%d)\n", i+1, maxRetries)
+			emitOutput(ctx, fmt.Sprintf("Waiting for Proxmark3 to be ready... (attempt %d/%d)", i+1, maxRetries))
 			time.Sleep(2 * time.Second)
 		}
 	}
 	return false
 }
 
-func writeCardData(cardType string, cardData uint64, bitLength int, facilityCode int, cardNumber int, hexData string, verify bool, formatCodeOrUID string) {
-	switch cardType {
-	case "iclass":
-		fmt.Println("\n|----------- WRITE -----------|")
-		WriteStatusProgress("Encoding iCLASS card data...")
-		formatCode := formatCodeOrUID
-		command := fmt.Sprintf("hf iclass encode -w %s --fc %d --cn %d --ki 0", formatCode, facilityCode, cardNumber)
-		output, err := writeProxmark3Command(command)
+// WriteResult is the result payload of a write
+type WriteResult struct {
+	CardType string     `json:"cardType"`
+	Params   CardParams `json:"params"`
+	Attempts int        `json:"attempts"`
+}
+
+// writeCardData writes a credential to a blank card. Low frequency cards are written several
+// times as the T5577 is moved over the antenna; the rest are written once. Writing several
+// times fails only when every attempt failed.
+func writeCardData(ctx context.Context, ct CardType, p CardParams, verify bool) error {
+	command, err := ct.WriteCommand(p)
+	if err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, fmt.Errorf("%w: %v", ErrInvalidInput, err))
+	}
+
+	attempts := ct.WriteAttempts()
+	if attempts > 1 {
+		// T5577 cards are written the configured number of times
+		attempts = writeAttempts()
+	}
+	if attempts <= 1 {
+		emitOutput(ctx, "\n|----------- WRITE -----------|")
+		WriteStatusProgress(ctx, "Writing %s card...", ct.DisplayName())
+		output, err := writeProxmark3Command(ctx, command)
+		if isCancelled(err) {
+			emitOutput(ctx, output)
+			WriteStatusInfo(ctx, "Operation cancelled by user")
+			return err
+		}
 		if err != nil {
-			WriteStatusError("Failed to write iCLASS card: %v", err)
-			fmt.Println(output)
+			WriteStatusError(ctx, "Failed to write %s card: %v", ct.DisplayName(), err)
+			emitOutput(ctx, output)
+			return emitFailure(ctx, err)
+		}
+		emitOutput(ctx, output)
+		if verify {
+			WriteStatusSuccess(ctx, "Write complete - starting verification")
 		} else {
-			fmt.Println(output)
-			if verify {
-				WriteStatusSuccess("Write complete - starting verification")
-			} else {
-				WriteStatusSuccess("Write complete")
-			}
+			WriteStatusSuccess(ctx, "Write complete")
 		}
-	case "prox":
-		WriteStatusProgress("Writing Prox card (5 attempts)...")
+		emitResult(ctx, "Card written", WriteResult{CardType: ct.Name(), Params: p, Attempts: 1})
+		return nil
+	}
 
-		for i := 0; i < 5; i++ {
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			fmt.Printf("\n|----------- WRITE #%d -----------|\n", i+1)
-			var output string
-			var err error
-			if bitLength == 26 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w H10301 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 30 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w ATSW30 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 31 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w ADT31 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 33 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w D10202 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 34 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w H10306 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 35 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w C1k35s --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 36 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w S12906 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 37 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w H10304 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 46 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w H800002 --fc %d --cn %d", facilityCode, cardNumber))
-			} else if bitLength == 48 {
-				output, err = writeProxmark3Command(fmt.Sprintf("lf hid clone -w C1k48s --fc %d --cn %d", facilityCode, cardNumber))
-			}
-			if err != nil {
-				WriteStatusError("Write attempt #%d failed: %v", i+1, err)
-			} else {
-				fmt.Println(output)
-			}
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			time.Sleep(1 * time.Second)
-			if i < 4 {
-				WriteStatusProgress("Move card slowly... Write attempt #%d complete", i+1)
-			} else {
-				if verify {
-					WriteStatusSuccess("All 5 write attempts complete - starting verification")
-				} else {
-					WriteStatusSuccess("All 5 write attempts complete")
-				}
-			}
+	WriteStatusProgress(ctx, "Writing %s card (%d attempts)...", ct.DisplayName(), attempts)
+	var lastErr error
+	written := false
+	for i := 0; i < attempts; i++ {
+		if ctx.Err() != nil {
+			WriteStatusInfo(ctx, "Operation cancelled by user")
+			return ctx.Err()
 		}
-	case "awid":
-		WriteStatusProgress("Writing AWID card (5 attempts)...")
-		for i := 0; i < 5; i++ {
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			fmt.Printf("\n|----------- WRITE #%d -----------|\n", i+1)
-			output, err := writeProxmark3Command(fmt.Sprintf("lf awid clone --fmt 26 --fc %d --cn %d", facilityCode, cardNumber))
-			if err != nil {
-				WriteStatusError("Write attempt #%d failed: %v", i+1, err)
-			} else {
-				fmt.Println(output)
-			}
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			time.Sleep(1 * time.Second)
-			if i < 4 {
-				WriteStatusProgress("Move card slowly... Write attempt #%d complete", i+1)
-			} else {
-				if verify {
-					WriteStatusSuccess("All 5 write attempts complete - starting verification")
-				} else {
-					WriteStatusSuccess("All 5 write attempts complete")
-				}
-			}
+		emitOutput(ctx, fmt.Sprintf("\n|----------- WRITE #%d -----------|", i+1))
+		output, err := writeProxmark3Command(ctx, command)
+		if isCancelled(err) {
+			emitOutput(ctx, output)
+			WriteStatusInfo(ctx, "Operation cancelled by user")
+			return err
 		}
-	case "indala":
-		WriteStatusProgress("Writing Indala card (5 attempts)...")
-		for i := 0; i < 5; i++ {
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			fmt.Printf("\n|----------- WRITE #%d -----------|\n", i+1)
-			output, err := writeProxmark3Command(fmt.Sprintf("lf indala clone --fc %d --cn %d", facilityCode, cardNumber))
-			if err != nil {
-				WriteStatusError("Write attempt #%d failed: %v", i+1, err)
-			} else {
-				fmt.Println(output)
-			}
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			time.Sleep(1 * time.Second)
-			if i < 4 {
-				WriteStatusProgress("Move card slowly... Write attempt #%d complete", i+1)
-			} else {
-				if verify {
-					WriteStatusSuccess("All 5 write attempts complete - starting verification")
-				} else {
-					WriteStatusSuccess("All 5 write attempts complete")
-				}
-			}
+		if err != nil {
+			WriteStatusError(ctx, "Write attempt #%d failed: %v", i+1, err)
+			lastErr = err
+		} else {
+			emitOutput(ctx, output)
+			written = true
 		}
-	case "avigilon":
-		WriteStatusProgress("Writing Avigilon card (5 attempts)...")
-		for i := 0; i < 5; i++ {
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			fmt.Printf("\n|----------- WRITE #%d -----------|\n", i+1)
-			output, err := writeProxmark3Command(fmt.Sprintf("lf hid clone -w Avig56 --fc %d --cn %d", facilityCode, cardNumber))
-			if err != nil {
-				WriteStatusError("Write attempt #%d failed: %v", i+1, err)
-			} else {
-				fmt.Println(output)
-			}
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			time.Sleep(1 * time.Second)
-			if i < 4 {
-				WriteStatusProgress("Move card slowly... Write attempt #%d complete", i+1)
-			} else {
-				if verify {
-					WriteStatusSuccess("All 5 write attempts complete - starting verification")
-				} else {
-					WriteStatusSuccess("All 5 write attempts complete")
-				}
-			}
+		if ctx.Err() != nil {
+			WriteStatusInfo(ctx, "Operation cancelled by user")
+			return ctx.Err()
 		}
-	case "em":
-		WriteStatusProgress("Writing EM4100 / Net2 card (5 attempts)...")
-		for i := 0; i < 5; i++ {
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			fmt.Printf("\n|----------- WRITE #%d -----------|\n", i+1)
-			output, err := writeProxmark3Command(fmt.Sprintf("lf em 410x clone --id %s", hexData))
-			if err != nil {
-				WriteStatusError("Write attempt #%d failed: %v", i+1, err)
-			} else {
-				fmt.Println(output)
-			}
-			if IsOperationCancelled() {
-				WriteStatusInfo("Operation cancelled by user")
-				return
-			}
-			time.Sleep(1 * time.Second)
-			if i < 4 {
-				WriteStatusProgress("Move card slowly... Write attempt #%d complete", i+1)
+		time.Sleep(writePause())
+		if i < attempts-1 {
+			WriteStatusProgress(ctx, "Move card slowly... Write attempt #%d complete", i+1)
+		} else {
+			if verify {
+				WriteStatusSuccess(ctx, "All %d write attempts complete - starting verification", attempts)
 			} else {
-				if verify {
-					WriteStatusSuccess("All 5 write attempts complete - starting verification")
-				} else {
-					WriteStatusSuccess("All 5 write attempts complete")
-				}
+				WriteStatusSuccess(ctx, "All %d write attempts complete", attempts)
 			}
 		}
-	case "piv", "mifare":
-		fmt.Println("\n|----------- WRITE -----------|")
-		WriteStatusProgress("Writing UID to card...")
-		uid := formatCodeOrUID
-		command := fmt.Sprintf("hf mf csetuid -u %s", uid)
-		output, err := writeProxmark3Command(command)
-		if err != nil {
-			WriteStatusError("Failed to write UID: %v", err)
-			fmt.Println(output)
-		} else {
-			fmt.Println(output)
-			WriteStatusSuccess("UID written successfully")
-		}
 	}
+	if !written {
+		return emitFailure(ctx, lastErr)
+	}
+	emitResult(ctx, "Card written", WriteResult{CardType: ct.Name(), Params: p, Attempts: attempts})
+	return nil
 }
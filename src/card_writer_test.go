@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWriteCardDataRetries(t *testing.T) {
+	const clone = "lf hid clone -w H10301 --fc 118 --cn 1603"
+	failed := fmt.Errorf("exit status 1")
+	tests := []struct {
+		name     string
+		attempts int
+		replies  []fakePm3Response
+		wantErr  error
+	}{
+		{"first attempt succeeds", 3, []fakePm3Response{{Output: "[+] Done"}}, nil},
+		{"later attempt succeeds", 3, []fakePm3Response{{Err: failed}, {Err: failed}, {Output: "[+] Done"}}, nil},
+		{"every attempt fails", 3, []fakePm3Response{{Err: failed}}, ErrWriteFailed},
+		{"single attempt", 1, []fakePm3Response{{Output: "[+] Done"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakePm3Runner()
+			for _, reply := range tt.replies {
+				fake.RespondError(clone, reply.Output, reply.Err)
+			}
+			useFakePm3(t, fake)
+			c := currentConfig()
+			c.WriteAttempts = intPtr(tt.attempts)
+			applyConfig(c)
+
+			prox, _ := lookupCardType("prox")
+			err := writeCardData(context.Background(), prox, CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, false)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			// the T5577 is written every attempt, even after one succeeded
+			if got := len(fake.Commands()); got != tt.attempts {
+				t.Errorf("wrote %d times, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
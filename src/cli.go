@@ -0,0 +1,746 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// cliCommand is a subcommand of the CLI, e.g. "doppelganger_assistant read -t prox". setup
+// defines the command's flags and returns the function run once they are parsed.
+type cliCommand struct {
+	name  string
+	args  string // argument synopsis for usage
+	help  string
+	setup func(fs *flag.FlagSet) func(ctx context.Context, args []string) error
+}
+
+// cliCommands are the subcommands, in the order they are listed in usage
+var cliCommands = []cliCommand{
+	{"read", "-t <card type> [-o <file>]", "Read a card and show its credential", setupReadCommand},
+	{"detect", "", "Detect the card type, including both chips of dual-frequency cards", setupDetectCommand},
+	{"write", "-t <card type> <card values> [-v]", "Write a credential to a card, optionally verifying it", setupWriteCommand},
+	{"verify", "-t <card type> <card values>", "Check that a card holds the given credential", setupVerifyCommand},
+	{"sim", "-t <card type> <card values>", "Simulate a credential until the Proxmark3 button is pressed", setupSimCommand},
+	{"import", "-f <log> [-n <entry> [-w] [-v] [-s]]", "List the credentials in a Doppelgänger capture log, or write, verify or simulate one", setupImportCommand},
+	{"recover", "[-m <method>]", "Recover MIFARE Classic keys from a hotel key card", setupRecoverCommand},
+	{"fchk", "[-k <key file>]", "Check keys on a MIFARE Classic card (hf mf fchk)", setupFchkCommand},
+	{"info", "", "Show MIFARE Classic card details (hf mf info)", setupInfoCommand},
+	{"setuid", "<uid>", "Set the UID of a magic MIFARE Classic card", setupSetUIDCommand},
+	{"restore", "[-f <dump file>] [-k <key file>] [-wipe=false]", "Write a MIFARE Classic dump to a card and verify it", setupRestoreCommand},
+	{"sniff", "", "Sniff HF reader-card traffic until the Proxmark3 button is pressed", setupSniffCommand},
+	{"vault", "[-export <file> -o <path>]", "List the files in the vault, or decrypt one of them", setupVaultCommand},
+	{"note", "<text>", "Add an operator note to the engagement workspace", setupNoteCommand},
+	{"report", "[-o <file>] [-html] [-redact]", "Write an engagement report of the workspace as Markdown or HTML", setupReportCommand},
+	{"verify-log", "[-f <audit log>]", "Check that the audit log of pm3 commands has not been changed", setupVerifyLogCommand},
+	{"config", "", "Show the config file and the settings in effect", setupConfigCommand},
+	{"serve", "[-addr <host:port>] [-token <token>]", "Serve a REST API for read, detect, write, verify, sim and recover", setupServeCommand},
+	{"tui", "", "Full-screen terminal UI with the sections of the GUI, e.g. over SSH", setupTUICommand},
+	{"repl", "", "Interactive shell with tab completion and history, e.g. read prox then clone", setupREPLCommand},
+}
+
+// cliSessions are commands that run operations until the user stops them rather than being an
+// operation themselves, so only their own error sets the exit code. The value is true for
+// sessions that show events themselves instead of having them printed.
+var cliSessions = map[string]bool{"serve": false, "tui": true, "repl": false}
+
+// hotelRecoveryMethods are the methods accepted by recover -m
+var hotelRecoveryMethods = []string{"autopwn", "darkside", "nested", "hardnested", "staticnested", "brute", "nack"}
+
+func lookupCLICommand(name string) (cliCommand, bool) {
+	for _, c := range cliCommands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return cliCommand{}, false
+}
+
+// cliUsage lists the subcommands
+func cliUsage() {
+	fmt.Fprintf(os.Stderr, Yellow+"Usage: %s <command> [flags]\n"+Reset, os.Args[0])
+	fmt.Fprintf(os.Stderr, "\n")
+	fmt.Fprintf(os.Stderr, Green+"Commands:\n"+Reset)
+	for _, c := range cliCommands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\n")
+	fmt.Fprintf(os.Stderr, "Run '%s help <command>' for the flags of a command.\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Run '%s -h' for the original flag interface.\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\n")
+	exitCodeUsage()
+}
+
+// exitCodeUsage documents the exit codes, shared by both CLIs
+func exitCodeUsage() {
+	fmt.Fprintf(os.Stderr, Green+"Exit codes:\n"+Reset)
+	fmt.Fprintf(os.Stderr, "  %-3d success\n", exitOK)
+	fmt.Fprintf(os.Stderr, "  %-3d failure not listed below\n", exitFailure)
+	fmt.Fprintf(os.Stderr, "  %-3d invalid input (flags, card values or files)\n", exitInvalidInput)
+	fmt.Fprintf(os.Stderr, "  %-3d Proxmark3 client not found or device offline\n", exitNoDevice)
+	fmt.Fprintf(os.Stderr, "  %-3d no card present or card not readable\n", exitNoCard)
+	fmt.Fprintf(os.Stderr, "  %-3d verification found a different credential\n", exitVerifyMismatch)
+	fmt.Fprintf(os.Stderr, "  %-3d authentication failed or no keys recovered\n", exitAuthFailed)
+	fmt.Fprintf(os.Stderr, "  %-3d write failed\n", exitWriteFailed)
+	fmt.Fprintf(os.Stderr, "  %-3d card format not supported\n", exitUnsupportedFormat)
+	fmt.Fprintf(os.Stderr, "  %-3d blocked by the engagement's scope policy\n", exitOutOfScope)
+	fmt.Fprintf(os.Stderr, "  %-3d audit log chain broken\n", exitAuditLogBroken)
+	fmt.Fprintf(os.Stderr, "  %-3d cancelled with Ctrl-C\n", exitCancelled)
+}
+
+// cliOutcome collects the results reported by the operations of a CLI run, for its exit code
+// and JSON report
+type cliOutcome struct {
+	mu          sync.Mutex
+	operation   int
+	err         error
+	result      interface{}
+	unsubscribe func()
+}
+
+// collectOutcome returns an outcome that records the results the given operation publishes
+// from now on, or those of every operation when it is 0
+func collectOutcome(operation int) *cliOutcome {
+	o := &cliOutcome{operation: operation}
+	o.unsubscribe = events.Subscribe(o.record)
+	return o
+}
+
+// close stops recording results, for runs that collect one outcome per command
+func (o *cliOutcome) close() {
+	o.unsubscribe()
+}
+
+// record keeps the first failure and the last result payload
+func (o *cliOutcome) record(e Event) {
+	if e.Kind != EventResult || (o.operation != 0 && e.Operation != o.operation) {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if e.Err != nil && o.err == nil {
+		o.err = e.Err
+	}
+	if e.Payload != nil {
+		o.result = e.Payload
+	}
+}
+
+// printError prints the error a run ended with, unless an operation already reported it as
+// its result, followed by what to do about it
+func (o *cliOutcome) printError(w io.Writer, err error) {
+	if err == nil || isCancelled(err) {
+		return
+	}
+	o.mu.Lock()
+	reported := err == o.err
+	o.mu.Unlock()
+	if !reported {
+		fmt.Fprintln(w, Red, err, Reset)
+	}
+	if hint := remediation(err); hint != "" {
+		fmt.Fprintln(w, Yellow+"Hint: "+hint+Reset)
+	}
+}
+
+// cliReport is what -json prints to stdout once the command has finished
+type cliReport struct {
+	Command  string      `json:"command"`
+	OK       bool        `json:"ok"`
+	ExitCode int         `json:"exitCode"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// finish returns the exit code of a run that ended with err, falling back to the first failure
+// an operation reported, and prints the JSON report if asked to
+func (o *cliOutcome) finish(ctx context.Context, command string, err error, jsonMode bool) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err == nil {
+		err = o.err
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	code := exitCodeFor(err)
+	if jsonMode {
+		report := cliReport{Command: command, OK: err == nil, ExitCode: code, Result: o.result}
+		if err != nil {
+			report.Error = err.Error()
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	}
+	return code
+}
+
+// runCLI runs a subcommand and returns the exit code. args starts with the command name.
+func runCLI(args []string) int {
+	name := args[0]
+	if name == "help" {
+		if len(args) > 1 {
+			if c, ok := lookupCLICommand(args[1]); ok {
+				fs, _ := newCLIFlagSet(c)
+				c.setup(fs)
+				fs.Usage()
+				return exitOK
+			}
+		}
+		cliUsage()
+		return exitOK
+	}
+	if configLoadErr != nil {
+		new(cliOutcome).printError(os.Stderr, configLoadErr)
+		return exitCodeFor(configLoadErr)
+	}
+
+	c, ok := lookupCLICommand(name)
+	if !ok {
+		fmt.Fprintln(os.Stderr, Red, fmt.Sprintf("Unknown command %q.", name), Reset)
+		cliUsage()
+		return exitInvalidInput
+	}
+
+	fs, common := newCLIFlagSet(c)
+	run := c.setup(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitInvalidInput
+	}
+	defer closePm3Runner()
+
+	if common.replay != "" {
+		if err := installPm3Replay(common.replay); err != nil {
+			fmt.Fprintln(os.Stderr, Red, err, Reset)
+			return exitInvalidInput
+		}
+	}
+	if common.device != "" {
+		selectPm3Device(common.device)
+	}
+	if common.logFile != "" {
+		closeLog, err := openEventLog(common.logFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, Red, err, Reset)
+			return exitInvalidInput
+		}
+		defer closeLog()
+	}
+	if common.workspace != "" {
+		if _, err := useWorkspace(common.workspace); err != nil {
+			fmt.Fprintln(os.Stderr, Red, err, Reset)
+			return exitInvalidInput
+		}
+		defer closeWorkspace()
+	}
+	if common.vault != "" {
+		if _, err := unlockVault(common.vault); err != nil {
+			fmt.Fprintln(os.Stderr, Red, err, Reset)
+			return exitInvalidInput
+		}
+		defer closeVault()
+	}
+	if common.scope != "" {
+		if err := useScopePolicy(common.scope); err != nil {
+			fmt.Fprintln(os.Stderr, Red, err, Reset)
+			return exitInvalidInput
+		}
+	}
+	fullScreen, session := cliSessions[c.name]
+	switch {
+	case fullScreen:
+	case common.json:
+		// stdout is reserved for the report
+		events.Subscribe(printEvents(os.Stderr, os.Stderr))
+	default:
+		events.Subscribe(printEvents(os.Stdout, os.Stderr))
+	}
+	if session {
+		cancelOnInterrupt()
+		err := run(context.Background(), fs.Args())
+		new(cliOutcome).printError(os.Stderr, err)
+		return exitCodeFor(err)
+	}
+	ctx := beginOperation()
+	if common.override != "" {
+		ctx = withScopeOverride(ctx, common.override)
+	}
+	// Only the command's own operation counts, not others publishing meanwhile
+	outcome := collectOutcome(operationIDFrom(ctx))
+	cancelOnInterrupt()
+	err := run(ctx, fs.Args())
+	outcome.printError(os.Stderr, err)
+	return outcome.finish(ctx, c.name, err, common.json)
+}
+
+// cliCommonFlags are accepted by every subcommand
+type cliCommonFlags struct {
+	device    string
+	replay    string
+	logFile   string
+	workspace string
+	vault     string
+	scope     string
+	override  string
+	json      bool
+}
+
+// newCLIFlagSet creates the flag set of a subcommand with the flags every command accepts
+func newCLIFlagSet(c cliCommand) (*flag.FlagSet, *cliCommonFlags) {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	common := &cliCommonFlags{}
+	fs.StringVar(&common.device, "p", "", "Proxmark3 port to use, e.g. /dev/ttyACM1 or COM4 (default: first detected)")
+	fs.StringVar(&common.replay, "replay", "", "Replay a recorded pm3 session instead of using a connected Proxmark3")
+	fs.StringVar(&common.logFile, "log", "", "Append pm3 commands, output and status messages to a log file")
+	fs.StringVar(&common.workspace, "workspace", os.Getenv("DOPPELGANGER_WORKSPACE"), "Record reads, writes, dumps, key files and the pm3 transcript to an engagement workspace directory ($DOPPELGANGER_WORKSPACE)")
+	fs.StringVar(&common.vault, "vault", os.Getenv("DOPPELGANGER_VAULT"), "Encrypt dumps, key files and card reads into a vault directory; the passphrase is asked for or taken from $DOPPELGANGER_VAULT_PASSPHRASE ($DOPPELGANGER_VAULT)")
+	fs.StringVar(&common.scope, "scope", os.Getenv("DOPPELGANGER_SCOPE"), "Scope policy to check writes, simulations, key recovery and restores against (default: scope.json in the workspace) ($DOPPELGANGER_SCOPE)")
+	fs.StringVar(&common.override, "override", "", "Run the command even if it is out of scope, logging this reason")
+	fs.BoolVar(&common.json, "json", false, "Print the result as JSON on stdout; pm3 output and status messages go to stderr")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, Yellow+"Usage: %s %s %s\n"+Reset, os.Args[0], c.name, c.args)
+		fmt.Fprintf(os.Stderr, "\n%s\n\n", c.help)
+		fs.PrintDefaults()
+	}
+	return fs, common
+}
+
+// installPm3Replay replaces the Proxmark3 with a recorded pm3 session
+func installPm3Replay(path string) error {
+	runner, err := loadPm3TranscriptFile(path)
+	if err != nil {
+		return err
+	}
+	setPm3Runner(runner)
+	return nil
+}
+
+// openEventLog appends every event to a log file until the returned function is called
+func openEventLog(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	unsubscribe := events.Subscribe(logEvents(file))
+	return func() {
+		unsubscribe()
+		file.Close()
+	}, nil
+}
+
+// cancelOnInterrupt makes Ctrl-C cancel the running pm3 command and stop the Proxmark3; a
+// second one exits
+func cancelOnInterrupt() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		cancelAllOperations()
+	}()
+}
+
+// cliCardFlags are the flags describing a credential, shared by write, verify and sim
+type cliCardFlags struct {
+	cardType     *string
+	bitLength    *int
+	facilityCode *int
+	cardNumber   *int
+	hexData      *string
+	uid          *string
+	readFile     *string
+}
+
+func addCardFlags(fs *flag.FlagSet) *cliCardFlags {
+	return &cliCardFlags{
+		cardType:     fs.String("t", "", "Card type ("+strings.Join(cardTypeNames(), ", ")+")"),
+		bitLength:    fs.Int("bl", 0, "Bit length (default: the card type's first bit length)"),
+		facilityCode: fs.Int("fc", 0, "Facility code"),
+		cardNumber:   fs.Int("cn", 0, "Card number"),
+		hexData:      fs.String("hex", "", "Hex data for EM cards"),
+		uid:          fs.String("uid", "", "UID for PIV and MIFARE cards"),
+		readFile:     fs.String("f", "", "Load card type and values from a card read saved with read -o"),
+	}
+}
+
+// card returns the card type and values given on the command line or loaded with -f
+func (f *cliCardFlags) card() (CardType, CardParams, error) {
+	name := *f.cardType
+	p := CardParams{
+		BitLength:    *f.bitLength,
+		FacilityCode: *f.facilityCode,
+		CardNumber:   *f.cardNumber,
+		HexData:      *f.hexData,
+		UID:          *f.uid,
+	}
+	if *f.readFile != "" {
+		cardRead, err := loadCardRead(*f.readFile)
+		if err != nil {
+			return nil, CardParams{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		name, p = cardRead.CardType, cardRead.Params()
+	}
+	if name == "" {
+		return nil, CardParams{}, fmt.Errorf("%w: -t (card type) or -f (card read file) is required", ErrInvalidInput)
+	}
+	return resolveCard(name, p)
+}
+
+func setupReadCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	cardType := fs.String("t", "", "Card type ("+strings.Join(cardTypeNames(), ", ")+")")
+	outFile := fs.String("o", "", "Save the card read to a JSON file")
+	return func(ctx context.Context, args []string) error {
+		ct, ok := lookupCardType(*cardType)
+		if !ok {
+			return fmt.Errorf("%w: -t must be one of: %s", ErrInvalidInput, strings.Join(cardTypeNames(), ", "))
+		}
+		cardRead, err := readCardData(ctx, ct.Name())
+		if err != nil {
+			return err
+		}
+		if *outFile != "" {
+			if err := saveCardRead(*outFile, cardRead); err != nil {
+				return fmt.Errorf("Failed to save card read: %w", err)
+			}
+			WriteStatusSuccess(ctx, "Card read saved to %s", *outFile)
+		}
+		return nil
+	}
+}
+
+func setupDetectCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		return detectCardType(ctx)
+	}
+}
+
+func setupWriteCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	card := addCardFlags(fs)
+	verify := fs.Bool("v", false, "Verify the card after writing")
+	return func(ctx context.Context, args []string) error {
+		ct, p, err := card.card()
+		if err != nil {
+			return err
+		}
+		return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, true, *verify, p.UID, p.HexData, false)
+	}
+}
+
+func setupVerifyCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	card := addCardFlags(fs)
+	return func(ctx context.Context, args []string) error {
+		ct, p, err := card.card()
+		if err != nil {
+			return err
+		}
+		return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, false, true, p.UID, p.HexData, false)
+	}
+}
+
+func setupSimCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	card := addCardFlags(fs)
+	return func(ctx context.Context, args []string) error {
+		ct, p, err := card.card()
+		if err != nil {
+			return err
+		}
+		return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, false, false, p.UID, p.HexData, true)
+	}
+}
+
+// ImportResult is the result of the import command when it lists a capture log
+type ImportResult struct {
+	File     string               `json:"file"`
+	Captures []capturedCredential `json:"captures"`
+}
+
+func setupImportCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	logFile := fs.String("f", "", "Capture log exported from a Doppelgänger Core or Stealth web interface, as CSV or JSON")
+	entry := fs.Int("n", 0, "Entry of the log to use, numbered as listed")
+	write := fs.Bool("w", false, "Write the entry to a card")
+	verify := fs.Bool("v", false, "Verify that a card holds the entry")
+	simulate := fs.Bool("s", false, "Simulate the entry")
+	return func(ctx context.Context, args []string) error {
+		if *logFile == "" {
+			return fmt.Errorf("%w: -f (capture log) is required", ErrInvalidInput)
+		}
+		path := expandUserPath(*logFile)
+		creds, err := importDoppelgangerLogFile(path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		if *entry == 0 {
+			if *write || *verify || *simulate {
+				return fmt.Errorf("%w: -n (entry) is required with -w, -v or -s", ErrInvalidInput)
+			}
+			for i, cred := range creds {
+				emitOutput(ctx, fmt.Sprintf("%3d  %s", i+1, cred.describe()))
+			}
+			emitResult(ctx, fmt.Sprintf("%d credentials in %s", len(creds), path), ImportResult{File: path, Captures: creds})
+			return nil
+		}
+		if *entry < 1 || *entry > len(creds) {
+			return fmt.Errorf("%w: -n must be between 1 and %d", ErrInvalidInput, len(creds))
+		}
+		cred := creds[*entry-1]
+		if err := cred.cloneError(); err != nil {
+			return err
+		}
+		WriteStatusInfo(ctx, "Loaded captured credential: %s", cred.describe())
+		if !*write && !*verify && !*simulate {
+			emitResult(ctx, "Captured credential", cred)
+			return nil
+		}
+		return handleCardType(ctx, cred.CardType, cred.FacilityCode, cred.CardNumber, cred.BitLength, *write, *verify, cred.UID, cred.HexData, *simulate)
+	}
+}
+
+func setupRecoverCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	method := fs.String("m", "autopwn", "Recovery method ("+strings.Join(hotelRecoveryMethods, ", ")+")")
+	return func(ctx context.Context, args []string) error {
+		known := false
+		for _, m := range hotelRecoveryMethods {
+			known = known || m == *method
+		}
+		if !known {
+			return fmt.Errorf("%w: -m must be one of: %s", ErrInvalidInput, strings.Join(hotelRecoveryMethods, ", "))
+		}
+		return recoverHotelKey(ctx, *method, func(dumpPath, keyPath string) {
+			if dumpPath != "" {
+				WriteStatusInfo(ctx, "Dump file: %s", dumpPath)
+			}
+			if keyPath != "" {
+				WriteStatusInfo(ctx, "Key file: %s", keyPath)
+			}
+		})
+	}
+}
+
+func setupFchkCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	keyFile := fs.String("k", "", "Key dictionary file (default: the client's built-in keys)")
+	return func(ctx context.Context, args []string) error {
+		keyPath := *keyFile
+		if keyPath != "" {
+			keyPath = expandUserPath(keyPath)
+		}
+		return checkKeysFast(ctx, keyPath)
+	}
+}
+
+func setupInfoCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		return getCardInfo(ctx)
+	}
+}
+
+func setupSetUIDCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	uid := fs.String("uid", "", "UID to set, e.g. 11223344 (may also be given as an argument)")
+	return func(ctx context.Context, args []string) error {
+		value := *uid
+		if value == "" && len(args) > 0 {
+			value = args[0]
+		}
+		if value == "" {
+			return fmt.Errorf("%w: UID is required", ErrInvalidInput)
+		}
+		return setMagicCardUID(ctx, strings.TrimSpace(value))
+	}
+}
+
+func setupVaultCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	export := fs.String("export", "", "Vault file to decrypt, by name or path, or a workspace transcript.log to decrypt the sealed lines of")
+	outFile := fs.String("o", "", "Where to write the decrypted file (required with -export)")
+	return func(ctx context.Context, args []string) error {
+		v := activeVault()
+		if v == nil {
+			return fmt.Errorf("%w: no vault unlocked; use -vault <dir> or set DOPPELGANGER_VAULT", ErrInvalidInput)
+		}
+		if *export != "" {
+			if *outFile == "" {
+				return fmt.Errorf("%w: -o is required with -export", ErrInvalidInput)
+			}
+			path := *export
+			if !strings.ContainsRune(path, filepath.Separator) {
+				path = filepath.Join(v.Dir, strings.TrimSuffix(path, vaultExtension)+vaultExtension)
+			}
+			data, err := v.Export(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(*outFile, data, 0600); err != nil {
+				return err
+			}
+			WriteStatusSuccess(ctx, "Decrypted %s to %s", filepath.Base(path), *outFile)
+			return nil
+		}
+
+		files, err := v.Files()
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			emitOutput(ctx, fmt.Sprintf("%s  %8d  %s", f.Modified.Format("2006-01-02 15:04:05"), f.Size, f.Name))
+		}
+		emitResult(ctx, fmt.Sprintf("%d files in the vault", len(files)), files)
+		return nil
+	}
+}
+
+func setupNoteCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		w := activeWorkspace()
+		if w == nil {
+			return errNoWorkspace
+		}
+		if err := w.AddNote(strings.Join(args, " ")); err != nil {
+			return err
+		}
+		WriteStatusSuccess(ctx, "Note added to workspace %s", w.Info.Name)
+		return nil
+	}
+}
+
+func setupReportCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	outFile := fs.String("o", "", "Where to write the report (default: report-<time>.md or .html in the workspace)")
+	html := fs.Bool("html", false, "Write a standalone HTML page instead of Markdown (default when -o ends in .html)")
+	redact := fs.Bool("redact", false, "Mask credential values, UIDs and keys")
+	return func(ctx context.Context, args []string) error {
+		w := activeWorkspace()
+		if w == nil {
+			return errNoWorkspace
+		}
+		ext := strings.ToLower(filepath.Ext(*outFile))
+		asHTML := *html || ext == ".html" || ext == ".htm"
+		path := defaultReportPath(w, asHTML)
+		if *outFile != "" {
+			path = expandUserPath(*outFile)
+		}
+		if err := writeEngagementReport(w, path, asHTML, *redact); err != nil {
+			return err
+		}
+		WriteStatusSuccess(ctx, "Report of %s written to %s", w.Info.Name, path)
+		return nil
+	}
+}
+
+func setupVerifyLogCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	logFile := fs.String("f", "", "Audit log to check (default: the workspace's, else ~/.doppelganger_assistant/audit.jsonl)")
+	return func(ctx context.Context, args []string) error {
+		path := auditLogPath()
+		if *logFile != "" {
+			path = expandUserPath(*logFile)
+		}
+		check, err := verifyAuditLog(path)
+		if err != nil {
+			WriteStatusError(ctx, "%d entries of %s check out before the break", check.Entries, path)
+			return err
+		}
+		WriteStatusSuccess(ctx, "Audit log intact: %d entries in %s", check.Entries, path)
+		if check.Last != "" {
+			WriteStatusInfo(ctx, "Last hash: %s", check.Last)
+		}
+		emitResult(ctx, "Audit log intact", check)
+		return nil
+	}
+}
+
+// ConfigReport is the result of the config command
+type ConfigReport struct {
+	Path      string   `json:"path"`
+	Settings  Config   `json:"settings"`
+	Overrides []string `json:"overrides,omitempty"` // environment variables that replace settings in the file
+}
+
+func setupConfigCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		c := currentConfig()
+		report := ConfigReport{Path: configPath(), Settings: c, Overrides: configEnvOverrides()}
+		if _, err := os.Stat(report.Path); err != nil {
+			WriteStatusInfo(ctx, "Config file: %s (not created yet, built-in defaults apply)", report.Path)
+		} else {
+			WriteStatusInfo(ctx, "Config file: %s", report.Path)
+		}
+		pm3Path := c.Pm3Path
+		if pm3Path == "" {
+			pm3Path = "auto-detect"
+		}
+		device := c.Device
+		if device == "" {
+			device = "first detected"
+		}
+		emitOutput(ctx, fmt.Sprintf("pm3 binary:       %s", pm3Path))
+		emitOutput(ctx, fmt.Sprintf("Device:           %s", device))
+		emitOutput(ctx, fmt.Sprintf("pm3 search paths: %s", strings.Join(c.Pm3SearchPaths, ", ")))
+		emitOutput(ctx, fmt.Sprintf("Dump folders:     %s", strings.Join(c.DumpDirs, ", ")))
+		emitOutput(ctx, fmt.Sprintf("Write attempts:   %d", writeAttempts()))
+		emitOutput(ctx, fmt.Sprintf("Write pause:      %s", writePause()))
+		emitOutput(ctx, fmt.Sprintf("Check timeout:    %s", checkTimeout()))
+		emitOutput(ctx, fmt.Sprintf("iCLASS key index: %d", iclassKeyIndex()))
+		if c.GUIScale != "" {
+			emitOutput(ctx, fmt.Sprintf("GUI scale:        %s", c.GUIScale))
+		}
+		if len(report.Overrides) > 0 {
+			WriteStatusInfo(ctx, "Overridden by the environment: %s", strings.Join(report.Overrides, ", "))
+		}
+		emitResult(ctx, "Config", report)
+		return nil
+	}
+}
+
+func setupRestoreCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	dumpFile := fs.String("f", "", "Dump file to write (default: the latest hf-mf dump)")
+	keyFile := fs.String("k", "", "Key file for the card (default: the latest hf-mf key file)")
+	wipe := fs.Bool("wipe", true, "Wipe the magic card before writing")
+	return func(ctx context.Context, args []string) error {
+		dumpPath := *dumpFile
+		if dumpPath == "" {
+			if dumpPath = findLatestDumpFile(); dumpPath == "" {
+				return fmt.Errorf("%w: dump file path is required and no recent dump file found", ErrInvalidInput)
+			}
+			WriteStatusInfo(ctx, "Auto-selected latest dump file: %s", dumpPath)
+		}
+		dumpPath = expandUserPath(dumpPath)
+		if _, err := os.Stat(dumpPath); err != nil {
+			return fmt.Errorf("%w: dump file does not exist: %s", ErrInvalidInput, dumpPath)
+		}
+		return restoreFromDump(ctx, dumpPath, *keyFile, *wipe, nil)
+	}
+}
+
+func setupSniffCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		return sniffHFKeys(ctx)
+	}
+}
+
+func setupServeCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on; use 0.0.0.0:8080 to accept other devices")
+	token := fs.String("token", "", "API token (default: $DOPPELGANGER_TOKEN, else a random token printed at start)")
+	return func(ctx context.Context, args []string) error {
+		return serveAPI(*addr, *token)
+	}
+}
+
+func setupTUICommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		return runTUI()
+	}
+}
+
+func setupREPLCommand(fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		return runREPL()
+	}
+}
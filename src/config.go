@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the settings that used to be hard-coded. They are read from config.json in the
+// XDG config directory, and each one can be overridden by an environment variable:
+//
+//	pm3Path         DOPPELGANGER_PM3_PATH          pm3 client to use instead of searching for it
+//	device          DOPPELGANGER_DEVICE            Proxmark3 port to use when none is chosen with -p
+//	pm3SearchPaths  DOPPELGANGER_PM3_SEARCH_PATHS  where to look for pm3 when it is not in PATH
+//	dumpDirs        DOPPELGANGER_DUMP_DIRS         where to look for the latest dump and key file
+//	writeAttempts   DOPPELGANGER_WRITE_ATTEMPTS    how many times a T5577 card is written
+//	writePause      DOPPELGANGER_WRITE_PAUSE       pause between T5577 write attempts, e.g. 1s
+//	checkTimeout    DOPPELGANGER_CHECK_TIMEOUT     how long the Proxmark3 may take to answer a check
+//	iclassKeyIndex  DOPPELGANGER_ICLASS_KEY_INDEX  iCLASS key slot used to read and encode (--ki)
+//	guiScale        FYNE_SCALE                     GUI scale factor
+//
+// The path lists use the OS path list separator in environment variables, ":" or ";".
+type Config struct {
+	Pm3Path        string          `json:"pm3Path,omitempty"`
+	Device         string          `json:"device,omitempty"`
+	Pm3SearchPaths []string        `json:"pm3SearchPaths,omitempty"`
+	DumpDirs       []string        `json:"dumpDirs,omitempty"`
+	WriteAttempts  *int            `json:"writeAttempts,omitempty"`
+	WritePause     *configDuration `json:"writePause,omitempty"`
+	CheckTimeout   *configDuration `json:"checkTimeout,omitempty"`
+	ICLASSKeyIndex *int            `json:"iclassKeyIndex,omitempty"`
+	GUIScale       string          `json:"guiScale,omitempty"`
+}
+
+// configDuration is a duration written as text in the config file, e.g. "1s" or "500ms"
+type configDuration time.Duration
+
+// durationPtr returns a pointer to d, for the optional durations of a Config
+func durationPtr(d time.Duration) *configDuration {
+	c := configDuration(d)
+	return &c
+}
+
+func (d configDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("durations are written as text, e.g. \"1s\"")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(parsed)
+	return nil
+}
+
+// defaultConfig is what the assistant uses for settings the file and environment leave out
+func defaultConfig() Config {
+	homeDir, _ := os.UserHomeDir()
+	return Config{
+		Pm3SearchPaths: defaultPm3SearchPaths(),
+		DumpDirs:       []string{homeDir, filepath.Join(homeDir, ".proxmark3"), "."},
+		WriteAttempts:  intPtr(5),
+		WritePause:     durationPtr(1 * time.Second),
+		CheckTimeout:   durationPtr(2 * time.Second),
+		ICLASSKeyIndex: intPtr(0),
+	}
+}
+
+// defaultPm3SearchPaths are the common install locations of pm3, tried when it is not in PATH
+func defaultPm3SearchPaths() []string {
+	switch runtime.GOOS {
+	case "windows":
+		return nil
+	case "darwin":
+		return []string{
+			"/opt/homebrew/bin/pm3",               // Homebrew on Apple Silicon
+			"/usr/local/bin/pm3",                  // Homebrew on Intel Mac
+			"/opt/local/bin/pm3",                  // MacPorts
+			"/usr/local/Cellar/proxmark3/bin/pm3", // Older Homebrew layout
+		}
+	default:
+		return []string{
+			"/usr/local/bin/pm3",
+			"/usr/bin/pm3",
+			"/opt/proxmark3/pm3",
+		}
+	}
+}
+
+// configPath is the config file: $DOPPELGANGER_CONFIG, else config.json under $XDG_CONFIG_HOME,
+// else under ~/.config
+func configPath() string {
+	if path := os.Getenv("DOPPELGANGER_CONFIG"); path != "" {
+		return expandUserPath(path)
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if runtime.GOOS == "windows" {
+			dir, _ = os.UserConfigDir()
+		} else if homeDir, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(homeDir, ".config")
+		}
+	}
+	return filepath.Join(dir, "doppelganger_assistant", "config.json")
+}
+
+// readConfigFile returns the settings saved in the config file; a missing file has none
+func readConfigFile(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%w: invalid config %s: %v", ErrInvalidInput, path, err)
+	}
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("%w: invalid config %s: %v", ErrInvalidInput, path, err)
+	}
+	return c, nil
+}
+
+// saveConfigFile writes settings to the config file
+func saveConfigFile(path string, c Config) error {
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	if c.WriteAttempts != nil && (*c.WriteAttempts < 1 || *c.WriteAttempts > 20) {
+		return fmt.Errorf("writeAttempts must be between 1 and 20")
+	}
+	if c.WritePause != nil && *c.WritePause < 0 {
+		return fmt.Errorf("writePause cannot be negative")
+	}
+	if c.CheckTimeout != nil && *c.CheckTimeout <= 0 {
+		return fmt.Errorf("checkTimeout must be longer than 0s")
+	}
+	if c.ICLASSKeyIndex != nil && (*c.ICLASSKeyIndex < 0 || *c.ICLASSKeyIndex > 255) {
+		return fmt.Errorf("iclassKeyIndex must be between 0 and 255")
+	}
+	if c.GUIScale != "" {
+		if scale, err := strconv.ParseFloat(c.GUIScale, 64); err != nil || scale <= 0 {
+			return fmt.Errorf("guiScale must be a positive number, e.g. 1.5")
+		}
+	}
+	return nil
+}
+
+// merge returns c with the settings set in other replacing its own
+func (c Config) merge(other Config) Config {
+	if other.Pm3Path != "" {
+		c.Pm3Path = other.Pm3Path
+	}
+	if other.Device != "" {
+		c.Device = other.Device
+	}
+	if len(other.Pm3SearchPaths) > 0 {
+		c.Pm3SearchPaths = other.Pm3SearchPaths
+	}
+	if len(other.DumpDirs) > 0 {
+		c.DumpDirs = other.DumpDirs
+	}
+	if other.WriteAttempts != nil {
+		c.WriteAttempts = other.WriteAttempts
+	}
+	if other.WritePause != nil {
+		c.WritePause = other.WritePause
+	}
+	if other.CheckTimeout != nil {
+		c.CheckTimeout = other.CheckTimeout
+	}
+	if other.ICLASSKeyIndex != nil {
+		c.ICLASSKeyIndex = other.ICLASSKeyIndex
+	}
+	if other.GUIScale != "" {
+		c.GUIScale = other.GUIScale
+	}
+	return c
+}
+
+// configFromEnv returns the settings overridden by environment variables
+func configFromEnv() (Config, error) {
+	var c Config
+	c.Pm3Path = os.Getenv("DOPPELGANGER_PM3_PATH")
+	c.Device = os.Getenv("DOPPELGANGER_DEVICE")
+	c.Pm3SearchPaths = splitPathList(os.Getenv("DOPPELGANGER_PM3_SEARCH_PATHS"))
+	c.DumpDirs = splitPathList(os.Getenv("DOPPELGANGER_DUMP_DIRS"))
+	c.GUIScale = os.Getenv("FYNE_SCALE")
+	if v := os.Getenv("DOPPELGANGER_WRITE_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("%w: DOPPELGANGER_WRITE_ATTEMPTS must be a number of attempts", ErrInvalidInput)
+		}
+		c.WriteAttempts = &n
+	}
+	for name, d := range map[string]**configDuration{"DOPPELGANGER_WRITE_PAUSE": &c.WritePause, "DOPPELGANGER_CHECK_TIMEOUT": &c.CheckTimeout} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return c, fmt.Errorf("%w: %s must be a duration, e.g. 1s or 500ms", ErrInvalidInput, name)
+			}
+			*d = durationPtr(parsed)
+		}
+	}
+	if v := os.Getenv("DOPPELGANGER_ICLASS_KEY_INDEX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("%w: DOPPELGANGER_ICLASS_KEY_INDEX must be a key slot number", ErrInvalidInput)
+		}
+		c.ICLASSKeyIndex = &n
+	}
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return c, nil
+}
+
+// configEnvVars are the environment variables that override settings in the config file
+var configEnvVars = []string{
+	"DOPPELGANGER_PM3_PATH", "DOPPELGANGER_DEVICE", "DOPPELGANGER_PM3_SEARCH_PATHS", "DOPPELGANGER_DUMP_DIRS",
+	"DOPPELGANGER_WRITE_ATTEMPTS", "DOPPELGANGER_WRITE_PAUSE", "DOPPELGANGER_CHECK_TIMEOUT",
+	"DOPPELGANGER_ICLASS_KEY_INDEX", "FYNE_SCALE",
+}
+
+// configEnvOverrides returns the environment variables set that override the config file
+func configEnvOverrides() []string {
+	var set []string
+	for _, name := range configEnvVars {
+		if os.Getenv(name) != "" {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+// splitPathList splits a list of paths given in an environment variable
+func splitPathList(list string) []string {
+	var paths []string
+	for _, path := range filepath.SplitList(list) {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+var (
+	appConfigMu sync.Mutex
+	appConfig   = defaultConfig()
+)
+
+// configLoadErr is why the settings could not be loaded at startup. CLI commands stop on it;
+// the GUI starts with the built-in defaults so the settings can be fixed there.
+var configLoadErr error
+
+// loadConfig reads the config file and the environment into the settings operations use
+func loadConfig() error {
+	file, err := readConfigFile(configPath())
+	if err != nil {
+		return err
+	}
+	env, err := configFromEnv()
+	if err != nil {
+		return err
+	}
+	applyConfig(defaultConfig().merge(file).merge(env))
+	return nil
+}
+
+// applyConfig makes c the settings operations use from now on
+func applyConfig(c Config) {
+	appConfigMu.Lock()
+	appConfig = c
+	appConfigMu.Unlock()
+	// The pm3 client and device are found again with the new settings
+	resetPm3PathCache()
+	resetPm3DeviceCache()
+}
+
+// currentConfig returns the settings in effect
+func currentConfig() Config {
+	appConfigMu.Lock()
+	defer appConfigMu.Unlock()
+	return appConfig
+}
+
+// iclassKeyIndex is the iCLASS key slot pm3 reads and encodes cards with
+func iclassKeyIndex() int {
+	if c := currentConfig(); c.ICLASSKeyIndex != nil {
+		return *c.ICLASSKeyIndex
+	}
+	return *defaultConfig().ICLASSKeyIndex
+}
+
+// writeAttempts is how many times a T5577 card is written
+func writeAttempts() int {
+	if c := currentConfig(); c.WriteAttempts != nil {
+		return *c.WriteAttempts
+	}
+	return *defaultConfig().WriteAttempts
+}
+
+// writePause is the pause between T5577 write attempts
+func writePause() time.Duration {
+	if c := currentConfig(); c.WritePause != nil {
+		return time.Duration(*c.WritePause)
+	}
+	return time.Duration(*defaultConfig().WritePause)
+}
+
+// checkTimeout is how long the Proxmark3 may take to answer a check
+func checkTimeout() time.Duration {
+	if c := currentConfig(); c.CheckTimeout != nil {
+		return time.Duration(*c.CheckTimeout)
+	}
+	return time.Duration(*defaultConfig().CheckTimeout)
+}
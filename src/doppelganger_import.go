@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// capturedCredential is a credential captured by a Doppelgänger Core or Stealth reader.
+// Card holds the device log columns as exported; the remaining fields are the typed values.
+type capturedCredential struct {
+	Card         Card   `json:"card"`
+	CardType     string `json:"cardType,omitempty"` // our card type ("prox", "iclass", ...), empty when it cannot be cloned
+	KnownFormat  bool   `json:"knownFormat"`        // the device format was recognised, even if it cannot be cloned
+	BitLength    int    `json:"bitLength,omitempty"`
+	FacilityCode int    `json:"facilityCode,omitempty"`
+	CardNumber   int    `json:"cardNumber,omitempty"`
+	HexData      string `json:"hexData,omitempty"`
+	UID          string `json:"uid,omitempty"`
+	Captured     string `json:"captured,omitempty"`
+}
+
+// cloneError returns why the credential cannot be cloned, or nil when it can
+func (c capturedCredential) cloneError() error {
+	switch {
+	case c.CardType != "":
+		return nil
+	case !c.KnownFormat:
+		return fmt.Errorf("%w: %q is not a format Doppelgänger readers log", ErrUnsupportedFormat, c.Card.DataType)
+	default:
+		return fmt.Errorf("%w: %s credentials cannot be cloned", ErrUnsupportedFormat, c.Card.DataType)
+	}
+}
+
+// doppelgangerColumns maps normalized export column names onto Card fields
+var doppelgangerColumns = map[string]string{
+	"datatype": "DataType", "cardtype": "DataType", "type": "DataType", "format": "DataType", "technology": "DataType",
+	"bitlength": "BitLength", "bits": "BitLength", "bl": "BitLength", "bitcount": "BitLength", "length": "BitLength",
+	"hexvalue": "HexValue", "hex": "HexValue", "hexdata": "HexValue", "rawhex": "HexValue", "raw": "HexValue",
+	"facilitycode": "FacilityCode", "fc": "FacilityCode", "facility": "FacilityCode", "sitecode": "FacilityCode",
+	"cardnumber": "CardNumber", "cn": "CardNumber", "cardno": "CardNumber", "number": "CardNumber", "cardid": "CardNumber",
+	"bin": "Bin", "binary": "Bin", "rawbinary": "Bin", "wiegand": "Bin", "wiegandbinary": "Bin",
+	"timestamp": "Captured", "time": "Captured", "date": "Captured", "datetime": "Captured", "captured": "Captured", "capturedat": "Captured",
+	"uid": "UID",
+}
+
+var nonAlnumRegex = regexp.MustCompile(`[^a-z0-9]`)
+
+// normalizeColumn lower-cases a column name and strips anything that is not a letter or digit
+func normalizeColumn(name string) string {
+	return nonAlnumRegex.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "")
+}
+
+// doppelgangerFormat is a card format named in a Doppelgänger log
+type doppelgangerFormat struct {
+	cardType  string // our card type, empty for captures that cannot be cloned
+	bitLength int    // the bit length the name fixes, or 0
+}
+
+// doppelgangerFormats maps the format names Doppelgänger Core and Stealth log, lower-cased, onto
+// our card types. The pm3 Wiegand format names and the card technologies are accepted too.
+var doppelgangerFormats = map[string]doppelgangerFormat{
+	"keypad pin codes": {}, "keypad pin": {}, "pin": {},
+
+	"hid h10301 26-bit": {"prox", 26}, "2804 wiegand 28-bit": {"prox", 28}, "ats wiegand 30-bit": {"prox", 30},
+	"hid adt 31-bit": {"prox", 31}, "hid d10202 33-bit": {"prox", 33}, "hid h10306 34-bit": {"prox", 34},
+	"hid corporate 1000 35-bit": {"prox", 35}, "hid simplex 36-bit (s12906)": {"prox", 36},
+	"hid h10304 37-bit": {"prox", 37}, "hid h800002 46-bit": {"prox", 46}, "hid corporate 1000 48-bit": {"prox", 48},
+	"h10301": {"prox", 26}, "2804w": {"prox", 28}, "atsw30": {"prox", 30}, "adt31": {"prox", 31},
+	"d10202": {"prox", 33}, "h10306": {"prox", 34}, "c1k35s": {"prox", 35}, "s12906": {"prox", 36},
+	"h10304": {"prox", 37}, "h800002": {"prox", 46}, "c1k48s": {"prox", 48}, "prox": {"prox", 0}, "hid prox": {"prox", 0},
+
+	"indala 26-bit": {"indala", 26}, "indala 27-bit": {"indala", 27}, "indala 29-bit": {"indala", 29}, "indala": {"indala", 0},
+	"em4102 / wiegand 32-bit": {"em", 32}, "em4100": {"em", 32}, "em4102": {"em", 32}, "em410x": {"em", 32}, "net2": {"em", 32},
+	"awid 50-bit": {"awid", 50}, "awid": {"awid", 0},
+	"avigilon 56-bit": {"avigilon", 56}, "avig56": {"avigilon", 56}, "avigilon": {"avigilon", 56},
+	"iclass": {"iclass", 0}, "iclass legacy": {"iclass", 0},
+	"c910 pivkey": {"piv", 0}, "piv": {"piv", 0},
+	"mifare (various types)": {"mifare", 0}, "mifare": {"mifare", 0}, "mifare classic": {"mifare", 0},
+}
+
+// genericWiegandRegex matches format names that only give the bit length, e.g. "Wiegand 26-bit"
+var genericWiegandRegex = regexp.MustCompile(`^(?:wiegand\s*)?(\d+)[-\s]?bits?$`)
+
+// lookupDoppelgangerFormat returns the format a device data type names. Entries without a data
+// type, or with a generic Wiegand one, are matched on their bit length. Unknown names are not
+// guessed at.
+func lookupDoppelgangerFormat(dataType string, bitLength int) (doppelgangerFormat, bool) {
+	name := strings.Join(strings.Fields(strings.ToLower(dataType)), " ")
+	if format, ok := doppelgangerFormats[name]; ok {
+		return format, true
+	}
+	if name != "" {
+		matches := genericWiegandRegex.FindStringSubmatch(name)
+		if matches == nil {
+			return doppelgangerFormat{}, false
+		}
+		if bitLength == 0 {
+			bitLength = mustAtoi(matches[1])
+		}
+	}
+
+	switch bitLength {
+	case 32:
+		return doppelgangerFormat{"em", 32}, true
+	case 50:
+		return doppelgangerFormat{"awid", 50}, true
+	case 56:
+		return doppelgangerFormat{"avigilon", 56}, true
+	}
+	if prox, ok := lookupCardType("prox"); ok && prox.WiegandFormat(bitLength) != "" {
+		return doppelgangerFormat{"prox", bitLength}, true
+	}
+	return doppelgangerFormat{}, false
+}
+
+// newCapturedCredential builds a typed credential from one exported log entry
+func newCapturedCredential(fields map[string]string) capturedCredential {
+	cred := capturedCredential{
+		Card: Card{
+			DataType:     fields["DataType"],
+			BitLength:    fields["BitLength"],
+			HexValue:     strings.ToUpper(fields["HexValue"]),
+			FacilityCode: fields["FacilityCode"],
+			CardNumber:   fields["CardNumber"],
+			Bin:          fields["Bin"],
+		},
+		Captured: fields["Captured"],
+	}
+
+	cred.BitLength = mustAtoi(strings.TrimSuffix(strings.ToLower(cred.Card.BitLength), "-bit"))
+	if cred.BitLength == 0 && cred.Card.Bin != "" {
+		cred.BitLength = len(strings.ReplaceAll(cred.Card.Bin, " ", ""))
+	}
+	format, known := lookupDoppelgangerFormat(cred.Card.DataType, cred.BitLength)
+	cred.CardType, cred.KnownFormat = format.cardType, known
+	if cred.BitLength == 0 {
+		cred.BitLength = format.bitLength
+	}
+
+	ct, _ := lookupCardType(cred.CardType)
+	input := InputWiegand
+	if ct != nil {
+		input = ct.Input()
+	}
+
+	switch input {
+	case InputHex:
+		cred.HexData = cred.Card.HexValue
+	case InputUID:
+		// The readers log the UID in the card number column
+		cred.UID = strings.ToUpper(strings.ReplaceAll(fields["UID"], " ", ""))
+		if cred.UID == "" {
+			cred.UID = strings.ToUpper(strings.ReplaceAll(cred.Card.CardNumber, " ", ""))
+		}
+		if cred.UID == "" {
+			cred.UID = cred.Card.HexValue
+		}
+	default:
+		cred.FacilityCode = mustAtoi(cred.Card.FacilityCode)
+		cred.CardNumber = mustAtoi(cred.Card.CardNumber)
+
+		// Decode FC/CN from the raw bits when the export only has the Wiegand data
+		if cred.Card.FacilityCode == "" && cred.Card.CardNumber == "" {
+			var bits uint64
+			var err error
+			if cred.Card.Bin != "" {
+				bits, cred.BitLength, err = parseWiegandBinary(cred.Card.Bin)
+			} else if cred.Card.HexValue != "" && cred.BitLength > 0 {
+				bits, err = parseWiegandHex(cred.Card.HexValue, cred.BitLength)
+			} else {
+				err = fmt.Errorf("no raw data")
+			}
+			if err == nil {
+				if decoded := decodeWiegand(bits, cred.BitLength); len(decoded) > 0 {
+					cred.FacilityCode = int(decoded[0].FacilityCode)
+					cred.CardNumber = int(decoded[0].CardNumber)
+				}
+			}
+		}
+	}
+
+	return cred
+}
+
+// mapDoppelgangerFields renames the keys of an exported entry onto Card field names
+func mapDoppelgangerFields(entry map[string]string) map[string]string {
+	fields := make(map[string]string)
+	for key, value := range entry {
+		if field, ok := doppelgangerColumns[normalizeColumn(key)]; ok && fields[field] == "" {
+			fields[field] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
+
+// parseDoppelgangerCSV parses a CSV log exported from the Doppelgänger web interface
+func parseDoppelgangerCSV(data []byte) ([]capturedCredential, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV log: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("CSV log has no entries")
+	}
+
+	header := records[0]
+	var creds []capturedCredential
+	for _, record := range records[1:] {
+		entry := make(map[string]string)
+		for i, value := range record {
+			if i < len(header) {
+				entry[header[i]] = value
+			}
+		}
+		fields := mapDoppelgangerFields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		creds = append(creds, newCapturedCredential(fields))
+	}
+	return creds, nil
+}
+
+// jsonValueString converts a decoded JSON value into the string a CSV export would hold
+func jsonValueString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
+
+// parseDoppelgangerJSON parses a JSON log, either an array of entries, an object wrapping
+// the array, or one entry per line
+func parseDoppelgangerJSON(data []byte) ([]capturedCredential, error) {
+	var entries []map[string]interface{}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON log: %w", err)
+		}
+	} else {
+		var wrapper map[string]interface{}
+		if err := json.Unmarshal(trimmed, &wrapper); err == nil {
+			for _, key := range []string{"cards", "credentials", "entries", "logs", "data"} {
+				if list, ok := wrapper[key].([]interface{}); ok {
+					for _, item := range list {
+						if entry, ok := item.(map[string]interface{}); ok {
+							entries = append(entries, entry)
+						}
+					}
+					break
+				}
+			}
+			if entries == nil {
+				entries = append(entries, wrapper)
+			}
+		} else {
+			// JSON Lines
+			scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					return nil, fmt.Errorf("failed to parse JSON log line: %w", err)
+				}
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	var creds []capturedCredential
+	for _, entry := range entries {
+		values := make(map[string]string)
+		for key, value := range entry {
+			values[key] = jsonValueString(value)
+		}
+		fields := mapDoppelgangerFields(values)
+		if len(fields) == 0 {
+			continue
+		}
+		creds = append(creds, newCapturedCredential(fields))
+	}
+	return creds, nil
+}
+
+// parseDoppelgangerLog detects the export format and parses it
+func parseDoppelgangerLog(data []byte) ([]capturedCredential, error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("log is empty")
+	}
+	if r, ok := parseSavedCardRead(trimmed); ok {
+		return []capturedCredential{capturedFromCardRead(r)}, nil
+	}
+	if trimmed[0] == '[' || trimmed[0] == '{' {
+		return parseDoppelgangerJSON(trimmed)
+	}
+	return parseDoppelgangerCSV(trimmed)
+}
+
+// parseSavedCardRead recognises a card read saved with -o, which uses our own card type names
+func parseSavedCardRead(data []byte) (*CardRead, bool) {
+	if data[0] != '{' {
+		return nil, false
+	}
+	var r CardRead
+	if err := json.Unmarshal(data, &r); err != nil || r.Command == "" {
+		return nil, false
+	}
+	if _, ok := lookupCardType(r.CardType); !ok {
+		return nil, false
+	}
+	return &r, true
+}
+
+// capturedFromCardRead converts a saved card read into a credential that can be loaded
+func capturedFromCardRead(r *CardRead) capturedCredential {
+	p := r.Params()
+	cred := capturedCredential{
+		Card: Card{
+			DataType:     r.CardType,
+			HexValue:     strings.ToUpper(r.Wiegand),
+			FacilityCode: strconv.Itoa(p.FacilityCode),
+			CardNumber:   strconv.Itoa(p.CardNumber),
+			Bin:          r.Bits,
+		},
+		CardType:     r.CardType,
+		KnownFormat:  true,
+		BitLength:    p.BitLength,
+		FacilityCode: p.FacilityCode,
+		CardNumber:   p.CardNumber,
+		HexData:      p.HexData,
+		UID:          p.UID,
+		Captured:     r.ReadAt,
+	}
+	if p.BitLength > 0 {
+		cred.Card.BitLength = strconv.Itoa(p.BitLength)
+	}
+	return cred
+}
+
+// importDoppelgangerLog reads and parses a Doppelgänger capture log, e.g. a file the GUI opened
+func importDoppelgangerLog(r io.Reader) ([]capturedCredential, error) {
+	data, err := io.ReadAll(io.LimitReader(r, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+	return parseDoppelgangerLog(data)
+}
+
+// importDoppelgangerLogFile reads and parses a Doppelgänger capture log from disk
+func importDoppelgangerLogFile(path string) ([]capturedCredential, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log: %w", err)
+	}
+	defer file.Close()
+	return importDoppelgangerLog(file)
+}
+
+// describe returns a one-line summary of the credential for selection lists
+func (c capturedCredential) describe() string {
+	var summary string
+	ct, ok := lookupCardType(c.CardType)
+	switch {
+	case !ok && !c.KnownFormat:
+		summary = fmt.Sprintf("%s (unknown format)", c.Card.DataType)
+	case !ok:
+		summary = fmt.Sprintf("%s (not cloneable)", c.Card.DataType)
+	default:
+		summary = describeCredential(ct, CardParams{
+			BitLength:    c.BitLength,
+			FacilityCode: c.FacilityCode,
+			CardNumber:   c.CardNumber,
+			HexData:      c.HexData,
+			UID:          c.UID,
+		})
+	}
+	if c.Captured != "" {
+		summary = c.Captured + "  " + summary
+	}
+	return summary
+}
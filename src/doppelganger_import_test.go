@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLookupDoppelgangerFormat(t *testing.T) {
+	tests := []struct {
+		dataType      string
+		bitLength     int
+		wantCardType  string
+		wantBitLength int
+		wantKnown     bool
+	}{
+		{"HID H10301 26-bit", 0, "prox", 26, true},
+		{"hid  corporate 1000 35-bit", 0, "prox", 35, true},
+		{"ATS Wiegand 30-bit", 0, "prox", 30, true},
+		{"Indala 27-bit", 0, "indala", 27, true},
+		{"EM4102 / Wiegand 32-bit", 0, "em", 32, true},
+		{"AWID 50-bit", 0, "awid", 50, true},
+		{"Avigilon 56-bit", 0, "avigilon", 56, true},
+		{"C910 PIVKey", 0, "piv", 0, true},
+		{"MIFARE", 0, "mifare", 0, true},
+		{"H10306", 0, "prox", 34, true},
+		{"Keypad PIN Codes", 0, "", 0, true},
+		{"Wiegand 26-bit", 0, "prox", 26, true},
+		{"", 37, "prox", 37, true},
+		{"", 50, "awid", 50, true},
+		// names that only contain a known word are not guessed at
+		{"Seos", 26, "", 0, false},
+		{"iCLASS SE / Seos", 26, "", 0, false},
+		{"Hidden 26-bit", 0, "", 0, false},
+		{"Stats", 26, "", 0, false},
+		{"Wiegand 99-bit", 0, "", 0, false},
+	}
+	for _, tt := range tests {
+		format, known := lookupDoppelgangerFormat(tt.dataType, tt.bitLength)
+		if format.cardType != tt.wantCardType || format.bitLength != tt.wantBitLength || known != tt.wantKnown {
+			t.Errorf("lookupDoppelgangerFormat(%q, %d) = %+v, %v; want %q, %d, %v",
+				tt.dataType, tt.bitLength, format, known, tt.wantCardType, tt.wantBitLength, tt.wantKnown)
+		}
+	}
+}
+
+func TestImportDoppelgangerLog(t *testing.T) {
+	const log = `Timestamp,Card Type,Bit Length,Facility Code,Card Number,Hex Value
+2026-10-01 09:12:03,HID H10301 26-bit,26,118,1603,2006EC0C86
+2026-10-01 09:13:44,Keypad PIN Codes,,,,1234
+2026-10-01 09:15:10,Seos,26,12,345,
+`
+	creds, err := importDoppelgangerLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 3 {
+		t.Fatalf("got %d credentials, want 3", len(creds))
+	}
+	if c := creds[0]; c.CardType != "prox" || c.BitLength != 26 || c.FacilityCode != 118 || c.CardNumber != 1603 || c.cloneError() != nil {
+		t.Errorf("entry 1 = %+v", c)
+	}
+	for i, want := range []string{"cannot be cloned", "is not a format"} {
+		err := creds[i+1].cloneError()
+		if !errors.Is(err, ErrUnsupportedFormat) || !strings.Contains(err.Error(), want) {
+			t.Errorf("entry %d: cloneError() = %v, want %q", i+2, err, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDoppelgangerLogPath is the capture log served by the device web interface, used
+// unless another path is given for firmware that serves the log elsewhere
+const defaultDoppelgangerLogPath = "/cards.jsonl"
+
+// doppelgangerPoller watches a Doppelgänger reader over its local HTTP interface
+// and reports each new capture once, de-duplicated by hex value.
+type doppelgangerPoller struct {
+	Host     string
+	Path     string
+	Username string
+	Password string
+	Interval time.Duration
+
+	// OnCapture is called for every new credential; OnError for failed polls
+	OnCapture func(capturedCredential)
+	OnError   func(error)
+
+	client  *http.Client
+	mu      sync.Mutex
+	seen    map[string]bool
+	seeded  bool
+	stop    chan struct{}
+	running bool
+}
+
+// newDoppelgangerPoller creates a poller for a device at host, e.g. "192.168.4.1", reading the
+// capture log at path, or at defaultDoppelgangerLogPath when path is empty
+func newDoppelgangerPoller(host, path, username, password string, interval time.Duration) *doppelgangerPoller {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if path == "" {
+		path = defaultDoppelgangerLogPath
+	}
+	return &doppelgangerPoller{
+		Host:     host,
+		Path:     path,
+		Username: username,
+		Password: password,
+		Interval: interval,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		seen:     make(map[string]bool),
+	}
+}
+
+// url returns the full URL of the capture log
+func (p *doppelgangerPoller) url() string {
+	host := strings.TrimRight(strings.TrimSpace(p.Host), "/")
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	path := p.Path
+	if path == "" {
+		path = defaultDoppelgangerLogPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return host + path
+}
+
+// captureKey returns the de-duplication key for a credential
+func captureKey(cred capturedCredential) string {
+	if cred.Card.HexValue != "" {
+		return strings.ToUpper(cred.Card.HexValue)
+	}
+	if cred.Card.Bin != "" {
+		return "BIN:" + cred.Card.Bin
+	}
+	return fmt.Sprintf("%s:%d:%d:%d:%s", cred.CardType, cred.BitLength, cred.FacilityCode, cred.CardNumber, cred.UID)
+}
+
+// fetch downloads and parses the current capture log from the device
+func (p *doppelgangerPoller) fetch() ([]capturedCredential, error) {
+	req, err := http.NewRequest("GET", p.url(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Username != "" || p.Password != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Doppelgänger device: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("Doppelgänger device rejected the credentials (HTTP %d)", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Doppelgänger device returned HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture log: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, nil
+	}
+	return parseDoppelgangerLog(data)
+}
+
+// poll fetches the log once and returns only the captures not seen before.
+// The first successful poll records existing captures without reporting them.
+func (p *doppelgangerPoller) poll() ([]capturedCredential, error) {
+	creds, err := p.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var fresh []capturedCredential
+	for _, cred := range creds {
+		key := captureKey(cred)
+		if p.seen[key] {
+			continue
+		}
+		p.seen[key] = true
+		if p.seeded {
+			fresh = append(fresh, cred)
+		}
+	}
+	p.seeded = true
+	return fresh, nil
+}
+
+// Start begins polling in the background until Stop is called
+func (p *doppelgangerPoller) Start() {
+	p.mu.Lock()
+	if p.running {
+		p.mu.Unlock()
+		return
+	}
+	p.running = true
+	p.stop = make(chan struct{})
+	stop := p.stop
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			fresh, err := p.poll()
+			if err != nil {
+				if p.OnError != nil {
+					p.OnError(err)
+				}
+			} else if p.OnCapture != nil {
+				for _, cred := range fresh {
+					p.OnCapture(cred)
+				}
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends polling
+func (p *doppelgangerPoller) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return
+	}
+	close(p.stop)
+	p.running = false
+}
+
+// Running reports whether the poller is active
+func (p *doppelgangerPoller) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
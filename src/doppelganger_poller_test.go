@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDoppelganger serves a capture log at path like the device web interface, behind basic
+// auth when username is set
+type fakeDoppelganger struct {
+	mu       sync.Mutex
+	path     string
+	username string
+	password string
+	log      []string
+	requests int
+}
+
+func (d *fakeDoppelganger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests++
+	if user, pass, ok := r.BasicAuth(); d.username != "" && (!ok || user != d.username || pass != d.password) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != d.path {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(strings.Join(d.log, "\n")))
+}
+
+func (d *fakeDoppelganger) capture(line string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, line)
+}
+
+const (
+	captureA = `{"type":"HID H10301 26-bit","bitLength":26,"fc":118,"cn":1603,"hex":"2006ec0c86"}`
+	captureB = `{"type":"HID H10301 26-bit","bitLength":26,"fc":118,"cn":1604,"hex":"2006ec0c88"}`
+)
+
+func TestDoppelgangerPollerPoll(t *testing.T) {
+	device := &fakeDoppelganger{path: "/log/cards.jsonl", username: "admin", password: "secret", log: []string{captureA}}
+	server := httptest.NewServer(device)
+	defer server.Close()
+
+	p := newDoppelgangerPoller(server.URL, "log/cards.jsonl", "admin", "secret", time.Second)
+	steps := []struct {
+		name    string
+		capture string
+		want    []string // card numbers reported by the poll
+	}{
+		{"first poll seeds existing captures", "", nil},
+		{"no new captures", "", nil},
+		{"new capture", captureB, []string{"1604"}},
+		{"repeated capture", captureB, nil},
+		{"same hex in other case", strings.Replace(captureA, "2006ec0c86", "2006EC0C86", 1), nil},
+	}
+	for _, step := range steps {
+		if step.capture != "" {
+			device.capture(step.capture)
+		}
+		fresh, err := p.poll()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		var got []string
+		for _, cred := range fresh {
+			got = append(got, cred.Card.CardNumber)
+		}
+		if strings.Join(got, ",") != strings.Join(step.want, ",") {
+			t.Errorf("%s: reported %q, want %q", step.name, got, step.want)
+		}
+	}
+}
+
+func TestDoppelgangerPollerErrors(t *testing.T) {
+	device := &fakeDoppelganger{path: "/cards.jsonl", username: "admin", password: "secret", log: []string{captureA}}
+	server := httptest.NewServer(device)
+	defer server.Close()
+
+	tests := []struct {
+		name, path, username, password, wantErr string
+	}{
+		{"wrong password", "", "admin", "guess", "rejected the credentials"},
+		{"no credentials", "", "", "", "rejected the credentials"},
+		{"wrong path", "/captures.jsonl", "admin", "secret", "HTTP 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newDoppelgangerPoller(server.URL, tt.path, tt.username, tt.password, time.Second)
+			if _, err := p.poll(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+			// a failed poll does not count as the seeding poll
+			if p.seeded {
+				t.Error("a failed poll seeded the poller")
+			}
+		})
+	}
+}
+
+func TestDoppelgangerPollerStartStop(t *testing.T) {
+	device := &fakeDoppelganger{path: "/cards.jsonl", log: []string{captureA}}
+	server := httptest.NewServer(device)
+	defer server.Close()
+
+	p := newDoppelgangerPoller(server.URL, "", "", "", 10*time.Millisecond)
+	captured := make(chan capturedCredential, 1)
+	p.OnCapture = func(cred capturedCredential) { captured <- cred }
+	p.Start()
+	// the first poll seeds the log, so only a later capture is reported
+	time.Sleep(30 * time.Millisecond)
+	device.capture(captureB)
+	select {
+	case cred := <-captured:
+		if cred.Card.CardNumber != "1604" {
+			t.Errorf("captured %+v, want card number 1604", cred)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("new capture was not reported")
+	}
+
+	p.Stop()
+	if p.Running() {
+		t.Error("poller still running after Stop")
+	}
+	device.mu.Lock()
+	requests := device.requests
+	device.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.requests > requests+1 {
+		t.Errorf("poller made %d requests after Stop", device.requests-requests)
+	}
+}
+
+func TestDoppelgangerPollerURL(t *testing.T) {
+	tests := []struct{ host, path, want string }{
+		{"192.168.4.1", "", "http://192.168.4.1/cards.jsonl"},
+		{"http://192.168.4.1/", "", "http://192.168.4.1/cards.jsonl"},
+		{"https://doppelganger.local", "logs/cards.jsonl", "https://doppelganger.local/logs/cards.jsonl"},
+		{" 10.0.0.2 ", "/export.csv", "http://10.0.0.2/export.csv"},
+	}
+	for _, tt := range tests {
+		if got := newDoppelgangerPoller(tt.host, tt.path, "", "", 0).url(); got != tt.want {
+			t.Errorf("url(%q, %q) = %q, want %q", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Failure classes operations return and report as their result. Failures wrap one of these,
+// so callers check them with errors.Is.
+var (
+	ErrPm3NotFound       = errors.New("Proxmark3 client (pm3) not found in PATH")
+	ErrDeviceOffline     = errors.New("Proxmark3 device not detected")
+	ErrNoCardPresent     = errors.New("no card present")
+	ErrAuthFailed        = errors.New("authentication failed")
+	ErrWriteFailed       = errors.New("write failed")
+	ErrVerifyMismatch    = errors.New("card does not match")
+	ErrUnsupportedFormat = errors.New("unsupported card format")
+	ErrInvalidInput      = errors.New("invalid input")
+	ErrOutOfScope        = errors.New("out of scope")
+	ErrAuditLogBroken    = errors.New("audit log chain broken")
+)
+
+// pm3FailurePatterns map lower-cased pm3 output to the failure it shows. The first match wins,
+// so device problems are found before the card problems they cause. A nil error marks output
+// that is not a failure.
+var pm3FailurePatterns = []struct {
+	text string
+	err  error
+}{
+	{"offline", ErrDeviceOffline},
+	{"cannot open", ErrDeviceOffline},
+	{"no such device", ErrDeviceOffline},
+	{"cannot communicate", ErrDeviceOffline},
+	{"button", nil}, // stopped with the Proxmark3 button
+	{"authenticat", ErrAuthFailed},
+	{"no valid key", ErrAuthFailed},
+	{"wrong key", ErrAuthFailed},
+	{"no tag found", ErrNoCardPresent},
+	{"no card", ErrNoCardPresent},
+	{"no known", ErrNoCardPresent},
+	{"no data found", ErrNoCardPresent},
+	{"select card failed", ErrNoCardPresent},
+	{"can't select card", ErrNoCardPresent},
+	{"write failed", ErrWriteFailed},
+	{"failed to write", ErrWriteFailed},
+	{"failed writing", ErrWriteFailed},
+	{"error writing", ErrWriteFailed},
+	{"unknown format", ErrUnsupportedFormat},
+	{"unsupported", ErrUnsupportedFormat},
+}
+
+// pm3TagRegex matches the status tag pm3 starts its lines with, e.g. "[-] " or "[!!] "
+var pm3TagRegex = regexp.MustCompile(`^\[[^\]]*\]\s*`)
+
+// classifyPm3Output tells why a pm3 command failed from its output and the error it returned.
+// The failure wraps the line of output that showed it. It is nil when the output shows no
+// failure, including a command stopped with the Proxmark3 button, and err when the output does
+// not say more.
+func classifyPm3Output(output string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		lower := strings.ToLower(line)
+		for _, p := range pm3FailurePatterns {
+			if !strings.Contains(lower, p.text) {
+				continue
+			}
+			if p.err == nil {
+				return nil
+			}
+			return fmt.Errorf("%w: %s", p.err, pm3TagRegex.ReplaceAllString(strings.TrimSpace(line), ""))
+		}
+	}
+	return err
+}
+
+// pm3Failure is the failure of a pm3 command that returned err, or whose output the caller
+// found wrong: a missing device, else the class its output shows, else fallback
+func pm3Failure(output string, err error, fallback error) error {
+	if errors.Is(err, ErrPm3NotFound) || errors.Is(err, ErrDeviceOffline) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	if failure := classifyPm3Output(output, nil); failure != nil {
+		return failure
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", fallback, err)
+	}
+	return fallback
+}
+
+// remediation suggests what to do about a failure, or returns "" when there is nothing specific
+func remediation(err error) string {
+	switch {
+	case errors.Is(err, ErrPm3NotFound):
+		return "Install the Proxmark3 client and make sure pm3 is in your PATH"
+	case errors.Is(err, ErrDeviceOffline):
+		return "Check the USB cable, reconnect the Proxmark3 and make sure no other program has its port open"
+	case errors.Is(err, ErrNoCardPresent):
+		return "Place the card flat on the Proxmark3 antenna and try again"
+	case errors.Is(err, ErrAuthFailed):
+		return "The card uses non-default keys - recover them first or supply a key file"
+	case errors.Is(err, ErrWriteFailed):
+		return "Check that the blank is the right media (T5577, iCLASS or magic card) and keep it still while writing"
+	case errors.Is(err, ErrVerifyMismatch):
+		return "Write the card again, keeping it still on the antenna, then verify"
+	case errors.Is(err, ErrUnsupportedFormat):
+		return "Check the card type, or decode the raw output above by hand"
+	case errors.Is(err, ErrOutOfScope):
+		return "Check the engagement's scope policy; if this is authorised, run it again with an override reason"
+	case errors.Is(err, ErrAuditLogBroken):
+		return "The audit log was changed after it was written - keep the copy as evidence and compare it with a backup"
+	default:
+		return ""
+	}
+}
+
+// showRemediation suggests what to do about a failed operation in the status pane of the GUI or TUI
+func showRemediation(ctx context.Context, err error) {
+	if err == nil || isCancelled(err) {
+		return
+	}
+	if hint := remediation(err); hint != "" {
+		WriteStatusInfo(ctx, "Hint: %s", hint)
+	}
+}
+
+// Exit codes of the CLI
+const (
+	exitOK                = 0
+	exitFailure           = 1 // any failure not listed below
+	exitInvalidInput      = 2
+	exitNoDevice          = 3
+	exitNoCard            = 4
+	exitVerifyMismatch    = 5
+	exitAuthFailed        = 6
+	exitWriteFailed       = 7
+	exitUnsupportedFormat = 8
+	exitOutOfScope        = 9
+	exitAuditLogBroken    = 10
+	exitCancelled         = 130
+)
+
+// exitCodeFor maps an operation's failure to the CLI exit code
+func exitCodeFor(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.Is(err, ErrInvalidInput):
+		return exitInvalidInput
+	case errors.Is(err, ErrPm3NotFound), errors.Is(err, ErrDeviceOffline):
+		return exitNoDevice
+	case errors.Is(err, ErrNoCardPresent):
+		return exitNoCard
+	case errors.Is(err, ErrVerifyMismatch):
+		return exitVerifyMismatch
+	case errors.Is(err, ErrAuthFailed):
+		return exitAuthFailed
+	case errors.Is(err, ErrWriteFailed):
+		return exitWriteFailed
+	case errors.Is(err, ErrUnsupportedFormat):
+		return exitUnsupportedFormat
+	case errors.Is(err, ErrOutOfScope):
+		return exitOutOfScope
+	case errors.Is(err, ErrAuditLogBroken):
+		return exitAuditLogBroken
+	default:
+		return exitFailure
+	}
+}
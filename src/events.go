@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventKind identifies what an operation reported
+type EventKind int
+
+const (
+	EventCommand  EventKind = iota // a pm3 command was issued
+	EventOutput                    // one line of raw pm3 output
+	EventStatus                    // a status message, see Event.Level
+	EventProgress                  // progress of a long-running step
+	EventResult                    // the operation finished
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventCommand:
+		return "command"
+	case EventOutput:
+		return "output"
+	case EventStatus:
+		return "status"
+	case EventProgress:
+		return "progress"
+	default:
+		return "result"
+	}
+}
+
+// StatusLevel is the severity of a status event
+type StatusLevel int
+
+const (
+	LevelInfo StatusLevel = iota
+	LevelSuccess
+	LevelError
+)
+
+func (l StatusLevel) String() string {
+	switch l {
+	case LevelSuccess:
+		return "SUCCESS"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "INFO"
+	}
+}
+
+// Event is something an operation reported. Operation and CardType come from the context the
+// event was published with; Operation is zero outside of an operation, e.g. for GUI messages.
+type Event struct {
+	Kind      EventKind
+	Level     StatusLevel
+	Time      time.Time
+	Operation int
+	CardType  string
+	Text      string
+	// Payload holds structured data for results, e.g. the *CardRead of a read or the Card of a
+	// generated credential
+	Payload interface{}
+	// Err is set on the result of a failed operation and wraps one of the failure classes in
+	// errors.go when the cause is known
+	Err error
+}
+
+// EventBus delivers events to every subscriber, synchronously and in the order they were
+// published by each operation
+type EventBus struct {
+	mu          sync.Mutex
+	nextID      int
+	subscribers map[int]func(Event)
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]func(Event))}
+}
+
+// events is the bus operations publish to; the GUI, CLI and log file subscribe to it
+var events = newEventBus()
+
+// Subscribe calls fn for every event published from now on. Events from concurrent operations
+// may arrive on different goroutines. The returned function removes the subscription.
+func (b *EventBus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish delivers an event to every subscriber
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	subscribers := make([]func(Event), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+type operationIDKey struct{}
+
+// withOperationID returns a context whose events are attributed to the given operation
+func withOperationID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+// operationIDFrom returns the operation attached to ctx with withOperationID
+func operationIDFrom(ctx context.Context) int {
+	id, _ := ctx.Value(operationIDKey{}).(int)
+	return id
+}
+
+type cardTypeKey struct{}
+
+// withCardType returns a context whose events are tagged with the given card type
+func withCardType(ctx context.Context, cardType string) context.Context {
+	return context.WithValue(ctx, cardTypeKey{}, cardType)
+}
+
+// cardTypeFrom returns the card type attached to ctx with withCardType
+func cardTypeFrom(ctx context.Context) string {
+	cardType, _ := ctx.Value(cardTypeKey{}).(string)
+	return cardType
+}
+
+// publish sends an event for the operation in ctx
+func publish(ctx context.Context, e Event) {
+	e.Time = time.Now()
+	e.Operation = operationIDFrom(ctx)
+	e.CardType = cardTypeFrom(ctx)
+	events.Publish(e)
+}
+
+// emitCommand reports a pm3 command issued by the operation in ctx
+func emitCommand(ctx context.Context, command string) {
+	publish(ctx, Event{Kind: EventCommand, Text: command})
+}
+
+// emitOutput reports pm3 output, one event per line
+func emitOutput(ctx context.Context, output string) {
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		publish(ctx, Event{Kind: EventOutput, Text: strings.TrimRight(line, "\r")})
+	}
+}
+
+// emitResult reports the outcome of an operation with its structured data
+func emitResult(ctx context.Context, text string, payload interface{}) {
+	publish(ctx, Event{Kind: EventResult, Text: text, Payload: payload})
+}
+
+// emitFailure reports that an operation failed and returns err for the operation to return
+func emitFailure(ctx context.Context, err error) error {
+	publish(ctx, Event{Kind: EventResult, Level: LevelError, Text: err.Error(), Err: err})
+	return err
+}
+
+// eventOutputWriter turns streamed pm3 output into output events
+type eventOutputWriter struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	partial string
+}
+
+// outputWriter returns a writer whose complete lines are published as output events of the
+// operation in ctx, for commands that stream their output
+func outputWriter(ctx context.Context) io.Writer {
+	return &eventOutputWriter{ctx: ctx}
+}
+
+func (w *eventOutputWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	text := w.partial + string(p)
+	end := strings.LastIndex(text, "\n")
+	if end < 0 {
+		w.partial = text
+		return len(p), nil
+	}
+	w.partial = text[end+1:]
+	emitOutput(w.ctx, text[:end])
+	return len(p), nil
+}
+
+// statusPrefix is the tag status events are printed with on the console and in log files
+func statusPrefix(e Event) string {
+	if e.Kind == EventProgress {
+		return "[PROGRESS] "
+	}
+	return "[" + e.Level.String() + "] "
+}
+
+// printEvents returns a subscriber for the CLI: pm3 commands and output go to stdout, status
+// messages to stderr
+func printEvents(stdout, stderr io.Writer) func(Event) {
+	var mu sync.Mutex
+	return func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch e.Kind {
+		case EventCommand, EventOutput:
+			fmt.Fprintln(stdout, e.Text)
+		case EventStatus, EventProgress:
+			fmt.Fprintln(stderr, statusPrefix(e)+e.Text)
+		}
+	}
+}
+
+// logEvents returns a subscriber that writes every event to w with its time, operation and
+// card type
+func logEvents(w io.Writer) func(Event) {
+	var mu sync.Mutex
+	return func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		stamp := e.Time.Format("2006-01-02 15:04:05")
+		var prefix string
+		switch e.Kind {
+		case EventCommand:
+			prefix = "pm3 --> "
+		case EventStatus, EventProgress:
+			prefix = statusPrefix(e)
+		case EventResult:
+			prefix = "[RESULT] "
+		}
+		if e.Operation != 0 {
+			stamp += fmt.Sprintf(" #%d", e.Operation)
+		}
+		if e.CardType != "" {
+			stamp += " " + e.CardType
+		}
+		fmt.Fprintf(w, "%s %s%s\n", stamp, prefix, e.Text)
+	}
+}
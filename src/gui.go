@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image/color"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -25,50 +27,6 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-// Global cancellation channel for operations
-var operationCancelChan chan struct{}
-var operationCancelMutex sync.Mutex
-
-// IsOperationCancelled checks if the current operation has been cancelled
-func IsOperationCancelled() bool {
-	operationCancelMutex.Lock()
-	defer operationCancelMutex.Unlock()
-	select {
-	case <-operationCancelChan:
-		return true
-	default:
-		return false
-	}
-}
-
-// filterSearchOutput filters out "Searching for..." lines and keeps only important results
-func filterSearchOutput(output string) string {
-	lines := strings.Split(output, "\n")
-	var filtered []string
-
-	for _, line := range lines {
-		// Skip "Searching for..." lines and progress indicators
-		if strings.Contains(line, "Searching for") {
-			continue
-		}
-		// Skip progress spinner lines (contain [\], [|], [/], [-])
-		if strings.Contains(line, "[\\]") || strings.Contains(line, "[|]") ||
-			strings.Contains(line, "[/]") || strings.Contains(line, "[-]") {
-			// But keep lines that have [-] and actual content (like errors or results)
-			if strings.Contains(line, "[-]") &&
-				(strings.Contains(line, "found") || strings.Contains(line, "No") ||
-					strings.Contains(line, "failed") || strings.Contains(line, "error")) {
-				filtered = append(filtered, line)
-			}
-			continue
-		}
-		// Keep everything else (results, errors, hints, etc.)
-		filtered = append(filtered, line)
-	}
-
-	return strings.Join(filtered, "\n")
-}
-
 // outputDisplay provides a text display widget for command and status output.
 type outputDisplay struct {
 	widget.Label
@@ -130,34 +88,113 @@ func stripANSI(str string) string {
 	return ansiRegex.ReplaceAllString(str, "")
 }
 
-// guiWriter implements io.Writer to route output to GUI widgets.
-type guiWriter struct {
-	output *outputDisplay
-	scroll *container.Scroll
+// guiStatusLine formats a status event for the status pane.
+func guiStatusLine(e Event) string {
+	if e.Kind == EventProgress {
+		return "⋯  " + e.Text
+	}
+	switch e.Level {
+	case LevelSuccess:
+		return "✓  " + e.Text
+	case LevelError:
+		return "✗  " + e.Text
+	default:
+		return "►  " + e.Text
+	}
+}
+
+// guiStatusColor picks the theme colour a status event is shown in.
+func guiStatusColor(e Event) fyne.ThemeColorName {
+	if e.Kind == EventProgress {
+		return theme.ColorNameWarning
+	}
+	switch e.Level {
+	case LevelSuccess:
+		return theme.ColorNameSuccess
+	case LevelError:
+		return theme.ColorNameError
+	default:
+		return theme.ColorNameForeground
+	}
+}
+
+// statusFilters are the choices of the status pane's level picker.
+var statusFilters = map[string]func(Event) bool{
+	"All messages":  nil,
+	"Hide progress": func(e Event) bool { return e.Kind != EventProgress },
+	"Errors only":   func(e Event) bool { return e.Kind == EventStatus && e.Level == LevelError },
+}
+
+// statusDisplay shows status events coloured by level. Events hidden by the filter are kept
+// and shown again when the filter changes.
+type statusDisplay struct {
+	widget.RichText
+	mu     sync.Mutex
+	events []Event
+	filter func(Event) bool
 }
 
-func (w *guiWriter) Write(p []byte) (n int, err error) {
-	text := string(p)
-
-	if strings.HasPrefix(text, "[SUCCESS] ") {
-		text = "✓  " + strings.TrimPrefix(text, "[SUCCESS] ")
-	} else if strings.HasPrefix(text, "[ERROR] ") {
-		text = "✗  " + strings.TrimPrefix(text, "[ERROR] ")
-	} else if strings.HasPrefix(text, "[INFO] ") {
-		text = "►  " + strings.TrimPrefix(text, "[INFO] ")
-	} else if strings.HasPrefix(text, "[PROGRESS] ") {
-		text = "⋯  " + strings.TrimPrefix(text, "[PROGRESS] ")
+func newStatusDisplay() *statusDisplay {
+	s := &statusDisplay{}
+	s.ExtendBaseWidget(s)
+	s.Wrapping = fyne.TextWrapWord
+	return s
+}
+
+func statusSegment(e Event) widget.RichTextSegment {
+	return &widget.TextSegment{
+		Text: stripANSI(guiStatusLine(e)),
+		Style: widget.RichTextStyle{
+			ColorName: guiStatusColor(e),
+			TextStyle: fyne.TextStyle{Monospace: true},
+		},
 	}
+}
 
-	w.output.Append(text)
+// Add shows a status or progress event.
+func (s *statusDisplay) Add(e Event) {
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	shown := s.filter == nil || s.filter(e)
+	s.mu.Unlock()
 
-	if w.scroll != nil {
+	if shown {
 		fyne.Do(func() {
-			w.scroll.ScrollToBottom()
+			s.Segments = append(s.Segments, statusSegment(e))
+			s.Refresh()
 		})
 	}
+}
+
+func (s *statusDisplay) Clear() {
+	s.mu.Lock()
+	s.events = nil
+	s.mu.Unlock()
+	s.render()
+}
+
+// SetFilter shows only the events filter accepts; nil shows every event.
+func (s *statusDisplay) SetFilter(filter func(Event) bool) {
+	s.mu.Lock()
+	s.filter = filter
+	s.mu.Unlock()
+	s.render()
+}
+
+func (s *statusDisplay) render() {
+	s.mu.Lock()
+	segments := []widget.RichTextSegment{}
+	for _, e := range s.events {
+		if s.filter == nil || s.filter(e) {
+			segments = append(segments, statusSegment(e))
+		}
+	}
+	s.mu.Unlock()
 
-	return len(p), nil
+	fyne.Do(func() {
+		s.Segments = segments
+		s.Refresh()
+	})
 }
 
 // fixedWidthLayout provides a fixed-width layout for the left sidebar.
@@ -329,86 +366,14 @@ func (t *arrowDarkTheme) Size(name fyne.ThemeSizeName) float32 {
 	return theme.DefaultTheme().Size(name)
 }
 
-// validateCardInput validates facility code and card number ranges for each card type and bit length
-func validateCardInput(cardType string, bitLength int, fc int, cn int) (bool, string) {
-	type rangeInfo struct {
-		fcMax int
-		cnMax int
-	}
-
-	// Define valid ranges for each card type and bit length
-	ranges := map[string]map[int]rangeInfo{
-		"prox": {
-			26: {fcMax: 255, cnMax: 65535},        // HID H10301
-			28: {fcMax: 255, cnMax: 32767},        // 2804 Wiegand 28-bit
-			30: {fcMax: 2047, cnMax: 32767},       // ATS Wiegand
-			31: {fcMax: 15, cnMax: 8388607},       // HID ADT
-			33: {fcMax: 127, cnMax: 16777215},     // HID D10202
-			34: {fcMax: 65535, cnMax: 65535},      // HID H10306
-			35: {fcMax: 4095, cnMax: 1048575},     // HID Corporate 1000 35-bit
-			36: {fcMax: 255, cnMax: 65535},        // HID Simplex (S12906)
-			37: {fcMax: 65535, cnMax: 524287},     // HID H10304
-			46: {fcMax: 16383, cnMax: 1073741823}, // HID H800002
-			48: {fcMax: 4194303, cnMax: 8388607},  // HID Corporate 1000 48-bit
-		},
-		"iclass": {
-			26: {fcMax: 255, cnMax: 65535},        // HID H10301
-			30: {fcMax: 2047, cnMax: 32767},       // ATS Wiegand
-			33: {fcMax: 127, cnMax: 16777215},     // HID D10202
-			34: {fcMax: 65535, cnMax: 65535},      // HID H10306
-			35: {fcMax: 4095, cnMax: 1048575},     // HID Corporate 1000 35-bit
-			36: {fcMax: 255, cnMax: 65535},        // HID Simplex (S12906)
-			37: {fcMax: 65535, cnMax: 524287},     // HID H10304
-			46: {fcMax: 16383, cnMax: 1073741823}, // HID H800002
-			48: {fcMax: 4194303, cnMax: 8388607},  // HID Corporate 1000 48-bit
-		},
-		"awid": {
-			26: {fcMax: 255, cnMax: 65535},     // Standard 26-bit
-			50: {fcMax: 65535, cnMax: 8388607}, // Extended 50-bit
-		},
-		"indala": {
-			26: {fcMax: 255, cnMax: 65535},  // Standard 26-bit
-			27: {fcMax: 4095, cnMax: 8191},  // Indala 27-bit
-			29: {fcMax: 4095, cnMax: 32767}, // Indala 29-bit
-		},
-		"avigilon": {
-			56: {fcMax: 1048575, cnMax: 4194303}, // Avigilon 56-bit (20/22 split)
-		},
-	}
-
-	// Check if card type has defined ranges
-	cardRanges, exists := ranges[cardType]
-	if !exists {
-		return true, "" // No validation needed for this card type
-	}
-
-	// Check if bit length is valid for this card type
-	limits, exists := cardRanges[bitLength]
-	if !exists {
-		return false, fmt.Sprintf("Invalid bit length %d for card type %s", bitLength, cardType)
-	}
-
-	// Validate facility code
-	if fc < 0 || fc > limits.fcMax {
-		return false, fmt.Sprintf("Facility Code must be between 0 and %d for %d-bit %s cards", limits.fcMax, bitLength, cardType)
-	}
-
-	// Validate card number
-	if cn < 0 || cn > limits.cnMax {
-		return false, fmt.Sprintf("Card Number must be between 0 and %d for %d-bit %s cards", limits.cnMax, bitLength, cardType)
-	}
-
-	return true, ""
-}
-
 func runGUI() {
 	os.Setenv("FYNE_DISABLE_CALL_CHECKING", "1")
 
-	// Set proper scaling for Linux to match macOS appearance
-	if runtime.GOOS == "linux" {
-		if os.Getenv("FYNE_SCALE") == "" {
-			os.Setenv("FYNE_SCALE", "1.0")
-		}
+	// Use the configured scale, else set proper scaling for Linux to match macOS appearance
+	if scale := currentConfig().GUIScale; scale != "" {
+		os.Setenv("FYNE_SCALE", scale)
+	} else if runtime.GOOS == "linux" {
+		os.Setenv("FYNE_SCALE", "1.0")
 	}
 
 	a := app.New()
@@ -438,7 +403,10 @@ func runGUI() {
 
 	cardTypeLabel := canvas.NewText("CARD TYPE", color.RGBA{R: 169, G: 182, B: 201, A: 255})
 	cardTypeLabel.TextSize = 11
-	cardTypes := []string{"PROX", "iCLASS", "AWID", "Indala", "Avigilon", "EM4100 / Net2", "PIV", "MIFARE"}
+	var cardTypes []string
+	for _, ct := range registeredCardTypes() {
+		cardTypes = append(cardTypes, ct.DisplayName())
+	}
 	cardType := widget.NewSelect(cardTypes, nil)
 
 	bitLengthLabel := canvas.NewText("BIT LENGTH", color.RGBA{R: 169, G: 182, B: 201, A: 255})
@@ -468,39 +436,34 @@ func runGUI() {
 	updateDataBlocks := func(selectedType string) {
 		dataBlocks.Objects = nil
 
-		// Update bit lengths
-		bitLengthOptions := map[string][]string{
-			"PROX":     {"26", "28", "30", "31", "33", "34", "35", "36", "37", "46", "48"},
-			"iCLASS":   {"26", "30", "33", "34", "35", "36", "37", "46", "48"},
-			"AWID":     {"26", "50"},
-			"Indala":   {"26", "27", "29"},
-			"Avigilon": {"56"},
+		ct, ok := lookupCardTypeByDisplayName(selectedType)
+		if !ok {
+			dataBlocks.Refresh()
+			return
 		}
 
-		if options, ok := bitLengthOptions[selectedType]; ok {
-			bitLength.Options = options
+		// Update bit lengths and input fields based on card type
+		bitLength.Options = []string{}
+		switch ct.Input() {
+		case InputWiegand:
+			for _, bl := range ct.BitLengths() {
+				bitLength.Options = append(bitLength.Options, strconv.Itoa(bl))
+			}
 			bitLength.SetSelectedIndex(0)
 			dataBlocks.Add(bitLengthLabel)
 			dataBlocks.Add(bitLength)
 			dataBlocks.Add(widget.NewSeparator())
-		} else {
-			bitLength.Options = []string{}
-		}
-
-		// Update input fields based on card type
-		switch selectedType {
-		case "PROX", "iCLASS", "AWID", "Indala", "Avigilon":
 			dataBlocks.Add(facilityCode)
 			dataBlocks.Add(cardNumber)
-		case "EM4100 / Net2":
+		case InputHex:
 			dataBlocks.Add(hexData)
-		case "PIV", "MIFARE":
+		case InputUID:
 			dataBlocks.Add(uid)
 		}
 
 		// Update action options based on card type
-		// iCLASS simulation is disabled, so remove that option
-		if selectedType == "iCLASS" {
+		// Remove the simulate option for card types that cannot be simulated
+		if !ct.CanSimulate() {
 			action.Options = []string{"Generate Command", "Write & Verify"}
 		} else {
 			action.Options = []string{"Generate Command", "Write & Verify", "Simulate Card"}
@@ -518,29 +481,91 @@ func runGUI() {
 
 	cardType.OnChanged = updateDataBlocks
 
-	statusOutput := newOutputDisplay(true)
+	statusOutput := newStatusDisplay()
 	commandOutput := newOutputDisplay(false)
-	var currentStatusOutput *outputDisplay = statusOutput
+	var currentStatusOutput *statusDisplay = statusOutput
 	var currentCommandOutput *outputDisplay = commandOutput
 
 	var statusScroll *container.Scroll
 	var commandScroll *container.Scroll
 
+	// showEvent adds an event to the output panes: status messages to the status pane, pm3
+	// commands and output to the command pane.
+	showEvent := func(e Event) {
+		switch e.Kind {
+		case EventStatus, EventProgress:
+			currentStatusOutput.Add(e)
+			if statusScroll != nil {
+				fyne.Do(func() {
+					statusScroll.ScrollToBottom()
+				})
+			}
+		case EventCommand, EventOutput:
+			currentCommandOutput.Append(e.Text + "\n")
+			if commandScroll != nil {
+				fyne.Do(func() {
+					commandScroll.ScrollToBottom()
+				})
+			}
+		}
+	}
+
+	// The panes follow one job at a time: the one started last, or the one picked in the job
+	// list. Events published outside of a job are always shown.
+	var shownJobMu sync.Mutex
+	shownJob := 0
+	followJob := func(id int) {
+		shownJobMu.Lock()
+		defer shownJobMu.Unlock()
+		shownJob = id
+	}
+	events.Subscribe(func(e Event) {
+		shownJobMu.Lock()
+		shown := e.Operation == 0 || e.Operation == shownJob
+		shownJobMu.Unlock()
+		if shown {
+			showEvent(e)
+		}
+	})
+
+	// runJob queues an operation on the Proxmark3 of ctx; it runs once the device is free.
+	// An operation blocked by the scope policy can be run again with an override reason.
+	var runJob func(ctx context.Context, name string, fn func(ctx context.Context) error)
+	runJob = func(ctx context.Context, name string, fn func(ctx context.Context) error) {
+		go func() {
+			_, err := jobs.Submit(ctx, name, func(ctx context.Context, job *Job) {
+				followJob(job.ID)
+				err := fn(ctx)
+				showRemediation(ctx, err)
+				if !errors.Is(err, ErrOutOfScope) || scopeOverrideFrom(ctx) != "" {
+					return
+				}
+				fyne.Do(func() {
+					reason := widget.NewEntry()
+					reason.SetPlaceHolder("e.g. client approved by email")
+					dialog.ShowForm("Out of Scope", "OVERRIDE", "CANCEL", []*widget.FormItem{
+						widget.NewFormItem("Blocked", widget.NewLabel(err.Error())),
+						widget.NewFormItem("Reason", reason),
+					}, func(override bool) {
+						if override && strings.TrimSpace(reason.Text) != "" {
+							retry := withPm3Device(beginOperation(), pm3DeviceFrom(ctx))
+							runJob(withScopeOverride(retry, reason.Text), name, fn)
+						}
+					}, w)
+				})
+			})
+			if err != nil {
+				WriteStatusError(context.Background(), "%v", err)
+				showRemediation(context.Background(), err)
+			}
+		}()
+	}
+
 	executeCommand = func() {
 		// Clear output immediately when Execute is pressed
 		currentStatusOutput.Clear()
 		currentCommandOutput.Clear()
-
-		cardTypeMap := map[string]string{
-			"PROX":          "prox",
-			"iCLASS":        "iclass",
-			"AWID":          "awid",
-			"Indala":        "indala",
-			"Avigilon":      "avigilon",
-			"EM4100 / Net2": "em",
-			"PIV":           "piv",
-			"MIFARE":        "mifare",
-		}
+		ctx := beginOperation()
 
 		cardTypeValue := cardType.Selected
 		bitLengthValue := bitLength.Selected
@@ -550,15 +575,22 @@ func runGUI() {
 		uidValue := uid.Text
 		actionValue := action.Selected
 
-		cardTypeCmd := cardTypeMap[cardTypeValue]
+		selectedCardType, ok := lookupCardTypeByDisplayName(cardTypeValue)
+		if !ok {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "Please select a card type first")
+			return
+		}
+		cardTypeCmd := selectedCardType.Name()
 
 		var args []string
 		args = append(args, "-t", cardTypeCmd)
 
-		switch cardTypeCmd {
-		case "prox", "iclass", "awid", "indala", "avigilon":
+		switch selectedCardType.Input() {
+		case InputWiegand:
 			if facilityCodeValue == "" || cardNumberValue == "" {
-				currentStatusOutput.Set("✗  Facility Code and Card Number are required\n")
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "Facility Code and Card Number are required")
 				return
 			}
 
@@ -568,30 +600,35 @@ func runGUI() {
 			bl, blErr := strconv.Atoi(bitLengthValue)
 
 			if fcErr != nil || cnErr != nil || blErr != nil {
-				currentStatusOutput.Set("✗  Invalid numeric values for Facility Code, Card Number, or Bit Length\n")
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "Invalid numeric values for Facility Code, Card Number, or Bit Length")
 				return
 			}
 
 			if valid, errMsg := validateCardInput(cardTypeCmd, bl, fc, cn); !valid {
-				currentStatusOutput.Set(fmt.Sprintf("✗  %s\n", errMsg))
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "%s", errMsg)
 				return
 			}
 
 			args = append(args, "-bl", bitLengthValue, "-fc", facilityCodeValue, "-cn", cardNumberValue)
-		case "em":
+		case InputHex:
 			if hexDataValue == "" {
-				currentStatusOutput.Set("✗  Hex Data is required for EM4100 / Net2 cards\n")
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "Hex Data is required for %s cards", selectedCardType.DisplayName())
 				return
 			}
 			// Validate EM4100 hex data format
 			if valid, errMsg := validateEM4100Hex(hexDataValue); !valid {
-				currentStatusOutput.Set(fmt.Sprintf("✗  %s\n", errMsg))
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "%s", errMsg)
 				return
 			}
 			args = append(args, "--hex", hexDataValue)
-		case "mifare", "piv":
+		case InputUID:
 			if uidValue == "" {
-				currentStatusOutput.Set("✗  UID is required\n")
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "UID is required")
 				return
 			}
 			args = append(args, "--uid", uidValue)
@@ -604,136 +641,81 @@ func runGUI() {
 			args = append(args, "-s")
 		}
 
-		go func() {
-			fc, _ := strconv.Atoi(facilityCodeValue)
-			cn, _ := strconv.Atoi(cardNumberValue)
-			bl, _ := strconv.Atoi(bitLengthValue)
-
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
+		fc, _ := strconv.Atoi(facilityCodeValue)
+		cn, _ := strconv.Atoi(cardNumberValue)
+		bl, _ := strconv.Atoi(bitLengthValue)
 
-			done := make(chan bool, 1) // Buffered channel to prevent blocking
+		if actionValue == "Generate Command" {
+			// Generating does not use the Proxmark3, so it does not wait in the job queue
 			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
-
-			if actionValue == "Generate Command" {
-				WriteStatusInfo("Generating PM3 command...")
+				WriteStatusInfo(ctx, "Generating PM3 command...")
 
 				// Generate the actual PM3 command string based on card type
-				var cmdStr string
-				switch cardTypeCmd {
-				case "prox":
-					formatMap := map[int]string{26: "H10301", 28: "2804W", 30: "ATSW30", 31: "ADT31", 33: "D10202", 34: "H10306", 35: "C1k35s", 36: "S12906", 37: "H10304", 46: "H800002", 48: "C1k48s"}
-					if format, ok := formatMap[bl]; ok {
-						cmdStr = fmt.Sprintf("lf hid clone -w %s --fc %d --cn %d", format, fc, cn)
-					}
-				case "iclass":
-					formatMap := map[int]string{26: "H10301", 30: "ATSW30", 33: "D10202", 34: "H10306", 35: "C1k35s", 36: "S12906", 37: "H10304", 46: "H800002", 48: "C1k48s"}
-					if format, ok := formatMap[bl]; ok {
-						cmdStr = fmt.Sprintf("hf iclass encode -w %s --fc %d --cn %d --ki 0", format, fc, cn)
-					}
-				case "awid":
-					cmdStr = fmt.Sprintf("lf awid clone --fmt 26 --fc %d --cn %d", fc, cn)
-				case "indala":
-					cmdStr = fmt.Sprintf("lf indala clone --fc %d --cn %d", fc, cn)
-				case "avigilon":
-					cmdStr = fmt.Sprintf("lf hid clone -w Avig56 --fc %d --cn %d", fc, cn)
-				case "em":
-					cmdStr = fmt.Sprintf("lf em 410x clone --id %s", hexDataValue)
-				case "mifare", "piv":
-					cmdStr = fmt.Sprintf("hf mf csetuid -u %s", uidValue)
-				}
+				cmdStr, err := selectedCardType.WriteCommand(CardParams{
+					BitLength:    bl,
+					FacilityCode: fc,
+					CardNumber:   cn,
+					HexData:      hexDataValue,
+					UID:          uidValue,
+				})
 
 				// Show command in command output
-				if cmdStr != "" {
-					fmt.Println(cmdStr)
+				if err != nil {
+					emitOutput(ctx, fmt.Sprintf("Error: %v", err))
 				} else {
-					fmt.Println("Error: Unsupported configuration")
+					emitCommand(ctx, cmdStr)
 				}
 
-				// Restore stdout/stderr
-				w.Close()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
+				WriteStatusSuccess(ctx, "PM3 command generated")
 
-				WriteStatusSuccess("PM3 command generated")
-				return
-			}
+				// Show the encoded Wiegand data alongside the command
+				if card, err := generateCardData(cardTypeCmd, bl, fc, cn, hexDataValue, uidValue); err != nil {
+					WriteStatusError(ctx, "Failed to encode card data: %v", err)
+				} else {
+					displayGeneratedCard(ctx, cardTypeCmd, card)
+				}
+			}()
+			return
+		}
 
+		runJob(ctx, actionValue, func(ctx context.Context) error {
 			// Check Proxmark3 status for actual operations
-			WriteStatusInfo("Checking Proxmark3 connection...")
-			if ok, msg := checkProxmark3(); !ok {
-				WriteStatusError(msg)
-				// Clean up and restore state
-				w.Close()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
+			WriteStatusInfo(ctx, "Checking Proxmark3 connection...")
+			if err := checkProxmark3(ctx); err != nil {
+				WriteStatusError(ctx, "%v", err)
+				return emitFailure(ctx, err)
 			}
-			WriteStatusSuccess("Proxmark3 connected")
-			WriteStatusInfo("Executing %s...", actionValue)
+			WriteStatusSuccess(ctx, "Proxmark3 connected")
+			WriteStatusInfo(ctx, "Executing %s...", actionValue)
 
 			// Determine operation
 			write := (actionValue == "Write & Verify")
 			verify := (actionValue == "Write & Verify")
 			simulate := (actionValue == "Simulate Card")
 
-			handleCardType(cardTypeCmd, fc, cn, bl, write, verify, uidValue, hexDataValue, simulate)
-
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-
-			w.Close()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
+			if err := handleCardType(ctx, cardTypeCmd, fc, cn, bl, write, verify, uidValue, hexDataValue, simulate); err != nil {
+				return err
+			}
 
-			WriteStatusSuccess("%s completed", actionValue)
-		}()
+			WriteStatusSuccess(ctx, "%s completed", actionValue)
+			return nil
+		})
 	}
 
-	// Initialize cancellation channel
-	operationCancelChan = make(chan struct{})
-
 	submit := newOutlinedButton("WRITE", func() {
-		// Reset cancellation channel when starting new operation
-		operationCancelMutex.Lock()
-		close(operationCancelChan)
-		operationCancelChan = make(chan struct{})
-		operationCancelMutex.Unlock()
 		executeCommand()
 	})
 
+	// CANCEL stops the job whose output is shown; jobs on other Proxmark3s keep running
 	cancel := newOutlinedButton("CANCEL", func() {
-		operationCancelMutex.Lock()
-		select {
-		case <-operationCancelChan:
-			// Already closed
-		default:
-			close(operationCancelChan)
+		shownJobMu.Lock()
+		shown := shownJob
+		shownJobMu.Unlock()
+		if jobs.Cancel(shown) {
+			WriteStatusInfo(context.Background(), "Cancellation of job #%d requested...", shown)
+		} else {
+			WriteStatusInfo(context.Background(), "No running job to cancel")
 		}
-		operationCancelMutex.Unlock()
-		WriteStatusInfo("Operation cancellation requested...")
 	})
 
 	reset := newOutlinedButton("RESET", func() {
@@ -756,7 +738,7 @@ func runGUI() {
 		currentCommandOutput.mu.Unlock()
 
 		w.Clipboard().SetContent(commandText)
-		WriteStatusSuccess("Output copied to clipboard!")
+		WriteStatusSuccess(context.Background(), "Output copied to clipboard!")
 	})
 
 	clearOutput := newOutlinedButton("CLEAR SCREEN", func() {
@@ -767,12 +749,115 @@ func runGUI() {
 	launchPm3Button := newOutlinedButton("LAUNCH PM3", func() {
 		err := launchPm3Terminal()
 		if err != nil {
-			WriteStatusError("Failed to launch Proxmark3 terminal: %v", err)
+			WriteStatusError(context.Background(), "Failed to launch Proxmark3 terminal: %v", err)
 		} else {
-			WriteStatusSuccess("Proxmark3 terminal launched")
+			WriteStatusSuccess(context.Background(), "Proxmark3 terminal launched")
 		}
 	})
 
+	// Proxmark3 picker - operations run on the device selected when they start, so a read on
+	// one device and a write on another can run at the same time
+	const autoDetectDevice = "Auto-detect Proxmark3"
+	devicePorts := map[string]string{autoDetectDevice: ""}
+	devicePicker := widget.NewSelect([]string{autoDetectDevice}, func(selected string) {
+		selectPm3Device(devicePorts[selected])
+	})
+	if port := selectedPm3Device(); port != "" {
+		devicePorts[port] = port
+		devicePicker.Options = append(devicePicker.Options, port)
+		devicePicker.SetSelected(port)
+	} else {
+		devicePicker.SetSelected(autoDetectDevice)
+	}
+
+	refreshDevicesButton := newOutlinedButton("FIND DEVICES", func() {
+		WriteStatusProgress(context.Background(), "Looking for Proxmark3 devices...")
+		go func() {
+			devices, err := enumeratePm3Devices(context.Background())
+			if err != nil {
+				WriteStatusError(context.Background(), "Failed to list Proxmark3 devices: %v", err)
+				return
+			}
+			current := selectedPm3Device()
+			ports := map[string]string{autoDetectDevice: ""}
+			options := []string{autoDetectDevice}
+			selected := autoDetectDevice
+			for _, d := range devices {
+				label := d.Label()
+				ports[label] = d.Port
+				options = append(options, label)
+				if d.Port == current {
+					selected = label
+				}
+			}
+			fyne.Do(func() {
+				devicePorts = ports
+				devicePicker.Options = options
+				devicePicker.SetSelected(selected)
+				devicePicker.Refresh()
+			})
+			WriteStatusSuccess(context.Background(), "Found %d Proxmark3 device(s)", len(devices))
+		}()
+	})
+
+	// Job list - queued, running and recent Proxmark3 jobs; pick one to show its output, or
+	// cancel it with the button next to it
+	jobList := widget.NewList(
+		func() int {
+			return len(jobs.List())
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			cancelJob := widget.NewButtonWithIcon("", theme.CancelIcon(), nil)
+			cancelJob.Importance = widget.LowImportance
+			return container.NewBorder(nil, nil, nil, cancelJob, label)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			list := jobs.List()
+			if id >= len(list) {
+				return
+			}
+			job := list[id]
+			row := obj.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(job.Describe())
+			cancelJob := row.Objects[1].(*widget.Button)
+			if st := job.State(); st == JobQueued || st == JobRunning {
+				cancelJob.OnTapped = func() {
+					if jobs.Cancel(job.ID) {
+						WriteStatusInfo(context.Background(), "Cancellation of job #%d requested...", job.ID)
+					}
+				}
+				cancelJob.Show()
+			} else {
+				cancelJob.Hide()
+			}
+		},
+	)
+	jobList.OnSelected = func(id widget.ListItemID) {
+		list := jobs.List()
+		if id < len(list) {
+			job := list[id]
+			currentStatusOutput.Clear()
+			currentCommandOutput.Clear()
+			followJob(job.ID)
+			for _, e := range job.Events() {
+				showEvent(e)
+			}
+		}
+		jobList.UnselectAll()
+	}
+	jobs.OnChange = func() {
+		fyne.Do(func() {
+			jobList.Refresh()
+		})
+	}
+	jobListLabel := canvas.NewText("PROXMARK3 JOBS", color.RGBA{R: 169, G: 182, B: 201, A: 255})
+	jobListLabel.TextSize = 11
+	jobListMinSize := canvas.NewRectangle(color.RGBA{R: 0, G: 0, B: 0, A: 0})
+	jobListMinSize.SetMinSize(fyne.NewSize(0, 90))
+	jobListSized := container.NewStack(jobListMinSize, jobList)
+
 	submitSized := container.NewStack(submit)
 	submitSized.Resize(fyne.NewSize(100, 30))
 
@@ -792,98 +877,168 @@ func runGUI() {
 	versionText.TextSize = 11
 	versionText.Alignment = fyne.TextAlignCenter
 
+	// fillCardForm loads card values into the Corporate section so they can be written or verified
+	fillCardForm := func(ct CardType, p CardParams) {
+		cardType.SetSelected(ct.DisplayName())
+		if p.BitLength > 0 && ct.Input() == InputWiegand {
+			bitLength.SetSelected(strconv.Itoa(p.BitLength))
+		}
+
+		facilityCode.SetText("")
+		cardNumber.SetText("")
+		hexData.SetText("")
+		uid.SetText("")
+		switch ct.Input() {
+		case InputHex:
+			hexData.SetText(p.HexData)
+		case InputUID:
+			uid.SetText(p.UID)
+		default:
+			facilityCode.SetText(strconv.Itoa(p.FacilityCode))
+			cardNumber.SetText(strconv.Itoa(p.CardNumber))
+		}
+	}
+
 	// READ CARD DATA button - uses the cardType dropdown from Corporate section
 	readCardButton := newOutlinedButton("READ CARD DATA", func() {
 		// Clear output immediately when Read is pressed
 		currentStatusOutput.Clear()
 		currentCommandOutput.Clear()
 
-		// Map GUI card type to internal card type
-		readCardTypeMap := map[string]string{
-			"PROX":          "prox",
-			"iCLASS":        "iclass",
-			"AWID":          "awid",
-			"Indala":        "indala",
-			"Avigilon":      "avigilon",
-			"EM4100 / Net2": "em",
-			"PIV":           "piv",
-			"MIFARE":        "mifare",
-		}
-
 		// Use the cardType dropdown from Corporate section
-		selectedReadType := cardType.Selected
-		if selectedReadType == "" {
-			WriteStatusError("Please select a card type first")
+		selectedReadType, ok := lookupCardTypeByDisplayName(cardType.Selected)
+		if !ok {
+			WriteStatusError(context.Background(), "Please select a card type first")
 			return
 		}
-		cardTypeCmd := readCardTypeMap[selectedReadType]
+		cardTypeCmd := selectedReadType.Name()
+
+		ctx := beginOperation()
 
 		// Run in goroutine to keep UI responsive
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
+		runJob(ctx, "READ CARD DATA", func(ctx context.Context) error {
+			WriteStatusInfo(ctx, "Reading card...")
 
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
+			// Check Proxmark3 connection
+			if err := checkProxmark3(ctx); err != nil {
+				WriteStatusError(ctx, "%v", err)
+				return emitFailure(ctx, err)
+			}
 
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					// Write to command output window
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
+			WriteStatusSuccess(ctx, "Proxmark3 connected")
+			cardRead, err := readCardData(ctx, cardTypeCmd)
+			if err != nil {
+				return err
+			}
 
-			SetStatusWriter(statusWriter)
+			// Load the values that were read so they can be written without retyping
+			if cardRead.hasCredential() || cardRead.HexData != "" || cardRead.UID != "" {
+				fyne.Do(func() {
+					fillCardForm(selectedReadType, cardRead.Params())
+				})
+				WriteStatusInfo(ctx, "Card values loaded into the form")
+			}
 
-			WriteStatusInfo("Reading card...")
+			WriteStatusSuccess(ctx, "Read card completed")
+			return nil
+		})
+	})
 
-			// Check Proxmark3 connection
-			if ok, msg := checkProxmark3(); !ok {
-				WriteStatusError(msg)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
+	// Size the read card button
+	readCardButtonSized := container.NewStack(readCardButton)
+	readCardButtonSized.Resize(fyne.NewSize(120, 30))
+
+	// loadCapturedCredential fills the Corporate section with a credential captured by a Doppelgänger reader
+	loadCapturedCredential := func(cred capturedCredential) {
+		ct, ok := lookupCardType(cred.CardType)
+		if !ok {
+			return
+		}
+		fillCardForm(ct, CardParams{
+			BitLength:    cred.BitLength,
+			FacilityCode: cred.FacilityCode,
+			CardNumber:   cred.CardNumber,
+			HexData:      cred.HexData,
+			UID:          cred.UID,
+		})
+
+		currentStatusOutput.Clear()
+		WriteStatusSuccess(context.Background(), "Loaded captured credential: %s", cred.describe())
+	}
+
+	// showCapturedCredentialPicker lists captured credentials and loads the selected one
+	showCapturedCredentialPicker := func(title string, creds []capturedCredential) {
+		selected := -1
+		list := widget.NewList(
+			func() int { return len(creds) },
+			func() fyne.CanvasObject { return widget.NewLabel("") },
+			func(id widget.ListItemID, obj fyne.CanvasObject) {
+				obj.(*widget.Label).SetText(creds[id].describe())
+			},
+		)
+		list.OnSelected = func(id widget.ListItemID) {
+			selected = id
+		}
+
+		picker := dialog.NewCustomConfirm(title, "LOAD", "CANCEL", list, func(load bool) {
+			if !load || selected < 0 {
+				return
+			}
+			cred := creds[selected]
+			if err := cred.cloneError(); err != nil {
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "%v", err)
 				return
 			}
+			loadCapturedCredential(cred)
+		}, w)
+		picker.Resize(fyne.NewSize(700, 450))
+		picker.Show()
+	}
 
-			WriteStatusSuccess("Proxmark3 connected")
-			readCardData(cardTypeCmd)
+	// IMPORT LOG button - imports a Doppelgänger Core / Stealth capture log
+	importLogButton := newOutlinedButton("IMPORT LOG", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "Failed to open log: %v", err)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
 
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
+			creds, err := importDoppelgangerLog(reader)
+			if err != nil {
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "%v", err)
+				return
+			}
+			if len(creds) == 0 {
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "No credentials found in log")
+				return
+			}
 
-			WriteStatusSuccess("Read card completed")
-		}()
+			showCapturedCredentialPicker(fmt.Sprintf("Captured Credentials (%d)", len(creds)), creds)
+		}, w)
 	})
 
-	// Size the read card button
-	readCardButtonSized := container.NewStack(readCardButton)
-	readCardButtonSized.Resize(fyne.NewSize(120, 30))
+	importLogButtonSized := container.NewStack(importLogButton)
+	importLogButtonSized.Resize(fyne.NewSize(120, 30))
+
+	readImportRow := container.NewGridWithColumns(2,
+		readCardButtonSized,
+		importLogButtonSized,
+	)
 
 	// Corporate Access Control Cards Section - collapsible
 	corporateSectionContent := container.NewVBox(
 		container.NewPadded(cardTypeLabel),
 		container.NewPadded(cardType),
 		widget.NewSeparator(),
-		container.NewPadded(readCardButtonSized),
+		container.NewPadded(readImportRow),
 		widget.NewSeparator(),
 		container.NewPadded(dataBlocks),
 		widget.NewSeparator(),
@@ -905,107 +1060,10 @@ func runGUI() {
 	sniffKeysButton := newOutlinedButton("SNIFF KEYS", func() {
 		currentStatusOutput.Clear()
 		currentCommandOutput.Clear()
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
-
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
-			WriteStatusInfo("Starting key sniffing...")
-
-			if ok, msg := checkProxmark3(); !ok {
-				WriteStatusError(msg)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
-			}
-
-			WriteStatusSuccess("Proxmark3 connected")
-			WriteStatusInfo("Place card on reader and use it at a reader to capture keys")
-
-			pm3Binary, err := getPm3Path()
-			if err != nil {
-				WriteStatusError("Failed to find pm3 binary: %v", err)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
-			}
-
-			device, err := getPm3Device()
-			if err != nil {
-				WriteStatusError("Failed to detect pm3 device: %v", err)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
-			}
-
-			fmt.Println("hf sniff")
-			fmt.Println()
-			WriteStatusInfo("Sniffing will continue until you press the Proxmark3 button")
-			WriteStatusInfo("Use 'data samples' to download captured data")
-			WriteStatusInfo("Use 'data plot' to visualize captured data")
-
-			cmd := exec.Command(pm3Binary, "-c", "hf sniff", "-p", device)
-			output, cmdErr := cmd.CombinedOutput()
-			outputStr := string(output)
-			fmt.Println(outputStr)
-
-			if cmdErr != nil {
-				// Check if it's just the user pressing the button to stop
-				if strings.Contains(outputStr, "button") || strings.Contains(outputStr, "Button") {
-					WriteStatusSuccess("Sniffing stopped by user")
-					WriteStatusInfo("Use buttons below to process captured data")
-				} else {
-					WriteStatusError("Sniff failed: %v", cmdErr)
-				}
-			} else {
-				WriteStatusSuccess("Key sniffing completed")
-				WriteStatusInfo("Use buttons below to process captured data")
-			}
-
-			// Extract sample count if available
-			sampleRegex := regexp.MustCompile(`(\d+)\s+samples?`)
-			if match := sampleRegex.FindStringSubmatch(outputStr); len(match) > 1 {
-				WriteStatusInfo("Captured %s samples", match[1])
-			}
-
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-		}()
+		ctx := beginOperation()
+		runJob(ctx, "SNIFF KEYS", func(ctx context.Context) error {
+			return sniffHFKeys(ctx)
+		})
 	})
 
 	// Dump file path input
@@ -1038,299 +1096,37 @@ func runGUI() {
 				fyne.Do(func() {
 					dumpFilePathEntry.SetText(dumpPath)
 				})
-				WriteStatusInfo("Auto-selected latest dump file: %s", dumpPath)
+				WriteStatusInfo(context.Background(), "Auto-selected latest dump file: %s", dumpPath)
 			} else {
-				WriteStatusError("Dump file path is required and no recent dump file found")
+				WriteStatusError(context.Background(), "Dump file path is required and no recent dump file found")
 				return
 			}
 		}
 
-		// Expand any tilde in path
-		if strings.HasPrefix(dumpPath, "~") {
-			homeDir, err := os.UserHomeDir()
-			if err == nil {
-				dumpPath = filepath.Join(homeDir, strings.TrimPrefix(dumpPath, "~"))
-			}
-		}
-
-		// Convert to absolute path
-		if !filepath.IsAbs(dumpPath) {
-			absPath, err := filepath.Abs(dumpPath)
-			if err == nil {
-				dumpPath = absPath
-			}
-		}
+		dumpPath = expandUserPath(dumpPath)
 
 		// Validate dump file exists
 		if _, err := os.Stat(dumpPath); os.IsNotExist(err) {
-			WriteStatusError("Dump file does not exist: %s", dumpPath)
+			WriteStatusError(context.Background(), "Dump file does not exist: %s", dumpPath)
 			homeDir, _ := os.UserHomeDir()
-			WriteStatusInfo("Searched locations: %s, %s/.proxmark3, current directory", homeDir, homeDir)
+			WriteStatusInfo(context.Background(), "Searched locations: %s, %s/.proxmark3, current directory", homeDir, homeDir)
 			// Try to find similar files
 			if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dumpPath), filepath.Base(dumpPath)+"*")); len(matches) > 0 {
-				WriteStatusInfo("Found similar files: %v", matches)
+				WriteStatusInfo(context.Background(), "Found similar files: %v", matches)
 			}
 			return
 		}
 
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
-
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
-			WriteStatusInfo("Writing card from dump file...")
-
-			if ok, msg := checkProxmark3(); !ok {
-				WriteStatusError(msg)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
-			}
-
-			WriteStatusSuccess("Proxmark3 connected")
-			WriteStatusInfo("Place blank card on reader")
-
-			pm3Binary, err := getPm3Path()
-			if err != nil {
-				WriteStatusError("Failed to find pm3 binary: %v", err)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
-			}
-
-			device, err := getPm3Device()
-			if err != nil {
-				WriteStatusError("Failed to detect pm3 device: %v", err)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
-			}
-
-			// Wipe card first if requested (recommended for magic cards)
-			if wipeBeforeWrite.Checked {
-				WriteStatusProgress("Wiping card to default state...")
-				fmt.Println()
-				fmt.Println("hf mf cwipe")
-				fmt.Println()
-
-				wipeCmd := exec.Command(pm3Binary, "-c", "hf mf cwipe", "-p", device)
-				wipeOutput, wipeErr := wipeCmd.CombinedOutput()
-				wipeOutputStr := string(wipeOutput)
-				fmt.Println(wipeOutputStr)
-
-				if wipeErr != nil {
-					WriteStatusError("Wipe failed: %v", wipeErr)
-					WriteStatusInfo("Continuing with write anyway...")
-				} else {
-					WriteStatusSuccess("Card wiped successfully")
-				}
-			}
-
-			keyPath := strings.TrimSpace(keyFilePathEntry.Text)
-			// If not specified, try to find the latest key file
-			if keyPath == "" {
-				latestKey := findLatestKeyFile()
-				if latestKey != "" {
-					keyPath = latestKey
-					fyne.Do(func() {
-						keyFilePathEntry.SetText(keyPath)
-					})
-					WriteStatusInfo("Auto-selected latest key file: %s", keyPath)
-				}
-			}
-
-			// Expand any tilde in path
-			if keyPath != "" && strings.HasPrefix(keyPath, "~") {
-				homeDir, err := os.UserHomeDir()
-				if err == nil {
-					keyPath = filepath.Join(homeDir, strings.TrimPrefix(keyPath, "~"))
-				}
-			}
-
-			// Convert to absolute path
-			if keyPath != "" && !filepath.IsAbs(keyPath) {
-				absPath, err := filepath.Abs(keyPath)
-				if err == nil {
-					keyPath = absPath
-				}
-			}
-
-			// Validate key file exists if provided, otherwise skip it
-			if keyPath != "" {
-				if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-					WriteStatusError("Key file does not exist: %s", keyPath)
-					WriteStatusInfo("Proceeding without key file...")
-					keyPath = "" // Clear it so we don't use it
-				}
-			}
-
-			var cmd *exec.Cmd
-			var cmdStr string
-
-			if keyPath != "" {
-				cmdStr = fmt.Sprintf("hf mf restore -f %s -k %s", dumpPath, keyPath)
-				cmd = exec.Command(pm3Binary, "-c", cmdStr, "-p", device)
-				WriteStatusInfo("Using dump file: %s", dumpPath)
-				WriteStatusInfo("Using key file: %s", keyPath)
-			} else {
-				cmdStr = fmt.Sprintf("hf mf restore -f %s", dumpPath)
-				cmd = exec.Command(pm3Binary, "-c", cmdStr, "-p", device)
-				WriteStatusInfo("Using dump file: %s (no key file)", dumpPath)
-			}
-
-			fmt.Println(cmdStr)
-			fmt.Println()
-
-			output, cmdErr := cmd.CombinedOutput()
-			outputStr := string(output)
-			fmt.Println(outputStr)
-
-			if cmdErr != nil {
-				WriteStatusError("Write failed: %v", cmdErr)
-			} else {
-				WriteStatusSuccess("Card written successfully from dump file")
-
-				// Automatically verify the write
-				WriteStatusProgress("Verifying card data...")
-				fmt.Println()
-
-				// Use the key file if available, otherwise try without
-				var verifyCmd *exec.Cmd
-				var verifyCmdStr string
-				if keyPath != "" {
-					verifyCmdStr = fmt.Sprintf("hf mf dump --ns -k %s", keyPath)
-					verifyCmd = exec.Command(pm3Binary, "-c", verifyCmdStr, "-p", device)
-					fmt.Println(verifyCmdStr)
-				} else {
-					verifyCmdStr = "hf mf dump --ns"
-					verifyCmd = exec.Command(pm3Binary, "-c", verifyCmdStr, "-p", device)
-					fmt.Println(verifyCmdStr)
-				}
-				fmt.Println()
-
-				verifyOutput, verifyErr := verifyCmd.CombinedOutput()
-				verifyOutputStr := string(verifyOutput)
-				fmt.Println(verifyOutputStr)
-
-				if verifyErr != nil {
-					WriteStatusError("Verification dump failed: %v", verifyErr)
-					WriteStatusInfo("Note: Some blocks may require different keys or may be protected")
-				} else {
-					// Read original dump file and extract UID
-					var dumpUID, cardUID string
-					var dumpATQA, dumpSAK, cardATQA, cardSAK string
-
-					if dumpPath != "" {
-						dumpData, err := os.ReadFile(dumpPath)
-						if err == nil && len(dumpData) >= 16 {
-							// MIFARE Classic UID is in block 0, bytes 0-3
-							dumpUID = fmt.Sprintf("%02X%02X%02X%02X", dumpData[0], dumpData[1], dumpData[2], dumpData[3])
-							// ATQA is typically in block 0, byte 6-7, SAK in byte 5
-							if len(dumpData) > 7 {
-								dumpSAK = fmt.Sprintf("%02X", dumpData[5])
-								dumpATQA = fmt.Sprintf("%02X%02X", dumpData[6], dumpData[7])
-							}
-						}
-					}
-
-					// Extract UID from verification output (block 0)
-					// Look for pattern: "   0 | XX XX XX XX ..." where first 4 bytes are UID
-					block0Regex := regexp.MustCompile(`(?m)^\s+0\s+\|\s+([0-9A-F]{2})\s+([0-9A-F]{2})\s+([0-9A-F]{2})\s+([0-9A-F]{2})`)
-					block0Match := block0Regex.FindStringSubmatch(verifyOutputStr)
-					if len(block0Match) == 5 {
-						cardUID = strings.ToUpper(block0Match[1] + block0Match[2] + block0Match[3] + block0Match[4])
-					}
-
-					// Extract ATQA and SAK from block 0 if available
-					block0FullRegex := regexp.MustCompile(`(?m)^\s+0\s+\|\s+([0-9A-F]{2}\s+){5}([0-9A-F]{2})\s+([0-9A-F]{2})\s+([0-9A-F]{2})`)
-					block0FullMatch := block0FullRegex.FindStringSubmatch(verifyOutputStr)
-					if len(block0FullMatch) >= 5 {
-						cardSAK = strings.ToUpper(block0FullMatch[2])
-						cardATQA = strings.ToUpper(block0FullMatch[3] + block0FullMatch[4])
-					}
-
-					// Compare and display results
-					if dumpUID != "" && cardUID != "" {
-						if dumpUID == cardUID {
-							WriteStatusSuccess("✓ SUCCESS! Card UID matches dump file")
-							WriteStatusInfo("UID: %s (matches)", cardUID)
-						} else {
-							WriteStatusError("UID mismatch! Dump: %s, Card: %s", dumpUID, cardUID)
-						}
-
-						// Show ATQA and SAK if available
-						if dumpATQA != "" && cardATQA != "" {
-							if dumpATQA == cardATQA {
-								WriteStatusInfo("ATQA: %s (matches)", cardATQA)
-							} else {
-								WriteStatusInfo("ATQA: Dump=%s, Card=%s (mismatch)", dumpATQA, cardATQA)
-							}
-						}
-
-						if dumpSAK != "" && cardSAK != "" {
-							if dumpSAK == cardSAK {
-								WriteStatusInfo("SAK: %s (matches)", cardSAK)
-							} else {
-								WriteStatusInfo("SAK: Dump=%s, Card=%s (mismatch)", dumpSAK, cardSAK)
-							}
-						}
-					} else if cardUID != "" {
-						WriteStatusSuccess("✓ Card verified successfully")
-						WriteStatusInfo("Card UID: %s", cardUID)
-						if cardATQA != "" {
-							WriteStatusInfo("ATQA: %s", cardATQA)
-						}
-						if cardSAK != "" {
-							WriteStatusInfo("SAK: %s", cardSAK)
-						}
-					}
-
-					// Check for success indicators
-					if strings.Contains(verifyOutputStr, "Succeeded in dumping all blocks") {
-						okCount := strings.Count(verifyOutputStr, "( ok )")
-						WriteStatusInfo("All %d blocks read successfully", okCount)
-					}
-				}
-			}
-
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-		}()
+		keyPath := strings.TrimSpace(keyFilePathEntry.Text)
+		wipe := wipeBeforeWrite.Checked
+		ctx := beginOperation()
+		runJob(ctx, "WRITE FROM DUMP", func(ctx context.Context) error {
+			return restoreFromDump(ctx, dumpPath, keyPath, wipe, func(keyPath string) {
+				fyne.Do(func() {
+					keyFilePathEntry.SetText(keyPath)
+				})
+			})
+		})
 	})
 
 	// Start attack button (uses selected attack method)
@@ -1359,32 +1155,10 @@ func runGUI() {
 			recoveryMethod = "autopwn"
 		}
 
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
-
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
+		ctx := beginOperation()
+		runJob(ctx, "START ATTACK", func(ctx context.Context) error {
 			// Pass callback to auto-populate file paths when recovery completes
-			recoverHotelKey(recoveryMethod, func(dumpPath, keyPath string) {
+			return recoverHotelKey(ctx, recoveryMethod, func(dumpPath, keyPath string) {
 				if dumpPath != "" {
 					fyne.Do(func() {
 						dumpFilePathEntry.SetText(dumpPath)
@@ -1396,14 +1170,7 @@ func runGUI() {
 					})
 				}
 			})
-
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-		}()
+		})
 	})
 
 	// Size buttons consistently
@@ -1418,78 +1185,20 @@ func runGUI() {
 	cardInfoButton := newOutlinedButton("CARD INFO", func() {
 		currentStatusOutput.Clear()
 		currentCommandOutput.Clear()
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
-
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
-			getCardInfo()
-
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-		}()
+		ctx := beginOperation()
+		runJob(ctx, "CARD INFO", func(ctx context.Context) error {
+			return getCardInfo(ctx)
+		})
 	})
 
 	checkKeysButton := newOutlinedButton("CHECK KEYS", func() {
 		currentStatusOutput.Clear()
 		currentCommandOutput.Clear()
 		keyPath := strings.TrimSpace(keyFilePathEntry.Text)
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
-
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
-			checkKeysFast(keyPath)
-
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-		}()
+		ctx := beginOperation()
+		runJob(ctx, "CHECK KEYS", func(ctx context.Context) error {
+			return checkKeysFast(ctx, keyPath)
+		})
 	})
 
 	// UID input for magic card operations
@@ -1503,42 +1212,13 @@ func runGUI() {
 		currentCommandOutput.Clear()
 		uid := strings.TrimSpace(uidEntry.Text)
 		if uid == "" {
-			WriteStatusError("UID is required")
+			WriteStatusError(context.Background(), "UID is required")
 			return
 		}
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
-
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
-
-			SetStatusWriter(statusWriter)
-			setMagicCardUID(uid)
-
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-		}()
+		ctx := beginOperation()
+		runJob(ctx, "SET UID", func(ctx context.Context) error {
+			return setMagicCardUID(ctx, uid)
+		})
 	})
 
 	// Size all buttons consistently
@@ -1594,438 +1274,621 @@ func runGUI() {
 		currentStatusOutput.Clear()
 		currentCommandOutput.Clear()
 
+		ctx := beginOperation()
+
 		// Run in goroutine to keep UI responsive
-		go func() {
-			statusWriter := &guiWriter{output: currentStatusOutput, scroll: statusScroll}
+		runJob(ctx, "DETECT CARD TYPE", func(ctx context.Context) error {
+			return detectCardType(ctx)
+		})
+	})
 
-			oldStdout := os.Stdout
-			oldStderr := os.Stderr
-			r, w, _ := os.Pipe()
-			os.Stdout = w
-			os.Stderr = w
+	// Size the detect button to match other buttons
+	detectCardButtonSized := container.NewStack(detectCardButton)
+	detectCardButtonSized.Resize(fyne.NewSize(120, 30))
 
-			done := make(chan bool, 1)
-			go func() {
-				defer func() { done <- true }()
-				scanner := bufio.NewScanner(r)
-				for scanner.Scan() {
-					line := scanner.Text()
-					currentCommandOutput.Append(line + "\n")
-					if commandScroll != nil {
-						fyne.Do(func() {
-							commandScroll.ScrollToBottom()
-						})
-					}
-				}
-			}()
+	// Card Discovery section - only DETECT CARD TYPE
+	cardDiscoverySectionContent := container.NewVBox(
+		container.NewPadded(detectCardButtonSized),
+	)
 
-			SetStatusWriter(statusWriter)
+	// Doppelgänger Live Capture section - polls a reader's web interface for new captures
+	liveHostLabel := canvas.NewText("DEVICE HOST", color.RGBA{R: 169, G: 182, B: 201, A: 255})
+	liveHostLabel.TextSize = 11
+	liveHost := widget.NewEntry()
+	liveHost.SetPlaceHolder("192.168.4.1")
+	liveUser := widget.NewEntry()
+	liveUser.SetPlaceHolder("Username (optional)")
+	livePass := widget.NewPasswordEntry()
+	livePass.SetPlaceHolder("Password (optional)")
+	livePathLabel := canvas.NewText("CAPTURE LOG PATH", color.RGBA{R: 169, G: 182, B: 201, A: 255})
+	livePathLabel.TextSize = 11
+	livePath := widget.NewEntry()
+	livePath.SetPlaceHolder(defaultDoppelgangerLogPath)
+
+	liveIntervalLabel := canvas.NewText("POLL INTERVAL", color.RGBA{R: 169, G: 182, B: 201, A: 255})
+	liveIntervalLabel.TextSize = 11
+	liveInterval := widget.NewSelect([]string{"2s", "5s", "10s", "30s"}, nil)
+	liveInterval.SetSelected("5s")
+
+	var liveMu sync.Mutex
+	var liveCaptures []capturedCredential
+	var livePoller *doppelgangerPoller
+
+	// cloneCapturedCredential loads a capture into the Corporate section and runs Write & Verify
+	cloneCapturedCredential := func(cred capturedCredential) {
+		if err := cred.cloneError(); err != nil {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "%v", err)
+			return
+		}
+		loadCapturedCredential(cred)
+		action.SetSelected("Write & Verify")
+		executeCommand()
+	}
 
-			WriteStatusInfo("Detecting card type...")
+	liveList := widget.NewList(
+		func() int {
+			liveMu.Lock()
+			defer liveMu.Unlock()
+			return len(liveCaptures)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return container.NewBorder(nil, nil, nil, newOutlinedButton("CLONE", nil), label)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			liveMu.Lock()
+			cred := liveCaptures[id]
+			liveMu.Unlock()
+
+			row := obj.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(cred.describe())
+			row.Objects[1].(*outlinedButton).onTapped = func() {
+				cloneCapturedCredential(cred)
+			}
+		},
+	)
+	liveListMinSize := canvas.NewRectangle(color.RGBA{R: 0, G: 0, B: 0, A: 0})
+	liveListMinSize.SetMinSize(fyne.NewSize(0, 180))
+	liveListSized := container.NewStack(liveListMinSize, liveList)
+
+	var liveToggle *outlinedButton
+	liveToggle = newOutlinedButton("START POLLING", func() {
+		if livePoller != nil && livePoller.Running() {
+			livePoller.Stop()
+			liveToggle.text = "START POLLING"
+			liveToggle.Refresh()
+			WriteStatusInfo(context.Background(), "Stopped polling Doppelgänger device")
+			return
+		}
 
-			// Check Proxmark3 connection
-			if ok, msg := checkProxmark3(); !ok {
-				WriteStatusError(msg)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
+		host := strings.TrimSpace(liveHost.Text)
+		if host == "" {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "Device host is required")
+			return
+		}
+		interval, err := time.ParseDuration(liveInterval.Selected)
+		if err != nil {
+			interval = 5 * time.Second
+		}
+
+		livePoller = newDoppelgangerPoller(host, strings.TrimSpace(livePath.Text), strings.TrimSpace(liveUser.Text), livePass.Text, interval)
+		lastPollError := ""
+		livePoller.OnCapture = func(cred capturedCredential) {
+			lastPollError = ""
+			liveMu.Lock()
+			liveCaptures = append([]capturedCredential{cred}, liveCaptures...)
+			liveMu.Unlock()
+			fyne.Do(func() {
+				liveList.Refresh()
+			})
+			a.SendNotification(fyne.NewNotification("New credential captured", cred.describe()))
+			WriteStatusInfo(context.Background(), "New capture: %s", cred.describe())
+		}
+		livePoller.OnError = func(err error) {
+			// Only report an error once until it changes, polling would otherwise flood the status window
+			if err.Error() != lastPollError {
+				lastPollError = err.Error()
+				WriteStatusError(context.Background(), "%v", err)
 			}
+		}
+		livePoller.Start()
 
-			WriteStatusSuccess("Proxmark3 connected")
+		liveToggle.text = "STOP POLLING"
+		liveToggle.Refresh()
+		WriteStatusInfo(context.Background(), "Polling %s every %s", livePoller.url(), interval)
+	})
 
-			pm3Binary, err := getPm3Path()
-			if err != nil {
-				WriteStatusError("Failed to find pm3 binary: %v", err)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
-				return
+	liveClear := newOutlinedButton("CLEAR QUEUE", func() {
+		liveMu.Lock()
+		liveCaptures = nil
+		liveMu.Unlock()
+		liveList.Refresh()
+	})
+
+	// Polling would otherwise go on in the background after the window is closed
+	w.SetOnClosed(func() {
+		if livePoller != nil {
+			livePoller.Stop()
+		}
+	})
+
+	liveButtonRow := container.NewGridWithColumns(2,
+		liveToggle,
+		liveClear,
+	)
+
+	liveCaptureSectionContent := container.NewVBox(
+		container.NewPadded(liveHostLabel),
+		container.NewPadded(liveHost),
+		container.NewPadded(liveUser),
+		container.NewPadded(livePass),
+		container.NewPadded(livePathLabel),
+		container.NewPadded(livePath),
+		container.NewPadded(liveIntervalLabel),
+		container.NewPadded(liveInterval),
+		container.NewPadded(liveButtonRow),
+		widget.NewSeparator(),
+		container.NewPadded(liveListSized),
+	)
+
+	// Engagement Workspace section - records reads, writes, dumps and the pm3 transcript
+	workspaceName := widget.NewLabel("No workspace open")
+	workspaceName.Wrapping = fyne.TextWrapWord
+
+	var workspaceMu sync.Mutex
+	var workspaceRecords []WorkspaceRecord // newest first
+
+	// showWorkspaceRecord shows a record with its result data
+	showWorkspaceRecord := func(r WorkspaceRecord) {
+		details := fmt.Sprintf("Time: %s\nOperator: %s\n%s", r.Time.Format("2006-01-02 15:04:05"), r.Operator, r.Summary)
+		if r.Error != "" {
+			details += "\nError: " + r.Error
+		}
+		for _, file := range r.Files {
+			details += "\nFile: " + filepath.Join(activeWorkspace().Dir, filepath.FromSlash(file))
+		}
+		if result, err := r.Result(); err != nil {
+			details += "\n\n" + err.Error()
+		} else if len(result) > 0 {
+			var data bytes.Buffer
+			if json.Indent(&data, result, "", "  ") == nil {
+				details += "\n\n" + data.String()
 			}
+		}
+		text := widget.NewMultiLineEntry()
+		text.Wrapping = fyne.TextWrapWord
+		text.SetText(details)
+		d := dialog.NewCustom(strings.ToUpper(r.Kind), "CLOSE", text, w)
+		d.Resize(fyne.NewSize(700, 450))
+		d.Show()
+	}
 
-			device, err := getPm3Device()
+	workspaceList := widget.NewList(
+		func() int {
+			workspaceMu.Lock()
+			defer workspaceMu.Unlock()
+			return len(workspaceRecords)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			workspaceMu.Lock()
+			r := workspaceRecords[id]
+			workspaceMu.Unlock()
+			obj.(*widget.Label).SetText(r.Time.Format("Jan 2 15:04") + "  " + r.Summary)
+		},
+	)
+	workspaceList.OnSelected = func(id widget.ListItemID) {
+		workspaceMu.Lock()
+		r := workspaceRecords[id]
+		workspaceMu.Unlock()
+		workspaceList.UnselectAll()
+		showWorkspaceRecord(r)
+	}
+	workspaceListMinSize := canvas.NewRectangle(color.RGBA{R: 0, G: 0, B: 0, A: 0})
+	workspaceListMinSize.SetMinSize(fyne.NewSize(0, 220))
+	workspaceListSized := container.NewStack(workspaceListMinSize, workspaceList)
+
+	// showWorkspace lists a workspace's records and keeps the list up to date
+	showWorkspace := func(ws *Workspace) {
+		records, err := ws.Records()
+		if err != nil {
+			WriteStatusError(context.Background(), "%v", err)
+		}
+		workspaceMu.Lock()
+		workspaceRecords = nil
+		for i := len(records) - 1; i >= 0; i-- {
+			workspaceRecords = append(workspaceRecords, records[i])
+		}
+		workspaceMu.Unlock()
+		ws.SetOnRecord(func(r WorkspaceRecord) {
+			workspaceMu.Lock()
+			workspaceRecords = append([]WorkspaceRecord{r}, workspaceRecords...)
+			workspaceMu.Unlock()
+			fyne.Do(func() {
+				workspaceList.Refresh()
+			})
+		})
+		workspaceName.SetText(ws.Info.Name + "\n" + ws.Dir)
+		workspaceList.Refresh()
+	}
+
+	openGUIWorkspace := func(dir string) {
+		ws, err := useWorkspace(dir)
+		if err != nil {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "%v", err)
+			return
+		}
+		showWorkspace(ws)
+		WriteStatusSuccess(context.Background(), "Recording to workspace %s (%s)", ws.Info.Name, ws.Dir)
+	}
+
+	// chooseWorkspace opens an existing workspace, or makes a folder one
+	chooseWorkspace := func() {
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
 			if err != nil {
-				WriteStatusError("Failed to detect pm3 device: %v", err)
-				w.Close()
-				os.Stdout.Sync()
-				os.Stderr.Sync()
-				os.Stdout = oldStdout
-				os.Stderr = oldStderr
-				<-done
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "Failed to open folder: %v", err)
 				return
 			}
-
-			// Always check both LF and HF to detect dual chip cards
-			var lfFound bool
-			var hfFound bool
-			var lfCardType string
-			var hfCardType string
-
-			// Try LF search
-			WriteStatusProgress("Checking Low Frequency (LF)...")
-			fmt.Println("lf search")
-			fmt.Println()
-
-			lfCmd := exec.Command(pm3Binary, "-c", "lf search", "-p", device)
-			lfOutput, _ := lfCmd.CombinedOutput()
-			lfOutputStr := string(lfOutput)
-			// Filter out "Searching for..." lines and show only results
-			filteredLFOutput := filterSearchOutput(lfOutputStr)
-			if filteredLFOutput != "" {
-				fmt.Println(filteredLFOutput)
+			if uri != nil {
+				openGUIWorkspace(uri.Path())
 			}
+		}, w)
+	}
 
-			// Check if LF search found something (look for specific positive indicators)
-			// Positive indicators: "Valid ... ID found", "Valid ... tag found", "Chipset..."
-			// Negative indicators: "No known 125/134 kHz tags found", "No data found!"
-			lfFound = (strings.Contains(lfOutputStr, "Valid") &&
-				(strings.Contains(lfOutputStr, "ID found") || strings.Contains(lfOutputStr, "tag found"))) ||
-				strings.Contains(lfOutputStr, "Chipset...")
-
-			// Exclude only if we have explicit negative results
-			if lfFound && (strings.Contains(lfOutputStr, "No known 125/134 kHz tags found") ||
-				strings.Contains(lfOutputStr, "No data found!")) {
-				lfFound = false
+	// newWorkspace creates a workspace in a folder picked by the operator
+	newWorkspace := func() {
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err != nil {
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "Failed to open folder: %v", err)
+				return
 			}
+			if uri == nil {
+				return
+			}
+			name := widget.NewEntry()
+			name.SetPlaceHolder("e.g. acme-hq-2026")
+			dialog.ShowForm("New Workspace in "+uri.Name(), "CREATE", "CANCEL", []*widget.FormItem{
+				widget.NewFormItem("Name", name),
+			}, func(create bool) {
+				if create && strings.TrimSpace(name.Text) != "" {
+					openGUIWorkspace(filepath.Join(uri.Path(), strings.TrimSpace(name.Text)))
+				}
+			}, w)
+		}, w)
+	}
 
-			// Extract specific LF card type if found (check in order of specificity)
-			if lfFound {
-				// Check for specific card types
-				if strings.Contains(lfOutputStr, "EM410x") || strings.Contains(lfOutputStr, "EM 410x") {
-					lfCardType = "EM4100 / Net2"
-				} else if strings.Contains(lfOutputStr, "HID Prox") {
-					lfCardType = "HID Prox"
-				} else if strings.Contains(lfOutputStr, "AWID") {
-					lfCardType = "AWID"
-				} else if strings.Contains(lfOutputStr, "Indala") {
-					lfCardType = "Indala"
-				} else if strings.Contains(lfOutputStr, "FDX-B") {
-					lfCardType = "FDX-B"
-				} else if strings.Contains(lfOutputStr, "FDX-A") || strings.Contains(lfOutputStr, "Destron") {
-					lfCardType = "FDX-A Destron"
-				} else if strings.Contains(lfOutputStr, "NEDAP") {
-					lfCardType = "NEDAP"
-				} else if strings.Contains(lfOutputStr, "IO Prox") {
-					lfCardType = "IO Prox"
-				} else if strings.Contains(lfOutputStr, "Pyramid") {
-					lfCardType = "Pyramid"
-				} else if strings.Contains(lfOutputStr, "Paradox") {
-					lfCardType = "Paradox"
-				} else if strings.Contains(lfOutputStr, "Idteck") {
-					lfCardType = "Idteck"
-				} else if strings.Contains(lfOutputStr, "KERI") {
-					lfCardType = "KERI"
-				} else if strings.Contains(lfOutputStr, "NexWatch") {
-					lfCardType = "NexWatch"
-				} else if strings.Contains(lfOutputStr, "PAC") || strings.Contains(lfOutputStr, "Stanley") {
-					lfCardType = "PAC/Stanley"
-				} else if strings.Contains(lfOutputStr, "Guardall") || strings.Contains(lfOutputStr, "G-Prox") {
-					lfCardType = "Guardall G-Prox II"
-				} else if strings.Contains(lfOutputStr, "Jablotron") {
-					lfCardType = "Jablotron"
-				} else if strings.Contains(lfOutputStr, "Viking") {
-					lfCardType = "Viking"
-				} else if strings.Contains(lfOutputStr, "Visa2000") {
-					lfCardType = "Visa2000"
-				} else if strings.Contains(lfOutputStr, "Presco") {
-					lfCardType = "Presco"
-				} else if strings.Contains(lfOutputStr, "Securakey") {
-					lfCardType = "Securakey"
-				} else if strings.Contains(lfOutputStr, "Noralsy") {
-					lfCardType = "Noralsy"
-				} else if strings.Contains(lfOutputStr, "Motorola") || strings.Contains(lfOutputStr, "FlexPass") {
-					lfCardType = "Motorola FlexPass"
-				} else if strings.Contains(lfOutputStr, "COTAG") {
-					lfCardType = "COTAG"
-				} else if strings.Contains(lfOutputStr, "EM4x50") {
-					lfCardType = "EM4x50"
-				} else if strings.Contains(lfOutputStr, "EM4x05") || strings.Contains(lfOutputStr, "EM4x69") {
-					lfCardType = "EM4x05/EM4x69"
-				} else if strings.Contains(lfOutputStr, "EM4x70") {
-					lfCardType = "EM4x70"
-				} else if strings.Contains(lfOutputStr, "Paxton") {
-					lfCardType = "Paxton"
-				} else if strings.Contains(lfOutputStr, "Hitag") {
-					lfCardType = "Hitag"
-				} else if strings.Contains(lfOutputStr, "Gallagher") {
-					lfCardType = "Gallagher (LF)"
-				} else if strings.Contains(lfOutputStr, "T55xx") {
-					lfCardType = "T55xx"
-				} else {
-					lfCardType = "LF"
+	workspaceNote := widget.NewEntry()
+	workspaceNote.SetPlaceHolder("Operator note")
+	addWorkspaceNote := func() {
+		ws := activeWorkspace()
+		if ws == nil {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "Open a workspace first")
+			return
+		}
+		if err := ws.AddNote(workspaceNote.Text); err != nil {
+			WriteStatusError(context.Background(), "%v", err)
+			return
+		}
+		workspaceNote.SetText("")
+	}
+	workspaceNote.OnSubmitted = func(string) { addWorkspaceNote() }
+
+	// editScope edits the scope policy of the workspace, or the one given with -scope
+	editScope := func() {
+		path := activeScopePath()
+		if path == "" {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "Open a workspace first")
+			return
+		}
+		policy, err := activeScope()
+		if err != nil {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "%v", err)
+			return
+		}
+		if policy == nil {
+			policy = &ScopePolicy{}
+		}
+		listEntry := func(values []string, placeholder string) *widget.Entry {
+			e := widget.NewEntry()
+			e.SetText(strings.Join(values, ", "))
+			e.SetPlaceHolder(placeholder)
+			return e
+		}
+		splitList := func(text string) []string {
+			var values []string
+			for _, v := range strings.Split(text, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
 				}
 			}
-
-			// Always try HF search (for dual chip detection)
-			WriteStatusProgress("Checking High Frequency (HF)...")
-			fmt.Println()
-			fmt.Println("hf search")
-			fmt.Println()
-
-			hfCmd := exec.Command(pm3Binary, "-c", "hf search", "-p", device)
-			hfOutput, hfErr := hfCmd.CombinedOutput()
-			hfOutputStr := string(hfOutput)
-			// Filter out "Searching for..." lines and show only results
-			filteredHFOutput := filterSearchOutput(hfOutputStr)
-			if filteredHFOutput != "" {
-				fmt.Println(filteredHFOutput)
+			return values
+		}
+		engagement := widget.NewEntry()
+		engagement.SetText(policy.Engagement)
+		cardTypes := listEntry(policy.CardTypes, "e.g. prox, iclass (empty allows all)")
+		facilityCodes := listEntry(policy.FacilityCodes, "e.g. 118, 200-210")
+		uidPrefixes := listEntry(policy.UIDPrefixes, "e.g. 04A2")
+		hotelSystems := listEntry(policy.HotelSystems, "e.g. saflok")
+		dialog.ShowForm("Scope Policy", "SAVE", "CANCEL", []*widget.FormItem{
+			widget.NewFormItem("Engagement", engagement),
+			widget.NewFormItem("Card Types", cardTypes),
+			widget.NewFormItem("Facility Codes", facilityCodes),
+			widget.NewFormItem("UID Prefixes", uidPrefixes),
+			widget.NewFormItem("Hotel Systems", hotelSystems),
+		}, func(save bool) {
+			if !save {
+				return
 			}
-
-			// Extract magic capabilities and PRNG info for MIFARE cards
-			var magicCapabilities []string
-			var prngInfo string
-			var specificMifareType string
-
-			// Extract MIFARE type from "Possible types:" line (handles same-line or next-line format)
-			mifareTypeRegex := regexp.MustCompile(`(?s)Possible types:.*?MIFARE\s+([^\n\r]+?)(?:\s*\n|\s*$)`)
-			mifareTypeMatch := mifareTypeRegex.FindStringSubmatch(hfOutputStr)
-			if len(mifareTypeMatch) > 1 {
-				typeStr := strings.TrimSpace(mifareTypeMatch[1])
-				specificMifareType = "MIFARE " + typeStr
+			updated := &ScopePolicy{
+				Engagement:    strings.TrimSpace(engagement.Text),
+				CardTypes:     splitList(cardTypes.Text),
+				FacilityCodes: splitList(facilityCodes.Text),
+				UIDPrefixes:   splitList(uidPrefixes.Text),
+				HotelSystems:  splitList(hotelSystems.Text),
 			}
-
-			// Also check for "MIFARE Classic 1K" or "MIFARE Classic 4K" directly in the output
-			if specificMifareType == "" {
-				mifareDirectRegex := regexp.MustCompile(`(?i)MIFARE\s+Classic\s+(\d+K)`)
-				mifareDirectMatch := mifareDirectRegex.FindStringSubmatch(hfOutputStr)
-				if len(mifareDirectMatch) > 0 {
-					specificMifareType = "MIFARE Classic " + mifareDirectMatch[1]
-				}
+			currentStatusOutput.Clear()
+			if err := saveScopePolicy(path, updated); err != nil {
+				WriteStatusError(context.Background(), "%v", err)
+				return
 			}
+			WriteStatusSuccess(context.Background(), "Scope policy saved to %s", path)
+		}, w)
+	}
 
-			// Look for magic capabilities
-			magicRegex := regexp.MustCompile(`(?i)Magic capabilities\.\.\.\s+([^\n]+)`)
-			magicMatches := magicRegex.FindAllStringSubmatch(hfOutputStr, -1)
-			for _, match := range magicMatches {
-				if len(match) > 1 {
-					magicCapabilities = append(magicCapabilities, strings.TrimSpace(match[1]))
-				}
-			}
+	workspaceButtonRow := container.NewGridWithColumns(3,
+		newOutlinedButton("OPEN", chooseWorkspace),
+		newOutlinedButton("NEW", newWorkspace),
+		newOutlinedButton("SCOPE", editScope),
+	)
 
-			// Look for PRNG detection
-			prngRegex := regexp.MustCompile(`(?i)Prng detection\.\.\.\.\s+([^\n]+)`)
-			prngMatch := prngRegex.FindStringSubmatch(hfOutputStr)
-			if len(prngMatch) > 1 {
-				prngInfo = strings.TrimSpace(prngMatch[1])
+	vaultStatus := widget.NewLabel("Vault locked")
+	vaultStatus.Wrapping = fyne.TextWrapWord
+	var vaultToggle *outlinedButton
+	showVault := func(v *Vault) {
+		if v == nil {
+			vaultStatus.SetText("Vault locked")
+			vaultToggle.text = "UNLOCK VAULT"
+		} else {
+			vaultStatus.SetText("Vault unlocked\n" + v.Dir)
+			vaultToggle.text = "LOCK VAULT"
+		}
+		vaultToggle.Refresh()
+	}
+	vaultToggle = newOutlinedButton("UNLOCK VAULT", func() {
+		if activeVault() != nil {
+			closeVault()
+			showVault(nil)
+			WriteStatusInfo(context.Background(), "Vault locked")
+			return
+		}
+		dir := widget.NewEntry()
+		dir.SetText(defaultVaultDir())
+		passphrase := widget.NewPasswordEntry()
+		repeat := widget.NewPasswordEntry()
+		repeat.SetPlaceHolder("Only for a new vault")
+		dialog.ShowForm("Unlock Vault", "UNLOCK", "CANCEL", []*widget.FormItem{
+			widget.NewFormItem("Folder", dir),
+			widget.NewFormItem("Passphrase", passphrase),
+			widget.NewFormItem("Repeat", repeat),
+		}, func(unlock bool) {
+			if !unlock {
+				return
 			}
-
-			// Check if HF search found something and identify specific card type
-			// MUST check for actual "Valid ... found" or "detected" messages, NOT search messages
-			// Exclude negative results first
-			hasNegativeResult := strings.Contains(hfOutputStr, "No known/supported 13.56 MHz tags found") ||
-				(strings.Contains(hfOutputStr, "No known") && strings.Contains(hfOutputStr, "tags found"))
-
-			if hasNegativeResult {
-				hfFound = false
-			} else {
-				// Check for specific card types with their actual detection messages
-				// Order matters - check more specific types first
-
-				// MIFARE Classic - check for "Possible types: MIFARE Classic 1K" or "MIFARE Classic detected"
-				if strings.Contains(hfOutputStr, "MIFARE Classic") &&
-					(strings.Contains(hfOutputStr, "Possible types") || strings.Contains(hfOutputStr, "detected") ||
-						strings.Contains(hfOutputStr, "Valid ISO 14443-A")) {
-					hfFound = true
-					if specificMifareType != "" {
-						hfCardType = specificMifareType
-					} else {
-						hfCardType = "MIFARE Classic"
-					}
-				} else if (strings.Contains(hfOutputStr, "MIFARE Plus") && strings.Contains(hfOutputStr, "detected")) ||
-					(strings.Contains(hfOutputStr, "MIFARE Plus") && strings.Contains(hfOutputStr, "Possible types")) {
-					hfFound = true
-					hfCardType = specificMifareType
-					if specificMifareType == "" {
-						hfCardType = "MIFARE Plus"
-					}
-				} else if (strings.Contains(hfOutputStr, "MIFARE DESFire") || strings.Contains(hfOutputStr, "DESFire")) &&
-					(strings.Contains(hfOutputStr, "detected") || strings.Contains(hfOutputStr, "Possible types")) {
-					hfFound = true
-					hfCardType = specificMifareType
-					if specificMifareType == "" {
-						hfCardType = "MIFARE DESFire"
-					}
-				} else if (strings.Contains(hfOutputStr, "MIFARE Ultralight") && strings.Contains(hfOutputStr, "detected")) ||
-					(strings.Contains(hfOutputStr, "MIFARE Ultralight") && strings.Contains(hfOutputStr, "Possible types")) {
-					hfFound = true
-					hfCardType = "MIFARE Ultralight / NTAG"
-				} else if strings.Contains(hfOutputStr, "NTAG 424") || strings.Contains(hfOutputStr, "NTAG424") {
-					hfFound = true
-					hfCardType = specificMifareType
-					if specificMifareType == "" {
-						hfCardType = "NTAG 424 DNA"
-					}
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "iCLASS tag / PicoPass tag") && strings.Contains(hfOutputStr, "found") {
-					// Must have "Valid ... iCLASS tag / PicoPass tag ... found"
-					hfFound = true
-					hfCardType = "iCLASS / PicoPass"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "LEGIC Prime tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "LEGIC Prime"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "Topaz tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "Topaz (NFC Type 1)"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "LTO-CM tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "LTO-CM"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "TEXKOM tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "TEXKOM"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "Fuji/Xerox tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "Fuji/Xerox"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "ISO 14443-B tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "ISO 14443-B"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "ISO 15693 tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "ISO 15693"
-				} else if strings.Contains(hfOutputStr, "Valid") && strings.Contains(hfOutputStr, "ISO 18092 / FeliCa tag") && strings.Contains(hfOutputStr, "found") {
-					hfFound = true
-					hfCardType = "ISO 18092 / FeliCa"
-				} else if strings.Contains(hfOutputStr, "Valid ISO 14443-A tag found") {
-					hfFound = true
-					hfCardType = "ISO 14443-A"
-				} else if strings.Contains(hfOutputStr, "UID:") && strings.Contains(hfOutputStr, "ATQA:") && strings.Contains(hfOutputStr, "SAK:") {
-					// ISO14443-A info present (UID, ATQA, SAK) - this is a valid detection
-					hfFound = true
-					hfCardType = "ISO 14443-A"
-				}
+			vaultDir := strings.TrimSpace(dir.Text)
+			if !vaultExists(vaultDir) && repeat.Text != passphrase.Text {
+				currentStatusOutput.Clear()
+				WriteStatusError(context.Background(), "The passphrases differ")
+				return
 			}
-
-			// Report findings
-			if lfFound && hfFound {
-				// Dual chip card detected
-				WriteStatusSuccess("DUAL CHIP CARD DETECTED!")
-				WriteStatusInfo("LF Chip: %s", lfCardType)
-				WriteStatusInfo("HF Chip: %s", hfCardType)
-				WriteStatusInfo("This card contains both Low Frequency and High Frequency chips")
-
-				// Show specific MIFARE type if available
-				if specificMifareType != "" && strings.Contains(hfCardType, "MIFARE") {
-					WriteStatusInfo("MIFARE Type: %s", specificMifareType)
+			// Deriving the key takes a moment, keep the window responsive
+			go func() {
+				v, err := useVault(vaultDir, passphrase.Text)
+				if err != nil {
+					WriteStatusError(context.Background(), "%v", err)
+					return
 				}
+				fyne.Do(func() {
+					showVault(v)
+					workspaceList.Refresh()
+				})
+				WriteStatusSuccess(context.Background(), "Vault unlocked: dumps, key files and card reads are encrypted into %s", v.Dir)
+			}()
+		}, w)
+	})
 
-				// Show magic capabilities if detected
-				if len(magicCapabilities) > 0 {
-					WriteStatusInfo("Magic Capabilities: %s", strings.Join(magicCapabilities, ", "))
-				}
-				if prngInfo != "" {
-					WriteStatusInfo("PRNG Detection: %s", prngInfo)
-				}
+	// REPORT saves an engagement report of the workspace
+	reportButton := newOutlinedButton("REPORT", func() {
+		ws := activeWorkspace()
+		if ws == nil {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "Open a workspace first")
+			return
+		}
+		format := widget.NewSelect([]string{"Markdown", "HTML"}, nil)
+		format.SetSelected("HTML")
+		redact := widget.NewCheck("Mask credential values, UIDs and keys", nil)
+		dialog.ShowForm("Engagement Report", "SAVE", "CANCEL", []*widget.FormItem{
+			widget.NewFormItem("Format", format),
+			widget.NewFormItem("Redact", redact),
+		}, func(save bool) {
+			if !save {
+				return
+			}
+			asHTML := format.Selected == "HTML"
+			path := defaultReportPath(ws, asHTML)
+			currentStatusOutput.Clear()
+			if err := writeEngagementReport(ws, path, asHTML, redact.Checked); err != nil {
+				WriteStatusError(context.Background(), "%v", err)
+				return
+			}
+			WriteStatusSuccess(context.Background(), "Report of %s written to %s", ws.Info.Name, path)
+		}, w)
+	})
 
-				// If MIFARE Classic detected, run hf mf info for more details
-				if strings.Contains(hfCardType, "MIFARE Classic") {
-					WriteStatusProgress("Getting detailed MIFARE information...")
-					fmt.Println()
-					fmt.Println("hf mf info")
-					fmt.Println()
-
-					infoCmd := exec.Command(pm3Binary, "-c", "hf mf info", "-p", device)
-					infoOutput, infoErr := infoCmd.CombinedOutput()
-					infoOutputStr := string(infoOutput)
-					if infoErr == nil && infoOutputStr != "" {
-						fmt.Println(infoOutputStr)
-
-						// Extract additional details from hf mf info
-						// Extract UID
-						uidRegex1 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{2}(?:\s+[A-F0-9]{2})+)`)
-						if uidMatch := uidRegex1.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
-							uid := strings.ReplaceAll(uidMatch[1], " ", "")
-							WriteStatusInfo("UID: %s", uid)
-						} else {
-							uidRegex2 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{8,14})`)
-							if uidMatch := uidRegex2.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
-								WriteStatusInfo("UID: %s", uidMatch[1])
-							}
-						}
+	verifyAuditLogButton := newOutlinedButton("VERIFY AUDIT LOG", func() {
+		currentStatusOutput.Clear()
+		path := auditLogPath()
+		check, err := verifyAuditLog(path)
+		if err != nil {
+			WriteStatusError(context.Background(), "%v", err)
+			WriteStatusError(context.Background(), "%d entries of %s check out before the break", check.Entries, path)
+			showRemediation(context.Background(), err)
+			return
+		}
+		WriteStatusSuccess(context.Background(), "Audit log intact: %d entries in %s", check.Entries, path)
+		if check.Last != "" {
+			WriteStatusInfo(context.Background(), "Last hash: %s", check.Last)
+		}
+	})
 
-						// Check for Saflok
-						if strings.Contains(infoOutputStr, "Saflok") {
-							WriteStatusInfo("Detected: Saflok hotel key card")
-						}
-					}
-				}
-			} else if lfFound {
-				WriteStatusSuccess("✓ %s card detected (LF only)", lfCardType)
-			} else if hfFound {
-				WriteStatusSuccess("✓ %s card detected (HF only)", hfCardType)
-
-				// Show specific MIFARE type if available
-				if specificMifareType != "" && strings.Contains(hfCardType, "MIFARE") {
-					WriteStatusInfo("MIFARE Type: %s", specificMifareType)
-				}
+	workspaceSectionContent := container.NewVBox(
+		container.NewPadded(workspaceName),
+		container.NewPadded(workspaceButtonRow),
+		widget.NewSeparator(),
+		container.NewPadded(vaultStatus),
+		container.NewPadded(vaultToggle),
+		container.NewPadded(container.NewGridWithColumns(2, reportButton, verifyAuditLogButton)),
+		widget.NewSeparator(),
+		container.NewPadded(workspaceNote),
+		container.NewPadded(newOutlinedButton("ADD NOTE", addWorkspaceNote)),
+		widget.NewSeparator(),
+		container.NewPadded(workspaceListSized),
+	)
 
-				// Show magic capabilities if detected
-				if len(magicCapabilities) > 0 {
-					WriteStatusInfo("Magic Capabilities: %s", strings.Join(magicCapabilities, ", "))
+	// Settings section - edits the config file, see config.go
+	settingsPath := widget.NewLabel("Config file: " + configPath())
+	settingsPath.Wrapping = fyne.TextWrapWord
+	editSettings := func() {
+		// A broken config file is shown as far as it could be read, and replaced when saved
+		saved, err := readConfigFile(configPath())
+		if err != nil {
+			currentStatusOutput.Clear()
+			WriteStatusError(context.Background(), "%v", err)
+		}
+		defaults := defaultConfig()
+		entry := func(value, placeholder string) *widget.Entry {
+			e := widget.NewEntry()
+			e.SetText(value)
+			e.SetPlaceHolder(placeholder)
+			return e
+		}
+		numberText := func(n *int) string {
+			if n == nil {
+				return ""
+			}
+			return strconv.Itoa(*n)
+		}
+		durationText := func(d *configDuration) string {
+			if d == nil {
+				return ""
+			}
+			return time.Duration(*d).String()
+		}
+		splitList := func(text string) []string {
+			var values []string
+			for _, v := range strings.Split(text, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
 				}
-				if prngInfo != "" {
-					WriteStatusInfo("PRNG Detection: %s", prngInfo)
+			}
+			return values
+		}
+		pm3Binary := entry(saved.Pm3Path, "auto-detect")
+		device := entry(saved.Device, "first detected")
+		searchPaths := entry(strings.Join(saved.Pm3SearchPaths, ", "), strings.Join(defaults.Pm3SearchPaths, ", "))
+		dumpDirs := entry(strings.Join(saved.DumpDirs, ", "), "~, ~/.proxmark3, .")
+		writeAttempts := entry(numberText(saved.WriteAttempts), numberText(defaults.WriteAttempts))
+		writePause := entry(durationText(saved.WritePause), durationText(defaults.WritePause))
+		checkTimeout := entry(durationText(saved.CheckTimeout), durationText(defaults.CheckTimeout))
+		keyIndex := entry(numberText(saved.ICLASSKeyIndex), numberText(defaults.ICLASSKeyIndex))
+		guiScale := entry(saved.GUIScale, "1.0, applies on the next start")
+		dialog.ShowForm("Settings", "SAVE", "CANCEL", []*widget.FormItem{
+			widget.NewFormItem("pm3 Binary", pm3Binary),
+			widget.NewFormItem("Device", device),
+			widget.NewFormItem("pm3 Search Paths", searchPaths),
+			widget.NewFormItem("Dump Folders", dumpDirs),
+			widget.NewFormItem("Write Attempts", writeAttempts),
+			widget.NewFormItem("Write Pause", writePause),
+			widget.NewFormItem("Check Timeout", checkTimeout),
+			widget.NewFormItem("iCLASS Key Index", keyIndex),
+			widget.NewFormItem("GUI Scale", guiScale),
+		}, func(save bool) {
+			if !save {
+				return
+			}
+			currentStatusOutput.Clear()
+			updated := Config{
+				Pm3Path:        strings.TrimSpace(pm3Binary.Text),
+				Device:         strings.TrimSpace(device.Text),
+				Pm3SearchPaths: splitList(searchPaths.Text),
+				DumpDirs:       splitList(dumpDirs.Text),
+				GUIScale:       strings.TrimSpace(guiScale.Text),
+			}
+			if text := strings.TrimSpace(writeAttempts.Text); text != "" {
+				n, err := strconv.Atoi(text)
+				if err != nil {
+					WriteStatusError(context.Background(), "Write attempts must be a number of attempts")
+					return
 				}
-
-				// If MIFARE Classic detected, run hf mf info for more details
-				if strings.Contains(hfCardType, "MIFARE Classic") {
-					WriteStatusProgress("Getting detailed MIFARE information...")
-					fmt.Println()
-					fmt.Println("hf mf info")
-					fmt.Println()
-
-					infoCmd := exec.Command(pm3Binary, "-c", "hf mf info", "-p", device)
-					infoOutput, infoErr := infoCmd.CombinedOutput()
-					infoOutputStr := string(infoOutput)
-					if infoErr == nil && infoOutputStr != "" {
-						fmt.Println(infoOutputStr)
-
-						// Extract additional details from hf mf info
-						// Extract UID
-						uidRegex1 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{2}(?:\s+[A-F0-9]{2})+)`)
-						if uidMatch := uidRegex1.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
-							uid := strings.ReplaceAll(uidMatch[1], " ", "")
-							WriteStatusInfo("UID: %s", uid)
-						} else {
-							uidRegex2 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{8,14})`)
-							if uidMatch := uidRegex2.FindStringSubmatch(infoOutputStr); len(uidMatch) > 1 {
-								WriteStatusInfo("UID: %s", uidMatch[1])
-							}
-						}
-
-						// Check for Saflok
-						if strings.Contains(infoOutputStr, "Saflok") {
-							WriteStatusInfo("Detected: Saflok hotel key card")
-						}
+				updated.WriteAttempts = &n
+			}
+			for _, d := range []struct {
+				name  string
+				entry *widget.Entry
+				value **configDuration
+			}{{"Write pause", writePause, &updated.WritePause}, {"Check timeout", checkTimeout, &updated.CheckTimeout}} {
+				if text := strings.TrimSpace(d.entry.Text); text != "" {
+					parsed, err := time.ParseDuration(text)
+					if err != nil {
+						WriteStatusError(context.Background(), "%s must be a duration, e.g. 1s or 500ms", d.name)
+						return
 					}
+					*d.value = durationPtr(parsed)
 				}
-			} else if hfErr != nil {
-				WriteStatusError("HF detection failed: %v", hfErr)
-			} else {
-				WriteStatusInfo("No card detected. Make sure card is placed on reader.")
 			}
+			if text := strings.TrimSpace(keyIndex.Text); text != "" {
+				n, err := strconv.Atoi(text)
+				if err != nil {
+					WriteStatusError(context.Background(), "iCLASS key index must be a key slot number")
+					return
+				}
+				updated.ICLASSKeyIndex = &n
+			}
+			path := configPath()
+			if err := saveConfigFile(path, updated); err != nil {
+				WriteStatusError(context.Background(), "%v", err)
+				return
+			}
+			if err := loadConfig(); err != nil {
+				WriteStatusError(context.Background(), "%v", err)
+				return
+			}
+			WriteStatusSuccess(context.Background(), "Settings saved to %s", path)
+			if overrides := configEnvOverrides(); len(overrides) > 0 {
+				WriteStatusInfo(context.Background(), "Overridden by the environment: %s", strings.Join(overrides, ", "))
+			}
+		}, w)
+	}
 
-			w.Close()
-			os.Stdout.Sync()
-			os.Stderr.Sync()
-			os.Stdout = oldStdout
-			os.Stderr = oldStderr
-			<-done
-
-			WriteStatusSuccess("Card detection completed")
-		}()
-	})
-
-	// Size the detect button to match other buttons
-	detectCardButtonSized := container.NewStack(detectCardButton)
-	detectCardButtonSized.Resize(fyne.NewSize(120, 30))
-
-	// Card Discovery section - only DETECT CARD TYPE
-	cardDiscoverySectionContent := container.NewVBox(
-		container.NewPadded(detectCardButtonSized),
+	settingsNote := widget.NewLabel("Empty fields use the built-in defaults. Environment variables, e.g. DOPPELGANGER_DEVICE, override these settings.")
+	settingsNote.Wrapping = fyne.TextWrapWord
+	settingsSectionContent := container.NewVBox(
+		container.NewPadded(settingsPath),
+		container.NewPadded(settingsNote),
+		container.NewPadded(newOutlinedButton("SETTINGS", editSettings)),
 	)
 
 	// Create accordion for collapsible sections
@@ -2033,11 +1896,17 @@ func runGUI() {
 		widget.NewAccordionItem("Card Discovery", cardDiscoverySectionContent),
 		widget.NewAccordionItem("Corporate Access Control Cards", corporateSectionContent),
 		widget.NewAccordionItem("Hotel / Residence Access Control", hotelSectionContent),
+		widget.NewAccordionItem("Doppelgänger Live Capture", liveCaptureSectionContent),
+		widget.NewAccordionItem("Engagement Workspace", workspaceSectionContent),
+		widget.NewAccordionItem("Settings", settingsSectionContent),
 	)
 	// Start with Corporate expanded, Hotel collapsed, Card Discovery collapsed
 	accordion.Items[0].Open = false // Card Discovery collapsed
 	accordion.Items[1].Open = true  // Corporate expanded
 	accordion.Items[2].Open = false // Hotel collapsed
+	accordion.Items[3].Open = false // Live Capture collapsed
+	accordion.Items[4].Open = false // Workspace collapsed
+	accordion.Items[5].Open = false // Settings collapsed
 
 	// Make accordion mutually exclusive using a periodic check
 	// Fyne's Accordion doesn't have OnChanged, so we monitor state changes
@@ -2081,9 +1950,18 @@ func runGUI() {
 		container.NewPadded(versionText),
 	)
 
+	// Level picker for the status pane
+	statusFilter := widget.NewSelect([]string{"All messages", "Hide progress", "Errors only"}, func(selected string) {
+		statusOutput.SetFilter(statusFilters[selected])
+	})
+	statusFilter.SetSelected("All messages")
+
 	outputHeader := container.NewHBox(
 		container.NewPadded(outputLabel),
+		container.NewPadded(statusFilter),
 		layout.NewSpacer(),
+		container.NewPadded(devicePicker),
+		container.NewPadded(refreshDevicesButton),
 		container.NewPadded(launchPm3Button),
 		container.NewPadded(copyOutput),
 		container.NewPadded(clearOutput),
@@ -2111,7 +1989,7 @@ func runGUI() {
 	)
 
 	rightColumn := container.NewBorder(
-		outputHeader,
+		container.NewVBox(outputHeader, container.NewPadded(jobListLabel), jobListSized),
 		nil, nil, nil,
 		outputArea,
 	)
@@ -2153,6 +2031,38 @@ func runGUI() {
 	action.SetSelectedIndex(1)
 	updateDataBlocks(cardTypes[0])
 
+	if configLoadErr != nil {
+		WriteStatusError(context.Background(), "Settings could not be loaded, the built-in defaults apply until they are fixed: %v", configLoadErr)
+	}
+
+	if v := activeVault(); v != nil {
+		showVault(v)
+	}
+
+	// A workspace chosen with -workspace is shown, otherwise the operator is offered one
+	if ws := activeWorkspace(); ws != nil {
+		showWorkspace(ws)
+	} else {
+		var picker *dialog.CustomDialog
+		pickThen := func(choose func()) func() {
+			return func() {
+				picker.Hide()
+				if choose != nil {
+					choose()
+				}
+			}
+		}
+		picker = dialog.NewCustomWithoutButtons("Engagement Workspace", container.NewVBox(
+			widget.NewLabel("Record this session's reads, writes, dumps, key files and pm3 transcript to a workspace?"),
+			container.NewGridWithColumns(3,
+				newOutlinedButton("OPEN", pickThen(chooseWorkspace)),
+				newOutlinedButton("NEW", pickThen(newWorkspace)),
+				newOutlinedButton("NOT NOW", pickThen(nil)),
+			),
+		), w)
+		picker.Show()
+	}
+
 	// Check for updates in background
 	go func() {
 		time.Sleep(2 * time.Second) // Wait for window to fully load
@@ -2186,14 +2096,14 @@ func runGUI() {
 
 			dialog.ShowInformation("Update Available", message, w)
 
-			WriteStatusInfo("Update available: v%s (current: v%s)", latestVersion, Version)
-			WriteStatusInfo("Download: %s", downloadURL)
+			WriteStatusInfo(context.Background(), "Update available: v%s (current: v%s)", latestVersion, Version)
+			WriteStatusInfo(context.Background(), "Download: %s", downloadURL)
 			if runtime.GOOS == "linux" {
 				if _, err := os.Stat("/proc/version"); err == nil {
 					if data, err := os.ReadFile("/proc/version"); err == nil &&
 						(strings.Contains(strings.ToLower(string(data)), "microsoft") ||
 							strings.Contains(strings.ToLower(string(data)), "wsl")) {
-						WriteStatusInfo("WSL Update: powershell -ExecutionPolicy Bypass -File C:\\doppelganger_assistant\\wsl_update.ps1")
+						WriteStatusInfo(context.Background(), "WSL Update: powershell -ExecutionPolicy Bypass -File C:\\doppelganger_assistant\\wsl_update.ps1")
 					}
 				}
 			}
@@ -2217,93 +2127,3 @@ func joinArgs(args []string) string {
 	}
 	return result
 }
-
-// findLatestDumpFile finds the most recently modified dump file matching the pattern
-func findLatestDumpFile() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return ""
-	}
-
-	// Search in home directory and common locations
-	searchDirs := []string{
-		homeDir,
-		filepath.Join(homeDir, ".proxmark3"),
-		".",
-	}
-
-	var latestFile string
-	var latestTime time.Time
-
-	for _, dir := range searchDirs {
-		// Try multiple patterns to catch all dump files
-		patterns := []string{
-			"hf-mf-*-dump-*.bin",
-			"hf-mf-*-dump-*.eml",
-			"*-dump-*.bin",
-			"*-dump-*.eml",
-		}
-
-		for _, pattern := range patterns {
-			matches, err := filepath.Glob(filepath.Join(dir, pattern))
-			if err != nil {
-				continue
-			}
-
-			for _, match := range matches {
-				info, err := os.Stat(match)
-				if err != nil {
-					continue
-				}
-				// Only consider files that actually exist
-				if !info.Mode().IsRegular() {
-					continue
-				}
-				if info.ModTime().After(latestTime) {
-					latestTime = info.ModTime()
-					latestFile = match
-				}
-			}
-		}
-	}
-
-	return latestFile
-}
-
-// findLatestKeyFile finds the most recently modified key file matching the pattern
-func findLatestKeyFile() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return ""
-	}
-
-	// Search in home directory and common locations
-	searchDirs := []string{
-		homeDir,
-		filepath.Join(homeDir, ".proxmark3"),
-		".",
-	}
-
-	var latestFile string
-	var latestTime time.Time
-
-	for _, dir := range searchDirs {
-		matches, err := filepath.Glob(filepath.Join(dir, "hf-mf-*-key.bin"))
-		if err != nil {
-			continue
-		}
-
-		for _, match := range matches {
-			info, err := os.Stat(match)
-			if err != nil {
-				continue
-			}
-			if info.ModTime().After(latestTime) {
-				latestTime = info.ModTime()
-				latestFile = match
-			}
-		}
-	}
-
-	return latestFile
-}
@@ -1,35 +1,41 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 )
 
+// RecoveryResult is the result payload of a hotel key recovery
+type RecoveryResult struct {
+	Method           string      `json:"method"`
+	SectorsRecovered int         `json:"sectorsRecovered"`
+	DumpFile         string      `json:"dumpFile,omitempty"`
+	KeyFile          string      `json:"keyFile,omitempty"`
+	Keys             *KeySummary `json:"keys,omitempty"` // the key table, when pm3 printed one
+}
+
+// RestoreResult is the result payload of writing a card from a MIFARE Classic dump
+type RestoreResult struct {
+	DumpFile string `json:"dumpFile"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	UID      string `json:"uid,omitempty"`
+}
+
 // recoverHotelKey attempts to recover keys from a hotel key card (MIFARE Classic)
 // Uses Proxmark3's built-in recovery tools
 // onFilePathsFound is called with dumpFilePath and keyFilePath when files are found
-func recoverHotelKey(recoveryMethod string, onFilePathsFound func(string, string)) {
-	if ok, msg := checkProxmark3(); !ok {
-		WriteStatusError(msg)
-		return
-	}
-
-	pm3Binary, err := getPm3Path()
-	if err != nil {
-		WriteStatusError("Failed to find pm3 binary: %v", err)
-		return
+func recoverHotelKey(ctx context.Context, recoveryMethod string, onFilePathsFound func(string, string)) error {
+	if err := checkProxmark3(ctx); err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
 	}
 
-	device, err := getPm3Device()
-	if err != nil {
-		WriteStatusError("Failed to detect pm3 device: %v", err)
-		return
-	}
-
-	var cmd *exec.Cmd
 	var cmdStr string
 	isRecoveryMethod := true
 
@@ -37,101 +43,106 @@ func recoverHotelKey(recoveryMethod string, onFilePathsFound func(string, string
 	case "autopwn":
 		// Automatic key recovery - tries multiple methods
 		cmdStr = "hf mf autopwn"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf autopwn", "-p", device)
-		WriteStatusProgress("Starting hotel key card recovery...")
-		WriteStatusInfo("Place the hotel key card on the reader and keep it there during recovery")
-		WriteStatusInfo("Using automatic recovery (autopwn) - this will try multiple attack methods")
+		WriteStatusProgress(ctx, "Starting hotel key card recovery...")
+		WriteStatusInfo(ctx, "Place the hotel key card on the reader and keep it there during recovery")
+		WriteStatusInfo(ctx, "Using automatic recovery (autopwn) - this will try multiple attack methods")
 	case "darkside":
 		// Darkside attack - fast but only works on vulnerable cards
 		cmdStr = "hf mf darkside"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf darkside", "-p", device)
-		WriteStatusProgress("Starting hotel key card recovery...")
-		WriteStatusInfo("Place the hotel key card on the reader and keep it there during recovery")
-		WriteStatusInfo("Using Darkside attack - fast but only works on vulnerable cards")
+		WriteStatusProgress(ctx, "Starting hotel key card recovery...")
+		WriteStatusInfo(ctx, "Place the hotel key card on the reader and keep it there during recovery")
+		WriteStatusInfo(ctx, "Using Darkside attack - fast but only works on vulnerable cards")
 	case "nested":
 		// Nested attack - works on most cards but slower
 		cmdStr = "hf mf nested"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf nested", "-p", device)
-		WriteStatusProgress("Starting hotel key card recovery...")
-		WriteStatusInfo("Place the hotel key card on the reader and keep it there during recovery")
-		WriteStatusInfo("Using Nested attack - works on most cards but may take longer")
+		WriteStatusProgress(ctx, "Starting hotel key card recovery...")
+		WriteStatusInfo(ctx, "Place the hotel key card on the reader and keep it there during recovery")
+		WriteStatusInfo(ctx, "Using Nested attack - works on most cards but may take longer")
 	case "hardnested":
 		// Hardnested attack - for hardened cards
 		cmdStr = "hf mf hardnested"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf hardnested", "-p", device)
-		WriteStatusProgress("Starting hotel key card recovery...")
-		WriteStatusInfo("Place the hotel key card on the reader and keep it there during recovery")
-		WriteStatusInfo("Using Hardnested attack - for hardened MIFARE Classic cards")
+		WriteStatusProgress(ctx, "Starting hotel key card recovery...")
+		WriteStatusInfo(ctx, "Place the hotel key card on the reader and keep it there during recovery")
+		WriteStatusInfo(ctx, "Using Hardnested attack - for hardened MIFARE Classic cards")
 	case "staticnested":
 		// Static nested attack - for cards with static nonces
 		cmdStr = "hf mf staticnested"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf staticnested", "-p", device)
-		WriteStatusProgress("Starting hotel key card recovery...")
-		WriteStatusInfo("Place the hotel key card on the reader and keep it there during recovery")
-		WriteStatusInfo("Using Static Nested attack - for cards with static nonces")
+		WriteStatusProgress(ctx, "Starting hotel key card recovery...")
+		WriteStatusInfo(ctx, "Place the hotel key card on the reader and keep it there during recovery")
+		WriteStatusInfo(ctx, "Using Static Nested attack - for cards with static nonces")
 	case "brute":
 		// Smart bruteforce - exploits weak key generators
 		cmdStr = "hf mf brute"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf brute", "-p", device)
-		WriteStatusProgress("Starting hotel key card recovery...")
-		WriteStatusInfo("Place the hotel key card on the reader and keep it there during recovery")
-		WriteStatusInfo("Using Smart Bruteforce attack - exploits weak key generators")
+		WriteStatusProgress(ctx, "Starting hotel key card recovery...")
+		WriteStatusInfo(ctx, "Place the hotel key card on the reader and keep it there during recovery")
+		WriteStatusInfo(ctx, "Using Smart Bruteforce attack - exploits weak key generators")
 	case "nack":
 		// NACK bug test - tests for MIFARE NACK bug vulnerability
 		cmdStr = "hf mf nack"
-		cmd = exec.Command(pm3Binary, "-c", "hf mf nack", "-p", device)
-		WriteStatusProgress("Testing MIFARE card for NACK bug vulnerability...")
-		WriteStatusInfo("Place the card on the reader")
+		WriteStatusProgress(ctx, "Testing MIFARE card for NACK bug vulnerability...")
+		WriteStatusInfo(ctx, "Place the card on the reader")
 		isRecoveryMethod = false
 	default:
-		WriteStatusError("Unknown recovery method: %s", recoveryMethod)
-		return
+		WriteStatusError(ctx, "Unknown recovery method: %s", recoveryMethod)
+		return emitFailure(ctx, fmt.Errorf("%w: unknown recovery method %q", ErrInvalidInput, recoveryMethod))
 	}
 
-	fmt.Println(cmdStr)
-	fmt.Println()
+	if err := checkHotelCardScope(ctx); err != nil {
+		return err
+	}
+
+	emitCommand(ctx, cmdStr)
+	emitOutput(ctx, "")
 
-	output, cmdErr := cmd.CombinedOutput()
-	outputStr := string(output)
+	outputStr, cmdErr := runPm3(ctx, cmdStr)
 
 	// Print full output (no filtering)
-	fmt.Println(outputStr)
+	emitOutput(ctx, outputStr)
 
 	// For NACK test, just report completion
 	if !isRecoveryMethod {
+		if isCancelled(cmdErr) {
+			WriteStatusInfo(ctx, "NACK test cancelled by user")
+			return cmdErr
+		}
 		if cmdErr != nil {
-			WriteStatusError("NACK test failed: %v", cmdErr)
-		} else {
-			WriteStatusSuccess("NACK test completed. Review output above for results.")
+			WriteStatusError(ctx, "NACK test failed: %v", cmdErr)
+			return emitFailure(ctx, pm3Failure(outputStr, cmdErr, ErrNoCardPresent))
 		}
-		return
+		WriteStatusSuccess(ctx, "NACK test completed. Review output above for results.")
+		return nil
 	}
 
 	// Parse recovery results
 	sectorsRecovered := parseRecoveryOutput(outputStr)
 
 	if cmdErr != nil {
-		WriteStatusError("Recovery failed: %v", cmdErr)
+		if isCancelled(cmdErr) {
+			WriteStatusInfo(ctx, "Recovery cancelled by user")
+		} else {
+			WriteStatusError(ctx, "Recovery failed: %v", cmdErr)
+		}
 		if sectorsRecovered > 0 {
-			WriteStatusInfo("Partial recovery: %d sectors recovered", sectorsRecovered)
+			WriteStatusInfo(ctx, "Partial recovery: %d sectors recovered", sectorsRecovered)
+		}
+		if isCancelled(cmdErr) {
+			return cmdErr
 		}
-		return
+		return emitFailure(ctx, pm3Failure(outputStr, cmdErr, ErrAuthFailed))
 	}
 
 	// Check if keys were recovered
 	if sectorsRecovered > 0 {
-		WriteStatusSuccess("Key recovery successful! Recovered keys for %d sectors", sectorsRecovered)
+		WriteStatusSuccess(ctx, "Key recovery successful! Recovered keys for %d sectors", sectorsRecovered)
 
 		// Automatically dump the card data after key recovery
-		WriteStatusProgress("Dumping card data...")
-		fmt.Println()
-		fmt.Println("hf mf dump")
-		fmt.Println()
+		WriteStatusProgress(ctx, "Dumping card data...")
+		emitOutput(ctx, "")
+		emitCommand(ctx, "hf mf dump")
+		emitOutput(ctx, "")
 
-		dumpCmd := exec.Command(pm3Binary, "-c", "hf mf dump", "-p", device)
-		dumpOutput, dumpErr := dumpCmd.CombinedOutput()
-		dumpOutputStr := string(dumpOutput)
-		fmt.Println(dumpOutputStr)
+		dumpOutputStr, dumpErr := runPm3(ctx, "hf mf dump")
+		emitOutput(ctx, dumpOutputStr)
 
 		var dumpFilePath string
 		var keyFilePath string
@@ -142,21 +153,21 @@ func recoverHotelKey(recoveryMethod string, onFilePathsFound func(string, string
 			dumpFileRegex := regexp.MustCompile(`Saved.*?to.*?file.*?[` + "`" + `'"]?([^` + "`" + `'"]+hf-mf-[A-F0-9]+-dump-[0-9]+\.(bin|eml))[` + "`" + `'"]?`)
 			if matches := dumpFileRegex.FindStringSubmatch(dumpOutputStr); len(matches) > 1 {
 				dumpFilePath = matches[1]
-				WriteStatusSuccess("Card data dumped to: %s", dumpFilePath)
+				WriteStatusSuccess(ctx, "Card data dumped to: %s", dumpFilePath)
 			} else {
 				// Try pattern without backticks
 				dumpFileRegex2 := regexp.MustCompile(`Saved.*?to.*?file.*?([/][^\s]+\.(bin|eml))`)
 				if matches := dumpFileRegex2.FindStringSubmatch(dumpOutputStr); len(matches) > 1 {
 					dumpFilePath = matches[1]
-					WriteStatusSuccess("Card data dumped to: %s", dumpFilePath)
+					WriteStatusSuccess(ctx, "Card data dumped to: %s", dumpFilePath)
 				} else {
 					// Try alternative pattern for any dump file
 					dumpFileRegex3 := regexp.MustCompile(`([/][^\s]+hf-mf-[A-F0-9]+-dump-[0-9]+\.(bin|eml))`)
 					if matches := dumpFileRegex3.FindStringSubmatch(dumpOutputStr); len(matches) > 1 {
 						dumpFilePath = matches[1]
-						WriteStatusSuccess("Card data dumped to: %s", dumpFilePath)
+						WriteStatusSuccess(ctx, "Card data dumped to: %s", dumpFilePath)
 					} else {
-						WriteStatusSuccess("Card data dumped successfully")
+						WriteStatusSuccess(ctx, "Card data dumped successfully")
 					}
 				}
 			}
@@ -166,25 +177,30 @@ func recoverHotelKey(recoveryMethod string, onFilePathsFound func(string, string
 			keyFileRegex := regexp.MustCompile(`Saved.*?key.*?file.*?[` + "`" + `'"]?([/][^` + "`" + `'"]+hf-mf-[A-F0-9]+-key\.bin)[` + "`" + `'"]?`)
 			if matches := keyFileRegex.FindStringSubmatch(dumpOutputStr); len(matches) > 1 {
 				keyFilePath = matches[1]
-				WriteStatusInfo("Keys saved to: %s", keyFilePath)
+				WriteStatusInfo(ctx, "Keys saved to: %s", keyFilePath)
 			} else {
 				// Try pattern without backticks
 				keyFileRegex2 := regexp.MustCompile(`Saved.*?key.*?file.*?([/][^\s]+\.bin)`)
 				if matches := keyFileRegex2.FindStringSubmatch(dumpOutputStr); len(matches) > 1 {
 					keyFilePath = matches[1]
-					WriteStatusInfo("Keys saved to: %s", keyFilePath)
+					WriteStatusInfo(ctx, "Keys saved to: %s", keyFilePath)
 				} else {
 					// Try alternative pattern for any key file
 					keyFileRegex3 := regexp.MustCompile(`([/][^\s]+hf-mf-[A-F0-9]+-key\.bin)`)
 					if matches := keyFileRegex3.FindStringSubmatch(dumpOutputStr); len(matches) > 1 {
 						keyFilePath = matches[1]
-						WriteStatusInfo("Keys saved to: %s", keyFilePath)
+						WriteStatusInfo(ctx, "Keys saved to: %s", keyFilePath)
 					}
 				}
 			}
 
 			// Show summary of recovered keys
-			parseAndDisplayKeySummary(outputStr)
+			keys := parseAndDisplayKeySummary(ctx, outputStr)
+
+			// With a vault unlocked the dump and keys do not stay on disk in plaintext
+			if v := activeVault(); v != nil {
+				dumpFilePath, keyFilePath = storeRecoveredFiles(ctx, v, dumpFilePath, keyFilePath)
+			}
 
 			// Call callback to update GUI fields if provided
 			if onFilePathsFound != nil {
@@ -193,22 +209,42 @@ func recoverHotelKey(recoveryMethod string, onFilePathsFound func(string, string
 
 			// After successful dump, offer to write/restore to a new card
 			if dumpFilePath != "" {
-				WriteStatusInfo("")
-				WriteStatusInfo("To write this data to a new card, use:")
-				if keyFilePath != "" {
-					WriteStatusInfo("  hf mf restore -f %s -k %s", dumpFilePath, keyFilePath)
+				WriteStatusInfo(ctx, "")
+				WriteStatusInfo(ctx, "To write this data to a new card, use:")
+				if isSealedFile(dumpFilePath) {
+					// pm3 cannot read vault files, the assistant decrypts them for the restore
+					restore := fmt.Sprintf("doppelganger_assistant restore -vault %s -f %s", filepath.Dir(dumpFilePath), dumpFilePath)
+					if keyFilePath != "" {
+						restore += " -k " + keyFilePath
+					}
+					WriteStatusInfo(ctx, "  WRITE FROM DUMP, or: %s", restore)
+				} else if keyFilePath != "" {
+					WriteStatusInfo(ctx, "  hf mf restore -f %s -k %s", dumpFilePath, keyFilePath)
 				} else {
-					WriteStatusInfo("  hf mf restore -f %s", dumpFilePath)
+					WriteStatusInfo(ctx, "  hf mf restore -f %s", dumpFilePath)
 				}
 			}
 
-		} else {
-			WriteStatusError("Dump failed: %v", dumpErr)
-			WriteStatusInfo("You can manually dump with: hf mf dump")
+			emitResult(ctx, "Key recovery successful", RecoveryResult{
+				Method:           recoveryMethod,
+				SectorsRecovered: sectorsRecovered,
+				DumpFile:         dumpFilePath,
+				KeyFile:          keyFilePath,
+				Keys:             keys,
+			})
+			return nil
+
+		} else if isCancelled(dumpErr) {
+			WriteStatusInfo(ctx, "Dump cancelled by user")
+			WriteStatusInfo(ctx, "You can manually dump with: hf mf dump")
+			return dumpErr
 		}
-	} else {
-		WriteStatusInfo("Recovery completed. Review output above for results.")
+		WriteStatusError(ctx, "Dump failed: %v", dumpErr)
+		WriteStatusInfo(ctx, "You can manually dump with: hf mf dump")
+		return emitFailure(ctx, pm3Failure(dumpOutputStr, dumpErr, fmt.Errorf("dump failed")))
 	}
+	WriteStatusInfo(ctx, "Recovery completed. Review output above for results.")
+	return emitFailure(ctx, pm3Failure(outputStr, nil, fmt.Errorf("%w: no sector keys recovered", ErrAuthFailed)))
 }
 
 // parseRecoveryOutput parses the autopwn output to count recovered sectors
@@ -241,12 +277,46 @@ func parseRecoveryOutput(output string) int {
 	return len(matches2) / 2 // Divide by 2 since each sector has key A and key B
 }
 
-// parseAndDisplayKeySummary extracts and displays a clean summary of recovered keys
-func parseAndDisplayKeySummary(output string) {
+// KeySummary sums up the key table pm3 prints after a recovery
+type KeySummary struct {
+	RecoveredSectors []string       `json:"recoveredSectors"`
+	FailedSectors    []string       `json:"failedSectors,omitempty"`
+	KeysFound        int            `json:"keysFound"`
+	Methods          map[string]int `json:"methods,omitempty"` // keys found by each method, e.g. Dictionary
+}
+
+// keyMethodNames names the result codes of the key table
+var keyMethodNames = map[string]string{
+	"D": "Dictionary",
+	"S": "Darkside",
+	"U": "User",
+	"R": "Reused",
+	"N": "Nested",
+	"H": "Hardnested",
+	"C": "Static Nested",
+	"A": "Key A",
+}
+
+// MethodsUsed lists the methods that found keys with how many each found, e.g. "Dictionary (30)"
+func (s *KeySummary) MethodsUsed() []string {
+	var names []string
+	for name := range s.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var methods []string
+	for _, name := range names {
+		methods = append(methods, fmt.Sprintf("%s (%d)", name, s.Methods[name]))
+	}
+	return methods
+}
+
+// parseKeySummary extracts the key table from recovery output, or returns nil when there is none
+func parseKeySummary(output string) *KeySummary {
 	// Extract key table section
 	keyTableStart := strings.Index(output, "-----+-----+--------------+---+--------------+----")
 	if keyTableStart == -1 {
-		return
+		return nil
 	}
 
 	// Find the end of the key table (look for the legend line)
@@ -259,11 +329,7 @@ func parseAndDisplayKeySummary(output string) {
 	keyTableSection = keyTableSection[:keyTableEnd]
 	lines := strings.Split(keyTableSection, "\n")
 
-	var recoveredSectors []string
-	var failedSectors []string
-	var totalKeys int
-	var keyAMethods = make(map[string]int) // Count by method
-	var keyBMethods = make(map[string]int)
+	summary := &KeySummary{Methods: make(map[string]int)}
 
 	// Parse each line in the key table
 	keyLineRegex := regexp.MustCompile(`\[\+\]\s+(\d{3})\s+\|\s+\d{3}\s+\|\s+([A-F0-9]{12}|-{12})\s+\|\s+([DSUNHRCA0])\s+\|\s+([A-F0-9]{12}|-{12})\s+\|\s+([DSUNHRCA0])`)
@@ -278,123 +344,91 @@ func parseAndDisplayKeySummary(output string) {
 
 			// Track recovery methods
 			if keyAResult != "0" && keyA != "------------" {
-				totalKeys++
-				keyAMethods[keyAResult]++
+				summary.KeysFound++
+				if name, ok := keyMethodNames[keyAResult]; ok {
+					summary.Methods[name]++
+				}
 			}
 			if keyBResult != "0" && keyB != "------------" {
-				totalKeys++
-				keyBMethods[keyBResult]++
+				summary.KeysFound++
+				if name, ok := keyMethodNames[keyBResult]; ok {
+					summary.Methods[name]++
+				}
 			}
 
 			// Track sectors
 			if keyAResult != "0" || keyBResult != "0" {
-				recoveredSectors = append(recoveredSectors, sectorNum)
+				summary.RecoveredSectors = append(summary.RecoveredSectors, sectorNum)
 			} else {
-				failedSectors = append(failedSectors, sectorNum)
+				summary.FailedSectors = append(summary.FailedSectors, sectorNum)
 			}
 		}
 	}
+	return summary
+}
+
+// parseAndDisplayKeySummary extracts and displays a clean summary of recovered keys, and returns
+// it for the recovery's result
+func parseAndDisplayKeySummary(ctx context.Context, output string) *KeySummary {
+	summary := parseKeySummary(output)
+	if summary == nil {
+		return nil
+	}
 
 	// Display summary
-	WriteStatusInfo("")
-	WriteStatusInfo("--- Recovery Summary ---")
-	WriteStatusInfo("Sectors recovered: %d / 16", len(recoveredSectors))
-	WriteStatusInfo("Total keys found: %d", totalKeys)
+	WriteStatusInfo(ctx, "")
+	WriteStatusInfo(ctx, "--- Recovery Summary ---")
+	WriteStatusInfo(ctx, "Sectors recovered: %d / 16", len(summary.RecoveredSectors))
+	WriteStatusInfo(ctx, "Total keys found: %d", summary.KeysFound)
 
-	if len(recoveredSectors) > 0 {
-		WriteStatusSuccess("Recovered sectors: %s", strings.Join(recoveredSectors, ", "))
+	if len(summary.RecoveredSectors) > 0 {
+		WriteStatusSuccess(ctx, "Recovered sectors: %s", strings.Join(summary.RecoveredSectors, ", "))
 	}
 
-	if len(failedSectors) > 0 {
-		WriteStatusError("Failed sectors: %s", strings.Join(failedSectors, ", "))
+	if len(summary.FailedSectors) > 0 {
+		WriteStatusError(ctx, "Failed sectors: %s", strings.Join(summary.FailedSectors, ", "))
 	}
 
 	// Show recovery methods used
-	methodNames := map[string]string{
-		"D": "Dictionary",
-		"S": "Darkside",
-		"U": "User",
-		"R": "Reused",
-		"N": "Nested",
-		"H": "Hardnested",
-		"C": "Static Nested",
-		"A": "Key A",
-	}
-
-	var methodsUsed []string
-	for method, count := range keyAMethods {
-		if name, ok := methodNames[method]; ok {
-			methodsUsed = append(methodsUsed, fmt.Sprintf("%s (%d)", name, count))
-		}
-	}
-	for method, count := range keyBMethods {
-		if name, ok := methodNames[method]; ok {
-			// Check if already added
-			found := false
-			for i, m := range methodsUsed {
-				if strings.Contains(m, name) {
-					// Update count
-					methodsUsed[i] = fmt.Sprintf("%s (%d)", name, count+keyAMethods[method])
-					found = true
-					break
-				}
-			}
-			if !found {
-				methodsUsed = append(methodsUsed, fmt.Sprintf("%s (%d)", name, count))
-			}
-		}
-	}
-
-	if len(methodsUsed) > 0 {
-		WriteStatusInfo("Recovery methods: %s", strings.Join(methodsUsed, ", "))
+	if methodsUsed := summary.MethodsUsed(); len(methodsUsed) > 0 {
+		WriteStatusInfo(ctx, "Recovery methods: %s", strings.Join(methodsUsed, ", "))
 	}
+	return summary
 }
 
 // executeMifareCommand executes a MIFARE command and displays output
-func executeMifareCommand(cmdStr string, description string) (string, error) {
-	if ok, msg := checkProxmark3(); !ok {
-		WriteStatusError(msg)
-		return "", fmt.Errorf("%s", msg)
-	}
-
-	pm3Binary, err := getPm3Path()
-	if err != nil {
-		WriteStatusError("Failed to find pm3 binary: %v", err)
+func executeMifareCommand(ctx context.Context, cmdStr string, description string) (string, error) {
+	if err := checkProxmark3(ctx); err != nil {
+		WriteStatusError(ctx, "%v", err)
 		return "", err
 	}
 
-	device, err := getPm3Device()
-	if err != nil {
-		WriteStatusError("Failed to detect pm3 device: %v", err)
-		return "", err
-	}
-
-	WriteStatusProgress(description)
-	WriteStatusInfo("Place card on reader")
+	WriteStatusProgress(ctx, "%s", description)
+	WriteStatusInfo(ctx, "Place card on reader")
 
-	fmt.Println(cmdStr)
-	fmt.Println()
+	emitCommand(ctx, cmdStr)
+	emitOutput(ctx, "")
 
-	cmd := exec.Command(pm3Binary, "-c", cmdStr, "-p", device)
-	output, cmdErr := cmd.CombinedOutput()
-	outputStr := string(output)
-	fmt.Println(outputStr)
+	outputStr, cmdErr := runPm3(ctx, cmdStr)
+	emitOutput(ctx, outputStr)
 
 	return outputStr, cmdErr
 }
 
 // checkKeysFast executes hf mf fchk to check all keys on card
-func checkKeysFast(keyFilePath string) {
+func checkKeysFast(ctx context.Context, keyFilePath string) error {
 	cmdStr := "hf mf fchk"
 	if keyFilePath != "" {
 		cmdStr = fmt.Sprintf("hf mf fchk -f %s", keyFilePath)
 	}
 
-	outputStr, cmdErr := executeMifareCommand(cmdStr, "Checking keys on card (fast check)...")
+	outputStr, cmdErr := executeMifareCommand(ctx, cmdStr, "Checking keys on card (fast check)...")
 
-	if cmdErr != nil {
-		WriteStatusError("Key check failed: %v", cmdErr)
-		return
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Key check cancelled by user - showing keys found so far")
+	} else if cmdErr != nil {
+		WriteStatusError(ctx, "Key check failed: %v", cmdErr)
+		return emitFailure(ctx, pm3Failure(outputStr, cmdErr, ErrNoCardPresent))
 	}
 
 	// Parse key check results from the table
@@ -492,24 +526,29 @@ func checkKeysFast(keyFilePath string) {
 
 	// Always display keys if found, regardless of count source
 	if len(foundKeys) > 0 {
-		WriteStatusSuccess("Found %d valid keys", successCount)
+		WriteStatusSuccess(ctx, "Found %d valid keys", successCount)
 		if failedCount > 0 {
-			WriteStatusInfo("%d keys failed authentication", failedCount)
+			WriteStatusInfo(ctx, "%d keys failed authentication", failedCount)
 		}
-		WriteStatusInfo("")
-		WriteStatusInfo("--- Found Keys ---")
+		WriteStatusInfo(ctx, "")
+		WriteStatusInfo(ctx, "--- Found Keys ---")
 		for _, keyInfo := range foundKeys {
-			WriteStatusInfo(keyInfo)
+			WriteStatusInfo(ctx, "%s", keyInfo)
 		}
 	} else if successCount > 0 {
-		WriteStatusSuccess("Found %d valid keys", successCount)
+		WriteStatusSuccess(ctx, "Found %d valid keys", successCount)
 		if failedCount > 0 {
-			WriteStatusInfo("%d keys failed authentication", failedCount)
+			WriteStatusInfo(ctx, "%d keys failed authentication", failedCount)
 		}
-		WriteStatusInfo("(Keys found but format not recognized - see full output)")
+		WriteStatusInfo(ctx, "(Keys found but format not recognized - see full output)")
 	} else {
-		WriteStatusError("No valid keys found")
+		WriteStatusError(ctx, "No valid keys found")
+		if isCancelled(cmdErr) {
+			return cmdErr
+		}
+		return emitFailure(ctx, pm3Failure(outputStr, nil, fmt.Errorf("%w: no valid keys found", ErrAuthFailed)))
 	}
+	return cmdErr
 }
 
 // min helper function
@@ -521,12 +560,16 @@ func min(a, b int) int {
 }
 
 // getCardInfo executes hf mf info to get detailed card information
-func getCardInfo() {
-	outputStr, cmdErr := executeMifareCommand("hf mf info", "Getting detailed card information...")
+func getCardInfo(ctx context.Context) error {
+	outputStr, cmdErr := executeMifareCommand(ctx, "hf mf info", "Getting detailed card information...")
 
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return cmdErr
+	}
 	if cmdErr != nil {
-		WriteStatusError("Failed to get card info: %v", cmdErr)
-		return
+		WriteStatusError(ctx, "Failed to get card info: %v", cmdErr)
+		return emitFailure(ctx, pm3Failure(outputStr, cmdErr, ErrNoCardPresent))
 	}
 
 	// Extract UID - format is " UID: 5A F7 0D 9D" or " UID: 5AF70D9D"
@@ -535,22 +578,22 @@ func getCardInfo() {
 	if uidMatch := uidRegex1.FindStringSubmatch(outputStr); len(uidMatch) > 1 {
 		// Remove spaces from UID
 		uid := strings.ReplaceAll(uidMatch[1], " ", "")
-		WriteStatusSuccess("Card UID: %s", uid)
+		WriteStatusSuccess(ctx, "Card UID: %s", uid)
 	} else {
 		// Try without spaces
 		uidRegex2 := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{8,14})`)
 		if uidMatch := uidRegex2.FindStringSubmatch(outputStr); len(uidMatch) > 1 {
-			WriteStatusSuccess("Card UID: %s", uidMatch[1])
+			WriteStatusSuccess(ctx, "Card UID: %s", uidMatch[1])
 		}
 	}
 
 	// Extract card type
 	if strings.Contains(outputStr, "MIFARE Classic") {
-		WriteStatusInfo("Card Type: MIFARE Classic")
+		WriteStatusInfo(ctx, "Card Type: MIFARE Classic")
 		if strings.Contains(outputStr, "1K") {
-			WriteStatusInfo("Size: 1K (16 sectors)")
+			WriteStatusInfo(ctx, "Size: 1K (16 sectors)")
 		} else if strings.Contains(outputStr, "4K") {
-			WriteStatusInfo("Size: 4K (40 sectors)")
+			WriteStatusInfo(ctx, "Size: 4K (40 sectors)")
 		}
 	}
 
@@ -558,7 +601,7 @@ func getCardInfo() {
 	if strings.Contains(outputStr, "Magic capabilities") {
 		magicRegex := regexp.MustCompile(`Magic capabilities\.\.\.\s+([^\n]+)`)
 		if magicMatch := magicRegex.FindStringSubmatch(outputStr); len(magicMatch) > 1 {
-			WriteStatusInfo("Magic: %s", strings.TrimSpace(magicMatch[1]))
+			WriteStatusInfo(ctx, "Magic: %s", strings.TrimSpace(magicMatch[1]))
 		}
 	}
 
@@ -566,39 +609,518 @@ func getCardInfo() {
 	if strings.Contains(outputStr, "Prng") {
 		prngRegex := regexp.MustCompile(`Prng[^:]*:\s*([^\n]+)`)
 		if prngMatch := prngRegex.FindStringSubmatch(outputStr); len(prngMatch) > 1 {
-			WriteStatusInfo("PRNG: %s", strings.TrimSpace(prngMatch[1]))
+			WriteStatusInfo(ctx, "PRNG: %s", strings.TrimSpace(prngMatch[1]))
 		}
 	}
 
 	// Check for Saflok
 	if strings.Contains(outputStr, "Saflok") {
-		WriteStatusInfo("Detected: Saflok hotel key card")
+		WriteStatusInfo(ctx, "Detected: Saflok hotel key card")
 	}
+	return nil
 }
 
 // setMagicCardUID executes hf mf csetuid to set UID on Chinese magic card
-func setMagicCardUID(uid string) {
+func setMagicCardUID(ctx context.Context, uid string) error {
 	if uid == "" {
-		WriteStatusError("UID is required")
-		return
+		WriteStatusError(ctx, "UID is required")
+		return emitFailure(ctx, fmt.Errorf("%w: UID is required", ErrInvalidInput))
+	}
+	// The UID is written like a MIFARE credential, so the same scope applies
+	if ct, ok := lookupCardType("mifare"); ok {
+		err := checkScope(ctx, func(s *ScopePolicy) error { return s.checkCredential(ct, CardParams{UID: uid}) })
+		if err != nil {
+			return err
+		}
 	}
 	cmdStr := fmt.Sprintf("hf mf csetuid -u %s", uid)
 
-	outputStr, cmdErr := executeMifareCommand(cmdStr, "Setting UID on magic card...")
+	outputStr, cmdErr := executeMifareCommand(ctx, cmdStr, "Setting UID on magic card...")
 
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return cmdErr
+	}
 	if cmdErr != nil {
-		WriteStatusError("Failed to set UID: %v", cmdErr)
-		return
+		WriteStatusError(ctx, "Failed to set UID: %v", cmdErr)
+		return emitFailure(ctx, pm3Failure(outputStr, cmdErr, ErrWriteFailed))
 	}
 
 	// Check for success
 	if strings.Contains(outputStr, "success") || strings.Contains(outputStr, "Success") ||
 		strings.Contains(outputStr, "OK") || strings.Contains(outputStr, "ok") {
-		WriteStatusSuccess("UID set successfully: %s", uid)
+		WriteStatusSuccess(ctx, "UID set successfully: %s", uid)
 	} else if strings.Contains(outputStr, "error") || strings.Contains(outputStr, "Error") ||
 		strings.Contains(outputStr, "failed") || strings.Contains(outputStr, "Failed") {
-		WriteStatusError("Failed to set UID")
+		WriteStatusError(ctx, "Failed to set UID")
+		return emitFailure(ctx, pm3Failure(outputStr, nil, fmt.Errorf("%w: UID not set", ErrWriteFailed)))
 	} else {
-		WriteStatusInfo("UID operation completed - review output for confirmation")
+		WriteStatusInfo(ctx, "UID operation completed - review output for confirmation")
+	}
+	return nil
+}
+
+// sniffHFKeys runs hf sniff until the Proxmark3 button is pressed, capturing reader-card
+// traffic so keys can be recovered from it
+func sniffHFKeys(ctx context.Context) error {
+	WriteStatusInfo(ctx, "Starting key sniffing...")
+
+	if err := checkProxmark3(ctx); err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
+	}
+
+	WriteStatusSuccess(ctx, "Proxmark3 connected")
+	WriteStatusInfo(ctx, "Place card on reader and use it at a reader to capture keys")
+
+	emitCommand(ctx, "hf sniff")
+	emitOutput(ctx, "")
+	WriteStatusInfo(ctx, "Sniffing will continue until you press the Proxmark3 button")
+	WriteStatusInfo(ctx, "Use 'data samples' to download captured data")
+	WriteStatusInfo(ctx, "Use 'data plot' to visualize captured data")
+
+	outputStr, cmdErr := runPm3(ctx, "hf sniff")
+	emitOutput(ctx, outputStr)
+
+	var failure error
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Sniffing cancelled by user")
+		WriteStatusInfo(ctx, "Use buttons below to process captured data")
+	} else if cmdErr != nil {
+		// A sniff stopped with the Proxmark3 button is not a failure
+		if failure = classifyPm3Output(outputStr, cmdErr); failure == nil {
+			WriteStatusSuccess(ctx, "Sniffing stopped by user")
+			WriteStatusInfo(ctx, "Use buttons below to process captured data")
+		} else {
+			WriteStatusError(ctx, "Sniff failed: %v", cmdErr)
+			failure = emitFailure(ctx, failure)
+		}
+	} else {
+		WriteStatusSuccess(ctx, "Key sniffing completed")
+		WriteStatusInfo(ctx, "Use buttons below to process captured data")
+	}
+
+	// Extract sample count if available
+	sampleRegex := regexp.MustCompile(`(\d+)\s+samples?`)
+	if match := sampleRegex.FindStringSubmatch(outputStr); len(match) > 1 {
+		WriteStatusInfo(ctx, "Captured %s samples", match[1])
 	}
+	if isCancelled(cmdErr) {
+		return cmdErr
+	}
+	return failure
+}
+
+// restoreFromDump writes a MIFARE Classic dump to a card with hf mf restore and verifies the
+// result against the dump. The latest key file is used when keyPath is empty;
+// onKeyFileFound is called with it when one is found.
+func restoreFromDump(ctx context.Context, dumpPath, keyPath string, wipe bool, onKeyFileFound func(string)) error {
+	WriteStatusInfo(ctx, "Writing card from dump file...")
+
+	if err := checkProxmark3(ctx); err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
+	}
+
+	WriteStatusSuccess(ctx, "Proxmark3 connected")
+
+	// The dump's UID is checked before the wipe touches the blank
+	err := checkScope(ctx, func(s *ScopePolicy) error {
+		if len(s.UIDPrefixes) == 0 {
+			return nil
+		}
+		uid, err := dumpFileUID(dumpPath)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrOutOfScope, err)
+		}
+		return s.checkUID(uid)
+	})
+	if err != nil {
+		return err
+	}
+
+	WriteStatusInfo(ctx, "Place blank card on reader")
+
+	// Wipe card first if requested (recommended for magic cards)
+	if wipe {
+		WriteStatusProgress(ctx, "Wiping card to default state...")
+		emitOutput(ctx, "")
+		emitCommand(ctx, "hf mf cwipe")
+		emitOutput(ctx, "")
+
+		wipeOutputStr, wipeErr := runPm3(ctx, "hf mf cwipe")
+		emitOutput(ctx, wipeOutputStr)
+
+		if wipeErr != nil {
+			WriteStatusError(ctx, "Wipe failed: %v", wipeErr)
+			WriteStatusInfo(ctx, "Continuing with write anyway...")
+		} else {
+			WriteStatusSuccess(ctx, "Card wiped successfully")
+		}
+	}
+
+	// If not specified, try to find the latest key file
+	if keyPath == "" {
+		latestKey := findLatestKeyFile()
+		if latestKey != "" {
+			keyPath = latestKey
+			if onKeyFileFound != nil {
+				onKeyFileFound(keyPath)
+			}
+			WriteStatusInfo(ctx, "Auto-selected latest key file: %s", keyPath)
+		}
+	}
+
+	if keyPath != "" {
+		keyPath = expandUserPath(keyPath)
+	}
+
+	// Validate key file exists if provided, otherwise skip it
+	if keyPath != "" {
+		if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+			WriteStatusError(ctx, "Key file does not exist: %s", keyPath)
+			WriteStatusInfo(ctx, "Proceeding without key file...")
+			keyPath = "" // Clear it so we don't use it
+		}
+	}
+
+	var cmdStr string
+
+	// Files in the vault are decrypted only until the restore and its verification finish
+	plain, cleanup, err := decryptForPm3(dumpPath, keyPath)
+	if err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
+	}
+	defer cleanup()
+	dumpFile, keyFile := plain[0], plain[1]
+
+	if keyPath != "" {
+		cmdStr = fmt.Sprintf("hf mf restore -f %s -k %s", dumpFile, keyFile)
+		WriteStatusInfo(ctx, "Using dump file: %s", dumpPath)
+		WriteStatusInfo(ctx, "Using key file: %s", keyPath)
+	} else {
+		cmdStr = fmt.Sprintf("hf mf restore -f %s", dumpFile)
+		WriteStatusInfo(ctx, "Using dump file: %s (no key file)", dumpPath)
+	}
+
+	emitCommand(ctx, cmdStr)
+	emitOutput(ctx, "")
+
+	outputStr, cmdErr := runPm3(ctx, cmdStr)
+	emitOutput(ctx, outputStr)
+
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Write cancelled by user - card may be partially written")
+		return cmdErr
+	}
+	if cmdErr != nil {
+		WriteStatusError(ctx, "Write failed: %v", cmdErr)
+		return emitFailure(ctx, pm3Failure(outputStr, cmdErr, ErrWriteFailed))
+	}
+	WriteStatusSuccess(ctx, "Card written successfully from dump file")
+
+	// Automatically verify the write
+	WriteStatusProgress(ctx, "Verifying card data...")
+	emitOutput(ctx, "")
+
+	// Use the key file if available, otherwise try without
+	var verifyCmdStr string
+	if keyPath != "" {
+		verifyCmdStr = fmt.Sprintf("hf mf dump --ns -k %s", keyFile)
+		emitCommand(ctx, verifyCmdStr)
+	} else {
+		verifyCmdStr = "hf mf dump --ns"
+		emitCommand(ctx, verifyCmdStr)
+	}
+	emitOutput(ctx, "")
+
+	verifyOutputStr, verifyErr := runPm3(ctx, verifyCmdStr)
+	emitOutput(ctx, verifyOutputStr)
+
+	if isCancelled(verifyErr) {
+		WriteStatusInfo(ctx, "Verification cancelled by user")
+		return verifyErr
+	}
+	if verifyErr != nil {
+		WriteStatusError(ctx, "Verification dump failed: %v", verifyErr)
+		WriteStatusInfo(ctx, "Note: Some blocks may require different keys or may be protected")
+		return emitFailure(ctx, pm3Failure(verifyOutputStr, verifyErr, ErrAuthFailed))
+	}
+
+	// Read original dump file and extract UID
+	var failure error
+	var dumpUID, cardUID string
+	var dumpATQA, dumpSAK, cardATQA, cardSAK string
+
+	if dumpFile != "" {
+		dumpData, err := os.ReadFile(dumpFile)
+		if err == nil && len(dumpData) >= 16 {
+			// MIFARE Classic UID is in block 0, bytes 0-3
+			dumpUID = fmt.Sprintf("%02X%02X%02X%02X", dumpData[0], dumpData[1], dumpData[2], dumpData[3])
+			// ATQA is typically in block 0, byte 6-7, SAK in byte 5
+			if len(dumpData) > 7 {
+				dumpSAK = fmt.Sprintf("%02X", dumpData[5])
+				dumpATQA = fmt.Sprintf("%02X%02X", dumpData[6], dumpData[7])
+			}
+		}
+	}
+
+	// Extract UID from verification output (block 0)
+	// Look for pattern: "   0 | XX XX XX XX ..." where first 4 bytes are UID
+	block0Regex := regexp.MustCompile(`(?m)^\s+0\s+\|\s+([0-9A-F]{2})\s+([0-9A-F]{2})\s+([0-9A-F]{2})\s+([0-9A-F]{2})`)
+	block0Match := block0Regex.FindStringSubmatch(verifyOutputStr)
+	if len(block0Match) == 5 {
+		cardUID = strings.ToUpper(block0Match[1] + block0Match[2] + block0Match[3] + block0Match[4])
+	}
+
+	// Extract ATQA and SAK from block 0 if available
+	block0FullRegex := regexp.MustCompile(`(?m)^\s+0\s+\|\s+([0-9A-F]{2}\s+){5}([0-9A-F]{2})\s+([0-9A-F]{2})\s+([0-9A-F]{2})`)
+	block0FullMatch := block0FullRegex.FindStringSubmatch(verifyOutputStr)
+	if len(block0FullMatch) >= 5 {
+		cardSAK = strings.ToUpper(block0FullMatch[2])
+		cardATQA = strings.ToUpper(block0FullMatch[3] + block0FullMatch[4])
+	}
+
+	// Compare and display results
+	if dumpUID != "" && cardUID != "" {
+		if dumpUID == cardUID {
+			WriteStatusSuccess(ctx, "✓ SUCCESS! Card UID matches dump file")
+			WriteStatusInfo(ctx, "UID: %s (matches)", cardUID)
+		} else {
+			WriteStatusError(ctx, "UID mismatch! Dump: %s, Card: %s", dumpUID, cardUID)
+			failure = emitFailure(ctx, fmt.Errorf("%w: UID %s, dump has %s", ErrVerifyMismatch, cardUID, dumpUID))
+		}
+
+		// Show ATQA and SAK if available
+		if dumpATQA != "" && cardATQA != "" {
+			if dumpATQA == cardATQA {
+				WriteStatusInfo(ctx, "ATQA: %s (matches)", cardATQA)
+			} else {
+				WriteStatusInfo(ctx, "ATQA: Dump=%s, Card=%s (mismatch)", dumpATQA, cardATQA)
+			}
+		}
+
+		if dumpSAK != "" && cardSAK != "" {
+			if dumpSAK == cardSAK {
+				WriteStatusInfo(ctx, "SAK: %s (matches)", cardSAK)
+			} else {
+				WriteStatusInfo(ctx, "SAK: Dump=%s, Card=%s (mismatch)", dumpSAK, cardSAK)
+			}
+		}
+	} else if cardUID != "" {
+		WriteStatusSuccess(ctx, "✓ Card verified successfully")
+		WriteStatusInfo(ctx, "Card UID: %s", cardUID)
+		if cardATQA != "" {
+			WriteStatusInfo(ctx, "ATQA: %s", cardATQA)
+		}
+		if cardSAK != "" {
+			WriteStatusInfo(ctx, "SAK: %s", cardSAK)
+		}
+	}
+
+	// Check for success indicators
+	if strings.Contains(verifyOutputStr, "Succeeded in dumping all blocks") {
+		okCount := strings.Count(verifyOutputStr, "( ok )")
+		WriteStatusInfo(ctx, "All %d blocks read successfully", okCount)
+	}
+	if failure == nil {
+		emitResult(ctx, "Card written from dump file", RestoreResult{DumpFile: dumpPath, KeyFile: keyPath, UID: cardUID})
+	}
+	return failure
+}
+
+// checkHotelCardScope identifies the card on the reader with hf mf info when the scope policy
+// restricts UIDs or hotel systems, and checks it against the policy
+func checkHotelCardScope(ctx context.Context) error {
+	s, err := activeScope()
+	if err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
+	}
+	if s == nil || (len(s.UIDPrefixes) == 0 && len(s.HotelSystems) == 0) {
+		return nil
+	}
+
+	WriteStatusProgress(ctx, "Identifying the card to check it against the scope policy...")
+	emitCommand(ctx, "hf mf info")
+	outputStr, cmdErr := runPm3(ctx, "hf mf info")
+	emitOutput(ctx, outputStr)
+	if isCancelled(cmdErr) {
+		WriteStatusInfo(ctx, "Operation cancelled by user")
+		return cmdErr
+	}
+	uid, system := identifyHotelCard(outputStr)
+	return checkScope(ctx, func(s *ScopePolicy) error {
+		if err := s.checkUID(uid); err != nil {
+			return err
+		}
+		return s.checkHotelSystem(system)
+	})
+}
+
+// hotelSystems are the hotel key card systems identifyHotelCard recognises, the names a scope
+// policy can list
+var hotelSystems = []string{"saflok"}
+
+// identifyHotelCard finds the UID and the hotel system of a card in hf mf info output. The
+// system is "" when it is not one the assistant recognises.
+func identifyHotelCard(outputStr string) (uid, system string) {
+	if match := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{2}(?:\s+[A-F0-9]{2})+)`).FindStringSubmatch(outputStr); len(match) > 1 {
+		uid = strings.ReplaceAll(match[1], " ", "")
+	} else if match := regexp.MustCompile(`UID\s*:\s*([A-F0-9]{8,14})`).FindStringSubmatch(outputStr); len(match) > 1 {
+		uid = match[1]
+	}
+	if strings.Contains(outputStr, "Saflok") {
+		system = "saflok"
+	}
+	return uid, system
+}
+
+// dumpFileUID returns the UID in block 0 of a MIFARE Classic dump, binary or .eml, decrypting
+// it when it is in the vault
+func dumpFileUID(path string) (string, error) {
+	plain, cleanup, err := decryptForPm3(path)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	data, err := os.ReadFile(plain[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to read dump file: %w", err)
+	}
+	if strings.HasSuffix(strings.ToLower(strings.TrimSuffix(path, vaultExtension)), ".eml") {
+		line, _, _ := strings.Cut(string(data), "\n")
+		if line = strings.TrimSpace(line); len(line) >= 8 {
+			return strings.ToUpper(line[:8]), nil
+		}
+		return "", fmt.Errorf("dump file %s has no block 0", filepath.Base(path))
+	}
+	if len(data) < 4 {
+		return "", fmt.Errorf("dump file %s has no block 0", filepath.Base(path))
+	}
+	return fmt.Sprintf("%02X%02X%02X%02X", data[0], data[1], data[2], data[3]), nil
+}
+
+// storeRecoveredFiles moves a recovered dump and key file into the vault and returns their
+// paths in it. A file that could not be stored keeps its path.
+func storeRecoveredFiles(ctx context.Context, v *Vault, dumpPath, keyPath string) (string, string) {
+	store := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		stored, err := v.storeDump(path)
+		if stored == "" {
+			WriteStatusError(ctx, "Failed to move %s into the vault: %v", path, err)
+			return path
+		}
+		if err != nil {
+			WriteStatusError(ctx, "%v", err)
+		}
+		WriteStatusSuccess(ctx, "Encrypted into the vault: %s", stored)
+		return stored
+	}
+	return store(dumpPath), store(keyPath)
+}
+
+// expandUserPath expands a leading ~ to the home directory and makes the path absolute
+func expandUserPath(path string) string {
+	if strings.HasPrefix(path, "~") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+		}
+	}
+	if !filepath.IsAbs(path) {
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+	}
+	return path
+}
+
+// dumpSearchDirs are the directories searched for dumps and key files: the configured ones,
+// by default the home directory, ~/.proxmark3 and the working directory, and the open vault
+func dumpSearchDirs() []string {
+	var searchDirs []string
+	for _, dir := range currentConfig().DumpDirs {
+		searchDirs = append(searchDirs, expandUserPath(dir))
+	}
+	if v := activeVault(); v != nil {
+		searchDirs = append(searchDirs, v.Dir)
+	}
+	return searchDirs
+}
+
+// findLatestDumpFile finds the most recently modified dump file matching the pattern
+func findLatestDumpFile() string {
+	searchDirs := dumpSearchDirs()
+
+	var latestFile string
+	var latestTime time.Time
+
+	for _, dir := range searchDirs {
+		// Try multiple patterns to catch all dump files
+		patterns := []string{
+			"hf-mf-*-dump-*.bin",
+			"hf-mf-*-dump-*.eml",
+			"*-dump-*.bin",
+			"*-dump-*.eml",
+			"hf-mf-*-dump-*.bin" + vaultExtension,
+			"hf-mf-*-dump-*.eml" + vaultExtension,
+		}
+
+		for _, pattern := range patterns {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				continue
+			}
+
+			for _, match := range matches {
+				info, err := os.Stat(match)
+				if err != nil {
+					continue
+				}
+				// Only consider files that actually exist
+				if !info.Mode().IsRegular() {
+					continue
+				}
+				if info.ModTime().After(latestTime) {
+					latestTime = info.ModTime()
+					latestFile = match
+				}
+			}
+		}
+	}
+
+	return latestFile
+}
+
+// findLatestKeyFile finds the most recently modified key file matching the pattern
+func findLatestKeyFile() string {
+	searchDirs := dumpSearchDirs()
+
+	var latestFile string
+	var latestTime time.Time
+
+	for _, dir := range searchDirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "hf-mf-*-key.bin"))
+		if err != nil {
+			continue
+		}
+		sealed, _ := filepath.Glob(filepath.Join(dir, "hf-mf-*-key*.bin"+vaultExtension))
+		matches = append(matches, sealed...)
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().After(latestTime) {
+				latestTime = info.ModTime()
+				latestFile = match
+			}
+		}
+	}
+
+	return latestFile
 }
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// JobState is the lifecycle stage of a scheduled job
+type JobState int
+
+const (
+	JobQueued JobState = iota
+	JobRunning
+	JobDone
+	JobCancelled
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobQueued:
+		return "queued"
+	case JobRunning:
+		return "running"
+	case JobCancelled:
+		return "cancelled"
+	default:
+		return "done"
+	}
+}
+
+const (
+	// maxQueuedJobs is how many jobs may wait for one Proxmark3 before new ones are rejected
+	maxQueuedJobs = 4
+	// maxJobHistory is how many finished jobs are kept for the job list
+	maxJobHistory = 20
+)
+
+// Job is an operation queued for a Proxmark3. Jobs on the same device run one at a time in
+// the order they were submitted; jobs on different devices run independently.
+type Job struct {
+	ID     int // operation ID, shared with the events the job publishes
+	Name   string
+	Device string // port the job runs on, empty when a replay runner is installed
+
+	mu       sync.Mutex
+	state    JobState
+	events   []Event // everything the job published, kept for the job list
+	queued   time.Time
+	started  time.Time
+	finished time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(ctx context.Context, job *Job)
+	done   chan struct{}
+}
+
+// State returns the job's lifecycle stage
+func (j *Job) State() JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// Events returns the events the job has published so far
+func (j *Job) Events() []Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Event(nil), j.events...)
+}
+
+// Done is closed when the job has finished or was cancelled
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Result returns the payload of the job's last result and the failure it reported, if any
+func (j *Job) Result() (payload interface{}, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.events {
+		if e.Kind != EventResult {
+			continue
+		}
+		if e.Payload != nil {
+			payload = e.Payload
+		}
+		if e.Err != nil && err == nil {
+			err = e.Err
+		}
+	}
+	return payload, err
+}
+
+// Describe summarises the job for the job list
+func (j *Job) Describe() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	device := j.Device
+	if device == "" {
+		device = "replay"
+	}
+	desc := fmt.Sprintf("#%d %s on %s - %s", j.ID, j.Name, device, j.state)
+	switch j.state {
+	case JobRunning:
+		desc += fmt.Sprintf(" (%s)", time.Since(j.started).Round(time.Second))
+	case JobDone, JobCancelled:
+		if !j.started.IsZero() {
+			desc += fmt.Sprintf(" (%s)", j.finished.Sub(j.started).Round(time.Second))
+		}
+	}
+	return desc
+}
+
+func (j *Job) setState(state JobState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+	switch state {
+	case JobRunning:
+		j.started = time.Now()
+	case JobDone, JobCancelled:
+		j.finished = time.Now()
+	}
+}
+
+// jobScheduler serializes access to each Proxmark3
+type jobScheduler struct {
+	mu      sync.Mutex
+	jobs    []*Job            // queued, running and recent jobs, oldest first
+	queues  map[string][]*Job // jobs waiting per device
+	running map[string]*Job   // job running per device
+
+	// OnChange is called whenever a job is queued, starts or finishes
+	OnChange func()
+}
+
+func newJobScheduler() *jobScheduler {
+	s := &jobScheduler{
+		queues:  make(map[string][]*Job),
+		running: make(map[string]*Job),
+	}
+	events.Subscribe(s.record)
+	return s
+}
+
+// record keeps an event with the job that published it
+func (s *jobScheduler) record(e Event) {
+	if e.Operation == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.ID == e.Operation {
+			j.mu.Lock()
+			j.events = append(j.events, e)
+			j.mu.Unlock()
+			return
+		}
+	}
+}
+
+// jobs is the scheduler used by the GUI and the API server
+var jobs = newJobScheduler()
+
+// jobDevice resolves the device a job submitted with ctx will run on
+func jobDevice(ctx context.Context) (string, error) {
+	if installedPm3Runner() != nil {
+		return "", nil
+	}
+	if port := pm3DeviceFrom(ctx); port != "" {
+		return port, nil
+	}
+	port, err := getPm3Device()
+	if err != nil || port == "" {
+		return "", fmt.Errorf("%w. Please connect your Proxmark3", ErrDeviceOffline)
+	}
+	return port, nil
+}
+
+// Submit queues a job on the device of the operation in ctx. A job is rejected when the same
+// job is already queued or running on that device, or when too many jobs are waiting for it.
+func (s *jobScheduler) Submit(ctx context.Context, name string, run func(ctx context.Context, job *Job)) (*Job, error) {
+	device, err := jobDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if r := s.running[device]; r != nil && r.Name == name {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%s is already running on %s", name, device)
+	}
+	for _, queued := range s.queues[device] {
+		if queued.Name == name {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("%s is already queued for %s (job #%d)", name, device, queued.ID)
+		}
+	}
+	if len(s.queues[device]) >= maxQueuedJobs {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("too many jobs waiting for the Proxmark3 - wait for one to finish or cancel")
+	}
+
+	id := operationIDFrom(ctx)
+	if id == 0 {
+		id = newOperationID()
+	}
+	// Each job can be cancelled on its own without stopping the jobs of other devices
+	jobCtx, cancel := context.WithCancel(withOperationID(withPm3Device(ctx, device), id))
+	job := &Job{
+		ID:     id,
+		Name:   name,
+		Device: device,
+		state:  JobQueued,
+		queued: time.Now(),
+		ctx:    jobCtx,
+		cancel: cancel,
+		run:    run,
+		done:   make(chan struct{}),
+	}
+	s.jobs = append(s.jobs, job)
+	s.queues[device] = append(s.queues[device], job)
+	startWorker := s.running[device] == nil && len(s.queues[device]) == 1
+	if startWorker {
+		// Reserve the device so a second Submit does not start another worker
+		s.running[device] = job
+	}
+	s.mu.Unlock()
+
+	s.changed()
+	if startWorker {
+		go s.work(device)
+	}
+	return job, nil
+}
+
+// work runs the jobs queued for a device until its queue is empty
+func (s *jobScheduler) work(device string) {
+	for {
+		s.mu.Lock()
+		queue := s.queues[device]
+		if len(queue) == 0 {
+			delete(s.running, device)
+			s.mu.Unlock()
+			return
+		}
+		job := queue[0]
+		s.queues[device] = queue[1:]
+		s.running[device] = job
+		s.mu.Unlock()
+
+		if job.ctx.Err() != nil {
+			job.setState(JobCancelled)
+		} else {
+			job.setState(JobRunning)
+			s.changed()
+			job.run(job.ctx, job)
+			if job.ctx.Err() != nil {
+				job.setState(JobCancelled)
+			} else {
+				job.setState(JobDone)
+			}
+		}
+		s.finish(job)
+	}
+}
+
+// finish reports that a job has ended and releases its context
+func (s *jobScheduler) finish(job *Job) {
+	emitResult(job.ctx, fmt.Sprintf("%s %s", job.Name, job.State()), nil)
+	job.cancel()
+	close(job.done)
+	s.prune()
+	s.changed()
+}
+
+// Cancel cancels a job: a queued job is dropped from its device's queue, a running one has its
+// pm3 command stopped. It returns false when there is no such job or it has already finished.
+func (s *jobScheduler) Cancel(id int) bool {
+	s.mu.Lock()
+	var job *Job
+	for _, j := range s.jobs {
+		if j.ID == id {
+			job = j
+		}
+	}
+	if job == nil {
+		s.mu.Unlock()
+		return false
+	}
+	queued := false
+	queue := s.queues[job.Device]
+	for i, j := range queue {
+		if j == job {
+			s.queues[job.Device] = append(queue[:i:i], queue[i+1:]...)
+			queued = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if queued {
+		job.cancel()
+		job.setState(JobCancelled)
+		s.finish(job)
+		return true
+	}
+	// A job the worker has just taken off the queue is cancelled before it starts
+	if st := job.State(); st == JobDone || st == JobCancelled {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// CancelDevice cancels the running and queued jobs of a device and returns how many it cancelled
+func (s *jobScheduler) CancelDevice(device string) int {
+	cancelled := 0
+	for _, j := range s.List() {
+		if j.Device == device && s.Cancel(j.ID) {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// CancelAll cancels every running and queued job and returns how many it cancelled
+func (s *jobScheduler) CancelAll() int {
+	cancelled := 0
+	for _, j := range s.List() {
+		if s.Cancel(j.ID) {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// prune drops the oldest finished jobs beyond maxJobHistory
+func (s *jobScheduler) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := 0
+	for _, j := range s.jobs {
+		if st := j.State(); st == JobDone || st == JobCancelled {
+			finished++
+		}
+	}
+	kept := s.jobs[:0]
+	for _, j := range s.jobs {
+		if st := j.State(); (st == JobDone || st == JobCancelled) && finished > maxJobHistory {
+			finished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	s.jobs = kept
+}
+
+// List returns the queued, running and recent jobs, newest first
+func (s *jobScheduler) List() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*Job, len(s.jobs))
+	for i, j := range s.jobs {
+		list[len(s.jobs)-1-i] = j
+	}
+	return list
+}
+
+// Get returns the job with the given ID, or nil once it has dropped out of the job list
+func (s *jobScheduler) Get(id int) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *jobScheduler) changed() {
+	if s.OnChange != nil {
+		s.OnChange()
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 const (
@@ -25,10 +27,26 @@ type Card struct {
 }
 
 func main() {
+	// Settings come from the config file and environment, see config.go. The GUI starts
+	// without them, so a broken config only stops CLI commands.
+	configLoadErr = loadConfig()
+	// Writes, verifications and restores are logged from their results, see audit.go
+	events.Subscribe(auditResult)
+
+	// A first argument that is not a flag is a subcommand, see cli.go
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+	os.Exit(runFlagCLI())
+}
+
+// runFlagCLI runs the original flag interface and returns the exit code
+func runFlagCLI() int {
+
 	bitLength := flag.Int("bl", 0, "Bit length")
 	facilityCode := flag.Int("fc", 0, "Facility code")
 	cardNumber := flag.Int("cn", 0, "Card number")
-	cardType := flag.String("t", "prox", "Card type (iclass, prox, awid, indala, avigilon, em, piv, mifare)")
+	cardType := flag.String("t", "prox", "Card type ("+strings.Join(cardTypeNames(), ", ")+")")
 	uid := flag.String("uid", "", "UID for PIV and MIFARE cards (4 x HEX Bytes in the Card_Number column)")
 	hexData := flag.String("hex", "", "Hex data for EM cards")
 	write := flag.Bool("w", false, "Write card data")
@@ -36,26 +54,38 @@ func main() {
 	simulate := flag.Bool("s", false, "Card simulation")
 	showVersion := flag.Bool("version", false, "Show program version")
 	gui := flag.Bool("g", false, "Launch GUI")
+	csvFile := flag.String("c", "", "CSV file of credentials to process in batch (card type, bit length, FC, CN, hex, UID)")
+	resume := flag.Bool("resume", false, "Resume a batch (-c) from its saved position")
+	startRow := flag.Int("row", 0, "Start a batch (-c) at this row")
+	read := flag.Bool("r", false, "Read a card of the given type (-t)")
+	outFile := flag.String("o", "", "Save the card read (-r) to a JSON file")
+	readFile := flag.String("f", "", "Load card type and values from a card read saved with -o")
+	replay := flag.String("replay", "", "Replay a recorded pm3 session instead of using a connected Proxmark3")
+	var device string
+	flag.StringVar(&device, "p", "", "Proxmark3 port to use, e.g. /dev/ttyACM1 or COM4 (default: first detected)")
+	flag.StringVar(&device, "device", "", "Same as -p")
+	listDevices := flag.Bool("devices", false, "List connected Proxmark3 devices with their serial and firmware")
+	logFile := flag.String("log", "", "Append pm3 commands, output and status messages to a log file")
+	workspaceDir := flag.String("workspace", os.Getenv("DOPPELGANGER_WORKSPACE"), "Record reads, writes, dumps, key files and the pm3 transcript to an engagement workspace directory ($DOPPELGANGER_WORKSPACE)")
+	vaultDir := flag.String("vault", os.Getenv("DOPPELGANGER_VAULT"), "Encrypt dumps, key files and card reads into a vault directory; the passphrase is asked for or taken from $DOPPELGANGER_VAULT_PASSPHRASE ($DOPPELGANGER_VAULT)")
+	scopePolicy := flag.String("scope", os.Getenv("DOPPELGANGER_SCOPE"), "Scope policy to check writes and simulations against (default: scope.json in the workspace) ($DOPPELGANGER_SCOPE)")
+	override := flag.String("override", "", "Write or simulate even if out of scope, logging this reason")
+	jsonMode := flag.Bool("json", false, "Print the result as JSON on stdout; pm3 output and status messages go to stderr")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, Green+"\n--- About Doppelgänger Assistant ---\n"+Reset)
 		fmt.Fprintf(os.Stderr, "Author: @tweathers-sec\n")
 		fmt.Fprintf(os.Stderr, "Version: %s\n", Version)
 		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, Yellow+"Usage: %s -bl <bit length> -fc <facility code> -cn <card number> -t <card type> [-uid <UID>] [-hex <Hex Data>] [-w] [-v] [-s] [-version] [-g] [-c <csv file>]\n"+Reset, os.Args[0])
+		fmt.Fprintf(os.Stderr, Yellow+"Usage: %s -bl <bit length> -fc <facility code> -cn <card number> -t <card type> [-uid <UID>] [-hex <Hex Data>] [-w] [-v] [-s] [-version] [-g] [-c <csv file>] [-r [-o <file>]] [-f <file>] [-p <port>] [-devices] [-log <file>] [-workspace <dir>] [-vault <dir>] [-scope <file>] [-override <reason>] [-json]\n"+Reset, os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, Green+"Supported card types and bit lengths:\n"+Reset)
 		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "  iclass: 26, 35\n")
-		fmt.Fprintf(os.Stderr, "  prox: 26, 30, 31, 33, 34, 35, 36, 37, 46, 48\n")
-		fmt.Fprintf(os.Stderr, "  awid: 26\n")
-		fmt.Fprintf(os.Stderr, "  indala: 26, 27, 28, 29\n")
-		fmt.Fprintf(os.Stderr, "  avigilon: 56\n")
-		fmt.Fprintf(os.Stderr, "  em: 32\n")
-		fmt.Fprintf(os.Stderr, "  piv: N/A\n")
-		fmt.Fprintf(os.Stderr, "  mifare: N/A\n")
+		for _, ct := range registeredCardTypes() {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", ct.Name(), joinBitLengths(ct.BitLengths()))
+		}
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, Green+"Example #1: Generate encoded card values for manual writing with a Proxmark3\n"+Reset)
 		fmt.Fprintf(os.Stderr, "\n")
@@ -72,101 +102,239 @@ func main() {
 		fmt.Fprintf(os.Stderr, Green+"Example #3: Launch the application in GUI mode\n"+Reset)
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "  %s -g\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, Green+"Example #4: Write and verify every credential in a CSV file (card type, bit length, FC, CN, hex, UID)\n"+Reset)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s -c credentials.csv -w -v\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -c credentials.csv -w -v -resume\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, Green+"Example #5: Read a card, save it, then write and verify a clone from the saved read\n"+Reset)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s -t prox -r -o badge.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -f badge.json -w -v\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, Green+"Example #6: Replay a recorded pm3 session to check parsing without a Proxmark3\n"+Reset)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s -t prox -r -replay hid-read.txt\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, Green+"Example #7: Read with one Proxmark3 while writing with another\n"+Reset)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s -devices\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -p /dev/ttyACM0 -t prox -r -o badge.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -p /dev/ttyACM1 -f badge.json -w -v\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, Green+"Example #8: Use the subcommands, e.g. to script a headless box over SSH ('%s help' lists them)\n"+Reset, os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s detect\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s recover -m autopwn\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s restore -f hf-mf-01020304-dump.bin\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, Green+"Example #9: Read a card from a script, with the result as JSON and the exit code telling what failed\n"+Reset)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "  %s read -t prox -json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n")
+		exitCodeUsage()
 	}
 
 	flag.Parse()
+	defer closePm3Runner()
 
-	if flag.NFlag() == 0 {
+	// Choosing a workspace, vault or scope policy alone still launches the GUI
+	sessionOnly := true
+	flag.Visit(func(f *flag.Flag) {
+		sessionOnly = sessionOnly && (f.Name == "workspace" || f.Name == "vault" || f.Name == "scope")
+	})
+	if sessionOnly {
 		*gui = true
 	}
+	if configLoadErr != nil && !*gui {
+		new(cliOutcome).printError(os.Stderr, configLoadErr)
+		return exitCodeFor(configLoadErr)
+	}
 
 	if *showVersion {
 		fmt.Println("Version:", Version)
-		return
+		return exitOK
+	}
+
+	if *logFile != "" {
+		closeLog, err := openEventLog(*logFile)
+		if err != nil {
+			fmt.Println(Red, err, Reset)
+			return exitInvalidInput
+		}
+		defer closeLog()
+	}
+
+	if *workspaceDir != "" {
+		if _, err := useWorkspace(*workspaceDir); err != nil {
+			fmt.Println(Red, err, Reset)
+			return exitInvalidInput
+		}
+		defer closeWorkspace()
+	}
+
+	if *vaultDir != "" {
+		if _, err := unlockVault(*vaultDir); err != nil {
+			fmt.Println(Red, err, Reset)
+			return exitInvalidInput
+		}
+		defer closeVault()
+	}
+
+	if *scopePolicy != "" {
+		if err := useScopePolicy(*scopePolicy); err != nil {
+			fmt.Println(Red, err, Reset)
+			return exitInvalidInput
+		}
+	}
+
+	if *replay != "" {
+		if err := installPm3Replay(*replay); err != nil {
+			fmt.Println(Red, err, Reset)
+			return exitInvalidInput
+		}
+	}
+
+	if device != "" {
+		selectPm3Device(device)
+	}
+
+	if *listDevices {
+		devices, err := enumeratePm3Devices(context.Background())
+		if err != nil {
+			fmt.Println(Red, "Failed to list Proxmark3 devices:", err, Reset)
+			return exitFailure
+		}
+		if len(devices) == 0 {
+			fmt.Println(Yellow, "No Proxmark3 devices found.", Reset)
+			return exitNoDevice
+		}
+		for i, d := range devices {
+			fmt.Printf("%d: %s\n", i+1, d.Label())
+		}
+		return exitOK
 	}
 
 	if *gui {
 		runGUI()
-		return
+		return exitOK
+	}
+
+	if *jsonMode {
+		// stdout is reserved for the report
+		events.Subscribe(printEvents(os.Stderr, os.Stderr))
+	} else {
+		events.Subscribe(printEvents(os.Stdout, os.Stderr))
+	}
+	outcome := collectOutcome(0)
+
+	ctx := beginOperation()
+	if *override != "" {
+		ctx = withScopeOverride(ctx, *override)
+	}
+	cancelOnInterrupt()
+
+	command := "generate"
+	switch {
+	case *csvFile != "":
+		command = "batch"
+	case *read:
+		command = "read"
+	case *simulate:
+		command = "sim"
+	case *write:
+		command = "write"
+	}
+	// finish ends the run with the error it failed with, if any
+	finish := func(err error) int {
+		if *jsonMode {
+			outcome.printError(os.Stderr, err)
+		} else {
+			outcome.printError(os.Stdout, err)
+		}
+		return outcome.finish(ctx, command, err, *jsonMode)
 	}
 
 	if *simulate && (*write || *verify) {
-		fmt.Println(Red, "Cannot use -s (simulate) with -w (write) or -v (verify).", Reset)
-		return
+		return finish(fmt.Errorf("%w: cannot use -s (simulate) with -w (write) or -v (verify)", ErrInvalidInput))
 	}
 
 	if *verify && !*write {
-		fmt.Println(Red, "Cannot use -v (verify) without -w (write).", Reset)
-		return
+		return finish(fmt.Errorf("%w: cannot use -v (verify) without -w (write)", ErrInvalidInput))
+	}
+
+	if *read && (*write || *simulate) {
+		return finish(fmt.Errorf("%w: cannot use -r (read) with -w (write) or -s (simulate)", ErrInvalidInput))
 	}
 
-	if *write || *simulate {
-		if ok, msg := checkProxmark3(); !ok {
-			fmt.Printf("%sError: %s%s\n", Red, msg, Reset)
-			return
+	if *readFile != "" {
+		cardRead, err := loadCardRead(*readFile)
+		if err != nil {
+			return finish(fmt.Errorf("%w: %v", ErrInvalidInput, err))
 		}
+		p := cardRead.Params()
+		*cardType = cardRead.CardType
+		*bitLength, *facilityCode, *cardNumber = p.BitLength, p.FacilityCode, p.CardNumber
+		*hexData, *uid = p.HexData, p.UID
 	}
 
-	if *cardType == "piv" || *cardType == "mifare" {
-		if *uid == "" {
-			fmt.Println(Red, "UID is required for PIV and MIFARE card types.", Reset)
-			return
+	if *write || *simulate || *read {
+		if err := checkProxmark3(ctx); err != nil {
+			return finish(err)
 		}
-	} else {
-		if *cardType == "em" {
-			if *bitLength == 0 {
-				*bitLength = 32
-			}
-			if *bitLength != 32 {
-				fmt.Println(Red, "Invalid bit length for EM. Supported bit length is 32.", Reset)
-				return
-			}
-			if *hexData == "" {
-				fmt.Println(Red, "Hex data is required for EM card type.", Reset)
-				return
-			}
-			// Validate EM4100 hex data format
-			if valid, errMsg := validateEM4100Hex(*hexData); !valid {
-				fmt.Println(Red, errMsg, Reset)
-				return
-			}
-		} else {
-			if *bitLength == 0 || (*facilityCode == 0 || *cardNumber == 0) {
-				flag.Usage()
-				return
-			}
-			switch *cardType {
-			case "iclass":
-				if *bitLength != 26 && *bitLength != 30 && *bitLength != 33 && *bitLength != 34 && *bitLength != 35 && *bitLength != 36 && *bitLength != 37 && *bitLength != 46 && *bitLength != 48 {
-					fmt.Println(Red, "Invalid bit length for iCLASS. Supported bit lengths are 26, 30, 33, 34, 35, 36, 37, 46, and 48.", Reset)
-					return
-				}
-			case "indala":
-				if *bitLength != 26 && *bitLength != 27 && *bitLength != 28 && *bitLength != 29 {
-					fmt.Println(Red, "Invalid bit length for Indala. Supported bit lengths are 26, 27, 28, and 29.", Reset)
-					return
-				}
-			case "avigilon":
-				if *bitLength != 56 {
-					fmt.Println(Red, "Invalid bit length for Avigilon. Supported bit length is 56.", Reset)
-					return
-				}
-			case "prox":
-				if *bitLength != 26 && *bitLength != 30 && *bitLength != 31 && *bitLength != 33 && *bitLength != 34 && *bitLength != 35 && *bitLength != 36 && *bitLength != 37 && *bitLength != 46 && *bitLength != 48 {
-					fmt.Println(Red, "Invalid bit length for Prox. Supported bit lengths are 26, 30, 31, 33, 34, 35, 36, 37, 46, and 48.", Reset)
-					return
-				}
-			case "awid":
-				if *bitLength != 26 {
-					fmt.Println(Red, "Invalid bit length for AWID. Supported bit length is 26.", Reset)
-					return
-				}
-			default:
-				fmt.Println(Red, "Unsupported card type.", Reset)
-				return
+	}
+
+	if *csvFile != "" {
+		return finish(runBatch(ctx, *csvFile, *write, *verify, *simulate, *resume, *startRow))
+	}
+
+	ct, ok := lookupCardType(*cardType)
+	if !ok {
+		return finish(fmt.Errorf("%w: unsupported card type", ErrInvalidInput))
+	}
+
+	if *read {
+		cardRead, err := readCardData(ctx, ct.Name())
+		if err == nil && *outFile != "" {
+			if err := saveCardRead(*outFile, cardRead); err != nil {
+				return finish(fmt.Errorf("Failed to save card read: %w", err))
 			}
+			WriteStatusSuccess(ctx, "Card read saved to %s", *outFile)
+		}
+		return finish(err)
+	}
+
+	switch ct.Input() {
+	case InputHex:
+		if *bitLength == 0 {
+			*bitLength = ct.BitLengths()[0]
+		}
+	case InputWiegand:
+		if *bitLength == 0 || (*facilityCode == 0 || *cardNumber == 0) {
+			flag.Usage()
+			return exitInvalidInput
+		}
+	}
+
+	params := CardParams{
+		BitLength:    *bitLength,
+		FacilityCode: *facilityCode,
+		CardNumber:   *cardNumber,
+		HexData:      *hexData,
+		UID:          *uid,
+	}
+	if err := ct.Validate(params); err != nil {
+		return finish(fmt.Errorf("%w: %v", ErrInvalidInput, err))
+	}
+
+	if !*write && !*simulate {
+		card, err := generateCardData(*cardType, *bitLength, *facilityCode, *cardNumber, *hexData, *uid)
+		if err != nil {
+			return finish(fmt.Errorf("%w: %v", ErrInvalidInput, err))
 		}
+		displayGeneratedCard(ctx, *cardType, card)
 	}
 
-	handleCardType(*cardType, *facilityCode, *cardNumber, *bitLength, *write, *verify, *uid, *hexData, *simulate)
+	return finish(handleCardType(ctx, *cardType, *facilityCode, *cardNumber, *bitLength, *write, *verify, *uid, *hexData, *simulate))
 }
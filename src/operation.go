@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Operations started before cancelAllOperations share one root context, so Ctrl-C and shutting
+// down stop every pm3 command in flight; the next operation gets a fresh one. A single job is
+// cancelled through the job scheduler instead, see jobScheduler.Cancel.
+var (
+	operationMutex  sync.Mutex
+	operationCtx    context.Context    = context.Background()
+	operationCancel context.CancelFunc = func() {}
+	lastOperationID int
+)
+
+// newOperationID returns an ID no other operation in this process has used
+func newOperationID() int {
+	operationMutex.Lock()
+	defer operationMutex.Unlock()
+	lastOperationID++
+	return lastOperationID
+}
+
+// beginOperation starts a cancellable operation on the selected Proxmark3 and returns its
+// context, which tags the operation's events with a new operation ID
+func beginOperation() context.Context {
+	id := newOperationID()
+	operationMutex.Lock()
+	defer operationMutex.Unlock()
+	if operationCtx == context.Background() || operationCtx.Err() != nil {
+		operationCtx, operationCancel = context.WithCancel(context.Background())
+	}
+	return withOperationID(withPm3Device(operationCtx, selectedPm3Device()), id)
+}
+
+// cancelAllOperations cancels every running operation, for Ctrl-C and shutting down
+func cancelAllOperations() {
+	operationMutex.Lock()
+	defer operationMutex.Unlock()
+	operationCancel()
+}
+
+// isCancelled reports whether err came from cancelling the running operation
+func isCancelled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
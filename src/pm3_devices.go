@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Pm3Device is a connected Proxmark3. Serial and Firmware are empty when the device does not
+// report them.
+type Pm3Device struct {
+	Port     string `json:"port"`
+	Serial   string `json:"serial,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+// Label describes the device for the device picker and -devices
+func (d Pm3Device) Label() string {
+	label := d.Port
+	if d.Serial != "" {
+		label += " (" + d.Serial + ")"
+	}
+	if d.Firmware != "" {
+		label += " - " + d.Firmware
+	}
+	return label
+}
+
+// pm3PortLineRegex matches a port listed by 'pm3 --list', e.g. "1: /dev/ttyACM0" or "2: COM4"
+var pm3PortLineRegex = regexp.MustCompile(`^\s*\d+:\s*(/dev/\S+|COM\d+)\s*$`)
+
+// pm3FirmwareRegex matches the firmware image line of 'hw version', e.g. "OS......... Iceman/master/v4.18218"
+var pm3FirmwareRegex = regexp.MustCompile(`(?im)^\s*os[\s.:]+(\S.*?)\s*$`)
+
+// pm3SerialRegex matches the unique ID reported by 'hw version' or 'hw status'
+var pm3SerialRegex = regexp.MustCompile(`(?i)(?:uniq(?:ue)?\s*id|serial(?:\s*number)?)(?:\s*\([a-z]+\))?[\s.:]+(?:0x)?((?:[0-9a-f]{2}\s?){4,})`)
+
+// listPm3Ports returns every port listed by 'pm3 --list', in order
+func listPm3Ports() ([]string, error) {
+	pm3Binary, err := getPm3Path()
+	if err != nil || pm3Binary == "" {
+		return nil, fmt.Errorf("pm3 binary not found")
+	}
+
+	output, err := exec.Command(pm3Binary, "--list").CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	return parsePm3Ports(string(output)), nil
+}
+
+// parsePm3Ports extracts the ports from 'pm3 --list' output
+func parsePm3Ports(output string) []string {
+	var ports []string
+	for _, line := range strings.Split(output, "\n") {
+		if matches := pm3PortLineRegex.FindStringSubmatch(strings.TrimRight(line, "\r")); matches != nil {
+			ports = append(ports, matches[1])
+		}
+	}
+	return ports
+}
+
+// parsePm3DeviceInfo fills the firmware and serial of a device from 'hw version' and 'hw status' output
+func parsePm3DeviceInfo(d *Pm3Device, output string) {
+	if matches := pm3FirmwareRegex.FindStringSubmatch(output); matches != nil && d.Firmware == "" {
+		d.Firmware = matches[1]
+	}
+	if matches := pm3SerialRegex.FindStringSubmatch(output); matches != nil && d.Serial == "" {
+		d.Serial = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(matches[1]), " ", ""))
+	}
+}
+
+// enumeratePm3Devices lists the connected Proxmark3s with their serial and firmware. Devices
+// that do not answer are still listed so they can be selected once they are ready.
+func enumeratePm3Devices(ctx context.Context) ([]Pm3Device, error) {
+	ports, err := listPm3Ports()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]Pm3Device, 0, len(ports))
+	for _, port := range ports {
+		device := Pm3Device{Port: port}
+		runner := pm3RunnerFor(port)
+		for _, command := range []string{"hw version", "hw status"} {
+			output, err := runner.Run(ctx, command)
+			if err != nil {
+				break
+			}
+			parsePm3DeviceInfo(&device, output)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// pm3SelectedDevice is the port chosen with -p or the GUI device picker; empty auto-detects
+var pm3SelectedDevice string
+var pm3SelectedDeviceMutex sync.Mutex
+
+// selectPm3Device chooses the port used by operations started from now on
+func selectPm3Device(port string) {
+	pm3SelectedDeviceMutex.Lock()
+	defer pm3SelectedDeviceMutex.Unlock()
+	pm3SelectedDevice = port
+}
+
+// selectedPm3Device returns the port chosen with selectPm3Device
+func selectedPm3Device() string {
+	pm3SelectedDeviceMutex.Lock()
+	defer pm3SelectedDeviceMutex.Unlock()
+	return pm3SelectedDevice
+}
+
+// pm3Sessions holds one session per port so operations on different devices run independently
+var pm3Sessions = make(map[string]*pm3Session)
+var pm3SessionsMutex sync.Mutex
+
+// pm3RunnerFor returns the session for a port, creating it on first use
+func pm3RunnerFor(port string) Pm3Runner {
+	pm3SessionsMutex.Lock()
+	defer pm3SessionsMutex.Unlock()
+	s, ok := pm3Sessions[port]
+	if !ok {
+		s = newPm3Session(port)
+		pm3Sessions[port] = s
+	}
+	return s
+}
+
+// closePm3Sessions ends every pm3 client held open by a session
+func closePm3Sessions() {
+	pm3SessionsMutex.Lock()
+	defer pm3SessionsMutex.Unlock()
+	for _, s := range pm3Sessions {
+		s.Close()
+	}
+}
+
+type pm3DeviceKey struct{}
+
+// withPm3Device returns a context whose pm3 commands run on the given port
+func withPm3Device(ctx context.Context, port string) context.Context {
+	return context.WithValue(ctx, pm3DeviceKey{}, port)
+}
+
+// pm3DeviceFrom returns the port attached to ctx with withPm3Device
+func pm3DeviceFrom(ctx context.Context) string {
+	port, _ := ctx.Value(pm3DeviceKey{}).(string)
+	return port
+}
+
+// pm3RunnerFrom returns the runner for the operation in ctx: the installed runner when one
+// was set with setPm3Runner, otherwise the session for the operation's device, or for the
+// first detected device when none was chosen.
+func pm3RunnerFrom(ctx context.Context) Pm3Runner {
+	if r := installedPm3Runner(); r != nil {
+		return r
+	}
+	port := pm3DeviceFrom(ctx)
+	if port == "" {
+		var err error
+		if port, err = getPm3Device(); err != nil || port == "" {
+			return offlinePm3Runner{err: err}
+		}
+	}
+	return pm3RunnerFor(port)
+}
+
+// offlinePm3Runner reports that no Proxmark3 could be found
+type offlinePm3Runner struct {
+	err error
+}
+
+func (r offlinePm3Runner) error() error {
+	if pm3Binary, err := getPm3Path(); err != nil || pm3Binary == "" {
+		return ErrPm3NotFound
+	}
+	if r.err != nil {
+		return fmt.Errorf("%w: %v", ErrDeviceOffline, r.err)
+	}
+	return ErrDeviceOffline
+}
+
+func (r offlinePm3Runner) Run(ctx context.Context, command string) (string, error) {
+	return "", r.error()
+}
+
+func (r offlinePm3Runner) RunAttached(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return r.error()
+}
+
+func (r offlinePm3Runner) Check() error {
+	resetPm3DeviceCache()
+	if pm3Binary, err := getPm3Path(); err != nil || pm3Binary == "" {
+		return ErrPm3NotFound
+	}
+	return fmt.Errorf("%w. Please connect your Proxmark3", ErrDeviceOffline)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pm3Runner runs Proxmark3 client commands. All card operations go through pm3Runner so
+// they can be exercised against recorded output instead of a physical Proxmark3.
+type Pm3Runner interface {
+	// Run executes a pm3 command and returns its combined output. When ctx is cancelled the
+	// command is stopped and the output received so far is returned with ctx.Err().
+	Run(ctx context.Context, command string) (string, error)
+	// RunAttached executes a pm3 command wired to the given streams, e.g. for simulation
+	RunAttached(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) error
+	// Check returns nil when the Proxmark3 is connected and responding, otherwise an error
+	// wrapping ErrPm3NotFound or ErrDeviceOffline
+	Check() error
+}
+
+// pm3Runner replaces the Proxmark3 sessions for every operation when set, e.g. with a
+// transcript replay
+var pm3Runner Pm3Runner
+var pm3RunnerMutex sync.Mutex
+
+// setPm3Runner installs a runner used by every operation in place of the Proxmark3 sessions
+func setPm3Runner(r Pm3Runner) {
+	closePm3Runner()
+	pm3RunnerMutex.Lock()
+	defer pm3RunnerMutex.Unlock()
+	pm3Runner = r
+}
+
+// installedPm3Runner returns the runner set with setPm3Runner, or nil
+func installedPm3Runner() Pm3Runner {
+	pm3RunnerMutex.Lock()
+	defer pm3RunnerMutex.Unlock()
+	return pm3Runner
+}
+
+// runPm3 runs a command on the device chosen for the operation in ctx and logs it to the audit log
+func runPm3(ctx context.Context, command string) (string, error) {
+	runner, start := pm3RunnerFrom(ctx), time.Now()
+	output, err := runner.Run(ctx, command)
+	auditCommand(ctx, runner, start, command, err)
+	return output, err
+}
+
+// runPm3Attached runs a command on the device chosen for the operation in ctx, wired to the given
+// streams, and logs it to the audit log
+func runPm3Attached(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	runner, start := pm3RunnerFrom(ctx), time.Now()
+	err := runner.RunAttached(ctx, command, stdin, stdout, stderr)
+	auditCommand(ctx, runner, start, command, err)
+	return err
+}
+
+// fakePm3Response is one recorded reply to a pm3 command
+type fakePm3Response struct {
+	Output string
+	Err    error
+}
+
+// fakePm3Runner replays canned pm3 output instead of talking to a device. Replies for a
+// command are returned in the order they were recorded; the last one repeats once the
+// others are used up, so retry loops see a stable final answer.
+type fakePm3Runner struct {
+	mu        sync.Mutex
+	responses map[string][]fakePm3Response
+	commands  []string
+	offline   string // Check message when the fake reports the device as missing
+}
+
+// newFakePm3Runner creates a fake runner with no recorded replies
+func newFakePm3Runner() *fakePm3Runner {
+	return &fakePm3Runner{responses: make(map[string][]fakePm3Response)}
+}
+
+// normalizePm3Command collapses whitespace so recorded and issued commands compare equal
+func normalizePm3Command(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
+
+// Respond records output to return for a command
+func (f *fakePm3Runner) Respond(command, output string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := normalizePm3Command(command)
+	f.responses[key] = append(f.responses[key], fakePm3Response{Output: output})
+}
+
+// RespondError records output and an error to return for a command
+func (f *fakePm3Runner) RespondError(command, output string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := normalizePm3Command(command)
+	f.responses[key] = append(f.responses[key], fakePm3Response{Output: output, Err: err})
+}
+
+// SetOffline makes Check report the device as missing with the given message
+func (f *fakePm3Runner) SetOffline(message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offline = message
+}
+
+// Commands returns every command run so far, in order
+func (f *fakePm3Runner) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakePm3Runner) next(command string) (fakePm3Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := normalizePm3Command(command)
+	f.commands = append(f.commands, key)
+
+	queue := f.responses[key]
+	if len(queue) == 0 {
+		return fakePm3Response{}, fmt.Errorf("no recorded pm3 output for %q", key)
+	}
+	if len(queue) > 1 {
+		f.responses[key] = queue[1:]
+	}
+	return queue[0], nil
+}
+
+func (f *fakePm3Runner) Run(ctx context.Context, command string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	resp, err := f.next(command)
+	if err != nil {
+		return "", err
+	}
+	return resp.Output, resp.Err
+}
+
+func (f *fakePm3Runner) RunAttached(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	resp, err := f.next(command)
+	if err != nil {
+		return err
+	}
+	io.WriteString(stdout, resp.Output)
+	return resp.Err
+}
+
+func (f *fakePm3Runner) Check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.offline != "" {
+		return fmt.Errorf("%w: %s", ErrDeviceOffline, f.offline)
+	}
+	return nil
+}
+
+// pm3PromptRegex matches the client prompt echoed before each command in a transcript,
+// e.g. "[usb] pm3 --> lf hid reader" or "pm3 --> hw status"
+var pm3PromptRegex = regexp.MustCompile(`^(?:\[[^\]]*\]\s*)?pm3 -->\s*(.*)$`)
+
+// loadPm3Transcript builds a fake runner from a recorded pm3 session. Each prompt line
+// starts a command; the lines up to the next prompt are its output.
+func loadPm3Transcript(r io.Reader) (*fakePm3Runner, error) {
+	fake := newFakePm3Runner()
+
+	var command string
+	var output strings.Builder
+	flush := func() {
+		if command != "" {
+			fake.Respond(command, output.String())
+		}
+		output.Reset()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if matches := pm3PromptRegex.FindStringSubmatch(line); matches != nil {
+			flush()
+			command = strings.TrimSpace(matches[1])
+			continue
+		}
+		if command != "" {
+			output.WriteString(line)
+			output.WriteString("\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	flush()
+
+	if len(fake.responses) == 0 {
+		return nil, fmt.Errorf("no pm3 commands found in transcript")
+	}
+	return fake, nil
+}
+
+// loadPm3TranscriptFile reads a recorded pm3 session from disk
+func loadPm3TranscriptFile(path string) (*fakePm3Runner, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+	defer file.Close()
+	return loadPm3Transcript(file)
+}
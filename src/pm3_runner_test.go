@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hidTranscript is a recorded session reading an HID H10301 card, FC 118 CN 1603
+const hidTranscript = `[usb] pm3 --> lf hid reader
+[+] [H10301  ] HID H10301 26-bit                FC: 118  CN: 1603  parity ( ok )
+[=] found 1 matches
+[+] DemodBuffer:
+[=] raw: 000000000000002006ec0c86
+`
+
+// useFakePm3 installs fake for every operation in the test, with writes that do not pause
+// and a home directory of its own for the logs operations write
+func useFakePm3(t *testing.T, fake *fakePm3Runner) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	saved := currentConfig()
+	c := saved
+	c.WritePause = durationPtr(0)
+	applyConfig(c)
+	setPm3Runner(fake)
+	t.Cleanup(func() {
+		setPm3Runner(nil)
+		applyConfig(saved)
+	})
+}
+
+func TestLoadPm3Transcript(t *testing.T) {
+	fake, err := loadPm3Transcript(strings.NewReader(hidTranscript + "pm3 -->   hw   status\n[#] Unique ID......... 0x1234\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := fake.Run(context.Background(), "lf hid reader")
+	if err != nil || !strings.Contains(output, "CN: 1603") {
+		t.Fatalf("lf hid reader = %q, %v", output, err)
+	}
+	if output, _ := fake.Run(context.Background(), "hw status"); !strings.Contains(output, "Unique ID") {
+		t.Errorf("hw status = %q, want the recorded output", output)
+	}
+	if _, err := fake.Run(context.Background(), "lf em 410x reader"); err == nil {
+		t.Error("a command missing from the transcript should fail")
+	}
+	want := []string{"lf hid reader", "hw status", "lf em 410x reader"}
+	if got := fake.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Commands() = %q, want %q", got, want)
+	}
+
+	if _, err := loadPm3Transcript(strings.NewReader("[+] no prompt here\n")); err == nil {
+		t.Error("a transcript without commands should be rejected")
+	}
+}
+
+func TestFakePm3RunnerRepeatsLastResponse(t *testing.T) {
+	fake := newFakePm3Runner()
+	fake.RespondError("hw status", "", ErrDeviceOffline)
+	fake.Respond("hw status", "ok")
+	for i, want := range []string{"", "ok", "ok"} {
+		output, err := fake.Run(context.Background(), "hw status")
+		if output != want || (i == 0) != (err != nil) {
+			t.Errorf("run #%d = %q, %v", i+1, output, err)
+		}
+	}
+}
+
+func TestOfflineCheck(t *testing.T) {
+	prox, _ := lookupCardType("prox")
+	params := CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}
+	tests := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"read", func(ctx context.Context) error {
+			_, err := readCardData(ctx, "prox")
+			return err
+		}},
+		{"write", func(ctx context.Context) error { return writeCardData(ctx, prox, params, false) }},
+		{"verify", func(ctx context.Context) error { return verifyCardData(ctx, prox, params) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, err := loadPm3Transcript(strings.NewReader(hidTranscript))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake.SetOffline("Proxmark3 not responding")
+			useFakePm3(t, fake)
+
+			if err := fake.Check(); !errors.Is(err, ErrDeviceOffline) {
+				t.Fatalf("Check() = %v, want ErrDeviceOffline", err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- tt.run(context.Background()) }()
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrDeviceOffline) {
+					t.Errorf("err = %v, want ErrDeviceOffline", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the operation did not give up on an offline device")
+			}
+			if commands := fake.Commands(); len(commands) != 0 {
+				t.Errorf("ran %q on an offline device", commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pm3SessionStartTimeout bounds how long the client may take to connect and answer
+	pm3SessionStartTimeout = 15 * time.Second
+	// pm3SessionBreakTimeout bounds the hw break sent after a cancelled command
+	pm3SessionBreakTimeout = 5 * time.Second
+)
+
+// pm3Session keeps a single pm3 client open and sends it commands over stdin. Each command is
+// followed by "rem <marker>"; the client runs commands in order, so the remark line marks the
+// end of the command's output. Prompt echo lines are dropped from the output.
+//
+// Commands that poll the keyboard while they run take a line waiting on stdin as Enter and
+// abort, so nothing may be queued behind them. They run in a client of their own, see runAlone.
+type pm3Session struct {
+	Device        string // serial port
+	BreakOnCancel bool   // send hw break after a cancelled command to stop device-side loops
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string // client output, closed when the client exits
+	markers int
+}
+
+// newPm3Session creates a session that connects on first use
+func newPm3Session(device string) *pm3Session {
+	return &pm3Session{Device: device, BreakOnCancel: true}
+}
+
+// start launches the pm3 client and waits for it to answer. Callers hold s.mu.
+func (s *pm3Session) start() error {
+	if s.cmd != nil {
+		return nil
+	}
+
+	pm3Binary, err := getPm3Path()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPm3NotFound, err)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pm3 output pipe: %w", err)
+	}
+
+	cmd := exec.Command(pm3Binary, "-p", s.Device)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		pr.Close()
+		pw.Close()
+		return fmt.Errorf("failed to open pm3 input: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		pr.Close()
+		pw.Close()
+		return fmt.Errorf("failed to start pm3 client: %w", err)
+	}
+	pw.Close()
+	go cmd.Wait()
+
+	s.cmd, s.stdin, s.lines = cmd, stdin, readLines(pr)
+
+	// Discard the connection banner so it is not returned with the first command
+	ctx, cancel := context.WithTimeout(context.Background(), pm3SessionStartTimeout)
+	defer cancel()
+	if _, err := s.exchange(ctx, "", nil); err != nil {
+		s.stop()
+		return fmt.Errorf("%w: pm3 client did not respond: %v", ErrDeviceOffline, err)
+	}
+	return nil
+}
+
+// stop ends the client process, asking it to quit first and interrupting then killing it
+// if it is still busy with a command. Callers hold s.mu.
+func (s *pm3Session) stop() {
+	if s.cmd == nil {
+		return
+	}
+	io.WriteString(s.stdin, "quit\n")
+	s.stdin.Close()
+	if s.cmd.Process != nil {
+		exited := drain(s.lines)
+		select {
+		case <-exited:
+		case <-time.After(time.Second):
+			// Interrupt is not supported on Windows; the kill below covers it
+			s.cmd.Process.Signal(os.Interrupt)
+			select {
+			case <-exited:
+			case <-time.After(time.Second):
+				s.cmd.Process.Kill()
+			}
+		}
+	}
+	s.cmd, s.stdin, s.lines = nil, nil, nil
+}
+
+// readLines sends the lines read from r until it is closed, then closes r and the channel
+func readLines(r io.ReadCloser) chan string {
+	lines := make(chan string, 256)
+	go func() {
+		defer close(lines)
+		defer r.Close()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			lines <- strings.TrimRight(scanner.Text(), "\r")
+		}
+	}()
+	return lines
+}
+
+// drain discards the remaining output and reports when the client has exited
+func drain(lines chan string) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range lines {
+		}
+		close(done)
+	}()
+	return done
+}
+
+// exchange sends a command followed by an end marker and collects the output up to the
+// marker. Lines are copied to echo as they arrive when it is set. When ctx is done the output
+// received so far is returned with ctx.Err(). Callers hold s.mu.
+func (s *pm3Session) exchange(ctx context.Context, command string, echo io.Writer) (string, error) {
+	s.markers++
+	marker := fmt.Sprintf("doppelganger-%d-%d", os.Getpid(), s.markers)
+
+	var input string
+	if command != "" {
+		input = command + "\n"
+	}
+	input += "rem " + marker + "\n"
+	if _, err := io.WriteString(s.stdin, input); err != nil {
+		return "", fmt.Errorf("failed to send command to pm3 client: %w", err)
+	}
+
+	var output strings.Builder
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				return output.String(), fmt.Errorf("%w: pm3 client exited", ErrDeviceOffline)
+			}
+			if pm3PromptRegex.MatchString(line) || strings.Contains(line, "rem "+marker) {
+				continue
+			}
+			if strings.Contains(line, marker) {
+				return output.String(), nil
+			}
+			output.WriteString(line)
+			output.WriteString("\n")
+			if echo != nil {
+				fmt.Fprintln(echo, line)
+			}
+		case <-ctx.Done():
+			return output.String(), ctx.Err()
+		}
+	}
+}
+
+// run sends a command over the session, starting the client first if needed. A client that
+// exits, stops answering or is cancelled is discarded so the next command reconnects.
+func (s *pm3Session) run(ctx context.Context, command string, echo io.Writer) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if pollsKeyboard(command) {
+		output, err := s.runAlone(ctx, command, echo)
+		if errors.Is(err, context.Canceled) {
+			s.breakDevice()
+		}
+		return output, err
+	}
+	if err := s.start(); err != nil {
+		return "", err
+	}
+	output, err := s.exchange(ctx, command, echo)
+	if err == nil {
+		return output, nil
+	}
+
+	s.stop()
+	if errors.Is(err, context.Canceled) {
+		s.breakDevice()
+	} else {
+		resetPm3DeviceCache()
+	}
+	return output, err
+}
+
+// pm3KeyboardWords are the words of pm3 commands that poll the keyboard while they run, so a
+// simulation, sniff or key recovery can be stopped with Enter
+var pm3KeyboardWords = map[string]bool{
+	"sim": true, "sniff": true, "autopwn": true, "darkside": true, "nested": true, "hardnested": true,
+	"staticnested": true, "brute": true, "nack": true, "chk": true, "fchk": true,
+}
+
+// pollsKeyboard reports whether a pm3 command polls the keyboard while it runs
+func pollsKeyboard(command string) bool {
+	for _, word := range strings.Fields(command) {
+		// -@ repeats a reader until Enter is pressed
+		if word == "-@" || pm3KeyboardWords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// runAlone runs a command that polls the keyboard in a pm3 client started for it with -c, with
+// nothing on its stdin; the command has ended when the client exits. The session's client is
+// stopped first, as the port can only be open once. Callers hold s.mu.
+func (s *pm3Session) runAlone(ctx context.Context, command string, echo io.Writer) (string, error) {
+	s.stop()
+
+	pm3Binary, err := getPm3Path()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPm3NotFound, err)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to create pm3 output pipe: %w", err)
+	}
+	cmd := exec.Command(pm3Binary, "-c", command, "-p", s.Device)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		pr.Close()
+		pw.Close()
+		return "", fmt.Errorf("failed to start pm3 client: %w", err)
+	}
+	pw.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// The connection banner comes before the prompt echoing the command; it is only returned
+	// when the command never started, as it then says why
+	var banner, output strings.Builder
+	started := false
+	lines := readLines(pr)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				err := <-exited
+				if !started {
+					return banner.String(), err
+				}
+				return output.String(), err
+			}
+			if pm3PromptRegex.MatchString(line) {
+				started = true
+				continue
+			}
+			if !started {
+				banner.WriteString(line)
+				banner.WriteString("\n")
+				continue
+			}
+			output.WriteString(line)
+			output.WriteString("\n")
+			if echo != nil {
+				fmt.Fprintln(echo, line)
+			}
+		case <-ctx.Done():
+			// Interrupt is not supported on Windows; the kill below covers it
+			cmd.Process.Signal(os.Interrupt)
+			select {
+			case <-exited:
+			case <-time.After(time.Second):
+				cmd.Process.Kill()
+				<-exited
+			}
+			select {
+			case <-drain(lines):
+			case <-time.After(time.Second):
+			}
+			return output.String(), ctx.Err()
+		}
+	}
+}
+
+// breakDevice reconnects after a cancelled command and sends hw break, so a sniff,
+// simulation or attack left running on the Proxmark3 is stopped. Callers hold s.mu.
+func (s *pm3Session) breakDevice() {
+	if !s.BreakOnCancel {
+		return
+	}
+	if err := s.start(); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pm3SessionBreakTimeout)
+	defer cancel()
+	if _, err := s.exchange(ctx, "hw break", nil); err != nil {
+		s.stop()
+	}
+}
+
+func (s *pm3Session) Run(ctx context.Context, command string) (string, error) {
+	return s.run(ctx, command, nil)
+}
+
+// RunAttached streams the command output as it arrives. stdin is not forwarded, as a line on
+// it would stop the command; simulations are stopped with the Proxmark3 button.
+func (s *pm3Session) RunAttached(ctx context.Context, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	_, err := s.run(ctx, command, stdout)
+	return err
+}
+
+func (s *pm3Session) Check() error {
+	pm3Binary, err := getPm3Path()
+	if err != nil || pm3Binary == "" {
+		return ErrPm3NotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout())
+	defer cancel()
+	output, err := s.run(ctx, "hw ping", nil)
+	if err != nil || errors.Is(classifyPm3Output(output, nil), ErrDeviceOffline) {
+		s.Close()
+		resetPm3DeviceCache()
+		return fmt.Errorf("%w. Please connect your Proxmark3", ErrDeviceOffline)
+	}
+	return nil
+}
+
+// Close ends the pm3 client. The next command starts a new one.
+func (s *pm3Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+	return nil
+}
+
+// closePm3Runner releases every pm3 client held open, e.g. before the port is handed to a terminal
+func closePm3Runner() {
+	if c, ok := installedPm3Runner().(io.Closer); ok {
+		c.Close()
+	}
+	closePm3Sessions()
+}
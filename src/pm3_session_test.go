@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary stands in for the pm3 client when the session tests start it
+	if os.Getenv("DOPPELGANGER_FAKE_PM3") != "" {
+		os.Exit(fakePm3Client(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakePm3Client behaves like the pm3 client: commands come from -c, else one per line on stdin,
+// each echoed after a prompt. "hf sniff" polls the keyboard like the real command, taking any
+// line waiting on stdin as Enter and aborting.
+func fakePm3Client(args []string) int {
+	fmt.Println("[=] Session log /tmp/fake.log")
+	fmt.Println("[+] Communicating with PM3 over USB-CDC")
+
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	// kbdEnterPressed consumes the input waiting on stdin, like kbd_enter_pressed
+	kbdEnterPressed := func() bool {
+		pressed := false
+		for {
+			select {
+			case _, ok := <-lines:
+				if !ok {
+					return pressed
+				}
+				pressed = true
+			default:
+				return pressed
+			}
+		}
+	}
+	execute := func(command string) bool {
+		fmt.Println("[usb] pm3 --> " + command)
+		switch fields := strings.Fields(command); {
+		case command == "quit":
+			return false
+		case len(fields) > 0 && fields[0] == "rem":
+			fmt.Println("[#] " + strings.Join(fields[1:], " "))
+		case command == "hw ping":
+			fmt.Println("[+] Ping response received")
+		case command == "hf sniff" || command == "hf sniff --long":
+			rounds := 20
+			if command == "hf sniff --long" {
+				rounds = 1000
+			}
+			for i := 0; i < rounds; i++ {
+				time.Sleep(10 * time.Millisecond)
+				if kbdEnterPressed() {
+					fmt.Println("[!] aborted via keyboard!")
+					return true
+				}
+			}
+			fmt.Println("[+] sniff done")
+		default:
+			fmt.Println("[+] ran " + command)
+		}
+		return true
+	}
+
+	for i, arg := range args {
+		if arg == "-c" && i+1 < len(args) {
+			execute(args[i+1])
+			return 0
+		}
+	}
+	for command := range lines {
+		if !execute(command) {
+			break
+		}
+	}
+	return 0
+}
+
+// useFakePm3Client makes sessions start the fake client
+func useFakePm3Client(t *testing.T) {
+	t.Helper()
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOPPELGANGER_FAKE_PM3", "1")
+	saved := currentConfig()
+	c := saved
+	c.Pm3Path = binary
+	applyConfig(c)
+	t.Cleanup(func() { applyConfig(saved) })
+}
+
+func TestPm3SessionRun(t *testing.T) {
+	useFakePm3Client(t)
+	s := newPm3Session("fake")
+	defer s.Close()
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"hw ping", "[+] Ping response received\n"},
+		// a command reading stdin must not see anything queued behind it
+		{"hf sniff", "[+] sniff done\n"},
+		{"hw ping", "[+] Ping response received\n"},
+		{"lf hid reader", "[+] ran lf hid reader\n"},
+		{"hf sniff", "[+] sniff done\n"},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		output, err := s.Run(ctx, tt.command)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.command, err)
+		}
+		if output != tt.want {
+			t.Errorf("%s = %q, want %q", tt.command, output, tt.want)
+		}
+	}
+}
+
+func TestPm3SessionCancelKeyboardCommand(t *testing.T) {
+	useFakePm3Client(t)
+	s := newPm3Session("fake")
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := s.Run(ctx, "hf sniff --long"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancel took %s", elapsed)
+	}
+	// the session still works after the cancelled command
+	output, err := s.Run(context.Background(), "hw ping")
+	if err != nil || output != "[+] Ping response received\n" {
+		t.Errorf("hw ping after cancel = %q, %v", output, err)
+	}
+}
+
+func TestPollsKeyboard(t *testing.T) {
+	tests := map[string]bool{
+		"hf sniff":                             true,
+		"hf 14a sim -t 1 --uid 04A1B2C3":       true,
+		"lf hid sim -w H10301 --fc 1 --cn 2":   true,
+		"hf mf autopwn":                        true,
+		"hf mf hardnested --blk 0 -a":          true,
+		"lf hid reader -@":                     true,
+		"lf hid reader":                        false,
+		"hf mf info":                           false,
+		"lf hid clone -w H10301 --fc 1 --cn 2": false,
+	}
+	for command, want := range tests {
+		if got := pollsKeyboard(command); got != want {
+			t.Errorf("pollsKeyboard(%q) = %v, want %v", command, got, want)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	replPrompt = Green + "doppelganger> " + Reset
+	// replHistoryName is the history file in the home directory
+	replHistoryName = ".doppelganger_assistant_history"
+	// replHistoryLimit is how many lines of history are kept
+	replHistoryLimit = 500
+)
+
+// replCommand is a command of the REPL. complete returns the candidates for the argument at
+// index arg, given the arguments before it.
+type replCommand struct {
+	name     string
+	args     string // argument synopsis for help
+	help     string
+	run      func(r *repl, ctx context.Context, args []string) error
+	complete func(arg int, args []string) []string
+}
+
+// replCommands are the commands of the REPL, in the order help lists them
+var replCommands = []replCommand{
+	{"read", "<card type>", "Read a card and remember it for clone", (*repl).read, completeCardType},
+	{"detect", "", "Detect the card type, including dual-frequency cards", (*repl).detect, nil},
+	{"write", "<card type> [bit length] <fc> <cn> | <card type> <hex|uid>", "Write a credential and verify it", (*repl).write, completeCard},
+	{"verify", "[<card type> <values>]", "Check a card against a credential, by default the last one read or written", (*repl).verify, completeCard},
+	{"sim", "<card type> <values>", "Simulate a credential until the Proxmark3 button is pressed", (*repl).sim, completeCard},
+	{"clone", "", "Write the last card read to a blank and verify it", (*repl).clone, nil},
+	{"last", "", "Show the last card read", (*repl).last, nil},
+	{"recover", "[method]", "Recover MIFARE Classic keys from a hotel key card", (*repl).recover, completeRecoveryMethod},
+	{"info", "", "Show MIFARE Classic card details (hf mf info)", (*repl).info, nil},
+	{"note", "<text>", "Add an operator note to the engagement workspace", (*repl).note, nil},
+	{"override", "<reason>", "Let the next write, sim, clone or recover go out of scope, logging the reason", (*repl).armOverride, nil},
+}
+
+// replScopedCommands are the commands checked against the scope policy, which use up an override
+var replScopedCommands = map[string]bool{"write": true, "sim": true, "clone": true, "recover": true}
+
+// repl is an interactive shell running the card operations one line at a time
+type repl struct {
+	out      io.Writer
+	lastRead *CardRead
+	// lastCard is the credential last read or written, verified by a bare verify
+	lastCard *CardParams
+	lastType CardType
+	// override is the reason the next command may go out of scope
+	override string
+}
+
+func lookupReplCommand(name string) (replCommand, bool) {
+	for _, c := range replCommands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return replCommand{}, false
+}
+
+// runREPL reads commands from the terminal, with line editing, completion and history, or one
+// per line from stdin when it is not a terminal, until exit or end of input
+func runREPL() error {
+	r := &repl{out: os.Stdout}
+
+	// Ctrl-C cancels the running command; at the prompt the terminal is raw and reads it as a key
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			cancelAllOperations()
+		}
+	}()
+
+	inFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(inFd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if r.execute(scanner.Text()) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, replPrompt)
+	terminal.AutoCompleteCallback = r.completeCallback(terminal)
+	if history, err := openReplHistory(); err == nil {
+		defer history.Close()
+		terminal.History = history
+	} else {
+		fmt.Fprintln(os.Stderr, Yellow+"History is not saved: "+err.Error()+Reset)
+	}
+
+	fmt.Fprintln(os.Stdout, "Doppelgänger Assistant v"+Version+" - type help for commands, Tab to complete, exit to quit")
+	for {
+		state, err := term.MakeRaw(inFd)
+		if err != nil {
+			return fmt.Errorf("Failed to set up the terminal: %w", err)
+		}
+		if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			terminal.SetSize(w, h)
+		}
+		line, err := terminal.ReadLine()
+		term.Restore(inFd, state)
+		if err == io.EOF {
+			fmt.Fprintln(os.Stdout)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.execute(line) {
+			return nil
+		}
+	}
+}
+
+// execute runs one line and reports whether the REPL should exit
+func (r *repl) execute(line string) (exit bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+	switch fields[0] {
+	case "exit", "quit":
+		return true
+	case "help", "?":
+		r.help()
+		return false
+	}
+	c, ok := lookupReplCommand(fields[0])
+	if !ok {
+		fmt.Fprintln(os.Stderr, Red, fmt.Sprintf("Unknown command %q, type help for the commands.", fields[0]), Reset)
+		return false
+	}
+
+	ctx := beginOperation()
+	if r.override != "" && replScopedCommands[c.name] {
+		ctx = withScopeOverride(ctx, r.override)
+		r.override = ""
+	}
+	outcome := collectOutcome(operationIDFrom(ctx))
+	defer outcome.close()
+	err := c.run(r, ctx, fields[1:])
+	outcome.printError(os.Stderr, err)
+	return false
+}
+
+func (r *repl) help() {
+	fmt.Fprintln(r.out, Green+"Commands:"+Reset)
+	for _, c := range replCommands {
+		if c.args == "" {
+			fmt.Fprintf(r.out, "  %-8s %s\n", c.name, c.help)
+			continue
+		}
+		fmt.Fprintf(r.out, "  %-8s %s\n", c.name, c.args)
+		fmt.Fprintf(r.out, "           %s\n", c.help)
+	}
+	fmt.Fprintf(r.out, "  %-8s %s\n", "help", "Show this list")
+	fmt.Fprintf(r.out, "  %-8s %s\n", "exit", "Leave the shell (or Ctrl-D)")
+	fmt.Fprintln(r.out, "Ctrl-C cancels the running command.")
+}
+
+// remember keeps a credential for a bare verify
+func (r *repl) remember(ct CardType, p CardParams) {
+	r.lastType, r.lastCard = ct, &p
+}
+
+func (r *repl) read(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: usage: read <card type>", ErrInvalidInput)
+	}
+	ct, ok := lookupCardType(args[0])
+	if !ok {
+		return fmt.Errorf("%w: card type must be one of: %s", ErrInvalidInput, strings.Join(cardTypeNames(), ", "))
+	}
+	cardRead, err := readCardData(ctx, ct.Name())
+	if err != nil {
+		return err
+	}
+	r.lastRead = cardRead
+	if cardRead.hasCredential() || cardRead.HexData != "" || cardRead.UID != "" {
+		r.remember(ct, cardRead.Params())
+		WriteStatusInfo(ctx, "Remembered for clone and verify")
+	}
+	return nil
+}
+
+func (r *repl) detect(ctx context.Context, args []string) error {
+	return detectCardType(ctx)
+}
+
+func (r *repl) write(ctx context.Context, args []string) error {
+	ct, p, err := parseReplCard(args)
+	if err != nil {
+		return err
+	}
+	r.remember(ct, p)
+	return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, true, true, p.UID, p.HexData, false)
+}
+
+func (r *repl) verify(ctx context.Context, args []string) error {
+	ct, p := r.lastType, CardParams{}
+	if len(args) > 0 {
+		var err error
+		if ct, p, err = parseReplCard(args); err != nil {
+			return err
+		}
+	} else if r.lastCard == nil {
+		return fmt.Errorf("%w: nothing read or written yet, usage: verify <card type> <values>", ErrInvalidInput)
+	} else {
+		p = *r.lastCard
+	}
+	return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, false, true, p.UID, p.HexData, false)
+}
+
+func (r *repl) sim(ctx context.Context, args []string) error {
+	ct, p, err := parseReplCard(args)
+	if err != nil {
+		return err
+	}
+	return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, false, false, p.UID, p.HexData, true)
+}
+
+func (r *repl) clone(ctx context.Context, args []string) error {
+	if r.lastRead == nil {
+		return fmt.Errorf("%w: no card read yet, use read <card type> first", ErrInvalidInput)
+	}
+	ct, p, err := resolveCard(r.lastRead.CardType, r.lastRead.Params())
+	if err != nil {
+		return fmt.Errorf("the last card read cannot be cloned: %w", err)
+	}
+	WriteStatusInfo(ctx, "Cloning %s", r.lastRead.describe())
+	r.remember(ct, p)
+	return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, true, true, p.UID, p.HexData, false)
+}
+
+func (r *repl) last(ctx context.Context, args []string) error {
+	if r.lastRead == nil {
+		fmt.Fprintln(r.out, "No card read yet.")
+		return nil
+	}
+	fmt.Fprintln(r.out, r.lastRead.describe())
+	return nil
+}
+
+func (r *repl) recover(ctx context.Context, args []string) error {
+	method := "autopwn"
+	if len(args) > 0 {
+		method = args[0]
+	}
+	known := false
+	for _, m := range hotelRecoveryMethods {
+		known = known || m == method
+	}
+	if !known {
+		return fmt.Errorf("%w: method must be one of: %s", ErrInvalidInput, strings.Join(hotelRecoveryMethods, ", "))
+	}
+	return recoverHotelKey(ctx, method, nil)
+}
+
+func (r *repl) info(ctx context.Context, args []string) error {
+	return getCardInfo(ctx)
+}
+
+func (r *repl) armOverride(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: usage: override <reason>", ErrInvalidInput)
+	}
+	r.override = strings.Join(args, " ")
+	WriteStatusInfo(ctx, "The next write, sim, clone or recover may go out of scope; the reason will be logged")
+	return nil
+}
+
+func (r *repl) note(ctx context.Context, args []string) error {
+	w := activeWorkspace()
+	if w == nil {
+		return errNoWorkspace
+	}
+	if err := w.AddNote(strings.Join(args, " ")); err != nil {
+		return err
+	}
+	WriteStatusSuccess(ctx, "Note added to workspace %s", w.Info.Name)
+	return nil
+}
+
+// parseReplCard parses the credential of write, verify and sim: a card type followed by an
+// optional bit length, the facility code and the card number for Wiegand cards, or by the hex
+// data or UID
+func parseReplCard(args []string) (CardType, CardParams, error) {
+	if len(args) == 0 {
+		return nil, CardParams{}, fmt.Errorf("%w: a card type is required, one of: %s", ErrInvalidInput, strings.Join(cardTypeNames(), ", "))
+	}
+	ct, ok := lookupCardType(args[0])
+	if !ok {
+		return nil, CardParams{}, fmt.Errorf("%w: card type must be one of: %s", ErrInvalidInput, strings.Join(cardTypeNames(), ", "))
+	}
+	values := args[1:]
+	var p CardParams
+	switch ct.Input() {
+	case InputWiegand:
+		var numbers []int
+		for _, v := range values {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, CardParams{}, fmt.Errorf("%w: %q is not a number", ErrInvalidInput, v)
+			}
+			numbers = append(numbers, n)
+		}
+		switch len(numbers) {
+		case 2:
+			p.FacilityCode, p.CardNumber = numbers[0], numbers[1]
+		case 3:
+			p.BitLength, p.FacilityCode, p.CardNumber = numbers[0], numbers[1], numbers[2]
+		default:
+			return nil, CardParams{}, fmt.Errorf("%w: usage: %s [bit length] <facility code> <card number>", ErrInvalidInput, ct.Name())
+		}
+	case InputHex:
+		if len(values) != 1 {
+			return nil, CardParams{}, fmt.Errorf("%w: usage: %s <hex data>", ErrInvalidInput, ct.Name())
+		}
+		p.HexData = values[0]
+	case InputUID:
+		if len(values) != 1 {
+			return nil, CardParams{}, fmt.Errorf("%w: usage: %s <uid>", ErrInvalidInput, ct.Name())
+		}
+		p.UID = values[0]
+	}
+	return resolveCard(ct.Name(), p)
+}
+
+func completeCardType(arg int, args []string) []string {
+	if arg == 0 {
+		return cardTypeNames()
+	}
+	return nil
+}
+
+// completeCard completes the card type, then the bit lengths of Wiegand card types
+func completeCard(arg int, args []string) []string {
+	if arg == 0 {
+		return cardTypeNames()
+	}
+	if ct, ok := lookupCardType(args[0]); ok && arg == 1 && ct.Input() == InputWiegand {
+		var lengths []string
+		for _, bl := range ct.BitLengths() {
+			lengths = append(lengths, strconv.Itoa(bl))
+		}
+		return lengths
+	}
+	return nil
+}
+
+func completeRecoveryMethod(arg int, args []string) []string {
+	if arg == 0 {
+		return hotelRecoveryMethods
+	}
+	return nil
+}
+
+// completions returns the candidates for the word being typed at the end of line
+func completions(line string) (word string, candidates []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasSuffix(line, " ") {
+		fields = append(fields, "")
+	}
+	word = fields[len(fields)-1]
+	if len(fields) == 1 {
+		candidates = []string{"help", "exit"}
+		for _, c := range replCommands {
+			candidates = append(candidates, c.name)
+		}
+	} else if c, ok := lookupReplCommand(fields[0]); ok && c.complete != nil {
+		candidates = c.complete(len(fields)-2, fields[1:len(fields)-1])
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	sort.Strings(matches)
+	return word, matches
+}
+
+// completeCallback completes the word before the cursor on Tab. A single match is completed
+// with a space after it; several are completed to their common prefix, or listed when that
+// adds nothing.
+func (r *repl) completeCallback(terminal *term.Terminal) func(line string, pos int, key rune) (string, int, bool) {
+	return func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		word, matches := completions(line[:pos])
+		if len(matches) == 0 {
+			return "", 0, false
+		}
+		completion := matches[0]
+		if len(matches) > 1 {
+			completion = commonPrefix(matches)
+			if completion == word {
+				fmt.Fprintln(terminal, strings.Join(matches, "  "))
+				return "", 0, false
+			}
+		} else {
+			completion += " "
+		}
+		head := line[:pos-len(word)] + completion
+		return head + line[pos:], len(head), true
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// replHistory keeps the REPL's history in memory and appends each line to the history file
+type replHistory struct {
+	lines []string // oldest first
+	file  *os.File
+}
+
+// openReplHistory loads the history file from the home directory and opens it for appending.
+// The file is rewritten with only the most recent lines when it has grown past the limit.
+func openReplHistory() (*replHistory, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(home, replHistoryName)
+	h := &replHistory{}
+	if data, err := os.ReadFile(path); err == nil {
+		h.lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if len(h.lines) == 1 && h.lines[0] == "" {
+			h.lines = nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if len(h.lines) > replHistoryLimit {
+		h.lines = h.lines[len(h.lines)-replHistoryLimit:]
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	if h.file, err = os.OpenFile(path, flags, 0600); err != nil {
+		return nil, err
+	}
+	if flags&os.O_TRUNC != 0 {
+		h.file.WriteString(strings.Join(h.lines, "\n") + "\n")
+	}
+	return h, nil
+}
+
+// Add records a line, skipping repeats of the previous one
+func (h *replHistory) Add(line string) {
+	if len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > replHistoryLimit {
+		h.lines = h.lines[1:]
+	}
+	h.file.WriteString(line + "\n")
+}
+
+func (h *replHistory) Len() int {
+	return len(h.lines)
+}
+
+// At returns a line, 0 being the most recent
+func (h *replHistory) At(idx int) string {
+	return h.lines[len(h.lines)-1-idx]
+}
+
+func (h *replHistory) Close() error {
+	return h.file.Close()
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// engagementReport is the end-of-engagement summary built from a workspace's records, rendered
+// as Markdown or as a standalone HTML page
+type engagementReport struct {
+	Title     string
+	Facts     []reportFact
+	Sections  []reportSection
+	Generated time.Time
+}
+
+type reportFact struct {
+	Name, Value string
+}
+
+// reportSection is a table of the report; Empty is shown instead when it has no rows
+type reportSection struct {
+	Title  string
+	Header []string
+	Rows   [][]string
+	Empty  string
+}
+
+// reportTimeFormat is how times are shown in reports
+const reportTimeFormat = "2006-01-02 15:04:05"
+
+// reportRedactor masks credential values in a report. Values are collected from the records
+// first, so they are also found in free text such as failure messages and notes.
+type reportRedactor struct {
+	enabled bool
+	values  []string
+	known   *regexp.Regexp // matches any of values, built on first use
+}
+
+// labelledValueRegex matches numbers labelled as facility codes or card numbers in free text
+var labelledValueRegex = regexp.MustCompile(`(?i)\b(fc|cn|--fc|--cn|facility code|card number)(\s+)(\d+)`)
+
+// dumpNameRegex matches the UID in the names pm3 gives dumps and key files
+var dumpNameRegex = regexp.MustCompile(`(?i)(hf-mf-)([0-9a-f]+)(-)`)
+
+// mifareKeyRegex matches MIFARE Classic keys, 12 hex digits, in free text
+var mifareKeyRegex = regexp.MustCompile(`(?i)\b[0-9a-f]{12}\b`)
+
+// add remembers a sensitive value to mask wherever it appears
+func (r *reportRedactor) add(values ...string) {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) >= 3 {
+			r.values = append(r.values, v)
+		}
+	}
+}
+
+// mask hides a value, keeping the last two characters of long ones so cards can still be told
+// apart
+func (r *reportRedactor) mask(v string) string {
+	if !r.enabled || v == "" {
+		return v
+	}
+	if len(v) > 4 {
+		return strings.Repeat("*", len(v)-2) + v[len(v)-2:]
+	}
+	return strings.Repeat("*", len(v))
+}
+
+// optionalNumber shows an optional value of a read, "" when it is nil
+func optionalNumber(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+// text masks every sensitive value found in free text
+func (r *reportRedactor) text(s string) string {
+	if !r.enabled {
+		return s
+	}
+	if r.known == nil && len(r.values) > 0 {
+		values := append([]string(nil), r.values...)
+		// Longer values first, so a value inside another is not masked half-way
+		sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+		for i, v := range values {
+			values[i] = regexp.QuoteMeta(v)
+		}
+		r.known = regexp.MustCompile(`(?i)\b(?:` + strings.Join(values, "|") + `)\b`)
+	}
+	if r.known != nil {
+		s = r.known.ReplaceAllStringFunc(s, r.mask)
+	}
+	s = mifareKeyRegex.ReplaceAllStringFunc(s, r.mask)
+	s = labelledValueRegex.ReplaceAllStringFunc(s, func(m string) string {
+		parts := labelledValueRegex.FindStringSubmatch(m)
+		return parts[1] + parts[2] + r.mask(parts[3])
+	})
+	return dumpNameRegex.ReplaceAllStringFunc(s, func(m string) string {
+		parts := dumpNameRegex.FindStringSubmatch(m)
+		return parts[1] + r.mask(parts[2]) + parts[3]
+	})
+}
+
+// params masks the values of a credential
+func (r *reportRedactor) params(ct string, p CardParams) string {
+	t, ok := lookupCardType(ct)
+	if !ok {
+		return ""
+	}
+	switch t.Input() {
+	case InputHex:
+		return "ID " + r.mask(p.HexData)
+	case InputUID:
+		return "UID " + r.mask(p.UID)
+	default:
+		return fmt.Sprintf("%d-bit FC %s CN %s", p.BitLength, r.mask(strconv.Itoa(p.FacilityCode)), r.mask(strconv.Itoa(p.CardNumber)))
+	}
+}
+
+// file shows a workspace file by name, without the UID when redacting
+func (r *reportRedactor) file(path string) string {
+	if path == "" {
+		return ""
+	}
+	return r.text(filepath.Base(path))
+}
+
+// reportRecord is a workspace record with its result data decoded
+type reportRecord struct {
+	WorkspaceRecord
+	data   json.RawMessage
+	sealed bool // sealed in the vault, which is locked
+}
+
+// buildEngagementReport summarises the records of a workspace. Sealed records are included when
+// the vault is unlocked, otherwise they are counted as left out.
+func buildEngagementReport(dir string, info WorkspaceInfo, records []WorkspaceRecord, redact bool) *engagementReport {
+	red := &reportRedactor{enabled: redact}
+	var all []reportRecord
+	var sealed int
+	for _, wr := range records {
+		r := reportRecord{WorkspaceRecord: wr}
+		data, err := wr.Result()
+		if err != nil {
+			r.sealed = true
+			sealed++
+		}
+		r.data = data
+		all = append(all, r)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Time.Before(all[j].Time) })
+
+	// The values to mask are collected before anything is shown
+	for _, r := range all {
+		switch r.Kind {
+		case "read":
+			var read CardRead
+			if json.Unmarshal(r.data, &read) == nil {
+				red.add(read.HexData, read.UID, read.CSN, read.Raw, read.Wiegand)
+			}
+		case "write":
+			var result WriteResult
+			if json.Unmarshal(r.data, &result) == nil {
+				red.add(result.Params.HexData, result.Params.UID)
+			}
+		case "verify":
+			var result VerifyResult
+			if json.Unmarshal(r.data, &result) == nil {
+				red.add(result.Expected.HexData, result.Expected.UID)
+				if result.Read != nil {
+					red.add(result.Read.HexData, result.Read.UID, result.Read.CSN)
+				}
+			}
+		case "restore":
+			var result RestoreResult
+			if json.Unmarshal(r.data, &result) == nil {
+				red.add(result.UID)
+			}
+		}
+	}
+
+	report := &engagementReport{
+		Title:     "Engagement Report: " + info.Name,
+		Generated: time.Now(),
+	}
+	report.Facts = append(report.Facts, reportFact{"Engagement", info.Name})
+	report.Facts = append(report.Facts, reportFact{"Workspace", dir})
+	if len(all) > 0 {
+		report.Facts = append(report.Facts, reportFact{"Period", all[0].Time.Local().Format(reportTimeFormat) + " to " + all[len(all)-1].Time.Local().Format(reportTimeFormat)})
+	}
+	operators := map[string]bool{}
+	for _, r := range all {
+		if r.Operator != "" {
+			operators[r.Operator] = true
+		}
+	}
+	var operatorNames []string
+	for name := range operators {
+		operatorNames = append(operatorNames, name)
+	}
+	sort.Strings(operatorNames)
+	if len(operatorNames) > 0 {
+		report.Facts = append(report.Facts, reportFact{"Operators", strings.Join(operatorNames, ", ")})
+	}
+
+	counts := map[string]int{}
+	for _, r := range all {
+		counts[r.Kind]++
+	}
+	report.Facts = append(report.Facts, reportFact{"Activity", fmt.Sprintf("%d reads, %d writes, %d verifications, %d key recoveries, %d dump restores, %d failures, %d scope overrides, %d notes",
+		counts["read"], counts["write"], counts["verify"], counts["recovery"], counts["restore"], counts["failure"], counts["override"], counts["note"])})
+	if redact {
+		report.Facts = append(report.Facts, reportFact{"Redaction", "Credential values, card UIDs and keys are masked"})
+	}
+	if sealed > 0 {
+		report.Facts = append(report.Facts, reportFact{"Sealed records", fmt.Sprintf("%d records are sealed in the vault and left out; unlock the vault to include them", sealed)})
+	}
+
+	report.Sections = append(report.Sections,
+		reportCredentials(all, red),
+		reportTechnologies(all),
+		reportClones(all, red),
+		reportRecoveries(all, red),
+		reportOverrides(all, red),
+		reportTimeline(all, red),
+	)
+	return report
+}
+
+// reportCredentials lists the cards read
+func reportCredentials(all []reportRecord, red *reportRedactor) reportSection {
+	s := reportSection{
+		Title:  "Captured Credentials",
+		Header: []string{"Time", "Card Type", "Format", "Bits", "FC", "CN", "ID / UID", "Operator"},
+		Empty:  "No cards were read.",
+	}
+	for _, r := range all {
+		if r.Kind != "read" || r.sealed {
+			continue
+		}
+		var read CardRead
+		if json.Unmarshal(r.data, &read) != nil {
+			continue
+		}
+		id := read.HexData
+		if id == "" {
+			id = read.UID
+		}
+		if id == "" {
+			id = read.CSN
+		}
+		s.Rows = append(s.Rows, []string{
+			r.Time.Local().Format(reportTimeFormat),
+			cardTypeDisplayName(read.CardType),
+			read.Format,
+			optionalNumber(read.BitLength),
+			red.mask(optionalNumber(read.FacilityCode)),
+			red.mask(optionalNumber(read.CardNumber)),
+			red.mask(id),
+			r.Operator,
+		})
+	}
+	return s
+}
+
+// reportTechnologies counts the card technologies seen, read and written
+func reportTechnologies(all []reportRecord) reportSection {
+	s := reportSection{
+		Title:  "Card Technologies",
+		Header: []string{"Technology", "Read", "Written", "Verified"},
+		Empty:  "No cards were handled.",
+	}
+	type tally struct{ read, written, verified int }
+	tallies := map[string]*tally{}
+	var order []string
+	count := func(name string) *tally {
+		if tallies[name] == nil {
+			tallies[name] = &tally{}
+			order = append(order, name)
+		}
+		return tallies[name]
+	}
+	for _, r := range all {
+		switch r.Kind {
+		case "read":
+			count(cardTypeDisplayName(r.CardType)).read++
+		case "write":
+			count(cardTypeDisplayName(r.CardType)).written++
+		case "verify":
+			var result VerifyResult
+			if json.Unmarshal(r.data, &result) == nil && result.Match {
+				count(cardTypeDisplayName(r.CardType)).verified++
+			}
+		case "recovery":
+			count("MIFARE Classic (hotel key)").read++
+		case "restore":
+			t := count("MIFARE Classic (hotel key)")
+			t.written++
+			t.verified++
+		}
+	}
+	sort.Strings(order)
+	for _, name := range order {
+		t := tallies[name]
+		s.Rows = append(s.Rows, []string{name, strconv.Itoa(t.read), strconv.Itoa(t.written), strconv.Itoa(t.verified)})
+	}
+	return s
+}
+
+// reportClones lists the cards written and whether they verified. A verification run with a
+// write follows it with the same operation; operation IDs restart with every CLI command, so
+// only the write just before it is matched.
+func reportClones(all []reportRecord, red *reportRedactor) reportSection {
+	s := reportSection{
+		Title:  "Clones Written and Verified",
+		Header: []string{"Time", "Card Type", "Credential", "Written", "Verified", "Operator"},
+		Empty:  "No cards were written or verified.",
+	}
+	// lastWrite is the row of the previous record when it was a write, and its operation
+	lastWrite, lastOperation := -1, 0
+	for _, r := range all {
+		wroteRow := -1
+		switch r.Kind {
+		case "write":
+			var result WriteResult
+			if r.sealed || json.Unmarshal(r.data, &result) != nil {
+				break
+			}
+			written := "Yes"
+			if result.Attempts > 1 {
+				written = fmt.Sprintf("Yes (%d passes)", result.Attempts)
+			}
+			s.Rows = append(s.Rows, []string{r.Time.Local().Format(reportTimeFormat), cardTypeDisplayName(result.CardType), red.params(result.CardType, result.Params), written, "Not verified", r.Operator})
+			wroteRow = len(s.Rows) - 1
+		case "verify":
+			var result VerifyResult
+			if r.sealed || json.Unmarshal(r.data, &result) != nil {
+				break
+			}
+			verified := "Yes"
+			if !result.Match {
+				verified = "No - card differs"
+			}
+			if lastWrite >= 0 && lastOperation == r.Operation {
+				s.Rows[lastWrite][4] = verified
+				break
+			}
+			s.Rows = append(s.Rows, []string{r.Time.Local().Format(reportTimeFormat), cardTypeDisplayName(result.CardType), red.params(result.CardType, result.Expected), "", verified, r.Operator})
+		case "restore":
+			var result RestoreResult
+			if json.Unmarshal(r.data, &result) != nil {
+				break
+			}
+			credential := "Dump " + red.file(result.DumpFile)
+			if result.UID != "" {
+				credential += ", UID " + red.mask(result.UID)
+			}
+			s.Rows = append(s.Rows, []string{r.Time.Local().Format(reportTimeFormat), "MIFARE Classic", credential, "Yes", "Yes - UID matches dump", r.Operator})
+		}
+		lastWrite, lastOperation = wroteRow, r.Operation
+	}
+	return s
+}
+
+// reportRecoveries lists the hotel key card recoveries with the key table summary
+func reportRecoveries(all []reportRecord, red *reportRedactor) reportSection {
+	s := reportSection{
+		Title:  "Hotel Key Recovery",
+		Header: []string{"Time", "Attack", "Sectors", "Keys", "Found By", "Failed Sectors", "Files", "Operator"},
+		Empty:  "No hotel key cards were recovered.",
+	}
+	for _, r := range all {
+		if r.Kind != "recovery" {
+			continue
+		}
+		var result RecoveryResult
+		if json.Unmarshal(r.data, &result) != nil {
+			continue
+		}
+		sectors := fmt.Sprintf("%d / 16", result.SectorsRecovered)
+		var keys, methods, failed string
+		if result.Keys != nil {
+			keys = strconv.Itoa(result.Keys.KeysFound)
+			methods = strings.Join(result.Keys.MethodsUsed(), ", ")
+			failed = strings.Join(result.Keys.FailedSectors, ", ")
+		}
+		var files []string
+		for _, f := range []string{result.DumpFile, result.KeyFile} {
+			if f != "" {
+				files = append(files, red.file(f))
+			}
+		}
+		s.Rows = append(s.Rows, []string{r.Time.Local().Format(reportTimeFormat), result.Method, sectors, keys, methods, failed, strings.Join(files, ", "), r.Operator})
+	}
+	return s
+}
+
+// reportOverrides lists the operations run out of scope
+func reportOverrides(all []reportRecord, red *reportRedactor) reportSection {
+	s := reportSection{
+		Title:  "Scope Overrides",
+		Header: []string{"Time", "Operator", "Reason", "Out of Scope"},
+		Empty:  "No operation went out of scope.",
+	}
+	for _, r := range all {
+		if r.Kind != "override" {
+			continue
+		}
+		reason := strings.TrimPrefix(r.Summary, "Scope override: ")
+		s.Rows = append(s.Rows, []string{r.Time.Local().Format(reportTimeFormat), r.Operator, red.text(reason), red.text(r.Error)})
+	}
+	return s
+}
+
+// reportTimeline lists every record in order
+func reportTimeline(all []reportRecord, red *reportRedactor) reportSection {
+	s := reportSection{
+		Title:  "Timeline",
+		Header: []string{"Time", "Event", "Card Type", "Details", "Operator"},
+		Empty:  "Nothing was recorded.",
+	}
+	for _, r := range all {
+		details := r.Summary
+		if r.Error != "" && r.Error != r.Summary {
+			details += " - " + r.Error
+		}
+		cardType := ""
+		if r.CardType != "" {
+			cardType = cardTypeDisplayName(r.CardType)
+		}
+		s.Rows = append(s.Rows, []string{r.Time.Local().Format(reportTimeFormat), r.Kind, cardType, red.text(details), r.Operator})
+	}
+	return s
+}
+
+// Markdown renders the report as Markdown
+func (r *engagementReport) Markdown() []byte {
+	var b bytes.Buffer
+	cell := func(s string) string {
+		s = strings.ReplaceAll(s, "|", `\|`)
+		return strings.ReplaceAll(s, "\n", " ")
+	}
+	fmt.Fprintf(&b, "# %s\n\n", r.Title)
+	for _, f := range r.Facts {
+		fmt.Fprintf(&b, "- **%s:** %s\n", f.Name, f.Value)
+	}
+	fmt.Fprintf(&b, "- **Generated:** %s\n", r.Generated.Format(reportTimeFormat))
+	for _, s := range r.Sections {
+		fmt.Fprintf(&b, "\n## %s\n\n", s.Title)
+		if len(s.Rows) == 0 {
+			fmt.Fprintf(&b, "_%s_\n", s.Empty)
+			continue
+		}
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(s.Header, " | "))
+		fmt.Fprintf(&b, "|%s\n", strings.Repeat("---|", len(s.Header)))
+		for _, row := range s.Rows {
+			cells := make([]string, len(row))
+			for i, c := range row {
+				cells[i] = cell(c)
+			}
+			fmt.Fprintf(&b, "| %s |\n", strings.Join(cells, " | "))
+		}
+	}
+	return b.Bytes()
+}
+
+// reportHTMLTemplate is a standalone page: the styles are inline and nothing is loaded from
+// elsewhere, so the file can be attached to a report as it is
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1100px; color: #222; padding: 0 1em; }
+h1 { border-bottom: 2px solid #222; padding-bottom: .3em; }
+h2 { margin-top: 2em; border-bottom: 1px solid #ccc; padding-bottom: .2em; }
+dl { display: grid; grid-template-columns: max-content auto; gap: .3em 1em; }
+dt { font-weight: bold; }
+dd { margin: 0; }
+table { border-collapse: collapse; width: 100%; font-size: .9em; }
+th, td { border: 1px solid #ccc; padding: .35em .6em; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+tr:nth-child(even) td { background: #fafafa; }
+.empty { font-style: italic; color: #666; }
+@media print { body { margin: 0; max-width: none; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<dl>
+{{- range .Facts}}
+<dt>{{.Name}}</dt><dd>{{.Value}}</dd>
+{{- end}}
+<dt>Generated</dt><dd>{{.Generated.Format "2006-01-02 15:04:05"}}</dd>
+</dl>
+{{- range .Sections}}
+<h2>{{.Title}}</h2>
+{{- if .Rows}}
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- else}}
+<p class="empty">{{.Empty}}</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML renders the report as a standalone HTML page
+func (r *engagementReport) HTML() ([]byte, error) {
+	var b bytes.Buffer
+	if err := reportHTMLTemplate.Execute(&b, r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeEngagementReport writes the report of a workspace to path, as HTML when html is set and
+// as Markdown otherwise
+func writeEngagementReport(w *Workspace, path string, html, redact bool) error {
+	records, err := w.Records()
+	if err != nil {
+		return err
+	}
+	report := buildEngagementReport(w.Dir, w.Info, records, redact)
+	data := report.Markdown()
+	if html {
+		if data, err = report.HTML(); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// defaultReportPath is where a report is saved when no file is given: in the workspace, named
+// by the time it was made
+func defaultReportPath(w *Workspace, html bool) string {
+	ext := ".md"
+	if html {
+		ext = ".html"
+	}
+	return filepath.Join(w.Dir, "report-"+time.Now().Format("20060102-150405")+ext)
+}
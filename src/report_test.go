@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reportTestRecords is a workspace's records holding every kind of sensitive value a report
+// shows: facility codes, card numbers, hex IDs, UIDs, raw card data and MIFARE keys
+func reportTestRecords(t *testing.T) []WorkspaceRecord {
+	t.Helper()
+	start := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+	record := func(minute int, kind, cardType string, operation int, summary, errText string, data interface{}) WorkspaceRecord {
+		r := WorkspaceRecord{
+			Time:      start.Add(time.Duration(minute) * time.Minute),
+			Kind:      kind,
+			Operation: operation,
+			CardType:  cardType,
+			Operator:  "tester",
+			Summary:   summary,
+			Error:     errText,
+		}
+		if data != nil {
+			var err error
+			if r.Data, err = json.Marshal(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r
+	}
+	prox := CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}
+	return []WorkspaceRecord{
+		record(0, "read", "prox", 1, "HID Prox 26-bit FC 118 CN 1603, raw 2006ec0c86", "",
+			CardRead{CardType: "prox", Format: "H10301", BitLength: intPtr(26), FacilityCode: intPtr(118), CardNumber: intPtr(1603), Raw: "2006ec0c86"}),
+		record(1, "read", "mifare", 2, "MIFARE Classic UID 04A1B2C3", "", CardRead{CardType: "mifare", UID: "04A1B2C3"}),
+		record(2, "read", "em", 3, "EM4100 / Net2 ID 0F0368568B", "", CardRead{CardType: "em", HexData: "0F0368568B", BitLength: intPtr(32)}),
+		record(3, "write", "prox", 4, "Wrote FC 118 CN 1603", "", WriteResult{CardType: "prox", Params: prox, Attempts: 3}),
+		record(4, "verify", "prox", 4, "Verification successful", "", VerifyResult{CardType: "prox", Expected: prox, Match: true}),
+		record(5, "write", "mifare", 5, "Wrote UID 04A1B2C3", "", WriteResult{CardType: "mifare", Params: CardParams{UID: "04A1B2C3"}, Attempts: 1}),
+		record(6, "recovery", "mifare", 6, "Recovered 16 sectors", "", RecoveryResult{
+			Method: "autopwn", SectorsRecovered: 16,
+			DumpFile: "/engagements/acme-hq/hf-mf-04A1B2C3-dump.bin", KeyFile: "/engagements/acme-hq/hf-mf-04A1B2C3-key.bin",
+			Keys: &KeySummary{KeysFound: 32, Methods: map[string]int{"Dictionary": 32}},
+		}),
+		record(7, "restore", "mifare", 7, "Restored hf-mf-04A1B2C3-dump.bin", "", RestoreResult{DumpFile: "/engagements/acme-hq/hf-mf-04A1B2C3-dump.bin", UID: "04A1B2C3"}),
+		record(8, "failure", "mifare", 8, "Read failed", "authentication failed with key a0a1a2a3a4a5 on sector 1", nil),
+		record(9, "override", "prox", 9, "Scope override: FC 119 approved by the client", "out of scope: facility code 119 is not in scope for ACME HQ", nil),
+		record(10, "note", "", 0, "Lobby reader accepts key FFFFFFFFFFFF and card number 1603", "", nil),
+	}
+}
+
+func TestReportRedaction(t *testing.T) {
+	secrets := []string{"118", "119", "1603", "2006EC0C86", "04A1B2C3", "0F0368568B", "A0A1A2A3A4A5", "FFFFFFFFFFFF"}
+	records := reportTestRecords(t)
+
+	// Unredacted, every value is in the report, so the redacted one is checked against them
+	plain := strings.ToUpper(string(buildEngagementReport("/engagements/acme-hq", WorkspaceInfo{Name: "ACME HQ"}, records, false).Markdown()))
+	for _, secret := range secrets {
+		if !strings.Contains(plain, secret) {
+			t.Errorf("unredacted report does not show %s", secret)
+		}
+	}
+
+	report := buildEngagementReport("/engagements/acme-hq", WorkspaceInfo{Name: "ACME HQ"}, records, true)
+	html, err := report.HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for format, data := range map[string][]byte{"markdown": report.Markdown(), "html": html} {
+		text := strings.ToUpper(string(data))
+		for _, secret := range secrets {
+			if strings.Contains(text, secret) {
+				t.Errorf("%s report shows %s", format, secret)
+			}
+		}
+		// cards can still be told apart by the last characters of long values
+		if !strings.Contains(text, "******C3") || !strings.Contains(text, "********8B") {
+			t.Errorf("%s report does not keep the last characters of masked values", format)
+		}
+	}
+}
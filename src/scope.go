@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopeFile is where a workspace keeps its scope policy
+const scopeFile = "scope.json"
+
+// ScopePolicy lists what the rules of engagement allow. A list left empty does not restrict
+// anything; a non-empty one blocks what it does not list.
+type ScopePolicy struct {
+	Engagement    string   `json:"engagement,omitempty"`
+	CardTypes     []string `json:"cardTypes,omitempty"`     // e.g. prox, iclass
+	FacilityCodes []string `json:"facilityCodes,omitempty"` // single codes or ranges, e.g. 118 or 200-210
+	UIDPrefixes   []string `json:"uidPrefixes,omitempty"`   // hex, e.g. 04A2
+	HotelSystems  []string `json:"hotelSystems,omitempty"`  // one of hotelSystems, e.g. saflok
+}
+
+// loadScopePolicy reads and checks a scope policy file
+func loadScopePolicy(path string) (*ScopePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scope policy: %w", err)
+	}
+	var s ScopePolicy
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%w: invalid scope policy %s: %v", ErrInvalidInput, path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%w: invalid scope policy %s: %v", ErrInvalidInput, path, err)
+	}
+	return &s, nil
+}
+
+// saveScopePolicy writes a scope policy file
+func saveScopePolicy(path string, s *ScopePolicy) error {
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+var hexRegex = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+
+func (s *ScopePolicy) validate() error {
+	for _, name := range s.CardTypes {
+		if _, ok := lookupCardType(name); !ok {
+			return fmt.Errorf("unknown card type %q", name)
+		}
+	}
+	for _, codes := range s.FacilityCodes {
+		if _, _, err := parseFacilityCodeRange(codes); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range s.UIDPrefixes {
+		if !hexRegex.MatchString(prefix) {
+			return fmt.Errorf("UID prefix %q is not hex", prefix)
+		}
+	}
+	// A system identifyHotelCard cannot detect would block every recovery
+	for _, system := range s.HotelSystems {
+		if !containsFold(hotelSystems, system) {
+			return fmt.Errorf("hotel system %q cannot be identified; supported: %s", system, strings.Join(hotelSystems, ", "))
+		}
+	}
+	return nil
+}
+
+// parseFacilityCodeRange parses a facility code, e.g. "118", or a range, e.g. "200-210"
+func parseFacilityCodeRange(codes string) (low, high int, err error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(codes), "-")
+	if low, err = strconv.Atoi(strings.TrimSpace(first)); err != nil {
+		return 0, 0, fmt.Errorf("facility code %q is not a number or range", codes)
+	}
+	high = low
+	if isRange {
+		if high, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || high < low {
+			return 0, 0, fmt.Errorf("facility code %q is not a number or range", codes)
+		}
+	}
+	return low, high, nil
+}
+
+// describe names the engagement for messages
+func (s *ScopePolicy) describe() string {
+	if s.Engagement != "" {
+		return s.Engagement
+	}
+	return "the engagement"
+}
+
+// checkCredential returns why writing or simulating a credential is out of scope, or nil
+func (s *ScopePolicy) checkCredential(ct CardType, p CardParams) error {
+	if len(s.CardTypes) > 0 && !containsFold(s.CardTypes, ct.Name()) {
+		return fmt.Errorf("%w: %s cards are not in scope for %s", ErrOutOfScope, ct.DisplayName(), s.describe())
+	}
+	switch ct.Input() {
+	case InputWiegand:
+		if len(s.FacilityCodes) > 0 && !s.allowsFacilityCode(p.FacilityCode) {
+			return fmt.Errorf("%w: facility code %d is not in scope for %s", ErrOutOfScope, p.FacilityCode, s.describe())
+		}
+	case InputUID:
+		return s.checkUID(p.UID)
+	}
+	return nil
+}
+
+func (s *ScopePolicy) allowsFacilityCode(fc int) bool {
+	for _, codes := range s.FacilityCodes {
+		if low, high, err := parseFacilityCodeRange(codes); err == nil && fc >= low && fc <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUID returns why a card UID is out of scope, or nil
+func (s *ScopePolicy) checkUID(uid string) error {
+	if len(s.UIDPrefixes) == 0 {
+		return nil
+	}
+	uid = strings.ToUpper(strings.ReplaceAll(uid, " ", ""))
+	for _, prefix := range s.UIDPrefixes {
+		if strings.HasPrefix(uid, strings.ToUpper(prefix)) {
+			return nil
+		}
+	}
+	if uid == "" {
+		return fmt.Errorf("%w: the card UID could not be read to check it against %s", ErrOutOfScope, s.describe())
+	}
+	return fmt.Errorf("%w: UID %s is not in scope for %s", ErrOutOfScope, uid, s.describe())
+}
+
+// checkHotelSystem returns why a hotel key card system is out of scope, or nil
+func (s *ScopePolicy) checkHotelSystem(system string) error {
+	if len(s.HotelSystems) == 0 {
+		return nil
+	}
+	if system == "" {
+		return fmt.Errorf("%w: the hotel system could not be identified to check it against %s", ErrOutOfScope, s.describe())
+	}
+	if !containsFold(s.HotelSystems, system) {
+		return fmt.Errorf("%w: %s hotel cards are not in scope for %s", ErrOutOfScope, system, s.describe())
+	}
+	return nil
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	scopeMu   sync.Mutex
+	scopePath string
+)
+
+// useScopePolicy makes the policy in path the one operations are checked against, instead of
+// the scope.json of the workspace
+func useScopePolicy(path string) error {
+	path = expandUserPath(path)
+	if _, err := loadScopePolicy(path); err != nil {
+		return err
+	}
+	scopeMu.Lock()
+	defer scopeMu.Unlock()
+	scopePath = path
+	return nil
+}
+
+// activeScopePath is the policy file operations are checked against: the one given with
+// -scope, else the workspace's scope.json. It is "" when there is no workspace to keep one in.
+func activeScopePath() string {
+	scopeMu.Lock()
+	path := scopePath
+	scopeMu.Unlock()
+	if path == "" {
+		if w := activeWorkspace(); w != nil {
+			path = filepath.Join(w.Dir, scopeFile)
+		}
+	}
+	return path
+}
+
+// activeScope returns the policy operations are checked against, or nil when there is none.
+// The file is read on every check, so edits apply to the next operation.
+func activeScope() (*ScopePolicy, error) {
+	path := activeScopePath()
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return loadScopePolicy(path)
+}
+
+type scopeOverrideKey struct{}
+
+// withScopeOverride returns a context whose operation may go out of scope, for the given reason
+func withScopeOverride(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, scopeOverrideKey{}, strings.TrimSpace(reason))
+}
+
+// scopeOverrideFrom returns the reason the operation in ctx may go out of scope, or ""
+func scopeOverrideFrom(ctx context.Context) string {
+	reason, _ := ctx.Value(scopeOverrideKey{}).(string)
+	return reason
+}
+
+// checkScope runs check against the scope policy. An operation out of scope is blocked unless
+// ctx carries an override, which is logged to the status messages, the workspace and the audit
+// log.
+func checkScope(ctx context.Context, check func(s *ScopePolicy) error) error {
+	s, err := activeScope()
+	if err != nil {
+		WriteStatusError(ctx, "%v", err)
+		return emitFailure(ctx, err)
+	}
+	if s == nil {
+		return nil
+	}
+	violation := check(s)
+	if violation == nil {
+		return nil
+	}
+	reason := scopeOverrideFrom(ctx)
+	if reason == "" {
+		WriteStatusError(ctx, "Blocked: %v", violation)
+		return emitFailure(ctx, violation)
+	}
+	WriteStatusError(ctx, "Scope override by %s: %v - reason: %s", operatorName(), violation, reason)
+	auditOverride(ctx, violation, reason)
+	if w := activeWorkspace(); w != nil {
+		err := w.add(WorkspaceRecord{
+			Time:      time.Now(),
+			Kind:      "override",
+			Operation: operationIDFrom(ctx),
+			CardType:  cardTypeFrom(ctx),
+			Summary:   "Scope override: " + reason,
+			Error:     violation.Error(),
+		})
+		if err != nil {
+			WriteStatusError(ctx, "Workspace: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScopeCheckCredential(t *testing.T) {
+	prox, _ := lookupCardType("prox")
+	iclass, _ := lookupCardType("iclass")
+	mifare, _ := lookupCardType("mifare")
+	em, _ := lookupCardType("em")
+	tests := []struct {
+		name    string
+		scope   ScopePolicy
+		ct      CardType
+		params  CardParams
+		allowed bool
+	}{
+		{"empty policy", ScopePolicy{}, prox, CardParams{BitLength: 26, FacilityCode: 999, CardNumber: 1}, true},
+		{"card type listed", ScopePolicy{CardTypes: []string{"prox", "iclass"}}, iclass, CardParams{BitLength: 26, FacilityCode: 1, CardNumber: 1}, true},
+		{"card type listed in other case", ScopePolicy{CardTypes: []string{" PROX "}}, prox, CardParams{BitLength: 26, FacilityCode: 1, CardNumber: 1}, true},
+		{"card type not listed", ScopePolicy{CardTypes: []string{"prox"}}, em, CardParams{HexData: "0F0368568B"}, false},
+		{"facility code listed", ScopePolicy{FacilityCodes: []string{"118"}}, prox, CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, true},
+		{"facility code not listed", ScopePolicy{FacilityCodes: []string{"118"}}, prox, CardParams{BitLength: 26, FacilityCode: 119, CardNumber: 1603}, false},
+		{"facility code at range start", ScopePolicy{FacilityCodes: []string{"200-210"}}, prox, CardParams{BitLength: 26, FacilityCode: 200, CardNumber: 1}, true},
+		{"facility code at range end", ScopePolicy{FacilityCodes: []string{"200 - 210"}}, iclass, CardParams{BitLength: 26, FacilityCode: 210, CardNumber: 1}, true},
+		{"facility code past range", ScopePolicy{FacilityCodes: []string{"118", "200-210"}}, prox, CardParams{BitLength: 26, FacilityCode: 211, CardNumber: 1}, false},
+		{"facility codes do not restrict hex cards", ScopePolicy{FacilityCodes: []string{"118"}}, em, CardParams{HexData: "0F0368568B"}, true},
+		{"uid prefix listed", ScopePolicy{UIDPrefixes: []string{"04a1"}}, mifare, CardParams{UID: "04A1B2C3"}, true},
+		{"uid prefix not listed", ScopePolicy{UIDPrefixes: []string{"04A2"}}, mifare, CardParams{UID: "04A1B2C3"}, false},
+		{"uid prefixes do not restrict wiegand cards", ScopePolicy{UIDPrefixes: []string{"04A2"}}, prox, CardParams{BitLength: 26, FacilityCode: 118, CardNumber: 1603}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkScopeResult(t, tt.scope.checkCredential(tt.ct, tt.params), tt.allowed)
+		})
+	}
+}
+
+func TestScopeCheckUID(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		uid      string
+		allowed  bool
+	}{
+		{"no prefixes", nil, "DEADBEEF", true},
+		{"no prefixes, unread uid", nil, "", true},
+		{"prefix matches", []string{"04A2", "04A1"}, "04A1B2C3", true},
+		{"prefix matches in other case", []string{"04a1"}, "04A1B2C3", true},
+		{"uid with spaces", []string{"04A1"}, "04 a1 b2 c3", true},
+		{"whole uid as prefix", []string{"04A1B2C3"}, "04A1B2C3", true},
+		{"prefix does not match", []string{"04A2"}, "04A1B2C3", false},
+		{"prefix longer than uid", []string{"04A1B2C3D4"}, "04A1B2C3", false},
+		{"unread uid", []string{"04A1"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ScopePolicy{UIDPrefixes: tt.prefixes}
+			checkScopeResult(t, s.checkUID(tt.uid), tt.allowed)
+		})
+	}
+}
+
+func TestScopeCheckHotelSystem(t *testing.T) {
+	tests := []struct {
+		name    string
+		systems []string
+		system  string
+		allowed bool
+	}{
+		{"no systems", nil, "Saflok", true},
+		{"no systems, unidentified card", nil, "", true},
+		{"system listed", []string{"saflok"}, "Saflok", true},
+		{"system not listed", []string{"saflok"}, "Onity", false},
+		{"unidentified card", []string{"saflok"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ScopePolicy{HotelSystems: tt.systems}
+			checkScopeResult(t, s.checkHotelSystem(tt.system), tt.allowed)
+		})
+	}
+}
+
+func checkScopeResult(t *testing.T, err error, allowed bool) {
+	t.Helper()
+	if allowed && err != nil {
+		t.Errorf("blocked: %v", err)
+	}
+	if !allowed && !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("err = %v, want ErrOutOfScope", err)
+	}
+}
+
+func TestScopeOverrideAudited(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := filepath.Join(t.TempDir(), scopeFile)
+	if err := saveScopePolicy(path, &ScopePolicy{Engagement: "ACME HQ", FacilityCodes: []string{"118"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := useScopePolicy(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		scopeMu.Lock()
+		scopePath = ""
+		scopeMu.Unlock()
+	})
+
+	prox, _ := lookupCardType("prox")
+	check := func(s *ScopePolicy) error {
+		return s.checkCredential(prox, CardParams{BitLength: 26, FacilityCode: 119, CardNumber: 1603})
+	}
+	if err := checkScope(context.Background(), check); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("without an override: err = %v, want ErrOutOfScope", err)
+	}
+	ctx := withScopeOverride(context.Background(), " FC 119 approved by the client ")
+	if err := checkScope(ctx, check); err != nil {
+		t.Fatalf("with an override: err = %v", err)
+	}
+
+	file, err := os.Open(filepath.Join(home, ".doppelganger_assistant", auditLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 || entries[0].Kind != "override" {
+		t.Fatalf("audit log = %+v, want one override entry", entries)
+	}
+	var input struct{ Violation, Reason string }
+	if err := json.Unmarshal(entries[0].Input, &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.Reason != "FC 119 approved by the client" || !strings.Contains(input.Violation, "facility code 119") {
+		t.Errorf("override input = %+v", input)
+	}
+	if entries[0].Operator == "" {
+		t.Error("override entry has no operator")
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiServer exposes the card operations as a REST API. Operations run as jobs on the same
+// scheduler as the GUI, so API requests queue behind each other per Proxmark3; their status
+// and raw pm3 output are streamed to clients as server-sent events.
+type apiServer struct {
+	token string
+}
+
+const (
+	// apiEventBuffer is how many events a slow event stream may fall behind before it misses some
+	apiEventBuffer = 256
+	// apiKeepAlive is how often an idle event stream sends a comment so proxies keep it open
+	apiKeepAlive = 15 * time.Second
+)
+
+// apiEvent is an event as the API sends it
+type apiEvent struct {
+	Kind     string      `json:"kind"`
+	Level    string      `json:"level,omitempty"` // set on status events
+	Time     time.Time   `json:"time"`
+	Job      int         `json:"job,omitempty"`
+	CardType string      `json:"cardType,omitempty"`
+	Text     string      `json:"text"`
+	Payload  interface{} `json:"payload,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+func newAPIEvent(e Event) apiEvent {
+	ae := apiEvent{
+		Kind:     e.Kind.String(),
+		Time:     e.Time,
+		Job:      e.Operation,
+		CardType: e.CardType,
+		Text:     e.Text,
+		Payload:  e.Payload,
+	}
+	if e.Kind == EventStatus {
+		ae.Level = strings.ToLower(e.Level.String())
+	}
+	if e.Err != nil {
+		ae.Error = e.Err.Error()
+	}
+	return ae
+}
+
+// apiJob is a job as the API reports it. Result and Error are set once the job has finished.
+type apiJob struct {
+	ID     int         `json:"id"`
+	Name   string      `json:"name"`
+	Device string      `json:"device,omitempty"`
+	State  string      `json:"state"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Hint   string      `json:"hint,omitempty"`
+	Events []apiEvent  `json:"events,omitempty"`
+}
+
+func newAPIJob(j *Job, withEvents bool) apiJob {
+	aj := apiJob{ID: j.ID, Name: j.Name, Device: j.Device, State: j.State().String()}
+	if st := j.State(); st == JobDone || st == JobCancelled {
+		result, err := j.Result()
+		aj.Result = result
+		if err != nil {
+			aj.Error = err.Error()
+			aj.Hint = remediation(err)
+		}
+	}
+	if withEvents {
+		for _, e := range j.Events() {
+			aj.Events = append(aj.Events, newAPIEvent(e))
+		}
+	}
+	return aj
+}
+
+// apiCardType describes a card type for /api/cardtypes
+type apiCardType struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Input       string `json:"input"` // the values a credential needs: wiegand, hex or uid
+	BitLengths  []int  `json:"bitLengths,omitempty"`
+	Media       string `json:"media"`
+	CanSimulate bool   `json:"canSimulate"`
+}
+
+// apiCardRequest is the body of write, verify and simulate requests
+type apiCardRequest struct {
+	CardType string `json:"cardType"`
+	CardParams
+	Verify   bool   `json:"verify,omitempty"`   // write only: verify the card after writing
+	Override string `json:"override,omitempty"` // why going out of scope is authorised
+}
+
+// apiReadRequest is the body of read requests
+type apiReadRequest struct {
+	CardType string `json:"cardType"`
+}
+
+// apiRecoverRequest is the body of hotel key recovery requests
+type apiRecoverRequest struct {
+	Method   string `json:"method"`
+	Override string `json:"override,omitempty"`
+}
+
+// handler routes the API. Every route requires the token.
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/cardtypes", s.handleCardTypes)
+	mux.HandleFunc("/api/read", s.handleRead)
+	mux.HandleFunc("/api/detect", s.handleDetect)
+	mux.HandleFunc("/api/write", s.handleWrite)
+	mux.HandleFunc("/api/verify", s.handleVerify)
+	mux.HandleFunc("/api/simulate", s.handleSimulate)
+	mux.HandleFunc("/api/recover", s.handleRecover)
+	mux.HandleFunc("/api/cancel", s.handleCancel)
+	mux.HandleFunc("/api/jobs", s.handleJobs)
+	mux.HandleFunc("/api/jobs/", s.handleJob)
+	mux.HandleFunc("/api/events", s.handleEvents)
+	return s.authorize(mux)
+}
+
+// authorize lets requests with the API token through, given as a bearer token or, for clients
+// such as EventSource that cannot set headers, as the token query parameter
+func (s *apiServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers on other devices call the API from their own origin; the token guards access
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimPrefix(header, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, errors.New("missing or invalid API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeAPIJSON writes v as the JSON body of a response
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// writeAPIError writes err as a JSON error with what to do about it
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	body := struct {
+		Error string `json:"error"`
+		Hint  string `json:"hint,omitempty"`
+	}{err.Error(), remediation(err)}
+	writeAPIJSON(w, status, body)
+}
+
+// allowMethod rejects requests that do not use method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s requires %s", r.URL.Path, method))
+		return false
+	}
+	return true
+}
+
+// decodeAPIRequest reads the JSON body of a request into v. An empty body leaves v unchanged.
+func decodeAPIRequest(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: request body: %v", ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// submit queues an operation as a job and responds with it. With ?wait=1 the response is sent
+// once the job has finished; otherwise it is sent right away with 202 Accepted and the job can
+// be followed on /api/jobs/{id} and /api/events?job={id}.
+func (s *apiServer) submit(w http.ResponseWriter, r *http.Request, name string, fn func(ctx context.Context) error) {
+	job, err := jobs.Submit(beginOperation(), name, func(ctx context.Context, job *Job) {
+		fn(ctx)
+	})
+	if err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, ErrDeviceOffline) {
+			status = http.StatusServiceUnavailable
+		}
+		writeAPIError(w, status, err)
+		return
+	}
+
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		select {
+		case <-job.Done():
+			writeAPIJSON(w, http.StatusOK, newAPIJob(job, false))
+		case <-r.Context().Done():
+			// The client went away; the job keeps running
+		}
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", job.ID))
+	writeAPIJSON(w, http.StatusAccepted, newAPIJob(job, false))
+}
+
+func (s *apiServer) handleCardTypes(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	inputs := map[CardInput]string{InputWiegand: "wiegand", InputHex: "hex", InputUID: "uid"}
+	list := []apiCardType{}
+	for _, ct := range registeredCardTypes() {
+		list = append(list, apiCardType{
+			Name:        ct.Name(),
+			DisplayName: ct.DisplayName(),
+			Input:       inputs[ct.Input()],
+			BitLengths:  ct.BitLengths(),
+			Media:       ct.Media(),
+			CanSimulate: ct.CanSimulate(),
+		})
+	}
+	writeAPIJSON(w, http.StatusOK, list)
+}
+
+func (s *apiServer) handleRead(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req apiReadRequest
+	if err := decodeAPIRequest(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	ct, ok := lookupCardType(req.CardType)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("%w: cardType must be one of: %s", ErrInvalidInput, strings.Join(cardTypeNames(), ", ")))
+		return
+	}
+	s.submit(w, r, "read "+ct.Name(), func(ctx context.Context) error {
+		_, err := readCardData(ctx, ct.Name())
+		return err
+	})
+}
+
+func (s *apiServer) handleDetect(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.submit(w, r, "detect", detectCardType)
+}
+
+// cardRequest reads and validates the credential of a write, verify or simulate request
+func (s *apiServer) cardRequest(w http.ResponseWriter, r *http.Request) (CardType, CardParams, apiCardRequest, bool) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return nil, CardParams{}, apiCardRequest{}, false
+	}
+	var req apiCardRequest
+	if err := decodeAPIRequest(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return nil, CardParams{}, req, false
+	}
+	ct, p, err := resolveCard(req.CardType, req.CardParams)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return nil, CardParams{}, req, false
+	}
+	return ct, p, req, true
+}
+
+func (s *apiServer) handleWrite(w http.ResponseWriter, r *http.Request) {
+	ct, p, req, ok := s.cardRequest(w, r)
+	if !ok {
+		return
+	}
+	s.submit(w, r, "write "+ct.Name(), func(ctx context.Context) error {
+		ctx = withScopeOverride(ctx, req.Override)
+		return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, true, req.Verify, p.UID, p.HexData, false)
+	})
+}
+
+func (s *apiServer) handleVerify(w http.ResponseWriter, r *http.Request) {
+	ct, p, _, ok := s.cardRequest(w, r)
+	if !ok {
+		return
+	}
+	s.submit(w, r, "verify "+ct.Name(), func(ctx context.Context) error {
+		return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, false, true, p.UID, p.HexData, false)
+	})
+}
+
+func (s *apiServer) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	ct, p, req, ok := s.cardRequest(w, r)
+	if !ok {
+		return
+	}
+	if !ct.CanSimulate() {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("%w: %s cards cannot be simulated", ErrInvalidInput, ct.Name()))
+		return
+	}
+	s.submit(w, r, "simulate "+ct.Name(), func(ctx context.Context) error {
+		ctx = withScopeOverride(ctx, req.Override)
+		return handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, false, false, p.UID, p.HexData, true)
+	})
+}
+
+func (s *apiServer) handleRecover(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	req := apiRecoverRequest{Method: "autopwn"}
+	if err := decodeAPIRequest(r, &req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	known := false
+	for _, m := range hotelRecoveryMethods {
+		known = known || m == req.Method
+	}
+	if !known {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("%w: method must be one of: %s", ErrInvalidInput, strings.Join(hotelRecoveryMethods, ", ")))
+		return
+	}
+	s.submit(w, r, "recover", func(ctx context.Context) error {
+		return recoverHotelKey(withScopeOverride(ctx, req.Override), req.Method, nil)
+	})
+}
+
+// handleCancel cancels the running and queued jobs of the Proxmark3 given with ?device={port},
+// or of every Proxmark3 without it. A single job is cancelled with /api/jobs/{id}/cancel.
+func (s *apiServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if device := r.URL.Query().Get("device"); device != "" {
+		jobs.CancelDevice(device)
+	} else {
+		jobs.CancelAll()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	list := []apiJob{}
+	for _, j := range jobs.List() {
+		list = append(list, newAPIJob(j, false))
+	}
+	writeAPIJSON(w, http.StatusOK, list)
+}
+
+// handleJob reports one job; ?events=1 includes everything it published. A POST to
+// /api/jobs/{id}/cancel cancels the job, leaving the jobs of other devices running.
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	path, cancel := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/cancel")
+	method := http.MethodGet
+	if cancel {
+		method = http.MethodPost
+	}
+	if !allowMethod(w, r, method) {
+		return
+	}
+	id, err := strconv.Atoi(path)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no job %q", path))
+		return
+	}
+	job := jobs.Get(id)
+	if job == nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("no job #%d", id))
+		return
+	}
+	if cancel {
+		if !jobs.Cancel(id) {
+			writeAPIError(w, http.StatusConflict, fmt.Errorf("job #%d has already finished", id))
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, newAPIJob(job, false))
+		return
+	}
+	withEvents, _ := strconv.ParseBool(r.URL.Query().Get("events"))
+	writeAPIJSON(w, http.StatusOK, newAPIJob(job, withEvents))
+}
+
+// handleEvents streams events as server-sent events, each named after its kind. With ?job={id}
+// only that job's events are sent and the stream ends when the job finishes.
+func (s *apiServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	var jobID int
+	var jobDone <-chan struct{}
+	if v := r.URL.Query().Get("job"); v != "" {
+		id, err := strconv.Atoi(v)
+		job := jobs.Get(id)
+		if err != nil || job == nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("no job %q", v))
+			return
+		}
+		jobID, jobDone = id, job.Done()
+	}
+
+	queue := make(chan Event, apiEventBuffer)
+	unsubscribe := events.Subscribe(func(e Event) {
+		if jobID != 0 && e.Operation != jobID {
+			return
+		}
+		select {
+		case queue <- e:
+		default:
+			// A slow client misses events rather than holding up the operation
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(e Event) {
+		data, err := json.Marshal(newAPIEvent(e))
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+	}
+	keepAlive := time.NewTicker(apiKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-queue:
+			send(e)
+		case <-jobDone:
+			// The job's last events were queued before it was marked done
+			for len(queue) > 0 {
+				send(<-queue)
+			}
+			flusher.Flush()
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// apiToken returns the token given with -token or DOPPELGANGER_TOKEN, or a new random one
+func apiToken(token string) (string, bool, error) {
+	if token != "" {
+		return token, false, nil
+	}
+	if token = os.Getenv("DOPPELGANGER_TOKEN"); token != "" {
+		return token, false, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(b), true, nil
+}
+
+// serveAPI serves the API on addr until interrupted with Ctrl-C
+func serveAPI(addr, token string) error {
+	token, generated, err := apiToken(token)
+	if err != nil {
+		return fmt.Errorf("Failed to generate an API token: %w", err)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	// Ctrl-C stops the server; cancelling operations through the API must not
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	server := &http.Server{
+		Handler:           (&apiServer{token: token}).handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	WriteStatusSuccess(context.Background(), "Serving the API on http://%s/api/", listener.Addr())
+	if generated {
+		// Printed rather than published so the token does not end up in log files
+		fmt.Fprintf(os.Stderr, Yellow+"API token: %s"+Reset+"\n", token)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	WriteStatusInfo(context.Background(), "Shutting down the API server")
+	cancelAllOperations()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -1,60 +1,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"os"
-	"sync"
 )
 
-// StatusWriter handles writing status messages separately from command output.
-type StatusWriter struct {
-	mu     sync.Mutex
-	writer io.Writer
-}
-
-var (
-	globalStatusWriter *StatusWriter
-	statusMu           sync.Mutex
-)
-
-func init() {
-	globalStatusWriter = &StatusWriter{writer: os.Stderr}
-}
-
-// SetStatusWriter sets the global status writer.
-func SetStatusWriter(w io.Writer) {
-	statusMu.Lock()
-	defer statusMu.Unlock()
-	globalStatusWriter = &StatusWriter{writer: w}
-}
-
-// WriteStatus writes a status message to the status writer.
-func WriteStatus(format string, args ...interface{}) {
-	statusMu.Lock()
-	defer statusMu.Unlock()
-	if globalStatusWriter != nil && globalStatusWriter.writer != nil {
-		msg := fmt.Sprintf(format, args...)
-		globalStatusWriter.writer.Write([]byte(msg + "\n"))
-	}
+// WriteStatus publishes a status message for the operation in ctx.
+func WriteStatus(ctx context.Context, level StatusLevel, format string, args ...interface{}) {
+	publish(ctx, Event{Kind: EventStatus, Level: level, Text: fmt.Sprintf(format, args...)})
 }
 
 // WriteStatusSuccess writes a success status message.
-func WriteStatusSuccess(format string, args ...interface{}) {
-	WriteStatus("[SUCCESS] "+format, args...)
+func WriteStatusSuccess(ctx context.Context, format string, args ...interface{}) {
+	WriteStatus(ctx, LevelSuccess, format, args...)
 }
 
 // WriteStatusError writes an error status message.
-func WriteStatusError(format string, args ...interface{}) {
-	WriteStatus("[ERROR] "+format, args...)
+func WriteStatusError(ctx context.Context, format string, args ...interface{}) {
+	WriteStatus(ctx, LevelError, format, args...)
 }
 
 // WriteStatusInfo writes an info status message.
-func WriteStatusInfo(format string, args ...interface{}) {
-	WriteStatus("[INFO] "+format, args...)
+func WriteStatusInfo(ctx context.Context, format string, args ...interface{}) {
+	WriteStatus(ctx, LevelInfo, format, args...)
 }
 
 // WriteStatusProgress writes a progress status message.
-func WriteStatusProgress(format string, args ...interface{}) {
-	WriteStatus("[PROGRESS] "+format, args...)
+func WriteStatusProgress(ctx context.Context, format string, args ...interface{}) {
+	publish(ctx, Event{Kind: EventProgress, Text: fmt.Sprintf(format, args...)})
 }
@@ -0,0 +1,933 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ANSI sequences the terminal UI draws with, besides the colours in main.go
+const (
+	tuiAltScreen  = "\033[?1049h"
+	tuiMainScreen = "\033[?1049l"
+	tuiHideCursor = "\033[?25l"
+	tuiShowCursor = "\033[?25h"
+	tuiBold       = "\033[1m"
+	tuiDim        = "\033[2m"
+	tuiReverse    = "\033[7m"
+)
+
+const (
+	tuiFormWidth  = 44 // columns of the form on the left
+	tuiLabelWidth = 15 // columns of a field's label
+	tuiMinWidth   = 80 // smallest terminal the TUI draws in
+	tuiMinHeight  = 20
+	tuiMaxLines   = 2000 // lines kept per pane
+)
+
+// tuiAttackMethods are the attack methods as the GUI names them, in the order of
+// hotelRecoveryMethods
+var tuiAttackMethods = []string{"Autopwn", "Darkside", "Nested", "Hardnested", "Static Nested", "Bruteforce", "NACK Test"}
+
+// tuiFieldKind is what a line of a form does
+type tuiFieldKind int
+
+const (
+	tuiHeading tuiFieldKind = iota // label above a group of fields
+	tuiSelect                      // choice changed with the arrow keys
+	tuiEntry                       // text typed in
+	tuiToggle                      // checkbox flipped with Enter or Space
+	tuiButton                      // runs press with Enter
+)
+
+// tuiField is one line of a form, the TUI's widget
+type tuiField struct {
+	kind    tuiFieldKind
+	label   string
+	options []string // choices of a select
+	index   int      // chosen option of a select
+	text    string   // text of an entry
+	checked bool     // state of a toggle
+
+	visible func() bool // hides the field when it returns false
+	changed func()      // called after a select's choice changes
+	press   func()      // runs a button, or Enter in an entry
+}
+
+func (f *tuiField) selected() string {
+	if f.index < 0 || f.index >= len(f.options) {
+		return ""
+	}
+	return f.options[f.index]
+}
+
+// choose selects the option with the given text, if there is one
+func (f *tuiField) choose(option string) {
+	for i, o := range f.options {
+		if o == option {
+			f.index = i
+		}
+	}
+}
+
+func (f *tuiField) focusable() bool {
+	return f.kind != tuiHeading && (f.visible == nil || f.visible())
+}
+
+// tuiSection is a form, shown one at a time like the sections of the GUI's accordion
+type tuiSection struct {
+	tab    string // name in the title bar
+	name   string
+	fields []*tuiField
+	focus  int
+}
+
+// move focuses the next focusable field in the given direction, wrapping around
+func (s *tuiSection) move(delta int) {
+	for i := 1; i <= len(s.fields); i++ {
+		next := ((s.focus+delta*i)%len(s.fields) + len(s.fields)) % len(s.fields)
+		if s.fields[next].focusable() {
+			s.focus = next
+			return
+		}
+	}
+}
+
+// tui is the full-screen terminal UI. Everything but event delivery runs on the goroutine of
+// loop, so the forms and panes need no locking; operations hand it work with do.
+type tui struct {
+	out      io.Writer
+	sections []*tuiSection
+	section  int
+
+	status      []Event
+	output      []string
+	scroll      int // wrapped lines the output pane is scrolled back
+	outputWidth int
+	lastFrame   string
+	lastSize    [2]int
+	width       int
+	height      int
+	quit        bool
+
+	events chan Event
+	calls  chan func()
+	done   chan struct{}
+
+	// The panes follow one job at a time, like the GUI's
+	shownMu  sync.Mutex
+	shownJob int
+
+	cardType, bitLength, facilityCode, cardNumber, hexData, uid, action *tuiField
+	attackMethod, dumpPath, keyPath, wipe, magicUID                     *tuiField
+}
+
+func newTUI(out io.Writer) *tui {
+	t := &tui{
+		out:     out,
+		section: 1, // Corporate, open by default like in the GUI
+		events:  make(chan Event, 1024),
+		calls:   make(chan func(), 16),
+		done:    make(chan struct{}),
+	}
+
+	var cardTypes []string
+	for _, ct := range registeredCardTypes() {
+		cardTypes = append(cardTypes, ct.DisplayName())
+	}
+	input := func(in CardInput) func() bool {
+		return func() bool {
+			ct := t.selectedCardType()
+			return ct != nil && ct.Input() == in
+		}
+	}
+	t.cardType = &tuiField{kind: tuiSelect, label: "Card Type", options: cardTypes, changed: t.cardTypeChanged}
+	t.bitLength = &tuiField{kind: tuiSelect, label: "Bit Length", visible: input(InputWiegand)}
+	t.facilityCode = &tuiField{kind: tuiEntry, label: "Facility Code", visible: input(InputWiegand), press: t.executeCard}
+	t.cardNumber = &tuiField{kind: tuiEntry, label: "Card Number", visible: input(InputWiegand), press: t.executeCard}
+	t.hexData = &tuiField{kind: tuiEntry, label: "Hex Data", visible: input(InputHex), press: t.executeCard}
+	t.uid = &tuiField{kind: tuiEntry, label: "UID", visible: input(InputUID), press: t.executeCard}
+	t.action = &tuiField{kind: tuiSelect, label: "Action"}
+	t.cardTypeChanged()
+
+	t.attackMethod = &tuiField{kind: tuiSelect, label: "Attack Method", options: tuiAttackMethods}
+	t.dumpPath = &tuiField{kind: tuiEntry, label: "Dump File"}
+	t.keyPath = &tuiField{kind: tuiEntry, label: "Key File"}
+	t.wipe = &tuiField{kind: tuiToggle, label: "Wipe card before writing", checked: true}
+	t.magicUID = &tuiField{kind: tuiEntry, label: "Magic Card UID", press: t.setUID}
+
+	t.sections = []*tuiSection{
+		{tab: "Discovery", name: "Card Discovery", fields: []*tuiField{
+			{kind: tuiButton, label: "DETECT CARD TYPE", press: t.detectCard},
+		}},
+		{tab: "Corporate", name: "Corporate Access Control Cards", fields: []*tuiField{
+			t.cardType, t.bitLength, t.facilityCode, t.cardNumber, t.hexData, t.uid, t.action,
+			{kind: tuiButton, label: "EXECUTE", press: t.executeCard},
+			{kind: tuiButton, label: "READ CARD DATA", press: t.readCard},
+		}},
+		{tab: "Hotel", name: "Hotel / Residence Access Control", fields: []*tuiField{
+			{kind: tuiHeading, label: "CARD ANALYSIS"},
+			{kind: tuiButton, label: "CARD INFO", press: t.cardInfo},
+			{kind: tuiButton, label: "CHECK KEYS", press: t.checkKeys},
+			{kind: tuiHeading, label: "KEY RECOVERY"},
+			t.attackMethod, t.dumpPath, t.keyPath,
+			{kind: tuiButton, label: "START ATTACK", press: t.startAttack},
+			{kind: tuiButton, label: "SNIFF KEYS", press: t.sniffKeys},
+			t.wipe,
+			{kind: tuiButton, label: "WRITE FROM DUMP", press: t.writeFromDump},
+			{kind: tuiHeading, label: "MAGIC CARD"},
+			t.magicUID,
+			{kind: tuiButton, label: "SET UID", press: t.setUID},
+		}},
+	}
+	for _, s := range t.sections {
+		s.focus = len(s.fields) - 1
+		s.move(1)
+	}
+	return t
+}
+
+// runTUI runs the terminal UI until the user quits
+func runTUI() error {
+	inFd, outFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(inFd) || !term.IsTerminal(outFd) {
+		return fmt.Errorf("%w: the TUI needs an interactive terminal", ErrInvalidInput)
+	}
+	state, err := term.MakeRaw(inFd)
+	if err != nil {
+		return fmt.Errorf("Failed to set up the terminal: %w", err)
+	}
+	defer term.Restore(inFd, state)
+	fmt.Fprint(os.Stdout, tuiAltScreen+tuiHideCursor)
+	defer fmt.Fprint(os.Stdout, Reset+tuiShowCursor+tuiMainScreen)
+
+	t := newTUI(os.Stdout)
+	unsubscribe := events.Subscribe(t.receive)
+	defer unsubscribe()
+	defer close(t.done)
+
+	keys := make(chan tuiKey, 64)
+	go readTUIKeys(os.Stdin, keys)
+	t.loop(keys, func() (int, int, error) { return term.GetSize(outFd) })
+
+	// Stop whatever is still running on the Proxmark3 before the session closes
+	cancelAllOperations()
+	return nil
+}
+
+// loop handles keys, events and work from operations, redrawing after each, until the user quits
+func (t *tui) loop(keys <-chan tuiKey, size func() (int, int, error)) {
+	// The ticker picks up terminal resizes and keeps job durations current
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for !t.quit {
+		if w, h, err := size(); err == nil {
+			t.width, t.height = w, h
+		}
+		t.draw()
+
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return
+			}
+			t.handleKey(k)
+		case e := <-t.events:
+			t.addEvent(e)
+			// Take what else has arrived so bursts of output are drawn once
+			for n := len(t.events); n > 0; n-- {
+				t.addEvent(<-t.events)
+			}
+		case fn := <-t.calls:
+			fn()
+		case <-ticker.C:
+		}
+	}
+}
+
+// do runs fn on the TUI's goroutine, for operations updating the form
+func (t *tui) do(fn func()) {
+	select {
+	case t.calls <- fn:
+	case <-t.done:
+	}
+}
+
+// follow shows the events of the given job in the panes
+func (t *tui) follow(id int) {
+	t.shownMu.Lock()
+	defer t.shownMu.Unlock()
+	t.shownJob = id
+}
+
+// receive passes the events shown in the panes to the TUI's goroutine: those of the job
+// followed and those published outside of a job
+func (t *tui) receive(e Event) {
+	t.shownMu.Lock()
+	shown := e.Operation == 0 || e.Operation == t.shownJob
+	t.shownMu.Unlock()
+	if !shown || e.Kind == EventResult {
+		return
+	}
+	select {
+	case t.events <- e:
+	case <-t.done:
+	}
+}
+
+// addEvent adds an event to the panes: status messages to the status pane, pm3 commands and
+// output to the output pane
+func (t *tui) addEvent(e Event) {
+	switch e.Kind {
+	case EventStatus, EventProgress:
+		t.status = append(t.status, e)
+		if len(t.status) > tuiMaxLines {
+			t.status = t.status[len(t.status)-tuiMaxLines:]
+		}
+	case EventCommand, EventOutput:
+		line := strings.ReplaceAll(stripANSI(e.Text), "\t", "    ")
+		if t.scroll > 0 {
+			// Keep the scrolled-back view where it is
+			t.scroll += len(wrapTUILine(line, t.outputWidth))
+		}
+		t.output = append(t.output, line)
+		if len(t.output) > tuiMaxLines {
+			t.output = t.output[len(t.output)-tuiMaxLines:]
+		}
+	}
+}
+
+// clear empties both panes, as the GUI does when an operation starts
+func (t *tui) clear() {
+	t.status = nil
+	t.output = nil
+	t.scroll = 0
+}
+
+// runJob queues an operation on the selected Proxmark3; the panes follow it once it starts
+func (t *tui) runJob(name string, fn func(ctx context.Context) error) {
+	t.clear()
+	ctx := beginOperation()
+	go func() {
+		_, err := jobs.Submit(ctx, name, func(ctx context.Context, job *Job) {
+			t.follow(job.ID)
+			showRemediation(ctx, fn(ctx))
+		})
+		if err != nil {
+			WriteStatusError(context.Background(), "%v", err)
+			showRemediation(context.Background(), err)
+		}
+	}()
+}
+
+func (t *tui) selectedCardType() CardType {
+	ct, _ := lookupCardTypeByDisplayName(t.cardType.selected())
+	return ct
+}
+
+// cardTypeChanged updates the bit lengths and actions offered for the selected card type
+func (t *tui) cardTypeChanged() {
+	ct := t.selectedCardType()
+	if ct == nil {
+		return
+	}
+	t.bitLength.options = nil
+	for _, bl := range ct.BitLengths() {
+		t.bitLength.options = append(t.bitLength.options, strconv.Itoa(bl))
+	}
+	t.bitLength.index = 0
+
+	// Remove the simulate option for card types that cannot be simulated
+	t.action.options = []string{"Generate Command", "Write & Verify"}
+	if ct.CanSimulate() {
+		t.action.options = append(t.action.options, "Simulate Card")
+	}
+	t.action.index = 1 // Default to "Write & Verify"
+}
+
+// fillCardForm loads card values into the Corporate section so they can be written or verified
+func (t *tui) fillCardForm(ct CardType, p CardParams) {
+	t.cardType.choose(ct.DisplayName())
+	t.cardTypeChanged()
+	if p.BitLength > 0 && ct.Input() == InputWiegand {
+		t.bitLength.choose(strconv.Itoa(p.BitLength))
+	}
+
+	t.facilityCode.text, t.cardNumber.text, t.hexData.text, t.uid.text = "", "", "", ""
+	switch ct.Input() {
+	case InputHex:
+		t.hexData.text = p.HexData
+	case InputUID:
+		t.uid.text = p.UID
+	default:
+		t.facilityCode.text = strconv.Itoa(p.FacilityCode)
+		t.cardNumber.text = strconv.Itoa(p.CardNumber)
+	}
+}
+
+// executeCard runs the Corporate section's action on the credential in the form
+func (t *tui) executeCard() {
+	selectedCardType := t.selectedCardType()
+	if selectedCardType == nil {
+		t.clear()
+		WriteStatusError(context.Background(), "Please select a card type first")
+		return
+	}
+
+	p := CardParams{HexData: strings.TrimSpace(t.hexData.text), UID: strings.TrimSpace(t.uid.text)}
+	if selectedCardType.Input() == InputWiegand {
+		var fcErr, cnErr, blErr error
+		p.FacilityCode, fcErr = strconv.Atoi(strings.TrimSpace(t.facilityCode.text))
+		p.CardNumber, cnErr = strconv.Atoi(strings.TrimSpace(t.cardNumber.text))
+		p.BitLength, blErr = strconv.Atoi(t.bitLength.selected())
+		if fcErr != nil || cnErr != nil || blErr != nil {
+			t.clear()
+			WriteStatusError(context.Background(), "Facility Code and Card Number are required and must be numbers")
+			return
+		}
+	}
+	ct, p, err := resolveCard(selectedCardType.Name(), p)
+	if err != nil {
+		t.clear()
+		WriteStatusError(context.Background(), "%v", err)
+		return
+	}
+
+	actionValue := t.action.selected()
+	if actionValue == "Generate Command" {
+		// Generating does not use the Proxmark3, so it does not wait in the job queue
+		t.clear()
+		ctx := beginOperation()
+		t.follow(operationIDFrom(ctx))
+		go func() {
+			WriteStatusInfo(ctx, "Generating PM3 command...")
+			if cmdStr, err := ct.WriteCommand(p); err != nil {
+				emitOutput(ctx, fmt.Sprintf("Error: %v", err))
+			} else {
+				emitCommand(ctx, cmdStr)
+			}
+			WriteStatusSuccess(ctx, "PM3 command generated")
+
+			// Show the encoded Wiegand data alongside the command
+			if card, err := generateCardData(ct.Name(), p.BitLength, p.FacilityCode, p.CardNumber, p.HexData, p.UID); err != nil {
+				WriteStatusError(ctx, "Failed to encode card data: %v", err)
+			} else {
+				displayGeneratedCard(ctx, ct.Name(), card)
+			}
+		}()
+		return
+	}
+
+	t.runJob(actionValue, func(ctx context.Context) error {
+		WriteStatusInfo(ctx, "Checking Proxmark3 connection...")
+		if err := checkProxmark3(ctx); err != nil {
+			WriteStatusError(ctx, "%v", err)
+			return emitFailure(ctx, err)
+		}
+		WriteStatusSuccess(ctx, "Proxmark3 connected")
+		WriteStatusInfo(ctx, "Executing %s...", actionValue)
+
+		write := actionValue == "Write & Verify"
+		simulate := actionValue == "Simulate Card"
+		if err := handleCardType(ctx, ct.Name(), p.FacilityCode, p.CardNumber, p.BitLength, write, write, p.UID, p.HexData, simulate); err != nil {
+			return err
+		}
+		WriteStatusSuccess(ctx, "%s completed", actionValue)
+		return nil
+	})
+}
+
+// readCard reads a card of the selected type and loads what was read into the form
+func (t *tui) readCard() {
+	ct := t.selectedCardType()
+	if ct == nil {
+		t.clear()
+		WriteStatusError(context.Background(), "Please select a card type first")
+		return
+	}
+	t.runJob("READ CARD DATA", func(ctx context.Context) error {
+		cardRead, err := readCardData(ctx, ct.Name())
+		if err != nil {
+			return err
+		}
+		// Load the values that were read so they can be written without retyping
+		if cardRead.hasCredential() || cardRead.HexData != "" || cardRead.UID != "" {
+			t.do(func() { t.fillCardForm(ct, cardRead.Params()) })
+			WriteStatusInfo(ctx, "Card values loaded into the form")
+		}
+		WriteStatusSuccess(ctx, "Read card completed")
+		return nil
+	})
+}
+
+func (t *tui) detectCard() {
+	t.runJob("DETECT CARD TYPE", detectCardType)
+}
+
+func (t *tui) cardInfo() {
+	t.runJob("CARD INFO", getCardInfo)
+}
+
+func (t *tui) checkKeys() {
+	keyPath := strings.TrimSpace(t.keyPath.text)
+	t.runJob("CHECK KEYS", func(ctx context.Context) error {
+		return checkKeysFast(ctx, keyPath)
+	})
+}
+
+func (t *tui) sniffKeys() {
+	t.runJob("SNIFF KEYS", sniffHFKeys)
+}
+
+// startAttack recovers the keys of a hotel key card and fills in the dump and key files it saved
+func (t *tui) startAttack() {
+	method := hotelRecoveryMethods[t.attackMethod.index]
+	t.runJob("START ATTACK", func(ctx context.Context) error {
+		return recoverHotelKey(ctx, method, func(dumpPath, keyPath string) {
+			t.do(func() {
+				if dumpPath != "" {
+					t.dumpPath.text = dumpPath
+				}
+				if keyPath != "" {
+					t.keyPath.text = keyPath
+				}
+			})
+		})
+	})
+}
+
+// writeFromDump writes the dump file, or the latest dump when none is given, to a magic card
+func (t *tui) writeFromDump() {
+	t.clear()
+	dumpPath := strings.TrimSpace(t.dumpPath.text)
+	if dumpPath == "" {
+		if dumpPath = findLatestDumpFile(); dumpPath == "" {
+			WriteStatusError(context.Background(), "Dump file path is required and no recent dump file found")
+			return
+		}
+		t.dumpPath.text = dumpPath
+		WriteStatusInfo(context.Background(), "Auto-selected latest dump file: %s", dumpPath)
+	}
+	dumpPath = expandUserPath(dumpPath)
+	if _, err := os.Stat(dumpPath); os.IsNotExist(err) {
+		WriteStatusError(context.Background(), "Dump file does not exist: %s", dumpPath)
+		if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(dumpPath), filepath.Base(dumpPath)+"*")); len(matches) > 0 {
+			WriteStatusInfo(context.Background(), "Found similar files: %v", matches)
+		}
+		return
+	}
+
+	keyPath := strings.TrimSpace(t.keyPath.text)
+	wipe := t.wipe.checked
+	t.runJob("WRITE FROM DUMP", func(ctx context.Context) error {
+		return restoreFromDump(ctx, dumpPath, keyPath, wipe, func(keyPath string) {
+			t.do(func() { t.keyPath.text = keyPath })
+		})
+	})
+}
+
+func (t *tui) setUID() {
+	uid := strings.TrimSpace(t.magicUID.text)
+	if uid == "" {
+		t.clear()
+		WriteStatusError(context.Background(), "UID is required")
+		return
+	}
+	t.runJob("SET UID", func(ctx context.Context) error {
+		return setMagicCardUID(ctx, uid)
+	})
+}
+
+// handleKey applies a key press to the focused field or the TUI
+func (t *tui) handleKey(k tuiKey) {
+	s := t.sections[t.section]
+	f := s.fields[s.focus]
+	switch k.code {
+	case tuiKeyCtrl:
+		switch k.r {
+		case 'c', 'q':
+			t.quit = true
+		case 'x':
+			// Only the job shown is cancelled; jobs on other Proxmark3s keep running
+			t.shownMu.Lock()
+			shown := t.shownJob
+			t.shownMu.Unlock()
+			if jobs.Cancel(shown) {
+				WriteStatusInfo(context.Background(), "Cancellation of job #%d requested...", shown)
+			} else {
+				WriteStatusInfo(context.Background(), "No running job to cancel")
+			}
+		case 'l':
+			t.clear()
+		case 'n':
+			t.section = (t.section + 1) % len(t.sections)
+		case 'u':
+			if f.kind == tuiEntry {
+				f.text = ""
+			}
+		}
+	case tuiKeyF1, tuiKeyF2, tuiKeyF3:
+		t.section = int(k.code - tuiKeyF1)
+	case tuiKeyTab, tuiKeyDown:
+		s.move(1)
+	case tuiKeyBackTab, tuiKeyUp:
+		s.move(-1)
+	case tuiKeyLeft, tuiKeyRight:
+		if f.kind == tuiSelect && len(f.options) > 0 {
+			delta := 1
+			if k.code == tuiKeyLeft {
+				delta = len(f.options) - 1
+			}
+			f.index = (f.index + delta) % len(f.options)
+			if f.changed != nil {
+				f.changed()
+			}
+		}
+	case tuiKeyEnter:
+		switch {
+		case f.kind == tuiToggle:
+			f.checked = !f.checked
+		case f.press != nil:
+			f.press()
+		default:
+			s.move(1)
+		}
+	case tuiKeyBackspace:
+		if f.kind == tuiEntry && f.text != "" {
+			_, size := utf8.DecodeLastRuneInString(f.text)
+			f.text = f.text[:len(f.text)-size]
+		}
+	case tuiKeyRune:
+		switch f.kind {
+		case tuiEntry:
+			f.text += string(k.r)
+		case tuiToggle:
+			if k.r == ' ' {
+				f.checked = !f.checked
+			}
+		}
+	case tuiKeyPageUp:
+		t.scroll += t.height / 2
+	case tuiKeyPageDown:
+		if t.scroll -= t.height / 2; t.scroll < 0 {
+			t.scroll = 0
+		}
+	}
+}
+
+// draw writes the screen when it has changed since it was last drawn
+func (t *tui) draw() {
+	frame := t.frame()
+	if frame == t.lastFrame {
+		return
+	}
+	t.lastFrame = frame
+	io.WriteString(t.out, frame)
+}
+
+// frame renders the screen: a title bar with the sections, the form of the open section on the
+// left, the status and output panes on the right and the keys at the bottom
+func (t *tui) frame() string {
+	w, h := t.width, t.height
+	var b strings.Builder
+	if size := [2]int{w, h}; size != t.lastSize {
+		// Lines of the old size may be left over after a resize
+		t.lastSize = size
+		b.WriteString("\033[2J")
+	}
+	if w < tuiMinWidth || h < tuiMinHeight {
+		fmt.Fprintf(&b, "\033[H"+Reset+"Terminal too small (%dx%d), the TUI needs at least %dx%d", w, h, tuiMinWidth, tuiMinHeight)
+		return b.String()
+	}
+
+	bodyHeight := h - 2
+	form := t.formLines(tuiFormWidth, bodyHeight)
+	panes := t.paneLines(w-tuiFormWidth-1, bodyHeight)
+
+	rows := []string{t.titleBar(w)}
+	for i := 0; i < bodyHeight; i++ {
+		rows = append(rows, form[i]+tuiDim+"│"+Reset+panes[i])
+	}
+	rows = append(rows, tuiDim+fitTUI(" Tab/↑↓ move  ←→ choose  Enter run  F1-F3/^N section  ^X cancel  ^L clear  PgUp/PgDn scroll  ^C quit", w)+Reset)
+
+	for i, row := range rows {
+		fmt.Fprintf(&b, "\033[%d;1H%s"+Reset, i+1, row)
+	}
+	return b.String()
+}
+
+func (t *tui) titleBar(width int) string {
+	title := " Doppelgänger Assistant v" + Version + "  "
+	line := tuiBold + title + Reset
+	used := utf8.RuneCountInString(title)
+	for i, s := range t.sections {
+		tab := fmt.Sprintf(" F%d %s ", i+1, s.tab)
+		if used+utf8.RuneCountInString(tab) > width {
+			break
+		}
+		if i == t.section {
+			line += tuiReverse + tab + Reset
+		} else {
+			line += tab
+		}
+		used += utf8.RuneCountInString(tab)
+	}
+	return line + strings.Repeat(" ", width-used)
+}
+
+// formLines renders the open section, scrolled so the focused field is visible
+func (t *tui) formLines(width, height int) []string {
+	s := t.sections[t.section]
+	lines := []string{tuiBold + fitTUI(" "+strings.ToUpper(s.name), width) + Reset, fitTUI("", width)}
+	focusLine := 0
+	for i, f := range s.fields {
+		if f.visible != nil && !f.visible() {
+			continue
+		}
+		if i == s.focus {
+			focusLine = len(lines)
+		}
+		if f.kind == tuiHeading && len(lines) > 2 {
+			lines = append(lines, fitTUI("", width))
+		}
+		lines = append(lines, fieldLine(f, i == s.focus, width))
+	}
+	if focusLine >= height {
+		lines = lines[focusLine-height+1:]
+	}
+	for len(lines) < height {
+		lines = append(lines, fitTUI("", width))
+	}
+	return lines[:height]
+}
+
+// fieldLine renders a field as a line of the given width, its value highlighted when focused
+func fieldLine(f *tuiField, focused bool, width int) string {
+	var prefix, value string
+	switch f.kind {
+	case tuiHeading:
+		return tuiDim + fitTUI(" "+f.label, width) + Reset
+	case tuiSelect:
+		prefix = " " + fitTUI(f.label, tuiLabelWidth)
+		value = "‹ " + f.selected() + " ›"
+	case tuiEntry:
+		prefix = " " + fitTUI(f.label, tuiLabelWidth)
+		value = f.text
+		if focused {
+			value += "_"
+		}
+		// Show the end of text too long for the field
+		if room := width - tuiLabelWidth - 2; utf8.RuneCountInString(value) > room {
+			runes := []rune(value)
+			value = string(runes[len(runes)-room:])
+		}
+	case tuiToggle:
+		prefix = " "
+		value = "[ ] " + f.label
+		if f.checked {
+			value = "[x] " + f.label
+		}
+	case tuiButton:
+		prefix = " "
+		value = "[ " + f.label + " ]"
+	}
+
+	room := width - utf8.RuneCountInString(prefix)
+	if !focused {
+		return prefix + fitTUI(value, room)
+	}
+	value = fitTUI(value, room-1)
+	if f.kind == tuiEntry {
+		value = strings.TrimRight(value, " ")
+	}
+	return prefix + tuiReverse + value + Reset + strings.Repeat(" ", room-utf8.RuneCountInString(value))
+}
+
+// paneLines renders the status pane above the output pane
+func (t *tui) paneLines(width, height int) []string {
+	statusHeight := height * 2 / 5
+	outputHeight := height - statusHeight
+
+	statusTitle := " STATUS"
+	for _, j := range jobs.List() {
+		if j.State() == JobRunning {
+			statusTitle += " - " + j.Describe()
+			break
+		}
+	}
+	var status []string
+	for _, e := range t.status {
+		color := ""
+		switch {
+		case e.Kind == EventProgress:
+			color = Yellow
+		case e.Level == LevelSuccess:
+			color = Green
+		case e.Level == LevelError:
+			color = Red
+		}
+		for _, line := range wrapTUILine(guiStatusLine(e), width) {
+			status = append(status, color+fitTUI(line, width)+Reset)
+		}
+	}
+
+	t.outputWidth = width
+	var output []string
+	for _, line := range t.output {
+		for _, wrapped := range wrapTUILine(line, width) {
+			output = append(output, fitTUI(wrapped, width))
+		}
+	}
+	outputTitle := " OUTPUT"
+	if t.scroll > len(output) {
+		t.scroll = len(output)
+	}
+	if t.scroll > 0 {
+		outputTitle += fmt.Sprintf(" (scrolled back %d lines)", t.scroll)
+	}
+	output = output[:len(output)-t.scroll]
+
+	lines := append([]string{tuiBold + fitTUI(statusTitle, width) + Reset}, lastTUILines(status, statusHeight-1, width)...)
+	lines = append(lines, tuiBold+fitTUI(outputTitle, width)+Reset)
+	return append(lines, lastTUILines(output, outputHeight-1, width)...)
+}
+
+// lastTUILines returns the last n lines, padded with blank lines to n
+func lastTUILines(lines []string, n, width int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	for len(lines) < n {
+		lines = append(lines, fitTUI("", width))
+	}
+	return lines
+}
+
+// fitTUI truncates or pads text to exactly width columns
+func fitTUI(text string, width int) string {
+	n := utf8.RuneCountInString(text)
+	if n > width {
+		return string([]rune(text)[:width])
+	}
+	return text + strings.Repeat(" ", width-n)
+}
+
+// wrapTUILine breaks text into lines of at most width columns
+func wrapTUILine(text string, width int) []string {
+	runes := []rune(text)
+	if width <= 0 || len(runes) <= width {
+		return []string{text}
+	}
+	var lines []string
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(lines, string(runes))
+}
+
+// tuiKeyCode identifies a key read from the terminal
+type tuiKeyCode int
+
+const (
+	tuiKeyRune tuiKeyCode = iota
+	tuiKeyCtrl            // Ctrl with the letter in tuiKey.r
+	tuiKeyEnter
+	tuiKeyTab
+	tuiKeyBackTab
+	tuiKeyBackspace
+	tuiKeyUp
+	tuiKeyDown
+	tuiKeyLeft
+	tuiKeyRight
+	tuiKeyPageUp
+	tuiKeyPageDown
+	tuiKeyF1
+	tuiKeyF2
+	tuiKeyF3
+	tuiKeyUnknown
+)
+
+type tuiKey struct {
+	code tuiKeyCode
+	r    rune
+}
+
+// tuiEscapeKeys are the escape sequences terminals send for special keys, without the ESC
+var tuiEscapeKeys = map[string]tuiKeyCode{
+	"[A": tuiKeyUp, "[B": tuiKeyDown, "[C": tuiKeyRight, "[D": tuiKeyLeft,
+	"OA": tuiKeyUp, "OB": tuiKeyDown, "OC": tuiKeyRight, "OD": tuiKeyLeft,
+	"[Z": tuiKeyBackTab, "[5~": tuiKeyPageUp, "[6~": tuiKeyPageDown,
+	"OP": tuiKeyF1, "OQ": tuiKeyF2, "OR": tuiKeyF3,
+	"[11~": tuiKeyF1, "[12~": tuiKeyF2, "[13~": tuiKeyF3,
+}
+
+// readTUIKeys reads key presses from a terminal in raw mode until it fails
+func readTUIKeys(in io.Reader, keys chan<- tuiKey) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range parseTUIKeys(buf[:n]) {
+			keys <- k
+		}
+	}
+}
+
+// parseTUIKeys splits what a terminal sent into key presses. Escape sequences arrive whole
+// from a single read, so a lone ESC is dropped.
+func parseTUIKeys(b []byte) []tuiKey {
+	var keys []tuiKey
+	for len(b) > 0 {
+		c := b[0]
+		switch {
+		case c == 0x1b:
+			if len(b) < 2 || (b[1] != '[' && b[1] != 'O') {
+				b = b[1:]
+				continue
+			}
+			// The sequence ends with its first byte in @ to ~ after the introducer
+			end := 2
+			for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+				end++
+			}
+			if end == len(b) {
+				end--
+			}
+			code, ok := tuiEscapeKeys[string(b[1:end+1])]
+			if !ok {
+				code = tuiKeyUnknown
+			}
+			keys = append(keys, tuiKey{code: code})
+			b = b[end+1:]
+			continue
+		case c == '\r' || c == '\n':
+			keys = append(keys, tuiKey{code: tuiKeyEnter})
+		case c == '\t':
+			keys = append(keys, tuiKey{code: tuiKeyTab})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, tuiKey{code: tuiKeyBackspace})
+		case c < 0x20:
+			keys = append(keys, tuiKey{code: tuiKeyCtrl, r: rune(c) + 'a' - 1})
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, tuiKey{code: tuiKeyRune, r: r})
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
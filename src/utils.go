@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/term"
@@ -28,6 +29,15 @@ func bitCount(intType uint64) int {
 func findPm3Path() (string, error) {
 	var cmd *exec.Cmd
 
+	// A pinned pm3 client is used as is, even when another one is in PATH
+	if pinned := currentConfig().Pm3Path; pinned != "" {
+		path := expandUserPath(pinned)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("configured pm3 binary %s not found", path)
+		}
+		return path, nil
+	}
+
 	if runtime.GOOS == "windows" {
 		// On Windows, use 'where' command
 		cmd = exec.Command("cmd", "/c", "where", "pm3")
@@ -56,27 +66,10 @@ func findPm3Path() (string, error) {
 			}
 	}
 
-	// If shell lookup fails, try common installation paths directly
-	var commonPaths []string
-	if runtime.GOOS == "darwin" {
-		commonPaths = []string{
-			"/opt/homebrew/bin/pm3",               // Homebrew on Apple Silicon
-			"/usr/local/bin/pm3",                  // Homebrew on Intel Mac
-			"/opt/local/bin/pm3",                  // MacPorts
-			"/usr/local/Cellar/proxmark3/bin/pm3", // Older Homebrew layout
-		}
-	} else {
-		// Linux
-		commonPaths = []string{
-			"/usr/local/bin/pm3",
-			"/usr/bin/pm3",
-			"/opt/proxmark3/pm3",
-		}
-	}
-
-	for _, path := range commonPaths {
-		if _, err := os.Stat(path); err == nil {
-			return path, nil
+	// If shell lookup fails, try the configured installation paths directly
+	for _, path := range currentConfig().Pm3SearchPaths {
+		if _, err := os.Stat(expandUserPath(path)); err == nil {
+			return expandUserPath(path), nil
 		}
 	}
 
@@ -84,57 +77,34 @@ func findPm3Path() (string, error) {
 }
 
 // findPm3Device detects the pm3 device path using 'pm3 --list'
-// Returns the device path (e.g., /dev/tty.usbmodem1101 on macOS, /dev/ttyACM0 on Linux)
+// Returns the first device path (e.g., /dev/tty.usbmodem1101 on macOS, /dev/ttyACM0 on Linux)
 func findPm3Device() (string, error) {
-	// Get the full path to pm3 binary
-	pm3Binary, err := getPm3Path()
-	if err != nil || pm3Binary == "" {
-		return "", fmt.Errorf("pm3 binary not found")
-	}
-
-	cmd := exec.Command(pm3Binary, "--list")
-	output, err := cmd.CombinedOutput()
+	ports, err := listPm3Ports()
 	if err != nil {
 		return "", err
 	}
-
-	// Parse the output to get the device path
-	// Expected format: "1: /dev/tty.usbmodem1101" or "1: /dev/ttyACM0"
-	outputStr := string(output)
-	lines := strings.Split(outputStr, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		// Look for lines with device paths
-		if strings.Contains(line, ":") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				devicePath := strings.TrimSpace(parts[1])
-				// Validate it looks like a device path
-				if strings.HasPrefix(devicePath, "/dev/") {
-					return devicePath, nil
-				}
-			}
-		}
+	if len(ports) == 0 {
+		return "", fmt.Errorf("no pm3 device found")
 	}
-
-	return "", fmt.Errorf("no pm3 device found")
+	return ports[0], nil
 }
 
 // pm3Device caches the detected device path
 var pm3Device string
 var pm3DeviceErr error
 var pm3DeviceChecked bool
+var pm3DeviceMutex sync.Mutex
 
 // pm3Path caches the full path to pm3 binary
 var pm3Path string
 var pm3PathErr error
 var pm3PathChecked bool
+var pm3PathMutex sync.Mutex
 
 // getPm3Path returns the cached pm3 binary path or detects it
 func getPm3Path() (string, error) {
+	pm3PathMutex.Lock()
+	defer pm3PathMutex.Unlock()
 	if !pm3PathChecked {
 		pm3Path, pm3PathErr = findPm3Path()
 		pm3PathChecked = true
@@ -142,8 +112,17 @@ func getPm3Path() (string, error) {
 	return pm3Path, pm3PathErr
 }
 
-// getPm3Device returns the cached pm3 device path or detects it
+// getPm3Device returns the selected device, or the cached pm3 device path or detects it
 func getPm3Device() (string, error) {
+	if port := selectedPm3Device(); port != "" {
+		return port, nil
+	}
+	// A pinned device is used instead of the first one pm3 lists
+	if port := currentConfig().Device; port != "" {
+		return port, nil
+	}
+	pm3DeviceMutex.Lock()
+	defer pm3DeviceMutex.Unlock()
 	if !pm3DeviceChecked {
 		pm3Device, pm3DeviceErr = findPm3Device()
 		pm3DeviceChecked = true
@@ -151,49 +130,28 @@ func getPm3Device() (string, error) {
 	return pm3Device, pm3DeviceErr
 }
 
+// resetPm3PathCache clears the cached pm3 client lookup so it will be re-checked
+func resetPm3PathCache() {
+	pm3PathMutex.Lock()
+	defer pm3PathMutex.Unlock()
+	pm3PathChecked = false
+	pm3Path = ""
+	pm3PathErr = nil
+}
+
 // resetPm3DeviceCache clears the cached device detection so it will be re-checked
 func resetPm3DeviceCache() {
+	pm3DeviceMutex.Lock()
+	defer pm3DeviceMutex.Unlock()
 	pm3DeviceChecked = false
 	pm3Device = ""
 	pm3DeviceErr = nil
 }
 
 // checkProxmark3 verifies if Proxmark3 is connected and responding.
-// Returns (connected, error message).
-func checkProxmark3() (bool, string) {
-	pm3Binary, err := getPm3Path()
-	if err != nil || pm3Binary == "" {
-		return false, "Proxmark3 client (pm3) not found in PATH"
-	}
-
-	// Reset cache to force fresh detection
-	resetPm3DeviceCache()
-	device, err := getPm3Device()
-	if err != nil || device == "" {
-		return false, "Proxmark3 device not detected. Please connect your Proxmark3"
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, pm3Binary, "-c", "quit", "-p", device)
-	output, err := cmd.CombinedOutput()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		resetPm3DeviceCache()
-		return false, "Proxmark3 device not detected. Please connect your Proxmark3"
-	}
-
-	outputStr := strings.ToLower(string(output))
-	if strings.Contains(outputStr, "offline") ||
-		strings.Contains(outputStr, "cannot open") ||
-		strings.Contains(outputStr, "no such device") ||
-		err != nil && strings.Contains(err.Error(), "exit status") {
-		resetPm3DeviceCache()
-		return false, "Proxmark3 device not detected. Please connect your Proxmark3"
-	}
-
-	return true, ""
+// Returns an error wrapping ErrPm3NotFound or ErrDeviceOffline when it is not.
+func checkProxmark3(ctx context.Context) error {
+	return pm3RunnerFrom(ctx).Check()
 }
 
 // isInteractive checks if stdin is connected to a terminal.
@@ -229,6 +187,9 @@ func validateEM4100Hex(hexData string) (bool, string) {
 
 // launchPm3Terminal launches Proxmark3 in the OS default terminal
 func launchPm3Terminal() error {
+	// Release the port so the terminal's client can open it
+	closePm3Runner()
+
 	pm3Binary, err := getPm3Path()
 	if err != nil {
 		return fmt.Errorf("failed to find pm3 binary: %w", err)
@@ -319,7 +280,9 @@ end tell`, pm3Binary)
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 
-	return cmd.Start()
+	err = cmd.Start()
+	auditInteractiveSession(device, err)
+	return err
 }
 
 // GitHubRelease represents the GitHub API response for a release
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// A vault is a directory of files encrypted with a key derived from a passphrase. While one is
+// open, recovered dumps and key files are moved into it, card reads are written to it, and its
+// files are decrypted to a temporary directory only while pm3 needs them. Every file is sealed
+// with AES-256-GCM:
+//
+//	vaultMagic | 12 byte nonce | ciphertext and tag
+const (
+	vaultInfoFile   = "vault.json"
+	vaultExtension  = ".enc"
+	vaultIterations = 600000
+)
+
+var vaultMagic = []byte("DGVAULT1")
+
+// vaultCheck is sealed into vault.json to tell a wrong passphrase from a damaged file
+const vaultCheck = "doppelganger vault"
+
+// vaultInfo is a vault's vault.json, holding what is needed to derive its key
+type vaultInfo struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Check      []byte `json:"check"`
+}
+
+// VaultFile is a file in a vault
+type VaultFile struct {
+	Name     string    `json:"name"` // without the .enc extension
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Vault seals files with the key derived from its passphrase
+type Vault struct {
+	Dir string
+
+	aead        cipher.AEAD
+	unsubscribe func()
+}
+
+// openVault unlocks the vault in dir, creating it with the passphrase when it does not exist
+func openVault(dir, passphrase string) (*Vault, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("%w: the vault passphrase is empty", ErrInvalidInput)
+	}
+	dir = expandUserPath(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create vault: %w", err)
+	}
+	v := &Vault{Dir: dir}
+
+	infoPath := filepath.Join(dir, vaultInfoFile)
+	data, err := os.ReadFile(infoPath)
+	switch {
+	case err == nil:
+		var info vaultInfo
+		if err := json.Unmarshal(data, &info); err != nil || info.KDF != "pbkdf2-sha256" {
+			return nil, fmt.Errorf("%w: %s is not a vault", ErrInvalidInput, dir)
+		}
+		if v.aead, err = vaultCipher(passphrase, info.Salt, info.Iterations); err != nil {
+			return nil, err
+		}
+		if check, err := v.open(info.Check); err != nil || string(check) != vaultCheck {
+			return nil, fmt.Errorf("%w: wrong vault passphrase", ErrInvalidInput)
+		}
+	case os.IsNotExist(err):
+		info := vaultInfo{Version: 1, KDF: "pbkdf2-sha256", Iterations: vaultIterations, Salt: make([]byte, 16)}
+		if _, err := rand.Read(info.Salt); err != nil {
+			return nil, err
+		}
+		if v.aead, err = vaultCipher(passphrase, info.Salt, info.Iterations); err != nil {
+			return nil, err
+		}
+		if info.Check, err = v.seal([]byte(vaultCheck)); err != nil {
+			return nil, err
+		}
+		data, _ := json.MarshalIndent(info, "", "  ")
+		if err := os.WriteFile(infoPath, append(data, '\n'), 0600); err != nil {
+			return nil, fmt.Errorf("failed to create vault: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to open vault: %w", err)
+	}
+
+	v.unsubscribe = events.Subscribe(v.storeRead)
+	return v, nil
+}
+
+func vaultCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Close stops storing card reads in the vault
+func (v *Vault) Close() {
+	v.unsubscribe()
+}
+
+// seal encrypts data into the vault's file format
+func (v *Vault) seal(data []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, vaultMagic...), nonce...)
+	return v.aead.Seal(sealed, nonce, data, vaultMagic), nil
+}
+
+// open decrypts data sealed by seal
+func (v *Vault) open(sealed []byte) ([]byte, error) {
+	if !isSealed(sealed) || len(sealed) < len(vaultMagic)+v.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: not a vault file", ErrInvalidInput)
+	}
+	sealed = sealed[len(vaultMagic):]
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+	data, err := v.aead.Open(nil, nonce, ciphertext, vaultMagic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: vault file is damaged or was sealed by another vault", ErrInvalidInput)
+	}
+	return data, nil
+}
+
+// isSealed tells whether data is in the vault's file format
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, vaultMagic)
+}
+
+// isSealedFile tells whether the file at path is in the vault's file format
+func isSealedFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	head := make([]byte, len(vaultMagic))
+	n, _ := file.Read(head)
+	return isSealed(head[:n])
+}
+
+// StoreData seals data into the vault under name and returns the path of the vault file. A
+// timestamp is added to the name when the vault already holds a file of that name.
+func (v *Vault) StoreData(name string, data []byte) (string, error) {
+	sealed, err := v.seal(data)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(v.Dir, name+vaultExtension)
+	if _, err := os.Stat(path); err == nil {
+		ext := filepath.Ext(name)
+		path = filepath.Join(v.Dir, strings.TrimSuffix(name, ext)+time.Now().Format("-20060102-150405")+ext+vaultExtension)
+	}
+	if err := os.WriteFile(path, sealed, 0600); err != nil {
+		return "", fmt.Errorf("failed to write to vault: %w", err)
+	}
+	return path, nil
+}
+
+// Store moves a file into the vault: it is sealed and the plaintext is removed
+func (v *Vault) Store(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	stored, err := v.StoreData(filepath.Base(path), data)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return stored, fmt.Errorf("sealed into the vault but failed to remove %s: %w", path, err)
+	}
+	return stored, nil
+}
+
+// Open returns the plaintext of a vault file
+func (v *Vault) Open(path string) ([]byte, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return v.open(sealed)
+}
+
+// Export returns the plaintext of a vault file, or of a workspace transcript with the lines
+// sealed while the vault was unlocked decrypted
+func (v *Vault) Export(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isSealed(data) {
+		return v.open(data)
+	}
+	var plain bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		text := bytes.TrimRight(line, "\n")
+		if !bytes.HasPrefix(text, []byte(sealedLinePrefix)) {
+			plain.Write(line)
+			continue
+		}
+		sealed, err := base64.StdEncoding.DecodeString(string(text[len(sealedLinePrefix):]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s has a damaged sealed line", ErrInvalidInput, filepath.Base(path))
+		}
+		opened, err := v.open(sealed)
+		if err != nil {
+			return nil, err
+		}
+		plain.Write(opened)
+	}
+	return plain.Bytes(), nil
+}
+
+// Files lists the vault's files, newest first
+func (v *Vault) Files() ([]VaultFile, error) {
+	matches, err := filepath.Glob(filepath.Join(v.Dir, "*"+vaultExtension))
+	if err != nil {
+		return nil, err
+	}
+	var files []VaultFile
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, VaultFile{
+			Name:     strings.TrimSuffix(filepath.Base(match), vaultExtension),
+			Path:     match,
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Modified.After(files[j].Modified)
+	})
+	return files, nil
+}
+
+// storeRead keeps every card read in the vault
+func (v *Vault) storeRead(e Event) {
+	cardRead, ok := e.Payload.(*CardRead)
+	if e.Kind != EventResult || !ok {
+		return
+	}
+	data, err := json.MarshalIndent(cardRead, "", "  ")
+	if err != nil {
+		return
+	}
+	name := fmt.Sprintf("read-%s-%s.json", cardRead.CardType, e.Time.Format("20060102-150405"))
+	if _, err := v.StoreData(name, append(data, '\n')); err != nil {
+		WriteStatusError(context.Background(), "Vault: %v", err)
+	}
+}
+
+// storeDump moves a dump or key file into the vault, along with the .bin, .eml and .json
+// copies pm3 saves next to a dump, and returns the vault path of the file
+func (v *Vault) storeDump(path string) (string, error) {
+	stored, err := v.Store(path)
+	if err != nil {
+		return "", err
+	}
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".bin", ".eml", ".json"} {
+		if sibling := stem + ext; sibling != path {
+			if _, err := os.Stat(sibling); err == nil {
+				if _, err := v.Store(sibling); err != nil {
+					return stored, err
+				}
+			}
+		}
+	}
+	return stored, nil
+}
+
+// decryptForPm3 returns paths pm3 can read for the given files: vault files are decrypted to
+// a private temporary directory that cleanup removes, other files are returned as they are
+func decryptForPm3(paths ...string) (plain []string, cleanup func(), err error) {
+	tempDir := ""
+	cleanup = func() {
+		if tempDir != "" {
+			os.RemoveAll(tempDir)
+		}
+	}
+	for _, path := range paths {
+		if path == "" || !isSealedFile(path) {
+			plain = append(plain, path)
+			continue
+		}
+		v := activeVault()
+		if v == nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("%w: %s is in a vault; unlock the vault first", ErrInvalidInput, filepath.Base(path))
+		}
+		data, err := v.Open(path)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if tempDir == "" {
+			if tempDir, err = os.MkdirTemp("", "doppelganger-vault-"); err != nil {
+				return nil, nil, err
+			}
+		}
+		// pm3 tells file formats apart by extension, so the file keeps its name
+		decrypted := filepath.Join(tempDir, strings.TrimSuffix(filepath.Base(path), vaultExtension))
+		if err := os.WriteFile(decrypted, data, 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		plain = append(plain, decrypted)
+	}
+	return plain, cleanup, nil
+}
+
+var (
+	currentVaultMu sync.Mutex
+	currentVault   *Vault
+)
+
+// useVault unlocks the vault in dir as the one files are stored in, closing the previous one
+func useVault(dir, passphrase string) (*Vault, error) {
+	v, err := openVault(dir, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	currentVaultMu.Lock()
+	previous := currentVault
+	currentVault = v
+	currentVaultMu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return v, nil
+}
+
+// activeVault returns the unlocked vault, or nil when none is
+func activeVault() *Vault {
+	currentVaultMu.Lock()
+	defer currentVaultMu.Unlock()
+	return currentVault
+}
+
+// closeVault locks the current vault
+func closeVault() {
+	currentVaultMu.Lock()
+	v := currentVault
+	currentVault = nil
+	currentVaultMu.Unlock()
+	if v != nil {
+		v.Close()
+	}
+}
+
+// unlockVault unlocks the vault in dir with $DOPPELGANGER_VAULT_PASSPHRASE, or else with a
+// passphrase typed at the terminal, asked twice for a new vault
+func unlockVault(dir string) (*Vault, error) {
+	passphrase := os.Getenv("DOPPELGANGER_VAULT_PASSPHRASE")
+	if passphrase == "" {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("%w: set DOPPELGANGER_VAULT_PASSPHRASE to unlock the vault without a terminal", ErrInvalidInput)
+		}
+		creating := !vaultExists(dir)
+		prompt := "Vault passphrase: "
+		if creating {
+			prompt = "New vault passphrase: "
+		}
+		var err error
+		if passphrase, err = readPassphrase(fd, prompt); err != nil {
+			return nil, err
+		}
+		if creating {
+			again, err := readPassphrase(fd, "Repeat the passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if again != passphrase {
+				return nil, fmt.Errorf("%w: the passphrases differ", ErrInvalidInput)
+			}
+		}
+	}
+	return useVault(dir, passphrase)
+}
+
+// vaultExists tells whether dir holds a vault
+func vaultExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(expandUserPath(dir), vaultInfoFile))
+	return err == nil
+}
+
+func readPassphrase(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
+
+// defaultVaultDir is where the GUI offers to keep the vault: in the workspace when one is open
+func defaultVaultDir() string {
+	if w := activeWorkspace(); w != nil {
+		return filepath.Join(w.Dir, "vault")
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(homeDir, ".doppelganger_assistant", "vault")
+	}
+	return "vault"
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestVault(t *testing.T, dir, passphrase string) *Vault {
+	t.Helper()
+	v, err := openVault(dir, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Close)
+	return v
+}
+
+func TestVaultRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	dump := []byte("hf-mf-04A1B2C3-dump key A FFFFFFFFFFFF")
+	path, err := openTestVault(t, dir, "correct horse").StoreData("hf-mf-04A1B2C3-dump.bin", dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSealed(sealed) || bytes.Contains(sealed, []byte("FFFFFFFFFFFF")) {
+		t.Fatalf("vault file is not sealed: %q", sealed)
+	}
+
+	// the vault opens again with the same passphrase
+	v := openTestVault(t, dir, "correct horse")
+	got, err := v.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dump) {
+		t.Errorf("Open = %q, want %q", got, dump)
+	}
+	files, err := v.Files()
+	if err != nil || len(files) != 1 || files[0].Name != "hf-mf-04A1B2C3-dump.bin" {
+		t.Errorf("Files() = %+v, %v", files, err)
+	}
+}
+
+func TestVaultWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	openTestVault(t, dir, "correct horse")
+	_, err := openVault(dir, "battery staple")
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "wrong vault passphrase") {
+		t.Errorf("err = %v, want a wrong passphrase error", err)
+	}
+	if _, err := openVault(t.TempDir(), ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("empty passphrase: err = %v, want ErrInvalidInput", err)
+	}
+
+	// a truncated vault.json is reported rather than replaced by a new vault
+	info := filepath.Join(dir, vaultInfoFile)
+	data, err := os.ReadFile(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(info, data[:len(data)/2], 0600)
+	if _, err := openVault(dir, "correct horse"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("truncated %s: err = %v, want ErrInvalidInput", vaultInfoFile, err)
+	}
+}
+
+func TestVaultDamagedFile(t *testing.T) {
+	v := openTestVault(t, t.TempDir(), "correct horse")
+	path, err := v.StoreData("keys.dic", []byte("FFFFFFFFFFFF\nA0A1A2A3A4A5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openTestVault(t, t.TempDir(), "correct horse").seal([]byte("FFFFFFFFFFFF\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(i int) []byte {
+		b := append([]byte{}, sealed...)
+		b[i] ^= 0x01
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic only", sealed[:len(vaultMagic)]},
+		{"truncated nonce", sealed[:len(vaultMagic)+4]},
+		{"truncated ciphertext", sealed[:len(sealed)-1]},
+		{"tampered magic", flip(0)},
+		{"tampered nonce", flip(len(vaultMagic))},
+		{"tampered ciphertext", flip(len(vaultMagic) + 12)},
+		{"tampered tag", flip(len(sealed) - 1)},
+		{"sealed by another vault", other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			damaged := filepath.Join(t.TempDir(), "keys.dic"+vaultExtension)
+			if err := os.WriteFile(damaged, tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := v.Open(damaged); !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("Open = %q, %v, want ErrInvalidInput", data, err)
+			}
+		})
+	}
+}
+
+func TestVaultExportTranscript(t *testing.T) {
+	t.Cleanup(closeVault)
+	path := filepath.Join(t.TempDir(), workspaceTranscriptFile)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	transcript := transcriptWriter{file}
+
+	// lines written while no vault is unlocked stay plain
+	transcript.Write([]byte("[=] workspace opened\n"))
+	v, err := useVault(t.TempDir(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript.Write([]byte("[+] UID: 04 A1 B2 C3\n"))
+	transcript.Write([]byte("[+] found valid key [ FFFFFFFFFFFF ]\n"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("04 A1 B2 C3")) || bytes.Count(data, []byte(sealedLinePrefix)) != 2 {
+		t.Fatalf("transcript lines were not sealed:\n%s", data)
+	}
+	got, err := v.Export(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[=] workspace opened\n[+] UID: 04 A1 B2 C3\n[+] found valid key [ FFFFFFFFFFFF ]\n"
+	if string(got) != want {
+		t.Errorf("Export = %q, want %q", got, want)
+	}
+
+	// a damaged sealed line fails the export rather than dropping the line
+	damaged := filepath.Join(t.TempDir(), workspaceTranscriptFile)
+	os.WriteFile(damaged, []byte("[=] workspace opened\n"+sealedLinePrefix+"not base64!\n"), 0600)
+	if _, err := v.Export(damaged); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("damaged line: err = %v, want ErrInvalidInput", err)
+	}
+}
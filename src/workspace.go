@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An engagement workspace is a directory recording what was done during an engagement:
+//
+//	workspace.json   name and creation time
+//	records.jsonl    one WorkspaceRecord per line: reads, writes, verifications, key recoveries,
+//	                 dump restores, failures, scope overrides and operator notes
+//	scope.json       the scope policy operations are checked against, see ScopePolicy
+//	audit.jsonl      the hash-chained audit log of pm3 commands, see AuditEntry
+//	transcript.log   every pm3 command, its output and the status messages, as written by -log;
+//	                 lines logged while a vault is unlocked are sealed, see transcriptWriter
+//	files/           copies of the MIFARE dumps and key files the operations used or produced
+const (
+	workspaceInfoFile       = "workspace.json"
+	workspaceRecordsFile    = "records.jsonl"
+	workspaceTranscriptFile = "transcript.log"
+	workspaceFilesDir       = "files"
+)
+
+// WorkspaceInfo describes a workspace, stored in its workspace.json
+type WorkspaceInfo struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+// WorkspaceRecord is an entry of a workspace's records
+type WorkspaceRecord struct {
+	Time      time.Time       `json:"time"`
+	Kind      string          `json:"kind"` // read, write, verify, recovery, restore, failure, override or note
+	Operation int             `json:"operation,omitempty"`
+	CardType  string          `json:"cardType,omitempty"`
+	Operator  string          `json:"operator,omitempty"`
+	Summary   string          `json:"summary"`
+	Error     string          `json:"error,omitempty"`
+	Files     []string        `json:"files,omitempty"`  // relative to the workspace
+	Data      json.RawMessage `json:"data,omitempty"`   // the operation's result payload
+	Sealed    []byte          `json:"sealed,omitempty"` // Data sealed by the vault instead
+}
+
+// Workspace records every operation's results and pm3 transcript while it is open
+type Workspace struct {
+	Dir  string
+	Info WorkspaceInfo
+
+	mu          sync.Mutex
+	operator    string
+	records     *os.File
+	transcript  *os.File
+	unsubscribe func()
+	onRecord    func(WorkspaceRecord)
+}
+
+// openWorkspace opens the workspace in dir, creating it when it does not exist, and starts
+// recording to it
+func openWorkspace(dir string) (*Workspace, error) {
+	dir = expandUserPath(dir)
+	if err := os.MkdirAll(filepath.Join(dir, workspaceFilesDir), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	w := &Workspace{Dir: dir, operator: operatorName()}
+
+	infoPath := filepath.Join(dir, workspaceInfoFile)
+	data, err := os.ReadFile(infoPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &w.Info); err != nil {
+			return nil, fmt.Errorf("%w: %s is not a workspace: %v", ErrInvalidInput, dir, err)
+		}
+	case os.IsNotExist(err):
+		w.Info = WorkspaceInfo{Name: filepath.Base(dir), Created: time.Now()}
+		data, _ := json.MarshalIndent(w.Info, "", "  ")
+		if err := os.WriteFile(infoPath, append(data, '\n'), 0600); err != nil {
+			return nil, fmt.Errorf("failed to create workspace: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to open workspace: %w", err)
+	}
+
+	if w.records, err = os.OpenFile(filepath.Join(dir, workspaceRecordsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, fmt.Errorf("failed to open workspace: %w", err)
+	}
+	if w.transcript, err = os.OpenFile(filepath.Join(dir, workspaceTranscriptFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		w.records.Close()
+		return nil, fmt.Errorf("failed to open workspace: %w", err)
+	}
+	logTranscript := logEvents(transcriptWriter{w.transcript})
+	w.unsubscribe = events.Subscribe(func(e Event) {
+		logTranscript(e)
+		w.recordEvent(e)
+	})
+	return w, nil
+}
+
+// sealedLinePrefix starts a transcript line sealed by the vault, followed by the sealed text in
+// base64. vault -export decrypts them.
+const sealedLinePrefix = "sealed:"
+
+// transcriptWriter writes to a workspace transcript. While a vault is unlocked every line is
+// sealed, as status messages and pm3 output carry the credentials, UIDs and keys read.
+type transcriptWriter struct {
+	file *os.File
+}
+
+func (t transcriptWriter) Write(p []byte) (int, error) {
+	v := activeVault()
+	if v == nil {
+		return t.file.Write(p)
+	}
+	sealed, err := v.seal(p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := t.file.WriteString(sealedLinePrefix + base64.StdEncoding.EncodeToString(sealed) + "\n"); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close stops recording to the workspace
+func (w *Workspace) Close() {
+	w.unsubscribe()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.records.Close()
+	w.transcript.Close()
+}
+
+// SetOnRecord sets a function called with every record added from now on
+func (w *Workspace) SetOnRecord(fn func(WorkspaceRecord)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onRecord = fn
+}
+
+// recordEvent adds a record for the results of reads, writes, verifications, key recoveries
+// and restores, and for failures
+func (w *Workspace) recordEvent(e Event) {
+	if e.Kind != EventResult {
+		return
+	}
+	r := WorkspaceRecord{Time: e.Time, Operation: e.Operation, CardType: e.CardType, Summary: e.Text}
+	if e.Err != nil {
+		r.Error = e.Err.Error()
+	}
+	var files []string
+	// sealedSummary replaces the summary of records whose summary names a credential or UID when
+	// a vault is unlocked
+	var sealedSummary string
+	switch p := e.Payload.(type) {
+	case *CardRead:
+		r.Kind, r.CardType, r.Summary = "read", p.CardType, p.describe()
+		sealedSummary = "Read " + cardTypeDisplayName(p.CardType)
+	case WriteResult:
+		r.Kind, r.CardType = "write", p.CardType
+		if ct, ok := lookupCardType(p.CardType); ok {
+			r.Summary = "Wrote " + describeCredential(ct, p.Params)
+		}
+		sealedSummary = "Wrote " + cardTypeDisplayName(p.CardType)
+	case VerifyResult:
+		r.Kind, r.CardType = "verify", p.CardType
+		verb := "Verified "
+		if !p.Match {
+			verb = "Verification failed for "
+		}
+		if ct, ok := lookupCardType(p.CardType); ok {
+			r.Summary = verb + describeCredential(ct, p.Expected)
+		}
+		sealedSummary = verb + cardTypeDisplayName(p.CardType)
+	case RecoveryResult:
+		r.Kind = "recovery"
+		r.Summary = fmt.Sprintf("%s recovered %d sectors", p.Method, p.SectorsRecovered)
+		files = []string{p.DumpFile, p.KeyFile}
+	case RestoreResult:
+		r.Kind = "restore"
+		r.Summary = "Wrote " + filepath.Base(p.DumpFile)
+		if p.UID != "" {
+			r.Summary += " to UID " + p.UID
+		}
+		sealedSummary = "Wrote a dump to a magic card"
+		files = []string{p.DumpFile, p.KeyFile}
+	case nil:
+		// Cancelling is not a failure, and a job's completion repeats its operation's result
+		if e.Err == nil || isCancelled(e.Err) {
+			return
+		}
+		r.Kind = "failure"
+	default:
+		return
+	}
+	if e.Payload != nil {
+		r.Data, _ = json.Marshal(e.Payload)
+	}
+	// Every result payload is sealed while a vault is unlocked
+	if v := activeVault(); v != nil && r.Data != nil {
+		if sealedSummary == "" {
+			sealedSummary = r.Summary
+		}
+		sealed, err := v.seal(r.Data)
+		if err != nil {
+			WriteStatusError(context.Background(), "Workspace: %v", err)
+			return
+		}
+		r.Summary, r.Data, r.Sealed = sealedSummary+" (sealed)", nil, sealed
+	}
+	for _, path := range files {
+		if path == "" {
+			continue
+		}
+		copied, err := w.importFile(path)
+		if err != nil {
+			WriteStatusError(context.Background(), "Workspace: failed to copy %s: %v", path, err)
+			continue
+		}
+		r.Files = append(r.Files, copied)
+	}
+	if err := w.add(r); err != nil {
+		WriteStatusError(context.Background(), "Workspace: %v", err)
+	}
+}
+
+// Result returns the record's result data, unsealing it with the unlocked vault when needed
+func (r WorkspaceRecord) Result() (json.RawMessage, error) {
+	if len(r.Sealed) == 0 {
+		return r.Data, nil
+	}
+	v := activeVault()
+	if v == nil {
+		return nil, fmt.Errorf("%w: the record is sealed; unlock the vault first", ErrInvalidInput)
+	}
+	return v.open(r.Sealed)
+}
+
+// AddNote records an operator note
+func (w *Workspace) AddNote(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("%w: the note is empty", ErrInvalidInput)
+	}
+	return w.add(WorkspaceRecord{Time: time.Now(), Kind: "note", Summary: text})
+}
+
+// add appends a record to records.jsonl
+func (w *Workspace) add(r WorkspaceRecord) error {
+	r.Operator = w.operator
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	_, err = w.records.Write(append(data, '\n'))
+	onRecord := w.onRecord
+	w.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if onRecord != nil {
+		onRecord(r)
+	}
+	return nil
+}
+
+// Records returns the workspace's records, oldest first
+func (w *Workspace) Records() ([]WorkspaceRecord, error) {
+	return readWorkspaceRecords(w.Dir)
+}
+
+// readWorkspaceRecords reads the records of the workspace in dir
+func readWorkspaceRecords(dir string) ([]WorkspaceRecord, error) {
+	file, err := os.Open(filepath.Join(dir, workspaceRecordsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	defer file.Close()
+
+	var records []WorkspaceRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r WorkspaceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return records, fmt.Errorf("%s line %d: %w", workspaceRecordsFile, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// importFile copies a file into the workspace's files directory and returns its path relative
+// to the workspace. Files already in the workspace are not copied again.
+func (w *Workspace) importFile(path string) (string, error) {
+	src := expandUserPath(path)
+	if rel, err := filepath.Rel(w.Dir, src); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel), nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(src)
+	dst := filepath.Join(w.Dir, workspaceFilesDir, name)
+	if existing, err := os.ReadFile(dst); err == nil {
+		if bytes.Equal(existing, data) {
+			return filepath.ToSlash(filepath.Join(workspaceFilesDir, name)), nil
+		}
+		// A different file of the same name, e.g. a second dump of the same UID
+		name = time.Now().Format("20060102-150405-") + name
+		dst = filepath.Join(w.Dir, workspaceFilesDir, name)
+	}
+	if err := os.WriteFile(dst, data, 0600); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(filepath.Join(workspaceFilesDir, name)), nil
+}
+
+// operatorName is who is running the tool, recorded with every workspace record:
+// $DOPPELGANGER_OPERATOR or else the login name
+func operatorName() string {
+	if name := os.Getenv("DOPPELGANGER_OPERATOR"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+var (
+	currentWorkspaceMu sync.Mutex
+	currentWorkspace   *Workspace
+)
+
+// useWorkspace opens the workspace in dir as the one operations are recorded to, closing the
+// previous one
+func useWorkspace(dir string) (*Workspace, error) {
+	w, err := openWorkspace(dir)
+	if err != nil {
+		return nil, err
+	}
+	currentWorkspaceMu.Lock()
+	previous := currentWorkspace
+	currentWorkspace = w
+	currentWorkspaceMu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return w, nil
+}
+
+// activeWorkspace returns the workspace operations are recorded to, or nil when none is open
+func activeWorkspace() *Workspace {
+	currentWorkspaceMu.Lock()
+	defer currentWorkspaceMu.Unlock()
+	return currentWorkspace
+}
+
+// closeWorkspace stops recording to the current workspace
+func closeWorkspace() {
+	currentWorkspaceMu.Lock()
+	w := currentWorkspace
+	currentWorkspace = nil
+	currentWorkspaceMu.Unlock()
+	if w != nil {
+		w.Close()
+	}
+}
+
+// errNoWorkspace is returned by commands that need a workspace when none is open
+var errNoWorkspace = fmt.Errorf("%w: no workspace open; use -workspace <dir> or set DOPPELGANGER_WORKSPACE", ErrInvalidInput)